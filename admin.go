@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/basgys/booking-consensys/app"
+	"github.com/basgys/booking-consensys/app/auth"
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/csvimport"
+	"github.com/deixis/errors"
+	"github.com/google/uuid"
+)
+
+func seed(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("seed", "[-fixture file]")
+	fixture := fs.String("fixture", "", "JSON fixture file to load instead of random demo data (- for stdin)")
+	fs.Parse(args)
+
+	if *fixture == "" {
+		if e.cfg.Env == app.EnvProduction {
+			return errors.New("demo data cannot be loaded in production (use -fixture)")
+		}
+		return app.Seed(ctx, e.cfg, e.out)
+	}
+
+	r, err := openInput(*fixture)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f := app.Fixture{}
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return errors.Wrap(err, "error decoding fixture")
+	}
+	report, err := app.LoadFixture(ctx, &f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "%s: %d created, %d skipped\n", *fixture, report.Created, report.Skipped)
+	return nil
+}
+
+// migrate replays the reservation log to rebuild all projections from
+// scratch. It must run after upgrades which change projections.
+func migrate(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("migrate", "")
+	fs.Parse(args)
+
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error initialising reservation repository")
+	}
+	if err := reservations.Rebuild(ctx); err != nil {
+		return errors.Wrap(err, "error rebuilding projections")
+	}
+	fmt.Fprintln(e.out, "Projections rebuilt")
+	return nil
+}
+
+func createUser(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("create-user", "[-id id] [-group id] [-roles roles] [-email email] [-address address]")
+	id := fs.String("id", "", "User ID (default: random UUID)")
+	group := fs.String("group", "", "ID of the group of the user")
+	roles := fs.String("roles", "", "Roles granted to the user, separated by commas (e.g. admin)")
+	email := fs.String("email", "", "Email address where notifications are sent")
+	address := fs.String("address", "", "Ethereum address of the account of the user")
+	fs.Parse(args)
+
+	u := &iam.User{
+		ID:      *id,
+		GroupID: *group,
+		Email:   *email,
+	}
+	if u.ID == "" {
+		u.ID = uuid.New().String()
+	}
+	for _, r := range strings.Split(*roles, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			u.Roles = append(u.Roles, iam.Role(strings.ToLower(r)))
+		}
+	}
+	var addr *iam.Address
+	if *address != "" {
+		a, err := iam.ParseAddress(*address)
+		if err != nil {
+			return errors.Bad(&errors.FieldViolation{
+				Field:       "address",
+				Description: "Invalid Ethereum address",
+			})
+		}
+		addr = &a
+	}
+
+	if err := iam.CreateUser(ctx, u, addr); err != nil {
+		return err
+	}
+	fmt.Fprintln(e.out, u.ID)
+	return nil
+}
+
+func issueToken(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("issue-token", "(-address address | -user id) [-ttl duration]")
+	address := fs.String("address", "", "Ethereum address of the account")
+	userID := fs.String("user", "", "User ID (the token is issued for their first account)")
+	ttl := fs.Duration("ttl", 30*24*time.Hour, "Validity of the token")
+	fs.Parse(args)
+
+	accounts, err := iam.NewAccountRepository(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error initialising account repository")
+	}
+
+	var acc *iam.Account
+	switch {
+	case *address != "":
+		addr, err := iam.ParseAddress(*address)
+		if err != nil {
+			return errors.Bad(&errors.FieldViolation{
+				Field:       "address",
+				Description: "Invalid Ethereum address",
+			})
+		}
+		acc, err = accounts.Get(ctx, addr)
+		if errors.IsNotFound(err) {
+			return errors.Errorf("account %s does not exist", addr)
+		}
+		if err != nil {
+			return err
+		}
+	case *userID != "":
+		l, err := accounts.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, a := range l {
+			if a.UserID == *userID {
+				acc = a
+				break
+			}
+		}
+		if acc == nil {
+			return errors.Errorf("user %s does not have any account", *userID)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	auths, err := auth.New(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error initialising auth service")
+	}
+	auths.Secret = e.cfg.Auth.Secret
+	token, err := auths.IssueToken(acc, *ttl)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign JWT")
+	}
+	fmt.Fprintln(e.out, token)
+	return nil
+}
+
+func export(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("export", "[-o file]")
+	out := fs.String("o", "-", "Fixture file to write (- for stdout)")
+	fs.Parse(args)
+
+	f, err := app.ExportFixture(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := e.out
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+// importers import a kind of CSV file
+var importers = map[string]func(context.Context, io.Reader, bool) (*csvimport.Report, error){
+	"rooms": booking.ImportRooms,
+	"users": iam.ImportUsers,
+}
+
+func importCSV(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("import", "[-dry-run] (rooms | users) file")
+	dryRun := fs.Bool("dry-run", false, "Only validate the file")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, ok := importers[fs.Arg(0)]
+	if !ok {
+		return errors.Errorf("cannot import %q (expect rooms or users)", fs.Arg(0))
+	}
+	return importFile(ctx, e.out, fs.Arg(1), *dryRun, f)
+}
+
+// importFile imports CSV file `name` with `f` and prints the report to `w`
+func importFile(
+	ctx context.Context,
+	w io.Writer,
+	name string,
+	dryRun bool,
+	f func(context.Context, io.Reader, bool) (*csvimport.Report, error),
+) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := f(ctx, file, dryRun)
+	if err != nil {
+		return err
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(w, "%s:%d: %s %s\n", name, e.Line, e.Field, e.Message)
+	}
+	fmt.Fprintf(w, "%s: %d rows, %d created, %d updated, %d unchanged, %d errors\n",
+		name, report.Rows, report.Created, report.Updated, report.Unchanged, len(report.Errors),
+	)
+	if !report.Valid() {
+		return errors.New("invalid file, nothing has been imported")
+	}
+	return nil
+}
+
+// openInput opens file `name`, or the standard input when it is "-"
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
@@ -5,43 +5,48 @@ package app
 
 import (
 	"context"
-	"fmt"
 	"io"
+	"net"
 	nethttp "net/http"
+	"net/smtp"
 	"time"
 
+	"github.com/basgys/booking-consensys/app/audit"
 	"github.com/basgys/booking-consensys/app/auth"
 	"github.com/basgys/booking-consensys/app/booking"
 	"github.com/basgys/booking-consensys/app/iam"
-	"github.com/basgys/booking-consensys/pkg/jwtutil"
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/basgys/booking-consensys/app/notification"
+	"github.com/basgys/booking-consensys/app/webhook"
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
 	"github.com/basgys/booking-consensys/pkg/mw"
 	"github.com/deixis/errors"
-	"github.com/deixis/pkg/utc"
 	"github.com/deixis/spine"
 	"github.com/deixis/spine/net/http"
 	"github.com/deixis/storage/kvdb"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/form3tech-oss/jwt-go"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 type App struct {
 	ctx          context.Context
+	cfg          *Config
 	store        kvdb.Store
+	scheduler    *job.Scheduler
+	bookings     *booking.Service
 	services     []interface{}
 	httpHandlers []httpHandler
+	grpcHandlers []grpcHandler
 }
 
-func New(ctx context.Context) (*App, error) {
+func New(ctx context.Context, cfg *Config) (*App, error) {
 	store, ok := kvdb.FromContext(ctx)
 	if !ok {
 		return nil, errors.New("cannot find KV store in context")
 	}
 
-	auths, err := auth.New(ctx)
+	auths, err := newAuth(ctx, cfg)
 	if err != nil {
-		return nil, errors.Wrap(err, "error initialising auth service")
+		return nil, err
 	}
 	iams, err := iam.New(ctx)
 	if err != nil {
@@ -51,119 +56,140 @@ func New(ctx context.Context) (*App, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "error initialising booking service")
 	}
+	webhooks, err := webhook.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising webhook service")
+	}
+	audits, err := audit.New(ctx, func(ctx context.Context) error {
+		return iams.RequireRole(ctx, iam.RoleAdmin)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising audit service")
+	}
+
+	// Run timed jobs in background. Jobs are persisted and shared by all nodes.
+	scheduler, err := job.NewScheduler(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising job scheduler")
+	}
+
+	// Deliver webhook events
+	dispatcher, err := webhook.NewDispatcher(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising webhook dispatcher")
+	}
+	if err := dispatcher.Schedule(ctx, scheduler); err != nil {
+		return nil, errors.Wrap(err, "error scheduling webhook dispatcher")
+	}
+
+	// Email notifications
+	notifier, err := notification.NewDispatcher(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising notification dispatcher")
+	}
+	if addr := cfg.Notification.SMTPAddr; addr != "" {
+		sender := &notification.SMTPSender{
+			Addr:       addr,
+			RequireTLS: cfg.Notification.SMTPStartTLS,
+		}
+		if user := cfg.Notification.SMTPUsername; user != "" {
+			// The address has been validated with the config
+			host, _, _ := net.SplitHostPort(addr)
+			pass := cfg.Notification.SMTPPassword
+			if cfg.Notification.SMTPStartTLS {
+				sender.Auth = smtp.PlainAuth("", user, pass, host)
+			} else {
+				sender.Auth = notification.InsecurePlainAuth(user, pass, host)
+			}
+		}
+		notifier.Sender = sender
+	}
+	if v := cfg.Notification.From; v != "" {
+		notifier.From = v
+	}
+	if err := notifier.Schedule(ctx, scheduler); err != nil {
+		return nil, errors.Wrap(err, "error scheduling notification dispatcher")
+	}
+
+	// Move past reservations out of room schedules
+	archiver, err := booking.NewArchiver(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising booking archiver")
+	}
+	archiver.Horizon = time.Duration(cfg.Booking.ArchiveHorizon)
+	archiver.Summarise = cfg.Booking.ArchiveSummarise
+	if err := archiver.Schedule(ctx, scheduler); err != nil {
+		return nil, errors.Wrap(err, "error scheduling booking archiver")
+	}
+
+	// Purge expired idempotency keys and reject expired approval requests
+	expirer, err := booking.NewExpirer(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising booking expirer")
+	}
+	if err := expirer.Schedule(ctx, scheduler); err != nil {
+		return nil, errors.Wrap(err, "error scheduling booking expirer")
+	}
+
+	// Flag reservations which have not been checked in
+	noShows, err := booking.NewNoShowDetector(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising no-show detector")
+	}
+	if err := noShows.Schedule(ctx, scheduler); err != nil {
+		return nil, errors.Wrap(err, "error scheduling no-show detector")
+	}
+
+	if err := scheduler.Start(ctx); err != nil {
+		return nil, errors.Wrap(err, "error starting job scheduler")
+	}
 
 	return &App{
-		ctx:   ctx,
-		store: store,
+		ctx:       ctx,
+		cfg:       cfg,
+		store:     store,
+		scheduler: scheduler,
+		bookings:  bookings,
 		services: []interface{}{
 			auths,
 			iams,
 			bookings,
+			webhooks,
+			scheduler,
+			audits,
 		},
 		httpHandlers: []httpHandler{
 			auths,
+			iams,
+			bookings,
+			webhooks,
+			audits,
+		},
+		grpcHandlers: []grpcHandler{
+			auths,
+			iams,
 			bookings,
 		},
 	}, nil
 }
 
-func (a *App) Seed(ctx context.Context) error {
-	accounts, err := iam.NewAccountRepository(ctx)
-	if err != nil {
-		return errors.Wrap(err, "error initialising account repository")
-	}
-	users, err := iam.NewUserRepository(ctx)
-	if err != nil {
-		return errors.Wrap(err, "error initialising user repository")
-	}
-	groups, err := iam.NewGroupRepository(ctx)
-	if err != nil {
-		return errors.Wrap(err, "error initialising group repository")
-	}
-	rooms, err := booking.NewRoomsRepository(ctx)
-	if err != nil {
-		return errors.Wrap(err, "error initialising room repository")
-	}
-	reservations, err := booking.NewReservationRepository(ctx)
-	if err != nil {
-		return errors.Wrap(err, "error initialising reservation repository")
-	}
-
-	privKey, err := crypto.GenerateKey()
+// newAuth returns an auth service configured with `cfg`
+func newAuth(ctx context.Context, cfg *Config) (*auth.Service, error) {
+	auths, err := auth.New(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to generate private key for seed user")
-	}
-	addr := iam.Address(crypto.PubkeyToAddress(privKey.PublicKey))
-
-	// Create groups
-	coke := &iam.Group{
-		ID:  uuid.New().String(),
-		Ref: "COKE",
-	}
-	groups.Create(ctx, coke)
-	pepsi := &iam.Group{
-		ID:  uuid.New().String(),
-		Ref: "PEPSI",
-	}
-	groups.Create(ctx, pepsi)
-
-	// Create user with its account
-	usr := &iam.User{
-		ID:      uuid.New().String(),
-		GroupID: coke.ID,
-	}
-	users.Create(ctx, usr)
-	acc := &iam.Account{
-		Address: iam.Address(addr),
-		UserID:  usr.ID,
-	}
-	accounts.Create(ctx, acc)
-
-	// Create some random reservations
-	for _, g := range []string{"C", "P"} {
-		for i := 1; i <= 10; i++ {
-			roomRef := fmt.Sprintf("%s%02d", g, i)
-			rooms.Create(ctx, &booking.Room{
-				Ref: roomRef,
-			})
-
-			res := booking.Reservation{
-				RoomRef: roomRef,
-				From:    utc.MustParse("2021-07-26T12:00:00Z"),
-				To:      utc.MustParse("2021-07-26T13:00:00Z"),
-				UserID:  usr.ID,
-			}
-			err := reservations.Reserve(ctx, &res)
-			switch {
-			case err == nil || errors.IsAborted(err):
-			default:
-				return errors.Wrapf(err, "failed to create reservation for room %s", roomRef)
-			}
-		}
+		return nil, errors.Wrap(err, "error initialising auth service")
 	}
+	auths.Secret = cfg.Auth.Secret
+	auths.SessionTTL = time.Duration(cfg.Auth.SessionTTL)
+	return auths, nil
+}
 
-	// Create session
-	token := jwt.NewWithClaims(jwtutil.StandardMethod, jwt.StandardClaims{
-		Id:        acc.ID(),
-		ExpiresAt: int64(utc.Now().Add(30 * 24 * time.Hour)),
-	})
-	signedToken, err := token.SignedString([]byte("a-hardcoded-secret-is-the-safest-secret"))
-	if err != nil {
-		return errors.Wrap(err, "failed to sign JWT")
-	}
-
-	fmt.Println("====================================")
-	fmt.Println("Seed data")
-	fmt.Println("")
-	fmt.Println("JWT:", signedToken)
-	fmt.Println("Account:", addr.String())
-	fmt.Println("User:", usr.ID)
-	fmt.Println("")
-	fmt.Println("Groups:")
-	fmt.Println("Coke:", coke.ID)
-	fmt.Println("Pepsi:", pepsi.ID)
-	fmt.Println("====================================")
-	return nil
+// Drain stops claiming jobs and ends room streams, which would otherwise
+// hold the shutdown of the HTTP server. It must be called as soon as spine
+// starts draining.
+func (a *App) Drain() {
+	a.bookings.DrainStreams()
+	a.scheduler.Drain()
 }
 
 func (a *App) Close() error {
@@ -189,15 +215,20 @@ func (a *App) HandleHTTP(srv *http.Server) {
 	store := mw.Store{
 		S: a.store,
 	}
+	cors := mw.CORS{
+		Origins: a.cfg.CORSOrigins,
+	}
 
 	// Add middlewares
 	// Create HTTP handler with middlewares
 	srv.Append(ni.ReturnNodeInfo)
-	srv.Append(mwCORS) // FIXME: Unsafe, but ok for demo purpose
+	srv.Append(cors.Allow)
 	srv.Append(store.Inject)
+	srv.Append(mustOpenAPIValidator().Middleware)
 
 	// Return 200 OK on / for load balancer health check
 	srv.HandleFunc("/", http.GET, httpOK)
+	srv.HandleFunc("/openapi.json", http.GET, httpOpenAPI)
 
 	for _, h := range a.httpHandlers {
 		h.HandleHTTP(srv)
@@ -209,6 +240,24 @@ type httpHandler interface {
 	HandleHTTP(srv *http.Server)
 }
 
+func (a *App) HandleGRPC(srv *grpcutil.Server) {
+	store := mw.Store{
+		S: a.store,
+	}
+
+	// Add middlewares
+	srv.AppendUnaryMiddleware(grpcutil.MarshalErrors)
+	srv.AppendUnaryMiddleware(store.InjectGRPC)
+
+	for _, h := range a.grpcHandlers {
+		h.HandleGRPC(srv)
+	}
+}
+
+type grpcHandler interface {
+	HandleGRPC(srv *grpcutil.Server)
+}
+
 func httpOK(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	w.Head(http.StatusOK)
 }
@@ -225,16 +274,3 @@ func (m *matchAll) Attach(r *mux.Router, f func(nethttp.ResponseWriter, *nethttp
 func (m *matchAll) Serve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	w.Head(http.StatusNotFound)
 }
-
-func mwCORS(next http.ServeFunc) http.ServeFunc {
-	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Access-Control-Allow-Origin", "*")
-		w.Header().Add("Access-Control-Allow-Headers", "*")
-
-		if r.HTTP.Method == "OPTIONS" {
-			w.Head(http.StatusOK)
-			return
-		}
-		next(ctx, w, r)
-	}
-}
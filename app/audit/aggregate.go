@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/deixis/pkg/utc"
+)
+
+// Record describes a change made on the system.
+//
+// Records are chained together. Each one contains the hash of its
+// predecessor, so altering or removing a record breaks the chain.
+type Record struct {
+	// Seq is the position of the record on the chain (starts at 1)
+	Seq       int64           `json:"seq"`
+	Actor     Actor           `json:"actor"`
+	Action    string          `json:"action"`
+	Resource  string          `json:"resource,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	At        utc.UTC         `json:"at"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+// ComputeHash returns the hash of the record content chained to PrevHash
+func (r *Record) ComputeHash() (string, error) {
+	c := *r
+	c.Hash = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(r.PrevHash))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Actor is who made a change. An empty actor means the system itself.
+type Actor struct {
+	Address string `json:"address,omitempty"`
+	UserID  string `json:"userId,omitempty"`
+}
+
+func (a Actor) String() string {
+	if a.Address != "" {
+		return a.Address
+	}
+	return a.UserID
+}
+
+// Query filters audit records. Zero values are ignored.
+type Query struct {
+	// Actor matches either the actor address or user ID
+	Actor    string
+	Resource string
+	From     utc.UTC
+	To       utc.UTC
+	// After skips all records up to this sequence number (included)
+	After int64
+	Limit int
+}
+
+func (q *Query) match(r *Record) bool {
+	if q.Actor != "" && q.Actor != r.Actor.Address && q.Actor != r.Actor.UserID {
+		return false
+	}
+	if q.Resource != "" && q.Resource != r.Resource {
+		return false
+	}
+	if q.From != 0 && r.At < q.From {
+		return false
+	}
+	if q.To != 0 && r.At >= q.To {
+		return false
+	}
+	return r.Seq > q.After
+}
+
+// RoomResource returns the resource name of room `ref`
+func RoomResource(ref string) string {
+	return "room:" + strings.ToUpper(strings.TrimSpace(ref))
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/pkg/httputil"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/net/http"
+)
+
+func (s *Service) HandleHTTP(srv *http.Server) {
+	h := httpHandler{
+		svc: s,
+	}
+
+	srv.HandleFunc("/audit/records", http.GET, h.queryRecords)
+	srv.HandleFunc("/audit/verify", http.GET, h.verify)
+}
+
+type httpHandler struct {
+	svc *Service
+}
+
+func (h *httpHandler) queryRecords(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	query := req.HTTP.URL.Query()
+	params := struct {
+		Actor    string  `qs:"actor"`
+		Room     string  `qs:"room"`
+		Resource string  `qs:"resource"`
+		From     utc.UTC `qs:"from"`
+		To       utc.UTC `qs:"to"`
+		After    int64   `qs:"after"`
+		Limit    int     `qs:"limit"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	q := Query{
+		Actor:    params.Actor,
+		Resource: params.Resource,
+		From:     params.From,
+		To:       params.To,
+		After:    params.After,
+		Limit:    params.Limit,
+	}
+	if params.Room != "" {
+		q.Resource = RoomResource(params.Room)
+	}
+
+	records, err := h.svc.Query(ctx, q)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Records []*Record `json:"records"`
+	}{
+		Records: records,
+	})
+}
+
+func (h *httpHandler) verify(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	rec, err := h.svc.Verify(ctx)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Valid    bool    `json:"valid"`
+		Tampered *Record `json:"tampered,omitempty"`
+	}{
+		Valid:    rec == nil,
+		Tampered: rec,
+	})
+}
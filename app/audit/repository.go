@@ -0,0 +1,363 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	scontext "github.com/deixis/spine/context"
+	"github.com/deixis/storage/kvdb"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+var (
+	firstKey kvdb.TupleElement
+	lastKey  = kvdb.UUID{0xFF}
+)
+
+// RecordRepository stores the audit chain along with indexes by actor,
+// resource and time
+type RecordRepository struct {
+	// head contains the sequence number and hash of the last record. Every
+	// append reads and rewrites it, so concurrent appends conflict instead of
+	// writing the same sequence number.
+	head      kvdb.Subspace
+	records   kvdb.Subspace
+	actors    kvdb.Subspace
+	resources kvdb.Subspace
+	times     kvdb.Subspace
+}
+
+func NewRecordRepository(ctx context.Context) (*RecordRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	head, err := store.CreateOrOpenDir([]string{"audit", "head"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit/head dir")
+	}
+	records, err := store.CreateOrOpenDir([]string{"audit", "record"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit/record dir")
+	}
+	actors, err := store.CreateOrOpenDir([]string{"audit", "actor"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit/actor dir")
+	}
+	resources, err := store.CreateOrOpenDir([]string{"audit", "resource"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit/resource dir")
+	}
+	times, err := store.CreateOrOpenDir([]string{"audit", "time"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit/time dir")
+	}
+	return &RecordRepository{
+		head:      head,
+		records:   records,
+		actors:    actors,
+		resources: resources,
+		times:     times,
+	}, nil
+}
+
+// Log appends a record of `action` made by `actor` on `resource` to the chain.
+//
+// It joins the transaction carried by `ctx` (if any), so the record is only
+// kept when the change it describes is committed. `before` and `after` are
+// optional snapshots of the resource.
+func (r *RecordRepository) Log(
+	ctx context.Context,
+	actor Actor,
+	action string,
+	resource string,
+	before, after interface{},
+) error {
+	rec := &Record{
+		Actor:    actor,
+		Action:   action,
+		Resource: resource,
+		At:       utc.Now(),
+	}
+	if tr := scontext.TransitFromContext(ctx); tr != nil {
+		rec.RequestID = tr.UUID()
+	}
+	var err error
+	if rec.Before, err = snapshot(before); err != nil {
+		return errors.Wrap(err, "failed to marshal before snapshot")
+	}
+	if rec.After, err = snapshot(after); err != nil {
+		return errors.Wrap(err, "failed to marshal after snapshot")
+	}
+	return r.Append(ctx, rec)
+}
+
+// Append chains `rec` to the last record and stores it
+func (r *RecordRepository) Append(ctx context.Context, rec *Record) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			h, err := r.loadHead(tx)
+			if err != nil {
+				return nil, err
+			}
+			rec.Seq = h.Seq + 1
+			rec.PrevHash = h.Hash
+			if rec.Hash, err = rec.ComputeHash(); err != nil {
+				return nil, errors.Wrap(err, "failed to hash record")
+			}
+			if err := r.saveHead(tx, &chainHead{Seq: rec.Seq, Hash: rec.Hash}); err != nil {
+				return nil, err
+			}
+
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(rec); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal record")
+			}
+			tx.Set(r.records.Pack([]kvdb.TupleElement{rec.Seq}), encoded.Bytes())
+
+			// Indexes
+			if rec.Actor.Address != "" {
+				tx.Set(r.actors.Pack([]kvdb.TupleElement{rec.Actor.Address, rec.Seq}), nil)
+			}
+			if rec.Actor.UserID != "" && rec.Actor.UserID != rec.Actor.Address {
+				tx.Set(r.actors.Pack([]kvdb.TupleElement{rec.Actor.UserID, rec.Seq}), nil)
+			}
+			if rec.Resource != "" {
+				tx.Set(r.resources.Pack([]kvdb.TupleElement{rec.Resource, rec.Seq}), nil)
+			}
+			tx.Set(r.times.Pack([]kvdb.TupleElement{int64(rec.At), rec.Seq}), nil)
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Query returns records matching `q` ordered by sequence number
+func (r *RecordRepository) Query(
+	ctx context.Context, q Query,
+) (records []*Record, err error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			// Pick the most selective index
+			var rng kvdb.KeyRange
+			var ss kvdb.Subspace
+			byTime := false
+			switch {
+			case q.Actor != "":
+				ss = r.actors
+				rng = kvdb.KeyRange{
+					Begin: ss.Pack([]kvdb.TupleElement{q.Actor, q.After + 1}),
+					End:   ss.Pack([]kvdb.TupleElement{q.Actor, lastKey}),
+				}
+			case q.Resource != "":
+				ss = r.resources
+				rng = kvdb.KeyRange{
+					Begin: ss.Pack([]kvdb.TupleElement{q.Resource, q.After + 1}),
+					End:   ss.Pack([]kvdb.TupleElement{q.Resource, lastKey}),
+				}
+			case q.From != 0 || q.To != 0:
+				ss = r.times
+				byTime = true
+				rng = kvdb.KeyRange{
+					Begin: ss.Pack([]kvdb.TupleElement{int64(q.From)}),
+					End:   ss.Pack([]kvdb.TupleElement{lastKey}),
+				}
+			default:
+				ss = r.records
+				rng = kvdb.KeyRange{
+					Begin: ss.Pack([]kvdb.TupleElement{q.After + 1}),
+					End:   ss.Pack([]kvdb.TupleElement{lastKey}),
+				}
+			}
+
+			iter := tx.GetRange(rng).Iterator()
+			for len(records) < q.Limit && iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				t, err := ss.Unpack(kv.Key)
+				if err != nil {
+					return nil, err
+				}
+				// The sequence number is always the last element of the key
+				seq := t[len(t)-1].(int64)
+				if byTime && q.To != 0 && utc.UTC(t[0].(int64)) >= q.To {
+					break
+				}
+
+				rec, err := r.get(tx, seq)
+				if err != nil {
+					return nil, err
+				}
+				if q.match(rec) {
+					records = append(records, rec)
+				}
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Verify walks the whole chain and returns the first record which does not
+// match its hash or is not chained to its predecessor. It returns nil when
+// the chain is intact.
+func (r *RecordRepository) Verify(ctx context.Context) (*Record, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.records.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.records.Pack([]kvdb.TupleElement{lastKey}),
+			}
+			var prev *Record
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				rec, err := decode(kv.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				var seq int64 = 1
+				var prevHash string
+				if prev != nil {
+					seq = prev.Seq + 1
+					prevHash = prev.Hash
+				}
+				if rec.Seq != seq || rec.PrevHash != prevHash {
+					return rec, nil
+				}
+				hash, err := rec.ComputeHash()
+				if err != nil {
+					return nil, err
+				}
+				if hash != rec.Hash {
+					return rec, nil
+				}
+				prev = rec
+			}
+			return (*Record)(nil), nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Record), nil
+}
+
+// chainHead is the position of the last record on the chain
+type chainHead struct {
+	Seq  int64
+	Hash string
+}
+
+// loadHead returns the head of the chain. Chains recorded before the head
+// was stored fall back on their last record.
+func (r *RecordRepository) loadHead(tx kvdb.ReadTransaction) (*chainHead, error) {
+	data, err := tx.Get(r.head.Pack([]kvdb.TupleElement{})).Get()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		h := &chainHead{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(h); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal chain head")
+		}
+		return h, nil
+	}
+
+	last, err := r.last(tx)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return &chainHead{}, nil
+	}
+	return &chainHead{Seq: last.Seq, Hash: last.Hash}, nil
+}
+
+func (r *RecordRepository) saveHead(tx kvdb.Transaction, h *chainHead) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(h); err != nil {
+		return errors.Wrap(err, "failed to marshal chain head")
+	}
+	tx.Set(r.head.Pack([]kvdb.TupleElement{}), encoded.Bytes())
+	return nil
+}
+
+func (r *RecordRepository) last(tx kvdb.ReadTransaction) (*Record, error) {
+	rng := kvdb.KeyRange{
+		Begin: r.records.Pack([]kvdb.TupleElement{firstKey}),
+		End:   r.records.Pack([]kvdb.TupleElement{lastKey}),
+	}
+	iter := tx.GetRange(rng,
+		kvdb.WithRangeLimit(1),
+		kvdb.WithRangeReverse(true),
+	).Iterator()
+	if !iter.Advance() {
+		return nil, nil
+	}
+	kv, err := iter.Get()
+	if err != nil {
+		return nil, err
+	}
+	// Reach the limit to release the iterator. Badger does not allow more
+	// than one active iterator on a read-write transaction.
+	iter.Advance()
+	return decode(kv.Value)
+}
+
+func (r *RecordRepository) get(tx kvdb.ReadTransaction, seq int64) (*Record, error) {
+	data, err := tx.Get(r.records.Pack([]kvdb.TupleElement{seq})).Get()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.NotFound
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (*Record, error) {
+	rec := &Record{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(rec); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal record")
+	}
+	return rec, nil
+}
+
+func snapshot(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,210 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sync"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app/audit"
+	"github.com/basgys/booking-consensys/pkg/kvdb/kvretry"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+)
+
+// TestRecord_Query ensures records can be found by actor, resource and time
+func TestRecord_Query(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	records, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	start := utc.Now()
+	alice := audit.Actor{Address: "0xA11CE", UserID: "alice"}
+	bob := audit.Actor{Address: "0xB0B", UserID: "bob"}
+	logs := []struct {
+		actor audit.Actor
+		room  string
+	}{
+		{actor: alice, room: "C01"},
+		{actor: bob, room: "C01"},
+		{actor: alice, room: "C02"},
+	}
+	for _, l := range logs {
+		err := records.Log(ctx, l.actor, "booking.reserve", audit.RoomResource(l.room), nil, l)
+		if err != nil {
+			t.Fatal("expect to log a record, but got", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		query  audit.Query
+		expect int
+	}{
+		{name: "actor address", query: audit.Query{Actor: alice.Address}, expect: 2},
+		{name: "actor user", query: audit.Query{Actor: bob.UserID}, expect: 1},
+		{name: "room", query: audit.Query{Resource: audit.RoomResource("c01")}, expect: 2},
+		{name: "time", query: audit.Query{From: start}, expect: 3},
+		{name: "time in the past", query: audit.Query{To: start}, expect: 0},
+		{name: "all", query: audit.Query{}, expect: 3},
+		{name: "after", query: audit.Query{After: 2}, expect: 1},
+		{name: "limit", query: audit.Query{Limit: 2}, expect: 2},
+		{name: "actor and room", query: audit.Query{
+			Actor:    alice.UserID,
+			Resource: audit.RoomResource("C02"),
+		}, expect: 1},
+	}
+	for _, test := range tests {
+		l, err := records.Query(ctx, test.query)
+		if err != nil {
+			t.Fatalf("%s - expect to query records, but got %s", test.name, err)
+		}
+		if len(l) != test.expect {
+			t.Errorf("%s - expect %d records, but got %d", test.name, test.expect, len(l))
+		}
+	}
+}
+
+// TestRecord_Tamper ensures a modified record breaks the chain
+func TestRecord_Tamper(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	records, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	actor := audit.Actor{UserID: "alice"}
+	for _, room := range []string{"C01", "C02", "C03"} {
+		err := records.Log(ctx, actor, "booking.cancel", audit.RoomResource(room), nil, nil)
+		if err != nil {
+			t.Fatal("expect to log a record, but got", err)
+		}
+	}
+
+	rec, err := records.Verify(ctx)
+	if err != nil {
+		t.Fatal("expect to verify chain, but got", err)
+	}
+	if rec != nil {
+		t.Fatalf("expect chain to be intact, but record %d is broken", rec.Seq)
+	}
+
+	// Rewrite history
+	store, _ := kvdb.FromContext(ctx)
+	dir, err := store.CreateOrOpenDir([]string{"audit", "record"})
+	if err != nil {
+		t.Fatal("error opening dir", err)
+	}
+	_, err = store.Transact(ctx, func(tx kvdb.Transaction) (interface{}, error) {
+		key := dir.Pack([]kvdb.TupleElement{int64(2)})
+		data, err := tx.Get(key).Get()
+		if err != nil {
+			return nil, err
+		}
+		rec := audit.Record{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+			return nil, err
+		}
+		rec.Actor.UserID = "bob"
+
+		var encoded bytes.Buffer
+		if err := gob.NewEncoder(&encoded).Encode(&rec); err != nil {
+			return nil, err
+		}
+		tx.Set(key, encoded.Bytes())
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal("error tampering record", err)
+	}
+
+	rec, err = records.Verify(ctx)
+	if err != nil {
+		t.Fatal("expect to verify chain, but got", err)
+	}
+	if rec == nil || rec.Seq != 2 {
+		t.Fatal("expect record 2 to be detected as tampered, but got", rec)
+	}
+}
+
+// TestRecord_ConcurrentAppend ensures concurrent appends conflict instead of
+// overwriting each other, so no record is lost
+func TestRecord_ConcurrentAppend(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	records, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	actor := audit.Actor{UserID: "alice"}
+
+	// Interleave two appends
+	_, err = kvdb.Transact(ctx, func(txCtx context.Context, tx kvdb.Transaction) (interface{}, error) {
+		if err := records.Log(txCtx, actor, "booking.reserve", audit.RoomResource("C01"), nil, nil); err != nil {
+			return nil, err
+		}
+		return nil, records.Log(ctx, actor, "booking.reserve", audit.RoomResource("C02"), nil, nil)
+	})
+	if err != memory.ErrConflict {
+		t.Fatal("expect interleaved appends to conflict, but got", err)
+	}
+
+	// Appends are retried on conflict by the store
+	const n = 20
+	store, _ := kvdb.FromContext(ctx)
+	retried := kvretry.Wrap(store, func(err error) bool { return err == memory.ErrConflict })
+	retried.Attempts = n
+	retryCtx := kvdb.WithContext(ctx, retried)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := records.Log(retryCtx, actor, "booking.cancel", audit.RoomResource("C03"), nil, nil)
+			if err != nil {
+				t.Error("expect to log a record, but got", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	l, err := records.Query(ctx, audit.Query{})
+	if err != nil {
+		t.Fatal("expect to query records, but got", err)
+	}
+	if len(l) != n+1 {
+		t.Fatalf("expect %d records, but got %d", n+1, len(l))
+	}
+	for i, rec := range l {
+		if rec.Seq != int64(i+1) {
+			t.Errorf("expect record %d, but got %d", i+1, rec.Seq)
+		}
+	}
+	rec, err := records.Verify(ctx)
+	if err != nil {
+		t.Fatal("expect to verify chain, but got", err)
+	}
+	if rec != nil {
+		t.Errorf("expect chain to be intact, but record %d is broken", rec.Seq)
+	}
+}
+
+func loadStorage(name string) (context.Context, error) {
+	ctx := context.Background()
+	ctx = kvdb.WithContext(ctx, memory.New())
+	return ctx, nil
+}
@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/deixis/errors"
+)
+
+// Guard returns an error when the caller is not allowed to read the audit
+// trail
+type Guard func(ctx context.Context) error
+
+type Service struct {
+	records *RecordRepository
+	guard   Guard
+}
+
+func New(ctx context.Context, guard Guard) (*Service, error) {
+	records, err := NewRecordRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise record repository")
+	}
+
+	return &Service{
+		records: records,
+		guard:   guard,
+	}, nil
+}
+
+func (s *Service) Query(ctx context.Context, q Query) ([]*Record, error) {
+	if err := s.guard(ctx); err != nil {
+		return nil, err
+	}
+	return s.records.Query(ctx, q)
+}
+
+// Verify returns the first record that has been tampered with, or nil if
+// the audit trail is intact
+func (s *Service) Verify(ctx context.Context) (*Record, error) {
+	if err := s.guard(ctx); err != nil {
+		return nil, err
+	}
+	return s.records.Verify(ctx)
+}
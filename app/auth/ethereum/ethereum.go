@@ -2,6 +2,7 @@ package ethereum
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"github.com/deixis/errors"
 	"github.com/deixis/pkg/utc"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -48,6 +50,16 @@ func (a *Auth) Verify(account iam.Address, challenge string, hexSignature string
 	return errors.PermissionDenied
 }
 
+// Sign signs `challenge` with private key `key`. It returns the hexadecimal
+// signature expected by Verify.
+func Sign(challenge string, key *ecdsa.PrivateKey) (string, error) {
+	signature, err := crypto.Sign(signHash([]byte(challenge)), key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign challenge")
+	}
+	return hexutil.Encode(signature), nil
+}
+
 // https://github.com/ethereum/go-ethereum/blob/55599ee95d4151a2502465e0afc7c47bd1acba77/internal/ethapi/api.go#L404
 // signHash is a helper function that calculates a hash for the given message that can be
 // safely used to calculate a signature from.
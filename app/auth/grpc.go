@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
+	"github.com/basgys/booking-consensys/pkg/jwtutil"
+	"github.com/basgys/booking-consensys/pkg/pb"
+	"github.com/deixis/errors"
+)
+
+func (s *Service) HandleGRPC(srv *grpcutil.Server) {
+	h := grpcHandler{
+		svc: s,
+	}
+
+	// Attach middlewares
+	jwtm := &jwtutil.GRPCMiddleware{Secret: s.Secret}
+	srv.AppendUnaryMiddleware(jwtm.Parse)
+	srv.AppendUnaryMiddleware(h.accountMiddleware)
+
+	// Register service
+	pb.RegisterAuthServiceServer(srv, &h)
+}
+
+type grpcHandler struct {
+	pb.UnimplementedAuthServiceServer
+
+	svc *Service
+}
+
+func (h *grpcHandler) Challenge(
+	ctx context.Context, req *pb.ChallengeRequest,
+) (*pb.ChallengeResponse, error) {
+	addr, err := parseAddress(req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := h.svc.Challenge(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ChallengeResponse{Challenge: ch}, nil
+}
+
+func (h *grpcHandler) Authorise(
+	ctx context.Context, req *pb.AuthoriseRequest,
+) (*pb.AuthoriseResponse, error) {
+	addr, err := parseAddress(req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := h.svc.Authorise(ctx, addr, req.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AuthoriseResponse{Token: token}, nil
+}
+
+func (h *grpcHandler) accountMiddleware(next grpcutil.UnaryHandler) grpcutil.UnaryHandler {
+	return func(ctx context.Context, info *grpcutil.Info, req interface{}) (interface{}, error) {
+		token, ok := jwtutil.FromContext(ctx)
+		if !ok {
+			// Unauthenticated request
+			return next(ctx, info, req)
+		}
+
+		acc, err := h.svc.account(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+
+		// Attach account to context
+		ctx = iam.WithContext(ctx, acc)
+		return next(ctx, info, req)
+	}
+}
+
+// parseAddress parses address `s`. An empty address is nil.
+func parseAddress(s string) (*iam.Address, error) {
+	if s == "" {
+		return nil, nil
+	}
+	addr, err := iam.ParseAddress(s)
+	if err != nil {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "address",
+			Description: err.Error(),
+		})
+	}
+	return &addr, nil
+}
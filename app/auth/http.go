@@ -10,9 +10,7 @@ import (
 	"github.com/basgys/booking-consensys/pkg/jwtutil"
 	"github.com/deixis/errors"
 	"github.com/deixis/errors/httperrors"
-	"github.com/deixis/pkg/utc"
 	"github.com/deixis/spine/net/http"
-	"github.com/form3tech-oss/jwt-go"
 )
 
 func (s *Service) HandleHTTP(srv *http.Server) {
@@ -102,51 +100,12 @@ func (h *httpHandler) accountMiddleware(next http.ServeFunc) http.ServeFunc {
 			return
 		}
 
-		// Lengthy way to load account key from JWT
-		if err := token.Claims.Valid(); err != nil {
-			httperrors.Marshal(req.HTTP, w, err)
-			return
-		}
-		claims, ok := token.Claims.(*jwt.StandardClaims)
-		if !ok {
-			httperrors.Marshal(req.HTTP, w, errors.Bad(&errors.FieldViolation{
-				Field:       "jwt",
-				Description: "Invalid JWT claims format. Expect standard claims",
-			}))
-			return
-		}
-		if claims.Id == "" {
-			httperrors.Marshal(req.HTTP, w, errors.Bad(&errors.FieldViolation{
-				Field:       "id",
-				Description: "Missing ID in JWT claims",
-			}))
-			return
-		}
-		if claims.ExpiresAt < int64(utc.Now()) {
-			httperrors.Marshal(req.HTTP, w, errors.Unauthenticated)
-			return
-		}
-
-		addr, err := iam.ParseAddress(claims.Id)
+		acc, err := h.svc.account(ctx, token)
 		if err != nil {
 			httperrors.Marshal(req.HTTP, w, err)
 			return
 		}
 
-		// Load account from repository
-		acc, err := h.svc.accounts.Get(ctx, addr)
-		switch {
-		case err == nil:
-			// Good
-		case errors.IsNotFound(err):
-			// The JWT points to an account that does not exist
-			httperrors.Marshal(req.HTTP, w, errors.Unauthenticated)
-			return
-		default:
-			httperrors.Marshal(req.HTTP, w, err)
-			return
-		}
-
 		// Attach account to context
 		ctx = iam.WithContext(ctx, acc)
 		next(ctx, w, req)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/basgys/booking-consensys/app/audit"
 	"github.com/basgys/booking-consensys/app/auth/ethereum"
 	"github.com/basgys/booking-consensys/app/iam"
 	"github.com/basgys/booking-consensys/pkg/jwtutil"
@@ -14,15 +15,20 @@ import (
 )
 
 const (
-	sessionTTL = 2 * time.Hour
+	// DefaultSessionTTL is how long sessions are valid by default
+	DefaultSessionTTL = 2 * time.Hour
 )
 
 type Service struct {
+	// Secret signs session tokens. It must be set before tokens are issued.
 	Secret string
+	// SessionTTL is how long the sessions opened with Authorise are valid
+	SessionTTL time.Duration
 
 	auths      Auth
 	challenges ChallengeRepository
 	accounts   *iam.AccountRepository
+	audit      *audit.RecordRepository
 }
 
 func New(ctx context.Context) (*Service, error) {
@@ -30,12 +36,16 @@ func New(ctx context.Context) (*Service, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to initialise account repository")
 	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise audit repository")
+	}
 
 	return &Service{
-		// FIXME: Add secret to configuration file
-		Secret:   "a-hardcoded-secret-is-the-safest-secret",
-		auths:    &ethereum.Auth{},
-		accounts: accounts,
+		SessionTTL: DefaultSessionTTL,
+		auths:      &ethereum.Auth{},
+		accounts:   accounts,
+		audit:      audits,
 	}, nil
 }
 
@@ -93,7 +103,14 @@ func (s *Service) Authorise(ctx context.Context, address *iam.Address, signature
 	}
 
 	// Verify challenge
+	actor := audit.Actor{Address: address.String()}
+	resource := "account:" + address.String()
 	if err := s.auths.Verify(*address, challenge, signature); err != nil {
+		if aerr := s.audit.Log(ctx, actor, "auth.authorise.denied", resource, nil, nil); aerr != nil {
+			log.Warn(ctx, "auth.audit.err", "Failed to audit denied authorisation",
+				log.Error(aerr),
+			)
+		}
 		return "", err
 	}
 
@@ -103,14 +120,67 @@ func (s *Service) Authorise(ctx context.Context, address *iam.Address, signature
 		return "", err
 	}
 
-	// Create and sign JWT
+	signedToken, err := s.IssueToken(acc, s.SessionTTL)
+	if err != nil {
+		return "", err
+	}
+
+	actor.UserID = acc.UserID
+	if err := s.audit.Log(ctx, actor, "auth.authorise", resource, nil, nil); err != nil {
+		return "", err
+	}
+	return signedToken, nil
+}
+
+// IssueToken returns a JWT which authenticates account `acc` for `ttl`
+func (s *Service) IssueToken(acc *iam.Account, ttl time.Duration) (string, error) {
+	if s.Secret == "" {
+		return "", errors.New("missing secret to sign tokens")
+	}
 	token := jwt.NewWithClaims(jwtutil.StandardMethod, jwt.StandardClaims{
 		Id:        acc.ID(),
-		ExpiresAt: int64(utc.Now().Add(sessionTTL)),
+		ExpiresAt: int64(utc.Now().Add(ttl)),
 	})
-	signedToken, err := token.SignedString([]byte(s.Secret))
+	return token.SignedString([]byte(s.Secret))
+}
+
+// account returns the account authenticated by JWT `token`
+func (s *Service) account(ctx context.Context, token *jwt.Token) (*iam.Account, error) {
+	// Lengthy way to load account key from JWT
+	if err := token.Claims.Valid(); err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*jwt.StandardClaims)
+	if !ok {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "jwt",
+			Description: "Invalid JWT claims format. Expect standard claims",
+		})
+	}
+	if claims.Id == "" {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "id",
+			Description: "Missing ID in JWT claims",
+		})
+	}
+	if claims.ExpiresAt < int64(utc.Now()) {
+		return nil, errors.Unauthenticated
+	}
+
+	addr, err := iam.ParseAddress(claims.Id)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// Load account from repository
+	acc, err := s.accounts.Get(ctx, addr)
+	switch {
+	case err == nil:
+		return acc, nil
+	case errors.IsNotFound(err):
+		// The JWT points to an account that does not exist
+		return nil, errors.Unauthenticated
+	default:
+		return nil, err
 	}
-	return signedToken, nil
 }
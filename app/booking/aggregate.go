@@ -1,6 +1,7 @@
 package booking
 
 import (
+	"strings"
 	"time"
 
 	"github.com/basgys/booking-consensys/pkg/timeutil/interval"
@@ -9,7 +10,46 @@ import (
 )
 
 type Room struct {
-	Ref string `json:"ref"`
+	Ref  string `json:"ref"`
+	Name string `json:"name,omitempty"`
+	// Metadata contains free-form attributes (e.g. building, floor)
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Tags group rooms which share booking policies (e.g. "boardroom")
+	Tags []string `json:"tags,omitempty"`
+	// Capacity is the number of seats which can be reserved at the same time
+	// (e.g. hot-desk areas, event spaces). Zero means the room is reserved
+	// as a whole.
+	Capacity int `json:"capacity,omitempty"`
+	// RequiresApproval means reservations must be requested and approved
+	// instead of being first come first served
+	RequiresApproval bool `json:"requiresApproval,omitempty"`
+	// HoldPending makes pending requests block their slot until they are
+	// decided
+	HoldPending bool `json:"holdPending,omitempty"`
+	// Approvers are the users allowed to decide on requests (on top of admins)
+	Approvers []string `json:"approvers,omitempty"`
+	// ApproverGroups are the groups which members can decide on requests
+	ApproverGroups []string `json:"approverGroups,omitempty"`
+	// Version is incremented on every change
+	Version uint64 `json:"version"`
+}
+
+// HasTag returns whether the room is tagged with `tag`
+func (r *Room) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// capacity returns the number of seats which can be reserved at the same time
+func (r *Room) capacity() uint64 {
+	if r.Capacity < 1 {
+		return 1
+	}
+	return uint64(r.Capacity)
 }
 
 type Reservation struct {
@@ -18,16 +58,136 @@ type Reservation struct {
 	To      utc.UTC `json:"to"`
 	RoomRef string  `json:"roomRef"`
 	UserID  string  `json:"userId"`
+	// CreatedBy is the user who made the reservation. It differs from UserID
+	// when it was made by a delegate.
+	CreatedBy string `json:"createdBy,omitempty"`
+	// Seats is the number of seats reserved in the room
+	Seats int `json:"seats,omitempty"`
+	// Status is either active or cancelled
+	Status ReservationStatus `json:"status,omitempty"`
+	// CancelledAt is when the reservation was cancelled
+	CancelledAt utc.UTC `json:"cancelledAt,omitempty"`
+	// CancelledBy is the user who cancelled the reservation
+	CancelledBy string `json:"cancelledBy,omitempty"`
+	// LateCancel is set when the reservation was cancelled within the
+	// cancellation window of a policy
+	LateCancel bool `json:"lateCancel,omitempty"`
+	// CheckedInAt is when the owner showed up
+	CheckedInAt utc.UTC `json:"checkedInAt,omitempty"`
+	// CheckedInBy is the user who checked in
+	CheckedInBy string `json:"checkedInBy,omitempty"`
+	// NoShow is set when the reservation has not been checked in by the end
+	// of the grace period
+	NoShow bool `json:"noShow,omitempty"`
+	// Version is incremented every time the reservation is rescheduled
+	Version uint64 `json:"version"`
 }
 
+// ReservationStatus is the state of a reservation
+type ReservationStatus string
+
+const (
+	ReservationActive    ReservationStatus = "active"
+	ReservationCancelled ReservationStatus = "cancelled"
+)
+
 func (r *Reservation) Interval() interval.Interval {
 	return &timespan.Span{Start: r.From, End: r.To}
 }
 
+// seats returns the number of seats consumed by the reservation
+func (r *Reservation) seats() uint64 {
+	if r.Seats < 1 {
+		return 1
+	}
+	return uint64(r.Seats)
+}
+
+// BookingRequest describes a reservation to make
+type BookingRequest struct {
+	RoomRef string
+	From    utc.UTC
+	Hours   int64
+	// Seats is the number of seats to reserve (1 by default)
+	Seats int
+	// OnBehalfOf is the user who will own the reservation. It defaults to the
+	// current user, who must otherwise be a delegate of that user.
+	OnBehalfOf string
+}
+
+// ArchiveSummary aggregates the archived reservations of a room on a day
+type ArchiveSummary struct {
+	RoomRef      string  `json:"roomRef"`
+	Day          utc.UTC `json:"day"`
+	Reservations int     `json:"reservations"`
+	// Minutes is the total time reserved
+	Minutes int64 `json:"minutes"`
+}
+
+// IdempotencyRecord is the reservation created by a request with an
+// idempotency key
+type IdempotencyRecord struct {
+	UserID string
+	Key    string
+	// Fingerprint identifies the request which created the reservation
+	Fingerprint string
+	Reservation Reservation
+	ExpiresAt   utc.UTC
+}
+
+// ApprovalStatus is the state of an approval request
+type ApprovalStatus string
+
+func (s ApprovalStatus) String() string {
+	return string(s)
+}
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+)
+
+// ApprovalRequest is a reservation request on a room which requires approval
+type ApprovalRequest struct {
+	ID      string         `json:"id"`
+	RoomRef string         `json:"roomRef"`
+	UserID  string         `json:"userId"`
+	From    utc.UTC        `json:"from"`
+	To      utc.UTC        `json:"to"`
+	Seats   int            `json:"seats,omitempty"`
+	Status  ApprovalStatus `json:"status"`
+	// CreatedBy is the user who made the request on behalf of UserID
+	CreatedBy string `json:"createdBy,omitempty"`
+	// Reason explains the decision
+	Reason    string  `json:"reason,omitempty"`
+	DecidedBy string  `json:"decidedBy,omitempty"`
+	DecidedAt utc.UTC `json:"decidedAt,omitempty"`
+	// ReservationID is the reservation created once approved
+	ReservationID string  `json:"reservationId,omitempty"`
+	CreatedAt     utc.UTC `json:"createdAt"`
+	// ExpiresAt is when the request is rejected unless it has been decided
+	ExpiresAt utc.UTC `json:"expiresAt"`
+}
+
+// reservation returns the reservation requested
+func (a *ApprovalRequest) reservation() *Reservation {
+	return &Reservation{
+		From:      a.From,
+		To:        a.To,
+		RoomRef:   a.RoomRef,
+		UserID:    a.UserID,
+		CreatedBy: a.CreatedBy,
+		Seats:     a.Seats,
+	}
+}
+
 // TimeInterval represents a contiguous range of time periods
 type TimeInterval struct {
 	From utc.UTC `json:"from"`
 	To   utc.UTC `json:"to"`
+	// Capacity is the number of seats left on the interval
+	Capacity int `json:"capacity,omitempty"`
 }
 
 // Duration returns the distance in time between From and To
@@ -0,0 +1,456 @@
+package booking
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/timeutil/timespan"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+)
+
+const (
+	// defaultUtilizationPeriod is the period covered by reports when no
+	// interval is given
+	defaultUtilizationPeriod = 30 * 24 * time.Hour
+	// maxUtilizationBuckets limits the size of reports
+	maxUtilizationBuckets = 1000
+
+	// unassignedGroup is the key of reservations made by users without a group
+	unassignedGroup = "unassigned"
+)
+
+// Bucket is the period of time covered by each utilization entry
+type Bucket string
+
+const (
+	BucketDay   Bucket = "day"
+	BucketWeek  Bucket = "week"
+	BucketMonth Bucket = "month"
+)
+
+// start returns the beginning of the bucket which contains `t`. Weeks start on
+// Monday.
+func (b Bucket) start(t utc.UTC) utc.UTC {
+	tm := t.Time()
+	switch b {
+	case BucketWeek:
+		day := t.Floor(24 * time.Hour)
+		offset := (int(tm.Weekday()) + 6) % 7
+		return day.Add(-time.Duration(offset) * 24 * time.Hour)
+	case BucketMonth:
+		return utc.UTC(time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	default:
+		return t.Floor(24 * time.Hour)
+	}
+}
+
+// next returns the beginning of the bucket following the one starting at `t`
+func (b Bucket) next(t utc.UTC) utc.UTC {
+	switch b {
+	case BucketWeek:
+		return t.Add(7 * 24 * time.Hour)
+	case BucketMonth:
+		return utc.UTC(t.Time().AddDate(0, 1, 0).UnixNano())
+	default:
+		return t.Add(24 * time.Hour)
+	}
+}
+
+// Grouping defines how utilization entries are aggregated
+type Grouping string
+
+const (
+	GroupByRoom  Grouping = "room"
+	GroupByGroup Grouping = "group"
+)
+
+// UtilizationQuery selects the reservations measured by a report
+type UtilizationQuery struct {
+	From    utc.UTC
+	To      utc.UTC
+	Bucket  Bucket
+	GroupBy Grouping
+	// Rooms restricts the report to these rooms. All rooms are included when
+	// it is empty.
+	Rooms []string
+}
+
+// Utilization measures how a room, or the rooms booked by a group, have been
+// used over a period of time
+type Utilization struct {
+	// Key is either a room reference or a group ID
+	Key    string  `json:"key"`
+	Period utc.UTC `json:"period"`
+	// BookedHours is the time reserved multiplied by the seats reserved
+	// (cancelled reservations excluded)
+	BookedHours float64 `json:"bookedHours"`
+	// Occupancy is the percentage of the seat time reserved, i.e. BookedHours
+	// relative to the capacity of the room over the period. For groups, it is
+	// relative to all rooms in the report.
+	Occupancy float64 `json:"occupancy"`
+	// PeakHours are the hours of the day (UTC) with the most seat time reserved
+	PeakHours []int `json:"peakHours"`
+	// AverageLeadTimeHours is the average time between a reservation and its
+	// start (cancelled reservations and reservations made after their start
+	// excluded)
+	AverageLeadTimeHours float64 `json:"averageLeadTimeHours"`
+	// Reservations starting within the period, including cancelled ones
+	Reservations     int     `json:"reservations"`
+	Cancellations    int     `json:"cancellations"`
+	CancellationRate float64 `json:"cancellationRate"`
+	// LateCancellations are the cancellations made within the cancellation
+	// window of a policy
+	LateCancellations int `json:"lateCancellations"`
+}
+
+// utilizationEntry accumulates measurements of an Utilization
+type utilizationEntry struct {
+	u        *Utilization
+	booked   time.Duration
+	capacity time.Duration
+	lead     time.Duration
+	leads    int
+	hours    [24]time.Duration
+}
+
+// Utilization reports room usage within the query interval, per room or
+// group and per bucket. It is restricted to admins.
+//
+// Reservations are read from the timeline projection, which includes
+// archived and cancelled reservations.
+func (s *Service) Utilization(
+	ctx context.Context, q UtilizationQuery,
+) ([]*Utilization, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	if q.To == 0 {
+		q.To = utc.Now().Ceil(24 * time.Hour)
+	}
+	if q.From == 0 {
+		q.From = q.To.Add(-defaultUtilizationPeriod)
+	}
+	if q.To <= q.From {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to must be after from",
+		})
+	}
+	switch q.Bucket {
+	case "":
+		q.Bucket = BucketDay
+	case BucketDay, BucketWeek, BucketMonth:
+	default:
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "bucket",
+			Description: "The bucket must be day, week or month",
+		})
+	}
+	switch q.GroupBy {
+	case "":
+		q.GroupBy = GroupByRoom
+	case GroupByRoom, GroupByGroup:
+	default:
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "groupBy",
+			Description: "Reports can be grouped by room or group",
+		})
+	}
+
+	// Split the interval in buckets
+	var periods []*timespan.Span
+	for start := q.Bucket.start(q.From); start < q.To; start = q.Bucket.next(start) {
+		if len(periods) == maxUtilizationBuckets {
+			return nil, errors.Bad(&errors.FieldViolation{
+				Field:       "bucket",
+				Description: "Too many buckets. Use a larger bucket or a shorter interval",
+			})
+		}
+		periods = append(periods, &timespan.Span{
+			Start: maxUTC(start, q.From),
+			End:   minUTC(q.Bucket.next(start), q.To),
+		})
+	}
+
+	rooms, err := s.reportRooms(ctx, q.Rooms)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]map[utc.UTC]*utilizationEntry{}
+	entry := func(key string, period *timespan.Span) *utilizationEntry {
+		byPeriod, ok := entries[key]
+		if !ok {
+			byPeriod = map[utc.UTC]*utilizationEntry{}
+			entries[key] = byPeriod
+		}
+		e, ok := byPeriod[period.Start]
+		if !ok {
+			e = &utilizationEntry{u: &Utilization{Key: key, Period: period.Start}}
+			byPeriod[period.Start] = e
+		}
+		return e
+	}
+	periodOf := func(t utc.UTC) *timespan.Span {
+		i := sort.Search(len(periods), func(i int) bool { return periods[i].End > t })
+		if i == len(periods) || t < periods[i].Start {
+			return nil
+		}
+		return periods[i]
+	}
+
+	groups := map[string]string{}
+	keyOf := func(roomRef, userID string) (string, error) {
+		if q.GroupBy == GroupByRoom {
+			return roomRef, nil
+		}
+		if g, ok := groups[userID]; ok {
+			return g, nil
+		}
+		g := unassignedGroup
+		usr, err := s.iam.GetUser(ctx, userID)
+		switch {
+		case err == nil:
+			if usr.GroupID != "" {
+				g = usr.GroupID
+			}
+		case errors.IsNotFound(err):
+		default:
+			return "", err
+		}
+		groups[userID] = g
+		return g, nil
+	}
+
+	for _, room := range rooms {
+		roomRef := room.Ref
+		reservations, err := s.reservations.Timeline(ctx, roomRef, q.From, q.To)
+		if err != nil {
+			return nil, err
+		}
+
+		if q.GroupBy == GroupByRoom {
+			// Report unused rooms too
+			for _, p := range periods {
+				entry(roomRef, p)
+			}
+		}
+
+		for _, res := range reservations {
+			key, err := keyOf(roomRef, res.UserID)
+			if err != nil {
+				return nil, err
+			}
+
+			if p := periodOf(res.From); p != nil {
+				e := entry(key, p)
+				e.u.Reservations++
+				switch {
+				case res.Cancelled:
+					e.u.Cancellations++
+					if res.LateCancel {
+						e.u.LateCancellations++
+					}
+				case res.From >= res.ReservedAt:
+					e.lead += res.From.Distance(res.ReservedAt)
+					e.leads++
+				}
+				// Reservations made after they started (e.g. imported) have
+				// no lead time, so they are left out of the average
+			}
+			if res.Cancelled {
+				continue
+			}
+
+			// Measure the reserved seat time within each period
+			seats := time.Duration(res.seats())
+			set := timespan.Empty()
+			set.Insert(res.From, res.To)
+			for _, p := range periods {
+				if p.End <= res.From || p.Start >= res.To {
+					continue
+				}
+				e := entry(key, p)
+				iter := set.IntervalsBetween(p).Iterator()
+				for iter.Advance() {
+					span := iter.Get().(*timespan.Span)
+					e.booked += seats * span.Duration()
+					for t := span.Start; t < span.End; {
+						next := minUTC(t.Floor(time.Hour).Add(time.Hour), span.End)
+						e.hours[t.Time().Hour()] += seats * next.Distance(t)
+						t = next
+					}
+				}
+			}
+		}
+	}
+
+	// Capacity is the seat time the room (or all rooms of the report) could
+	// have been reserved
+	seats := map[string]uint64{}
+	var totalSeats uint64
+	for _, room := range rooms {
+		seats[room.Ref] = room.capacity()
+		totalSeats += room.capacity()
+	}
+	var l []*Utilization
+	for key, byPeriod := range entries {
+		for _, e := range byPeriod {
+			p := periodOf(e.u.Period)
+			n := totalSeats
+			if q.GroupBy == GroupByRoom {
+				n = seats[key]
+			}
+			e.capacity = time.Duration(n) * p.Duration()
+			l = append(l, e.measure())
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Key != l[j].Key {
+			return l[i].Key < l[j].Key
+		}
+		return l[i].Period < l[j].Period
+	})
+	return l, nil
+}
+
+// measure computes the ratios of the entry
+func (e *utilizationEntry) measure() *Utilization {
+	u := e.u
+	u.BookedHours = e.booked.Hours()
+	if e.capacity > 0 {
+		u.Occupancy = 100 * float64(e.booked) / float64(e.capacity)
+	}
+	if e.leads > 0 {
+		u.AverageLeadTimeHours = (e.lead / time.Duration(e.leads)).Hours()
+	}
+	if u.Reservations > 0 {
+		u.CancellationRate = float64(u.Cancellations) / float64(u.Reservations)
+	}
+
+	var peak time.Duration
+	for _, d := range e.hours {
+		if d > peak {
+			peak = d
+		}
+	}
+	u.PeakHours = []int{}
+	if peak > 0 {
+		for h, d := range e.hours {
+			if d == peak {
+				u.PeakHours = append(u.PeakHours, h)
+			}
+		}
+	}
+	return u
+}
+
+// WriteUtilizationCSV writes `report` as CSV, with a header row
+func WriteUtilizationCSV(w io.Writer, report []*Utilization) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"key",
+		"period",
+		"booked_hours",
+		"occupancy",
+		"peak_hours",
+		"average_lead_time_hours",
+		"reservations",
+		"cancellations",
+		"cancellation_rate",
+		"late_cancellations",
+	})
+	for _, u := range report {
+		peaks := make([]string, len(u.PeakHours))
+		for i, h := range u.PeakHours {
+			peaks[i] = strconv.Itoa(h)
+		}
+		cw.Write([]string{
+			u.Key,
+			u.Period.Time().Format("2006-01-02"),
+			strconv.FormatFloat(u.BookedHours, 'f', 2, 64),
+			strconv.FormatFloat(u.Occupancy, 'f', 2, 64),
+			strings.Join(peaks, ";"),
+			strconv.FormatFloat(u.AverageLeadTimeHours, 'f', 2, 64),
+			strconv.Itoa(u.Reservations),
+			strconv.Itoa(u.Cancellations),
+			strconv.FormatFloat(u.CancellationRate, 'f', 4, 64),
+			strconv.Itoa(u.LateCancellations),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reportRooms returns rooms `refs`, or all rooms which exist or have been
+// booked when it is empty. Rooms which do not exist (anymore) are returned
+// with their reference only.
+func (s *Service) reportRooms(ctx context.Context, refs []string) ([]*Room, error) {
+	seen := map[string]bool{}
+	var rooms []*Room
+	add := func(room *Room) {
+		room.Ref = strings.TrimSpace(strings.ToUpper(room.Ref))
+		if room.Ref != "" && !seen[room.Ref] {
+			seen[room.Ref] = true
+			rooms = append(rooms, room)
+		}
+	}
+
+	if len(refs) > 0 {
+		for _, ref := range refs {
+			ref = strings.TrimSpace(strings.ToUpper(ref))
+			if ref == "" || seen[ref] {
+				continue
+			}
+			room, err := s.rooms.Get(ctx, ref)
+			switch {
+			case err == nil:
+				add(room)
+			case errors.IsNotFound(err):
+				add(&Room{Ref: ref})
+			default:
+				return nil, err
+			}
+		}
+		return rooms, nil
+	}
+
+	l, err := s.rooms.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, room := range l {
+		add(room)
+	}
+	logged, err := s.reservations.Rooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range logged {
+		add(&Room{Ref: ref})
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Ref < rooms[j].Ref })
+	return rooms, nil
+}
+
+func minUTC(a, b utc.UTC) utc.UTC {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUTC(a, b utc.UTC) utc.UTC {
+	if a > b {
+		return a
+	}
+	return b
+}
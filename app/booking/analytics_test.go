@@ -0,0 +1,194 @@
+package booking_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/pkg/utc"
+)
+
+// TestService_Utilization ensures room usage is measured per room, group
+// and bucket, and that seats are weighted against the room capacity
+func TestService_Utilization(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening user repository", err)
+	}
+	err = users.Create(ctx, &iam.User{ID: "admin", GroupID: "g1", Roles: []iam.Role{iam.RoleAdmin}})
+	if err != nil {
+		t.Fatal("error creating user", err)
+	}
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "admin"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	if _, err := svc.ReserveRoom(ctx, "C01", utc.MustParse("2021-08-02T10:00:00Z"), 2); err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	res, err := svc.ReserveRoom(ctx, "C01", utc.MustParse("2021-08-02T14:00:00Z"), 1)
+	if err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	if err := svc.CancelRoomReservation(ctx, "C01", res.ID, 0); err != nil {
+		t.Fatal("expect to cancel a reservation, but got", err)
+	}
+
+	q := booking.UtilizationQuery{
+		From:  utc.MustParse("2021-08-02T00:00:00Z"),
+		To:    utc.MustParse("2021-08-04T00:00:00Z"),
+		Rooms: []string{"c01", "C02"},
+	}
+	report, err := svc.Utilization(ctx, q)
+	if err != nil {
+		t.Fatal("expect a report, but got", err)
+	}
+	if len(report) != 4 {
+		t.Fatalf("expect 2 rooms over 2 days, but got %d entries", len(report))
+	}
+	u := report[0]
+	if u.Key != "C01" || u.Period != q.From {
+		t.Fatalf("expect first entry to be C01 on the first day, but got %s %s", u.Key, u.Period)
+	}
+	if u.BookedHours != 2 || math.Abs(u.Occupancy-100.0/12) > 0.001 {
+		t.Errorf("expect 2 booked hours (8.33%%), but got %f (%f%%)", u.BookedHours, u.Occupancy)
+	}
+	if len(u.PeakHours) != 2 || u.PeakHours[0] != 10 || u.PeakHours[1] != 11 {
+		t.Errorf("expect peak hours 10 and 11, but got %v", u.PeakHours)
+	}
+	if u.Reservations != 2 || u.Cancellations != 1 || u.CancellationRate != 0.5 {
+		t.Errorf("expect half of 2 reservations to be cancelled, but got %d/%d", u.Cancellations, u.Reservations)
+	}
+	if report[3].Key != "C02" || report[3].BookedHours != 0 {
+		t.Errorf("expect unused room to be reported, but got %s", report[3].Key)
+	}
+
+	q.GroupBy = booking.GroupByGroup
+	q.Bucket = booking.BucketWeek
+	report, err = svc.Utilization(ctx, q)
+	if err != nil {
+		t.Fatal("expect a report, but got", err)
+	}
+	if len(report) != 1 || report[0].Key != "g1" {
+		t.Fatalf("expect a single entry for group g1, but got %d", len(report))
+	}
+	if math.Abs(report[0].Occupancy-100.0/48) > 0.001 {
+		t.Errorf("expect occupancy relative to 2 rooms over 2 days, but got %f", report[0].Occupancy)
+	}
+
+	var buf bytes.Buffer
+	if err := booking.WriteUtilizationCSV(&buf, report); err != nil {
+		t.Fatal("expect to write CSV, but got", err)
+	}
+	expect := "key,period,booked_hours,occupancy,peak_hours,average_lead_time_hours,reservations,cancellations,cancellation_rate,late_cancellations\n" +
+		"g1,2021-08-02,2.00,2.08,10;11,0.00,2,1,0.5000,0\n"
+	if buf.String() != expect {
+		t.Errorf("expect CSV\n%s\nbut got\n%s", expect, buf.String())
+	}
+
+	// Seats are weighted against the capacity of the room
+	err = svc.CreateRoom(ctx, &booking.Room{Ref: "HOTDESK", Capacity: 2})
+	if err != nil {
+		t.Fatal("expect to create a room, but got", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, _, err := svc.ReserveRoomOnce(ctx, "", booking.BookingRequest{
+			RoomRef: "HOTDESK",
+			From:    utc.MustParse("2021-08-02T00:00:00Z"),
+			Hours:   24,
+		})
+		if err != nil {
+			t.Fatal("expect to reserve a seat, but got", err)
+		}
+	}
+	report, err = svc.Utilization(ctx, booking.UtilizationQuery{
+		From:  utc.MustParse("2021-08-02T00:00:00Z"),
+		To:    utc.MustParse("2021-08-03T00:00:00Z"),
+		Rooms: []string{"HOTDESK"},
+	})
+	if err != nil {
+		t.Fatal("expect a report, but got", err)
+	}
+	if len(report) != 1 || report[0].BookedHours != 48 || report[0].Occupancy != 100 {
+		t.Errorf("expect a full room (48 seat hours), but got %+v", report[0])
+	}
+
+	// Reservations which started before the period are measured within it
+	if _, err := svc.ReserveRoom(ctx, "C02", utc.MustParse("2021-08-05T23:00:00Z"), 2); err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	report, err = svc.Utilization(ctx, booking.UtilizationQuery{
+		From:  utc.MustParse("2021-08-06T00:00:00Z"),
+		To:    utc.MustParse("2021-08-07T00:00:00Z"),
+		Rooms: []string{"C02"},
+	})
+	if err != nil {
+		t.Fatal("expect a report, but got", err)
+	}
+	if len(report) != 1 || report[0].BookedHours != 1 || report[0].Reservations != 0 {
+		t.Errorf("expect 1 booked hour and no reservation started, but got %+v", report[0])
+	}
+}
+
+// TestService_UtilizationLeadTime ensures reservations made after they
+// started are left out of the average lead time
+func TestService_UtilizationLeadTime(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening user repository", err)
+	}
+	err = users.Create(ctx, &iam.User{ID: "admin", GroupID: "g1", Roles: []iam.Role{iam.RoleAdmin}})
+	if err != nil {
+		t.Fatal("error creating user", err)
+	}
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "admin"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+
+	// Both reservations start within the current month
+	now := utc.Now()
+	month := utc.Convert(time.Date(now.Time().Year(), now.Time().Month(), 1, 0, 0, 0, 0, time.UTC))
+	next := utc.Convert(month.Time().AddDate(0, 1, 0))
+	future := now.Floor(time.Hour).Add(2 * time.Hour)
+	if future >= next {
+		t.Skip("the month is about to end")
+	}
+	if _, err := svc.ReserveRoom(ctx, "C01", month, 1); err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	if _, err := svc.ReserveRoom(ctx, "C01", future, 1); err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+
+	report, err := svc.Utilization(ctx, booking.UtilizationQuery{
+		From:   month,
+		To:     next,
+		Bucket: booking.BucketMonth,
+		Rooms:  []string{"C01"},
+	})
+	if err != nil {
+		t.Fatal("expect a report, but got", err)
+	}
+	if len(report) != 1 || report[0].Reservations != 2 {
+		t.Fatalf("expect 2 reservations in a single entry, but got %+v", report)
+	}
+	if lead := report[0].AverageLeadTimeHours; lead < 1 || lead > 2 {
+		t.Errorf("expect lead time of the future reservation only (1-2h), but got %f", lead)
+	}
+}
@@ -0,0 +1,103 @@
+package booking
+
+import (
+	"context"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+)
+
+const (
+	// DefaultArchiveHorizon is how long reservations stay in room schedules
+	// after they ended
+	DefaultArchiveHorizon = 30 * 24 * time.Hour
+
+	// archiveInterval defines how often the archiver runs
+	archiveInterval = time.Hour
+	// archiveBatchSize is the maximum number of reservations archived per
+	// transaction
+	archiveBatchSize = 100
+
+	// jobArchive is the scheduler target of the archiver
+	jobArchive = "booking.archive"
+)
+
+// Archiver moves past reservations out of room schedules, so that reads only
+// ever touch current and future reservations.
+//
+// It runs as a recurring job of the scheduler, so only one node runs it at a
+// time.
+type Archiver struct {
+	// Horizon is how long reservations stay in room schedules after they ended
+	Horizon time.Duration
+	// Summarise only keeps daily summaries of archived reservations
+	Summarise bool
+
+	reservations *ReservationRepository
+}
+
+func NewArchiver(ctx context.Context) (*Archiver, error) {
+	reservations, err := NewReservationRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise reservation repository")
+	}
+
+	return &Archiver{
+		Horizon:      DefaultArchiveHorizon,
+		reservations: reservations,
+	}, nil
+}
+
+// Schedule registers the archiver on scheduler `s` and schedules it every
+// `archiveInterval`
+func (a *Archiver) Schedule(ctx context.Context, s *job.Scheduler) error {
+	_, err := s.HandleFunc(jobArchive, func(ctx context.Context, id string, data []byte) error {
+		_, err := a.Run(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.Every(ctx, archiveInterval, jobArchive, nil); err != nil {
+		return errors.Wrapf(err, "failed to schedule %s", jobArchive)
+	}
+	return nil
+}
+
+// Run archives all reservations which ended before the horizon. It returns
+// the number of archived reservations.
+func (a *Archiver) Run(ctx context.Context) (int, error) {
+	rooms, err := a.reservations.Rooms(ctx)
+	if err != nil {
+		return 0, err
+	}
+	before := utc.Now().Add(-a.Horizon)
+
+	var total int
+	for _, roomRef := range rooms {
+		for {
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+
+			n, err := a.reservations.Archive(ctx, roomRef, before, a.Summarise, archiveBatchSize)
+			if err != nil {
+				return total, errors.Wrapf(err, "failed to archive room %s", roomRef)
+			}
+			total += n
+			if n < archiveBatchSize {
+				break
+			}
+		}
+	}
+	if total > 0 {
+		log.Trace(ctx, "booking.archive", "Reservations archived",
+			log.Int("reservations", total),
+			log.Stringer("before", before),
+		)
+	}
+	return total, nil
+}
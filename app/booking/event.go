@@ -0,0 +1,179 @@
+package booking
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/eventdb"
+)
+
+func init() {
+	eventdb.RegisterEvent((*ReservedEvent)(nil), "booking.Reserved")
+	eventdb.RegisterEvent((*CancelledEvent)(nil), "booking.Cancelled")
+	eventdb.RegisterEvent((*RescheduledEvent)(nil), "booking.Rescheduled")
+	eventdb.RegisterEvent((*ArchivedEvent)(nil), "booking.Archived")
+	eventdb.RegisterEvent((*CheckedInEvent)(nil), "booking.CheckedIn")
+	eventdb.RegisterEvent((*NoShowEvent)(nil), "booking.NoShow")
+}
+
+// Event is a change recorded on the reservation log of a room.
+//
+// The log is the source of truth. Room schedules and user indexes are
+// projections of it, which means they can be rebuilt by replaying all events.
+type Event interface {
+	eventdb.Event
+
+	// Room returns the reference of the room on which the event occurred
+	Room() string
+}
+
+// RecordedEvent is an event read from the reservation log
+type RecordedEvent struct {
+	// Version is the position of the event in the room log
+	Version uint64
+	Event   Event
+}
+
+// ReservedEvent is recorded when a room is reserved
+type ReservedEvent struct {
+	Reservation Reservation
+	At          utc.UTC
+}
+
+func (e *ReservedEvent) Room() string {
+	return e.Reservation.RoomRef
+}
+
+func (e *ReservedEvent) MarshalEvent() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+func (e *ReservedEvent) UnmarshalEvent(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// CancelledEvent is recorded when a reservation is cancelled
+type CancelledEvent struct {
+	RoomRef       string
+	ReservationID string
+	UserID        string
+	// CancelledBy is the user who cancelled the reservation
+	CancelledBy string
+	// Late is set when the reservation was cancelled within the cancellation
+	// window of a policy
+	Late bool
+	At   utc.UTC
+}
+
+func (e *CancelledEvent) Room() string {
+	return e.RoomRef
+}
+
+func (e *CancelledEvent) MarshalEvent() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+func (e *CancelledEvent) UnmarshalEvent(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// RescheduledEvent is recorded when a reservation is moved to another
+// time interval
+type RescheduledEvent struct {
+	RoomRef       string
+	ReservationID string
+	UserID        string
+	From          utc.UTC
+	To            utc.UTC
+	At            utc.UTC
+}
+
+func (e *RescheduledEvent) Room() string {
+	return e.RoomRef
+}
+
+func (e *RescheduledEvent) MarshalEvent() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+func (e *RescheduledEvent) UnmarshalEvent(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// ArchivedEvent is recorded when a past reservation is moved out of the room
+// schedule to the archive
+type ArchivedEvent struct {
+	Reservation Reservation
+	// Summarised is set when only the daily summary of the reservation is
+	// archived
+	Summarised bool
+	At         utc.UTC
+}
+
+func (e *ArchivedEvent) Room() string {
+	return e.Reservation.RoomRef
+}
+
+func (e *ArchivedEvent) MarshalEvent() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+func (e *ArchivedEvent) UnmarshalEvent(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// CheckedInEvent is recorded when the owner of a reservation shows up
+type CheckedInEvent struct {
+	RoomRef       string
+	ReservationID string
+	UserID        string
+	// CheckedInBy is the user who checked in
+	CheckedInBy string
+	At          utc.UTC
+}
+
+func (e *CheckedInEvent) Room() string {
+	return e.RoomRef
+}
+
+func (e *CheckedInEvent) MarshalEvent() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+func (e *CheckedInEvent) UnmarshalEvent(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// NoShowEvent is recorded when a reservation has not been checked in by the
+// end of the grace period
+type NoShowEvent struct {
+	RoomRef       string
+	ReservationID string
+	UserID        string
+	At            utc.UTC
+}
+
+func (e *NoShowEvent) Room() string {
+	return e.RoomRef
+}
+
+func (e *NoShowEvent) MarshalEvent() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+func (e *NoShowEvent) UnmarshalEvent(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+func marshalEvent(e Event) ([]byte, error) {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(e); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+func unmarshalEvent(data []byte, e Event) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(e)
+}
@@ -0,0 +1,81 @@
+package booking
+
+import (
+	"context"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+)
+
+const (
+	// expireInterval defines how often expired records are cleaned up
+	expireInterval = 15 * time.Minute
+	// expireBatchSize is the maximum number of records expired per
+	// transaction
+	expireBatchSize = 100
+
+	// jobExpire is the scheduler target of the expirer
+	jobExpire = "booking.expire"
+)
+
+// Expirer purges expired idempotency keys and rejects pending approval
+// requests which expired. It runs as a recurring job of the scheduler, so
+// only one node runs it at a time.
+type Expirer struct {
+	idempotency *IdempotencyRepository
+	approvals   *ApprovalRepository
+}
+
+func NewExpirer(ctx context.Context) (*Expirer, error) {
+	idempotency, err := NewIdempotencyRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise idempotency repository")
+	}
+	approvals, err := NewApprovalRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise approval repository")
+	}
+
+	return &Expirer{
+		idempotency: idempotency,
+		approvals:   approvals,
+	}, nil
+}
+
+// Schedule registers the expirer on scheduler `s` and schedules it every
+// `expireInterval`
+func (e *Expirer) Schedule(ctx context.Context, s *job.Scheduler) error {
+	_, err := s.HandleFunc(jobExpire, func(ctx context.Context, id string, data []byte) error {
+		return e.Run(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.Every(ctx, expireInterval, jobExpire, nil); err != nil {
+		return errors.Wrapf(err, "failed to schedule %s", jobExpire)
+	}
+	return nil
+}
+
+// Run purges all expired idempotency keys and rejects all expired approval
+// requests
+func (e *Expirer) Run(ctx context.Context) error {
+	now := utc.Now()
+	for _, expire := range []func(context.Context, utc.UTC, int) (int, error){
+		e.idempotency.Purge,
+		e.approvals.Expire,
+	} {
+		for {
+			n, err := expire(ctx, now, expireBatchSize)
+			if err != nil {
+				return err
+			}
+			if n < expireBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
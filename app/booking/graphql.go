@@ -0,0 +1,687 @@
+package booking
+
+import (
+	"context"
+	"sync"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const graphqlSchema = `
+	scalar Time
+
+	schema {
+		query: Query
+		mutation: Mutation
+	}
+
+	type Query {
+		rooms(cursor: String, limit: Int): RoomPage!
+		room(ref: String!): Room!
+		reservation(roomRef: String!, id: String!): Reservation!
+		me: User!
+	}
+
+	type Mutation {
+		reserveRoom(
+			roomRef: String!
+			from: Time!
+			hours: Int!
+			seats: Int
+			onBehalfOf: String
+			idempotencyKey: String
+		): Reservation!
+		cancelReservation(roomRef: String!, id: String!, version: Int): Reservation!
+	}
+
+	type RoomPage {
+		rooms: [Room!]!
+		next: String
+	}
+
+	type Room {
+		ref: String!
+		name: String!
+		tags: [String!]!
+		capacity: Int!
+		requiresApproval: Boolean!
+		version: Int!
+		# Free ranges between from and to with at least seats left (1 by default)
+		availabilities(from: Time!, to: Time!, seats: Int): [TimeInterval!]!
+		# Reservations overlapping [from, to)
+		reservations(from: Time, to: Time): [Reservation!]!
+	}
+
+	type Reservation {
+		id: String!
+		roomRef: String!
+		# Room is null when the room has not been created
+		room: Room
+		# User is restricted to the owner, their delegates and admins
+		user: User
+		from: Time!
+		to: Time!
+		seats: Int!
+		status: String!
+		createdBy: String
+		cancelledAt: Time
+		lateCancel: Boolean!
+		checkedInAt: Time
+		noShow: Boolean!
+		version: Int!
+	}
+
+	type TimeInterval {
+		from: Time!
+		to: Time!
+		capacity: Int!
+	}
+
+	type User {
+		id: String!
+		roles: [String!]!
+		group: Group
+		reservations: [Reservation!]!
+	}
+
+	type Group {
+		id: String!
+		ref: String!
+	}
+`
+
+// graphqlMaxDepth is the deepest selection accepted. Rooms and reservations
+// refer to each other, so queries would otherwise be able to nest them without
+// bounds.
+const graphqlMaxDepth = 8
+
+// GraphQLSchema returns the GraphQL schema of rooms, reservations and users
+func (s *Service) GraphQLSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &graphResolver{svc: s},
+		graphql.MaxDepth(graphqlMaxDepth),
+	)
+}
+
+type graphResolver struct {
+	svc *Service
+}
+
+func (r *graphResolver) Rooms(ctx context.Context, args struct {
+	Cursor *string
+	Limit  *int32
+}) (*roomPageResolver, error) {
+	p := Page{}
+	if args.Cursor != nil {
+		p.Cursor = *args.Cursor
+	}
+	if args.Limit != nil {
+		p.Limit = int(*args.Limit)
+	}
+	rooms, next, err := r.svc.ListRooms(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &roomPageResolver{
+		rooms: roomResolvers(r.svc, rooms),
+		next:  next,
+	}, nil
+}
+
+func (r *graphResolver) Room(ctx context.Context, args struct {
+	Ref string
+}) (*roomResolver, error) {
+	room, err := r.svc.GetRoom(ctx, args.Ref)
+	if err != nil {
+		return nil, err
+	}
+	return roomResolvers(r.svc, []*Room{room})[0], nil
+}
+
+func (r *graphResolver) Reservation(ctx context.Context, args struct {
+	RoomRef string
+	ID      string
+}) (*reservationResolver, error) {
+	res, err := r.svc.GetRoomReservation(ctx, args.RoomRef, args.ID)
+	if err != nil {
+		return nil, err
+	}
+	return reservationResolvers(r.svc, []*Reservation{res})[0], nil
+}
+
+func (r *graphResolver) Me(ctx context.Context) (*userResolver, error) {
+	u, err := r.svc.iam.GetProfile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{svc: r.svc, user: u}, nil
+}
+
+func (r *graphResolver) ReserveRoom(ctx context.Context, args struct {
+	RoomRef        string
+	From           graphql.Time
+	Hours          int32
+	Seats          *int32
+	OnBehalfOf     *string
+	IdempotencyKey *string
+}) (*reservationResolver, error) {
+	req := BookingRequest{
+		RoomRef: args.RoomRef,
+		From:    utc.Convert(args.From.Time),
+		Hours:   int64(args.Hours),
+	}
+	if args.Seats != nil {
+		req.Seats = int(*args.Seats)
+	}
+	if args.OnBehalfOf != nil {
+		req.OnBehalfOf = *args.OnBehalfOf
+	}
+	var key string
+	if args.IdempotencyKey != nil {
+		key = *args.IdempotencyKey
+	}
+
+	res, _, err := r.svc.ReserveRoomOnce(ctx, key, req)
+	if err != nil {
+		return nil, err
+	}
+	return reservationResolvers(r.svc, []*Reservation{res})[0], nil
+}
+
+func (r *graphResolver) CancelReservation(ctx context.Context, args struct {
+	RoomRef string
+	ID      string
+	Version *int32
+}) (*reservationResolver, error) {
+	var version uint64
+	if args.Version != nil {
+		version = uint64(*args.Version)
+	}
+	err := r.svc.CancelRoomReservation(ctx, args.RoomRef, args.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.svc.GetRoomReservation(ctx, args.RoomRef, args.ID)
+	if err != nil {
+		return nil, err
+	}
+	return reservationResolvers(r.svc, []*Reservation{res})[0], nil
+}
+
+// roomResolvers returns resolvers for `rooms` which load their schedules
+// together
+func roomResolvers(svc *Service, rooms []*Room) []*roomResolver {
+	loader := &scheduleLoader{
+		svc:   svc,
+		rooms: rooms,
+		calls: map[scheduleKey]*scheduleCall{},
+	}
+	resolvers := make([]*roomResolver, len(rooms))
+	for i, room := range rooms {
+		resolvers[i] = &roomResolver{svc: svc, room: room, loader: loader}
+	}
+	return resolvers
+}
+
+// reservationResolvers returns resolvers for `reservations` which load their
+// rooms and users together. Rooms which have already been resolved can be
+// passed in `known`.
+func reservationResolvers(
+	svc *Service, reservations []*Reservation, known ...*roomResolver,
+) []*reservationResolver {
+	rooms := &roomLoader{
+		svc:   svc,
+		rooms: map[string]*roomResolver{},
+	}
+	for _, r := range known {
+		rooms.rooms[r.room.Ref] = r
+	}
+	users := &userLoader{
+		svc:    svc,
+		users:  map[string]*userResolver{},
+		denied: map[string]bool{},
+	}
+	resolvers := make([]*reservationResolver, len(reservations))
+	for i, res := range reservations {
+		if _, ok := rooms.rooms[res.RoomRef]; !ok {
+			rooms.refs = append(rooms.refs, res.RoomRef)
+		}
+		users.ids = append(users.ids, res.UserID)
+		resolvers[i] = &reservationResolver{
+			svc:   svc,
+			res:   res,
+			rooms: rooms,
+			users: users,
+		}
+	}
+	return resolvers
+}
+
+// scheduleLoader loads the availabilities and reservations of rooms listed
+// together the first time one of them is resolved. Otherwise, a query over all
+// rooms would read reservations once per room.
+//
+// Loads are keyed by their arguments, since aliased fields can ask for
+// different ranges.
+type scheduleLoader struct {
+	svc   *Service
+	rooms []*Room
+
+	mu    sync.Mutex
+	calls map[scheduleKey]*scheduleCall
+}
+
+// scheduleKey identifies a load
+type scheduleKey struct {
+	field    string
+	from, to utc.UTC
+}
+
+// scheduleCall is a load which runs once
+type scheduleCall struct {
+	once sync.Once
+	v    interface{}
+	err  error
+}
+
+// do runs `f` the first time it is called with `key`, and returns its result
+func (l *scheduleLoader) do(
+	key scheduleKey, f func() (interface{}, error),
+) (interface{}, error) {
+	l.mu.Lock()
+	c, ok := l.calls[key]
+	if !ok {
+		c = &scheduleCall{}
+		l.calls[key] = c
+	}
+	l.mu.Unlock()
+
+	c.once.Do(func() {
+		c.v, c.err = f()
+	})
+	return c.v, c.err
+}
+
+// availabilities returns the free ranges of room `ref` between `from` and `to`
+func (l *scheduleLoader) availabilities(
+	ctx context.Context, ref string, from, to utc.UTC,
+) ([]*TimeInterval, error) {
+	key := scheduleKey{field: "availabilities", from: from, to: to}
+	v, err := l.do(key, func() (interface{}, error) {
+		return l.svc.ListRoomsAvailabilities(ctx, l.rooms, from, to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string][]*TimeInterval)[ref], nil
+}
+
+// reservations returns the reservations of room `ref` overlapping [from, to)
+func (l *scheduleLoader) reservations(
+	ctx context.Context, ref string, from, to utc.UTC,
+) ([]*Reservation, error) {
+	key := scheduleKey{field: "reservations", from: from, to: to}
+	v, err := l.do(key, func() (interface{}, error) {
+		return l.svc.ListRoomsReservations(ctx, l.rooms, from, to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string][]*Reservation)[ref], nil
+}
+
+// roomLoader loads the rooms of reservations listed together the first time
+// one of them is resolved
+type roomLoader struct {
+	svc *Service
+	// refs are the rooms to load
+	refs []string
+	// rooms are the rooms loaded (or known beforehand)
+	rooms map[string]*roomResolver
+
+	once sync.Once
+	err  error
+}
+
+// load returns room `ref`, or nil when it has not been created
+func (l *roomLoader) load(ctx context.Context, ref string) (*roomResolver, error) {
+	l.once.Do(func() {
+		l.err = l.loadAll(ctx)
+	})
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.rooms[ref], nil
+}
+
+func (l *roomLoader) loadAll(ctx context.Context) error {
+	if len(l.refs) == 0 {
+		return nil
+	}
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			var rooms []*Room
+			seen := map[string]bool{}
+			for _, ref := range l.refs {
+				if seen[ref] {
+					continue
+				}
+				seen[ref] = true
+
+				room, err := l.svc.rooms.Get(ctx, ref)
+				switch {
+				case err == nil:
+					rooms = append(rooms, room)
+				case errors.IsNotFound(err):
+				default:
+					return nil, err
+				}
+			}
+			return rooms, nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for _, r := range roomResolvers(l.svc, v.([]*Room)) {
+		l.rooms[r.room.Ref] = r
+	}
+	return nil
+}
+
+// userLoader loads the owners of reservations listed together the first time
+// one of them is resolved. Owners are only visible to themselves, their
+// delegates and admins.
+type userLoader struct {
+	svc *Service
+	// ids are the users to load
+	ids []string
+	// users are the users loaded
+	users map[string]*userResolver
+	// denied are the users the current user cannot see
+	denied map[string]bool
+
+	once sync.Once
+	err  error
+}
+
+// load returns user `id`, or nil when it does not exist
+func (l *userLoader) load(ctx context.Context, id string) (*userResolver, error) {
+	l.once.Do(func() {
+		l.err = l.loadAll(ctx)
+	})
+	if l.err != nil {
+		return nil, l.err
+	}
+	if l.denied[id] {
+		return nil, errors.PermissionDenied
+	}
+	return l.users[id], nil
+}
+
+func (l *userLoader) loadAll(ctx context.Context) error {
+	_, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			for _, id := range l.ids {
+				if _, ok := l.users[id]; ok || l.denied[id] {
+					continue
+				}
+
+				switch err := l.svc.requireOwner(ctx, id); {
+				case err == nil:
+				case errors.IsPermissionDenied(err):
+					l.denied[id] = true
+					continue
+				default:
+					return nil, err
+				}
+				u, err := l.svc.iam.GetUser(ctx, id)
+				switch {
+				case err == nil:
+					l.users[id] = &userResolver{svc: l.svc, user: u}
+				case errors.IsNotFound(err):
+				default:
+					return nil, err
+				}
+			}
+			return nil, nil
+		},
+	)
+	return err
+}
+
+type roomPageResolver struct {
+	rooms []*roomResolver
+	next  string
+}
+
+func (r *roomPageResolver) Rooms() []*roomResolver {
+	return r.rooms
+}
+
+func (r *roomPageResolver) Next() *string {
+	if r.next == "" {
+		return nil
+	}
+	return &r.next
+}
+
+type roomResolver struct {
+	svc    *Service
+	room   *Room
+	loader *scheduleLoader
+}
+
+func (r *roomResolver) Ref() string {
+	return r.room.Ref
+}
+
+func (r *roomResolver) Name() string {
+	return r.room.Name
+}
+
+func (r *roomResolver) Tags() []string {
+	return r.room.Tags
+}
+
+func (r *roomResolver) Capacity() int32 {
+	return int32(r.room.Capacity)
+}
+
+func (r *roomResolver) RequiresApproval() bool {
+	return r.room.RequiresApproval
+}
+
+func (r *roomResolver) Version() int32 {
+	return int32(r.room.Version)
+}
+
+func (r *roomResolver) Availabilities(ctx context.Context, args struct {
+	From  graphql.Time
+	To    graphql.Time
+	Seats *int32
+}) ([]*timeIntervalResolver, error) {
+	from, to := utc.Convert(args.From.Time), utc.Convert(args.To.Time)
+	seats := 1
+	if args.Seats != nil {
+		seats = int(*args.Seats)
+	}
+
+	ivals, err := r.loader.availabilities(ctx, r.room.Ref, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var resolvers []*timeIntervalResolver
+	for _, iv := range ivals {
+		if iv.Capacity < seats {
+			continue
+		}
+		resolvers = append(resolvers, &timeIntervalResolver{iv: iv})
+	}
+	return resolvers, nil
+}
+
+func (r *roomResolver) Reservations(ctx context.Context, args struct {
+	From *graphql.Time
+	To   *graphql.Time
+}) ([]*reservationResolver, error) {
+	var from, to utc.UTC
+	if args.From != nil {
+		from = utc.Convert(args.From.Time)
+	}
+	if args.To != nil {
+		to = utc.Convert(args.To.Time)
+	}
+
+	reservations, err := r.loader.reservations(ctx, r.room.Ref, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return reservationResolvers(r.svc, reservations, r), nil
+}
+
+type reservationResolver struct {
+	svc   *Service
+	res   *Reservation
+	rooms *roomLoader
+	users *userLoader
+}
+
+func (r *reservationResolver) ID() string {
+	return r.res.ID
+}
+
+func (r *reservationResolver) RoomRef() string {
+	return r.res.RoomRef
+}
+
+func (r *reservationResolver) Room(ctx context.Context) (*roomResolver, error) {
+	return r.rooms.load(ctx, r.res.RoomRef)
+}
+
+func (r *reservationResolver) User(ctx context.Context) (*userResolver, error) {
+	return r.users.load(ctx, r.res.UserID)
+}
+
+func (r *reservationResolver) From() graphql.Time {
+	return graphql.Time{Time: r.res.From.Time()}
+}
+
+func (r *reservationResolver) To() graphql.Time {
+	return graphql.Time{Time: r.res.To.Time()}
+}
+
+func (r *reservationResolver) Seats() int32 {
+	return int32(r.res.seats())
+}
+
+func (r *reservationResolver) Status() string {
+	if r.res.Status == "" {
+		return string(ReservationActive)
+	}
+	return string(r.res.Status)
+}
+
+func (r *reservationResolver) CreatedBy() *string {
+	if r.res.CreatedBy == "" {
+		return nil
+	}
+	return &r.res.CreatedBy
+}
+
+func (r *reservationResolver) CancelledAt() *graphql.Time {
+	if r.res.CancelledAt.IsZero() {
+		return nil
+	}
+	return &graphql.Time{Time: r.res.CancelledAt.Time()}
+}
+
+func (r *reservationResolver) LateCancel() bool {
+	return r.res.LateCancel
+}
+
+func (r *reservationResolver) CheckedInAt() *graphql.Time {
+	if r.res.CheckedInAt.IsZero() {
+		return nil
+	}
+	return &graphql.Time{Time: r.res.CheckedInAt.Time()}
+}
+
+func (r *reservationResolver) NoShow() bool {
+	return r.res.NoShow
+}
+
+func (r *reservationResolver) Version() int32 {
+	return int32(r.res.Version)
+}
+
+type timeIntervalResolver struct {
+	iv *TimeInterval
+}
+
+func (r *timeIntervalResolver) From() graphql.Time {
+	return graphql.Time{Time: r.iv.From.Time()}
+}
+
+func (r *timeIntervalResolver) To() graphql.Time {
+	return graphql.Time{Time: r.iv.To.Time()}
+}
+
+func (r *timeIntervalResolver) Capacity() int32 {
+	return int32(r.iv.Capacity)
+}
+
+type userResolver struct {
+	svc  *Service
+	user *iam.User
+}
+
+func (r *userResolver) ID() string {
+	return r.user.ID
+}
+
+func (r *userResolver) Roles() []string {
+	roles := make([]string, len(r.user.Roles))
+	for i, role := range r.user.Roles {
+		roles[i] = role.String()
+	}
+	return roles
+}
+
+func (r *userResolver) Group(ctx context.Context) (*groupResolver, error) {
+	if r.user.GroupID == "" {
+		return nil, nil
+	}
+	g, err := r.svc.iam.GetGroup(ctx, r.user.GroupID)
+	switch {
+	case err == nil:
+		return &groupResolver{group: g}, nil
+	case errors.IsNotFound(err):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (r *userResolver) Reservations(ctx context.Context) ([]*reservationResolver, error) {
+	reservations, err := r.svc.ListUserReservations(ctx, r.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return reservationResolvers(r.svc, reservations), nil
+}
+
+type groupResolver struct {
+	group *iam.Group
+}
+
+func (r *groupResolver) ID() string {
+	return r.group.ID
+}
+
+func (r *groupResolver) Ref() string {
+	return r.group.Ref
+}
@@ -0,0 +1,339 @@
+package booking_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/gqlutil"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// TestGraphQL_Rooms ensures rooms are returned with their availabilities and
+// reservations, and that schedules are read in batches
+func TestGraphQL_Rooms(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	const n = 5
+	for i := 1; i <= n; i++ {
+		ref := fmt.Sprintf("C%02d", i)
+		if err := rooms.Create(ctx, &booking.Room{Ref: ref}); err != nil {
+			t.Fatal("error creating room", err)
+		}
+		err := reservations.Reserve(ctx, &booking.Reservation{
+			RoomRef: ref,
+			From:    utc.MustParse("2021-08-01T12:00:00Z"),
+			To:      utc.MustParse("2021-08-01T13:00:00Z"),
+			UserID:  "foo",
+		})
+		if err != nil {
+			t.Fatal("error reserving room", err)
+		}
+	}
+
+	store, _ := kvdb.FromContext(ctx)
+	counter := &readCounter{Store: store}
+	ctx = kvdb.WithContext(ctx, counter)
+
+	var data struct {
+		Rooms struct {
+			Rooms []struct {
+				Ref            string
+				Availabilities []struct {
+					From     utc.UTC
+					To       utc.UTC
+					Capacity int
+				}
+				Reservations []struct {
+					ID   string
+					Room struct{ Ref string }
+				}
+			}
+		}
+	}
+	execGraphQL(t, ctx, svc, `{
+		rooms {
+			rooms {
+				ref
+				availabilities(from: "2021-08-01T10:00:00Z", to: "2021-08-01T15:00:00Z") {
+					from
+					to
+					capacity
+				}
+				reservations(from: "2021-08-01T00:00:00Z") {
+					id
+					room { ref }
+				}
+			}
+		}
+	}`, nil, &data)
+
+	if len(data.Rooms.Rooms) != n {
+		t.Fatalf("expect %d rooms, but got %d", n, len(data.Rooms.Rooms))
+	}
+	for _, room := range data.Rooms.Rooms {
+		if len(room.Availabilities) != 2 {
+			t.Errorf("expect 2 free ranges in %s, but got %v", room.Ref, room.Availabilities)
+		} else if room.Availabilities[0].To != utc.MustParse("2021-08-01T12:00:00Z") {
+			t.Errorf("expect %s to be free until 12:00, but got %s", room.Ref, room.Availabilities[0].To)
+		}
+		if len(room.Reservations) != 1 || room.Reservations[0].Room.Ref != room.Ref {
+			t.Errorf("expect 1 reservation in %s, but got %v", room.Ref, room.Reservations)
+		}
+	}
+
+	// 1 read to list rooms, 1 for all availabilities and 1 for all
+	// reservations
+	if got, expect := counter.reads(), int64(3); got != expect {
+		t.Errorf("expect %d reads, but got %d", expect, got)
+	}
+}
+
+// TestGraphQL_ReservationUser ensures reservation owners are only visible to
+// themselves, their delegates and admins, and that they are read in a single
+// batch
+func TestGraphQL_ReservationUser(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, u := range []*iam.User{
+		{ID: "admin", Roles: []iam.Role{iam.RoleAdmin}},
+		{ID: "foo"},
+		{ID: "bar"},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal("error creating user", err)
+		}
+	}
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	if err := rooms.Create(ctx, &booking.Room{Ref: "C01"}); err != nil {
+		t.Fatal("error creating room", err)
+	}
+	from := utc.MustParse("2021-08-01T12:00:00Z")
+	for i, id := range []string{"foo", "bar", "foo"} {
+		acc := iam.WithContext(ctx, &iam.Account{UserID: id})
+		_, err := svc.ReserveRoom(acc, "C01", from.Add(time.Duration(i)*time.Hour), 1)
+		if err != nil {
+			t.Fatal("error reserving room", err)
+		}
+	}
+
+	const query = `{
+		room(ref: "C01") {
+			reservations { user { id } }
+		}
+	}`
+	type result struct {
+		Room struct {
+			Reservations []struct {
+				User *struct{ ID string }
+			}
+		}
+	}
+
+	// Other users are hidden
+	res := gqlutil.Exec(iam.WithContext(ctx, &iam.Account{UserID: "foo"}), svc.GraphQLSchema(),
+		&gqlutil.Request{Query: query},
+	)
+	if code := errorCode(res.Errors); code != "PERMISSION_DENIED" {
+		t.Errorf("expect other users to be denied, but got %q", code)
+	}
+	var data result
+	if err := json.Unmarshal(res.Data, &data); err != nil {
+		t.Fatal("error decoding data", err)
+	}
+	for i, r := range data.Room.Reservations {
+		switch {
+		case i == 1 && r.User != nil:
+			t.Errorf("expect bar to be hidden from foo, but got %v", r.User)
+		case i != 1 && (r.User == nil || r.User.ID != "foo"):
+			t.Errorf("expect foo to see themselves, but got %v", r.User)
+		}
+	}
+
+	// Admins see everyone
+	store, _ := kvdb.FromContext(ctx)
+	counter := &readCounter{Store: store}
+	admin := iam.WithContext(kvdb.WithContext(ctx, counter), &iam.Account{UserID: "admin"})
+	data = result{}
+	execGraphQL(t, admin, svc, query, nil, &data)
+	if len(data.Room.Reservations) != 3 {
+		t.Fatalf("expect 3 reservations, but got %d", len(data.Room.Reservations))
+	}
+	for _, r := range data.Room.Reservations {
+		if r.User == nil || r.User.ID == "" {
+			t.Errorf("expect admin to see all users, but got %v", r.User)
+		}
+	}
+	// 1 read to get the room, 1 for its reservations and 1 for all users
+	if got, expect := counter.reads(), int64(3); got != expect {
+		t.Errorf("expect %d reads, but got %d", expect, got)
+	}
+}
+
+// TestGraphQL_MaxDepth ensures deeply nested queries are rejected
+func TestGraphQL_MaxDepth(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+
+	res := gqlutil.Exec(ctx, svc.GraphQLSchema(), &gqlutil.Request{Query: `{
+		room(ref: "C01") {
+			reservations { room { reservations { room { reservations { room {
+				reservations { id }
+			} } } } } }
+		}
+	}`})
+	if len(res.Errors) == 0 || !strings.Contains(res.Errors[0].Message, "depth") {
+		t.Error("expect deep query to be rejected, but got", res.Errors)
+	}
+}
+
+// TestGraphQL_Reserve ensures rooms can be reserved and cancelled, and that
+// errors are returned with a code
+func TestGraphQL_Reserve(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	schema := svc.GraphQLSchema()
+
+	const reserve = `mutation Reserve($from: Time!) {
+		reserveRoom(roomRef: "C01", from: $from, hours: 1) { id version }
+	}`
+	vars := map[string]interface{}{"from": "2021-08-01T12:00:00Z"}
+
+	res := gqlutil.Exec(ctx, schema, &gqlutil.Request{Query: reserve, Variables: vars})
+	if code := errorCode(res.Errors); code != "PERMISSION_DENIED" {
+		t.Errorf("expect anonymous reservation to be denied, but got %q", code)
+	}
+
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+	var reserved struct {
+		ReserveRoom struct {
+			ID      string
+			Version int
+		}
+	}
+	execGraphQL(t, ctx, svc, reserve, vars, &reserved)
+
+	res = gqlutil.Exec(ctx, schema, &gqlutil.Request{Query: reserve, Variables: vars})
+	if code := errorCode(res.Errors); code != "CONFLICT" {
+		t.Errorf("expect overlap to conflict, but got %q", code)
+	}
+
+	var cancelled struct {
+		CancelReservation struct {
+			Status      string
+			CancelledAt utc.UTC
+		}
+	}
+	execGraphQL(t, ctx, svc, `mutation Cancel($id: String!) {
+		cancelReservation(roomRef: "C01", id: $id) { status cancelledAt }
+	}`, map[string]interface{}{"id": reserved.ReserveRoom.ID}, &cancelled)
+	if cancelled.CancelReservation.Status != string(booking.ReservationCancelled) {
+		t.Errorf("expect reservation to be cancelled, but got %q", cancelled.CancelReservation.Status)
+	}
+	if cancelled.CancelReservation.CancelledAt.IsZero() {
+		t.Error("expect cancellation time")
+	}
+
+	res = gqlutil.Exec(ctx, schema, &gqlutil.Request{
+		Query: `{ reservation(roomRef: "C01", id: "missing") { id } }`,
+	})
+	if code := errorCode(res.Errors); code != "NOT_FOUND" {
+		t.Errorf("expect missing reservation not to be found, but got %q", code)
+	}
+}
+
+// execGraphQL executes `query` and decodes its data to `v`
+func execGraphQL(
+	t *testing.T,
+	ctx context.Context,
+	svc *booking.Service,
+	query string,
+	vars map[string]interface{},
+	v interface{},
+) {
+	t.Helper()
+	res := gqlutil.Exec(ctx, svc.GraphQLSchema(), &gqlutil.Request{
+		Query:     query,
+		Variables: vars,
+	})
+	if len(res.Errors) > 0 {
+		t.Fatal("expect query to succeed, but got", res.Errors)
+	}
+	if err := json.Unmarshal(res.Data, v); err != nil {
+		t.Fatal("error decoding data", err)
+	}
+}
+
+// errorCode returns the code of the first error
+func errorCode(errs []*gqlerrors.QueryError) string {
+	if len(errs) == 0 {
+		return ""
+	}
+	code, _ := errs[0].Extensions["code"].(string)
+	return code
+}
+
+// readCounter counts read transactions
+type readCounter struct {
+	kvdb.Store
+	n int64
+}
+
+func (c *readCounter) ReadTransact(
+	ctx context.Context, f func(kvdb.ReadTransaction) (interface{}, error),
+) (interface{}, error) {
+	atomic.AddInt64(&c.n, 1)
+	return c.Store.ReadTransact(ctx, f)
+}
+
+func (c *readCounter) reads() int64 {
+	return atomic.LoadInt64(&c.n)
+}
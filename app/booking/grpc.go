@@ -0,0 +1,349 @@
+package booking
+
+import (
+	"context"
+	"time"
+
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
+	"github.com/basgys/booking-consensys/pkg/pb"
+	"github.com/deixis/errors"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *Service) HandleGRPC(srv *grpcutil.Server) {
+	pb.RegisterBookingServiceServer(srv, &grpcHandler{
+		svc: s,
+	})
+}
+
+type grpcHandler struct {
+	pb.UnimplementedBookingServiceServer
+
+	svc *Service
+}
+
+func (h *grpcHandler) ListRooms(
+	ctx context.Context, req *pb.ListRoomsRequest,
+) (*pb.ListRoomsResponse, error) {
+	rooms, next, err := h.svc.ListRooms(ctx, Page{
+		Cursor: req.Cursor,
+		Limit:  int(req.Limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListRoomsResponse{
+		Rooms: make([]*pb.Room, len(rooms)),
+		Next:  next,
+	}
+	for i, room := range rooms {
+		res.Rooms[i] = roomToPB(room)
+	}
+	return res, nil
+}
+
+func (h *grpcHandler) GetRoom(
+	ctx context.Context, req *pb.GetRoomRequest,
+) (*pb.Room, error) {
+	room, err := h.svc.GetRoom(ctx, req.Ref)
+	if err != nil {
+		return nil, err
+	}
+	return roomToPB(room), nil
+}
+
+func (h *grpcHandler) CreateRoom(
+	ctx context.Context, req *pb.CreateRoomRequest,
+) (*pb.Room, error) {
+	room, err := roomFromPB(req.Room)
+	if err != nil {
+		return nil, err
+	}
+	room.Version = 0
+	if err := h.svc.CreateRoom(ctx, room); err != nil {
+		return nil, err
+	}
+	return roomToPB(room), nil
+}
+
+func (h *grpcHandler) UpdateRoom(
+	ctx context.Context, req *pb.UpdateRoomRequest,
+) (*pb.Room, error) {
+	room, err := roomFromPB(req.Room)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.svc.UpdateRoom(ctx, room); err != nil {
+		return nil, err
+	}
+	return roomToPB(room), nil
+}
+
+func (h *grpcHandler) DeleteRoom(
+	ctx context.Context, req *pb.DeleteRoomRequest,
+) (*emptypb.Empty, error) {
+	if err := h.svc.DeleteRoom(ctx, req.Ref, req.Version); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *grpcHandler) RoomAvailabilities(
+	ctx context.Context, req *pb.RoomAvailabilitiesRequest,
+) (*pb.RoomAvailabilitiesResponse, error) {
+	d := time.Duration(req.DurationMinutes) * time.Minute
+	availabilities, err := h.svc.RoomAvailabilities(
+		ctx, req.RoomRef, pb.UTC(req.From), pb.UTC(req.To), d,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.RoomAvailabilitiesResponse{
+		Availabilities: make([]*pb.TimeInterval, len(availabilities)),
+	}
+	for i, a := range availabilities {
+		res.Availabilities[i] = &pb.TimeInterval{
+			From:     pb.Timestamp(a.From),
+			To:       pb.Timestamp(a.To),
+			Capacity: int32(a.Capacity),
+		}
+	}
+	return res, nil
+}
+
+func (h *grpcHandler) ListRoomReservations(
+	ctx context.Context, req *pb.ListRoomReservationsRequest,
+) (*pb.ListReservationsResponse, error) {
+	reservations, next, err := h.svc.ListRoomReservations(ctx, req.RoomRef,
+		ReservationFilter{
+			From: pb.UTC(req.From),
+			To:   pb.UTC(req.To),
+			Page: Page{
+				Cursor: req.Cursor,
+				Limit:  int(req.Limit),
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return reservationsToPB(reservations, next), nil
+}
+
+func (h *grpcHandler) GetRoomReservation(
+	ctx context.Context, req *pb.GetRoomReservationRequest,
+) (*pb.Reservation, error) {
+	res, err := h.svc.GetRoomReservation(ctx, req.RoomRef, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return reservationToPB(res), nil
+}
+
+func (h *grpcHandler) ReserveRoom(
+	ctx context.Context, req *pb.ReserveRoomRequest,
+) (*pb.ReserveRoomResponse, error) {
+	res, replayed, err := h.svc.ReserveRoomOnce(ctx, req.IdempotencyKey, BookingRequest{
+		RoomRef:    req.RoomRef,
+		From:       pb.UTC(req.From),
+		Hours:      req.Hours,
+		Seats:      int(req.Seats),
+		OnBehalfOf: req.OnBehalfOf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReserveRoomResponse{
+		Reservation: reservationToPB(res),
+		Replayed:    replayed,
+	}, nil
+}
+
+func (h *grpcHandler) RescheduleRoomReservation(
+	ctx context.Context, req *pb.RescheduleRoomReservationRequest,
+) (*pb.Reservation, error) {
+	res, err := h.svc.RescheduleRoomReservation(
+		ctx, req.RoomRef, req.Id, req.Version, pb.UTC(req.From), req.Hours,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return reservationToPB(res), nil
+}
+
+func (h *grpcHandler) CancelRoomReservation(
+	ctx context.Context, req *pb.CancelRoomReservationRequest,
+) (*emptypb.Empty, error) {
+	err := h.svc.CancelRoomReservation(ctx, req.RoomRef, req.Id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *grpcHandler) ListMyReservations(
+	ctx context.Context, req *pb.ListMyReservationsRequest,
+) (*pb.ListReservationsResponse, error) {
+	reservations, err := h.svc.ListMyReservations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reservationsToPB(reservations, ""), nil
+}
+
+func (h *grpcHandler) ListUserReservations(
+	ctx context.Context, req *pb.ListUserReservationsRequest,
+) (*pb.ListReservationsResponse, error) {
+	reservations, err := h.svc.ListUserReservations(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return reservationsToPB(reservations, ""), nil
+}
+
+func (h *grpcHandler) RequestRoom(
+	ctx context.Context, req *pb.RequestRoomRequest,
+) (*pb.ApprovalRequest, error) {
+	request, err := h.svc.RequestRoom(ctx, BookingRequest{
+		RoomRef:    req.RoomRef,
+		From:       pb.UTC(req.From),
+		Hours:      req.Hours,
+		Seats:      int(req.Seats),
+		OnBehalfOf: req.OnBehalfOf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approvalRequestToPB(request), nil
+}
+
+func (h *grpcHandler) GetApprovalRequest(
+	ctx context.Context, req *pb.GetApprovalRequestRequest,
+) (*pb.ApprovalRequest, error) {
+	request, err := h.svc.GetApprovalRequest(ctx, req.RoomRef, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return approvalRequestToPB(request), nil
+}
+
+func (h *grpcHandler) ListApprovalRequests(
+	ctx context.Context, req *pb.ListApprovalRequestsRequest,
+) (*pb.ListApprovalRequestsResponse, error) {
+	requests, err := h.svc.ListApprovalRequests(
+		ctx, req.RoomRef, ApprovalStatus(req.Status),
+	)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListApprovalRequestsResponse{
+		Requests: make([]*pb.ApprovalRequest, len(requests)),
+	}
+	for i, request := range requests {
+		res.Requests[i] = approvalRequestToPB(request)
+	}
+	return res, nil
+}
+
+func (h *grpcHandler) ApproveRequest(
+	ctx context.Context, req *pb.DecisionRequest,
+) (*pb.ApprovalRequest, error) {
+	request, err := h.svc.ApproveRequest(ctx, req.RoomRef, req.Id, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return approvalRequestToPB(request), nil
+}
+
+func (h *grpcHandler) RejectRequest(
+	ctx context.Context, req *pb.DecisionRequest,
+) (*pb.ApprovalRequest, error) {
+	request, err := h.svc.RejectRequest(ctx, req.RoomRef, req.Id, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return approvalRequestToPB(request), nil
+}
+
+func roomFromPB(r *pb.Room) (*Room, error) {
+	if r == nil {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "room",
+			Description: "Missing room",
+		})
+	}
+	return &Room{
+		Ref:              r.Ref,
+		Name:             r.Name,
+		Metadata:         r.Metadata,
+		Tags:             r.Tags,
+		Capacity:         int(r.Capacity),
+		RequiresApproval: r.RequiresApproval,
+		HoldPending:      r.HoldPending,
+		Approvers:        r.Approvers,
+		ApproverGroups:   r.ApproverGroups,
+		Version:          r.Version,
+	}, nil
+}
+
+func roomToPB(r *Room) *pb.Room {
+	return &pb.Room{
+		Ref:              r.Ref,
+		Name:             r.Name,
+		Metadata:         r.Metadata,
+		Tags:             r.Tags,
+		Capacity:         int32(r.Capacity),
+		RequiresApproval: r.RequiresApproval,
+		HoldPending:      r.HoldPending,
+		Approvers:        r.Approvers,
+		ApproverGroups:   r.ApproverGroups,
+		Version:          r.Version,
+	}
+}
+
+func reservationToPB(r *Reservation) *pb.Reservation {
+	return &pb.Reservation{
+		Id:          r.ID,
+		From:        pb.Timestamp(r.From),
+		To:          pb.Timestamp(r.To),
+		RoomRef:     r.RoomRef,
+		UserId:      r.UserID,
+		CreatedBy:   r.CreatedBy,
+		Seats:       int32(r.Seats),
+		Status:      string(r.Status),
+		CancelledAt: pb.Timestamp(r.CancelledAt),
+		CancelledBy: r.CancelledBy,
+		LateCancel:  r.LateCancel,
+		Version:     r.Version,
+	}
+}
+
+func reservationsToPB(l []*Reservation, next string) *pb.ListReservationsResponse {
+	res := &pb.ListReservationsResponse{
+		Reservations: make([]*pb.Reservation, len(l)),
+		Next:         next,
+	}
+	for i, r := range l {
+		res.Reservations[i] = reservationToPB(r)
+	}
+	return res
+}
+
+func approvalRequestToPB(a *ApprovalRequest) *pb.ApprovalRequest {
+	return &pb.ApprovalRequest{
+		Id:            a.ID,
+		RoomRef:       a.RoomRef,
+		UserId:        a.UserID,
+		From:          pb.Timestamp(a.From),
+		To:            pb.Timestamp(a.To),
+		Seats:         int32(a.Seats),
+		Status:        a.Status.String(),
+		CreatedBy:     a.CreatedBy,
+		Reason:        a.Reason,
+		DecidedBy:     a.DecidedBy,
+		DecidedAt:     pb.Timestamp(a.DecidedAt),
+		ReservationId: a.ReservationID,
+		CreatedAt:     pb.Timestamp(a.CreatedAt),
+		ExpiresAt:     pb.Timestamp(a.ExpiresAt),
+	}
+}
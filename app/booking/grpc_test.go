@@ -0,0 +1,198 @@
+package booking_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/auth"
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
+	"github.com/basgys/booking-consensys/pkg/mw"
+	"github.com/basgys/booking-consensys/pkg/pb"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestGRPC_ReserveRoom ensures reservations can be made over gRPC with a JWT,
+// and that errors are mapped to gRPC status codes
+func TestGRPC_ReserveRoom(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	token, err := createSession(ctx, "foo")
+	if err != nil {
+		t.Fatal("error creating session", err)
+	}
+	client, stop, err := startGRPC(ctx)
+	if err != nil {
+		t.Fatal("error starting gRPC server", err)
+	}
+	defer stop()
+
+	req := &pb.ReserveRoomRequest{
+		RoomRef: "C01",
+		From:    pb.Timestamp(utc.MustParse("2021-08-01T12:00:00Z")),
+		Hours:   1,
+	}
+	_, err = client.ReserveRoom(ctx, req)
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("expect anonymous reservation to be denied, but got %s", code)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	res, err := client.ReserveRoom(ctx, req)
+	if err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	if res.Reservation.UserId != "foo" || res.Reservation.Id == "" {
+		t.Errorf("expect reservation of foo, but got %v", res.Reservation)
+	}
+	if got := pb.UTC(res.Reservation.To); got != utc.MustParse("2021-08-01T13:00:00Z") {
+		t.Errorf("expect reservation to end at 13:00, but got %s", got)
+	}
+
+	for _, test := range []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{
+			name: "overlap",
+			call: func() error {
+				_, err := client.ReserveRoom(ctx, req)
+				return err
+			},
+			code: codes.Aborted,
+		},
+		{
+			name: "bad hours",
+			call: func() error {
+				_, err := client.ReserveRoom(ctx, &pb.ReserveRoomRequest{
+					RoomRef: "C01",
+					From:    req.From,
+				})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing",
+			call: func() error {
+				_, err := client.GetRoomReservation(ctx, &pb.GetRoomReservationRequest{
+					RoomRef: "C01",
+					Id:      "missing",
+				})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "bad token",
+			call: func() error {
+				ctx := metadata.AppendToOutgoingContext(context.Background(),
+					"authorization", "Bearer "+token+"x",
+				)
+				_, err := client.ListMyReservations(ctx, &pb.ListMyReservationsRequest{})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+	} {
+		if code := status.Code(test.call()); code != test.code {
+			t.Errorf("%s: expect code %s, but got %s", test.name, test.code, code)
+		}
+	}
+
+	mine, err := client.ListMyReservations(ctx, &pb.ListMyReservationsRequest{})
+	if err != nil {
+		t.Fatal("error listing reservations", err)
+	}
+	if len(mine.Reservations) != 1 || mine.Reservations[0].Id != res.Reservation.Id {
+		t.Errorf("expect only reservation %s, but got %v", res.Reservation.Id, mine.Reservations)
+	}
+}
+
+// testSecret signs the sessions of gRPC tests
+const testSecret = "grpc-test-secret"
+
+// startGRPC serves the auth and booking gRPC services on a local port
+func startGRPC(ctx context.Context) (pb.BookingServiceClient, func(), error) {
+	store, _ := kvdb.FromContext(ctx)
+	auths, err := auth.New(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	auths.Secret = testSecret
+	bookings, err := booking.New(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv := grpcutil.NewServer()
+	inject := mw.Store{S: store}
+	srv.AppendUnaryMiddleware(grpcutil.MarshalErrors)
+	srv.AppendUnaryMiddleware(inject.InjectGRPC)
+	auths.HandleGRPC(srv)
+	bookings.HandleGRPC(srv)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	go srv.ServeListener(ctx, lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		srv.Drain()
+		return nil, nil, err
+	}
+	stop := func() {
+		conn.Close()
+		srv.Drain()
+	}
+	return pb.NewBookingServiceClient(conn), stop, nil
+}
+
+// createSession creates user `userID` with an account, and returns a JWT
+// for that account
+func createSession(ctx context.Context, userID string) (string, error) {
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		return "", err
+	}
+	accounts, err := iam.NewAccountRepository(ctx)
+	if err != nil {
+		return "", err
+	}
+	auths, err := auth.New(ctx)
+	if err != nil {
+		return "", err
+	}
+	auths.Secret = testSecret
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	acc := &iam.Account{
+		Address: iam.Address(crypto.PubkeyToAddress(key.PublicKey)),
+		UserID:  userID,
+	}
+	if err := users.Create(ctx, &iam.User{ID: userID}); err != nil {
+		return "", err
+	}
+	if err := accounts.Create(ctx, acc); err != nil {
+		return "", err
+	}
+
+	return auths.IssueToken(acc, time.Hour)
+}
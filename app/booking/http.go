@@ -1,30 +1,82 @@
 package booking
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"strings"
 	"time"
 
+	"github.com/basgys/booking-consensys/pkg/etag"
+	"github.com/basgys/booking-consensys/pkg/gqlutil"
+	"github.com/basgys/booking-consensys/pkg/sse"
 	"github.com/deixis/errors"
 	"github.com/deixis/errors/httperrors"
 	"github.com/deixis/pkg/httputil"
 	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
 	"github.com/deixis/spine/net/http"
 )
 
+const (
+	// DefaultStreamTimeout is how long room streams stay open. It is not bound
+	// by the request timeout of the server.
+	DefaultStreamTimeout = 5 * time.Minute
+	// streamRetry is the delay (in ms) before a client reconnects
+	streamRetry = 1000
+
+	// idempotencyKeyHeader is sent by clients to safely retry a reservation
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is returned when a reservation was already
+	// created by a previous request with the same idempotency key
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// DrainStreams ends the room streams in progress, so that they do not hold
+// the shutdown of the HTTP server
+func (s *Service) DrainStreams() {
+	s.streams.Drain()
+}
+
 func (s *Service) HandleHTTP(srv *http.Server) {
 	h := httpHandler{
 		svc: s,
 	}
 
 	srv.HandleFunc("/booking/rooms", http.GET, h.listRooms)
+	srv.HandleFunc("/booking/rooms", http.POST, h.createRoom)
+	srv.HandleFunc("/booking/rooms/import", http.POST, h.importRooms)
 	srv.HandleFunc("/booking/rooms/availabilities", http.GET, h.roomsAvailabilities)
+	srv.HandleEndpoint(sse.Endpoint("/booking/rooms/stream", s.streams, h.streamRooms))
+	srv.HandleFunc("/booking/rooms/{rid}", http.GET, h.getRoom)
+	srv.HandleFunc("/booking/rooms/{rid}", http.PUT, h.updateRoom)
+	srv.HandleFunc("/booking/rooms/{rid}", http.DELETE, h.deleteRoom)
 	srv.HandleFunc("/booking/rooms/{rid}/availabilities", http.GET, h.roomAvailabilities)
 	srv.HandleFunc("/booking/rooms/{rid}/reservations", http.GET, h.listRoomReservations)
 	srv.HandleFunc("/booking/rooms/{rid}/reservations", http.POST, h.reserveRoom)
+	srv.HandleFunc("/booking/rooms/{rid}/reservations/{id}", http.GET, h.getRoomReservation)
+	srv.HandleFunc("/booking/rooms/{rid}/reservations/{id}", http.PUT, h.rescheduleRoomReservation)
 	srv.HandleFunc("/booking/rooms/{rid}/reservations/{id}", http.DELETE, h.cancelRoomReservation)
+	srv.HandleFunc("/booking/rooms/{rid}/reservations/{id}/check-in", http.POST, h.checkInRoomReservation)
+	srv.HandleFunc("/booking/rooms/{rid}/cancellations", http.GET, h.listCancelledReservations)
+	srv.HandleFunc("/booking/rooms/{rid}/requests", http.GET, h.listApprovalRequests)
+	srv.HandleFunc("/booking/rooms/{rid}/requests", http.POST, h.requestRoom)
+	srv.HandleFunc("/booking/rooms/{rid}/requests/{id}", http.GET, h.getApprovalRequest)
+	srv.HandleFunc("/booking/rooms/{rid}/requests/{id}/approve", http.POST, h.approveRequest)
+	srv.HandleFunc("/booking/rooms/{rid}/requests/{id}/reject", http.POST, h.rejectRequest)
+	srv.HandleFunc("/booking/me/reservations", http.GET, h.listMyReservations)
+	srv.HandleFunc("/booking/users/{uid}/reservations", http.GET, h.listUserReservations)
+	srv.HandleFunc("/booking/policies", http.GET, h.listPolicies)
+	srv.HandleFunc("/booking/policies/{id}", http.PUT, h.putPolicy)
+	srv.HandleFunc("/booking/policies/{id}", http.DELETE, h.deletePolicy)
+	srv.HandleFunc("/booking/analytics/utilization", http.GET, h.utilization)
+	srv.HandleFunc("/booking/archive/rooms/{rid}/reservations", http.GET, h.listArchivedReservations)
+	srv.HandleFunc("/booking/archive/rooms/{rid}/summaries", http.GET, h.listArchiveSummaries)
+	srv.HandleFunc("/graphql", http.POST, gqlutil.Handler(s.GraphQLSchema()))
 }
 
 type httpHandler struct {
@@ -34,18 +86,154 @@ type httpHandler struct {
 func (h *httpHandler) listRooms(
 	ctx context.Context, w http.ResponseWriter, req *http.Request,
 ) {
-	rooms, err := h.svc.ListRooms(ctx)
+	query := req.HTTP.URL.Query()
+	params := struct {
+		Cursor string `qs:"cursor"`
+		Limit  int    `qs:"limit"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	rooms, next, err := h.svc.ListRooms(ctx, Page{
+		Cursor: params.Cursor,
+		Limit:  params.Limit,
+	})
 	if err != nil {
 		httperrors.Marshal(req.HTTP, w, err)
 		return
 	}
-	w.JSON(http.StatusOK, struct {
+	writeConditionalJSON(w, req, struct {
 		Rooms []*Room `json:"rooms"`
+		Next  string  `json:"next,omitempty"`
 	}{
 		Rooms: rooms,
+		Next:  next,
+	})
+}
+
+func (h *httpHandler) getRoom(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	room, err := h.svc.GetRoom(ctx, req.Params["rid"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Conditional(req.HTTP, etag.Version(room.Version), time.Time{}, func() error {
+		return w.JSON(http.StatusOK, room)
 	})
 }
 
+type httpRoomRequest struct {
+	Ref              string   `json:"ref"`
+	Name             string   `json:"name"`
+	Tags             []string `json:"tags"`
+	Capacity         int      `json:"capacity"`
+	RequiresApproval bool     `json:"requiresApproval"`
+	HoldPending      bool     `json:"holdPending"`
+	Approvers        []string `json:"approvers"`
+	ApproverGroups   []string `json:"approverGroups"`
+}
+
+// room returns the room described by the request
+func (r *httpRoomRequest) room(ref string, version uint64) *Room {
+	return &Room{
+		Ref:              ref,
+		Name:             r.Name,
+		Tags:             r.Tags,
+		Capacity:         r.Capacity,
+		RequiresApproval: r.RequiresApproval,
+		HoldPending:      r.HoldPending,
+		Approvers:        r.Approvers,
+		ApproverGroups:   r.ApproverGroups,
+		Version:          version,
+	}
+}
+
+func (h *httpHandler) createRoom(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	r := httpRoomRequest{}
+	if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	room := r.room(r.Ref, 0)
+	if err := h.svc.CreateRoom(ctx, room); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Header().Set("ETag", etag.Version(room.Version))
+	w.JSON(http.StatusCreated, room)
+}
+
+func (h *httpHandler) importRooms(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	params := struct {
+		DryRun bool `qs:"dry_run"`
+	}{}
+	if err := httputil.ParseQuery(req.HTTP.URL.Query(), &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	report, err := h.svc.ImportRooms(ctx, req.HTTP.Body, params.DryRun)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	if !report.Valid() {
+		w.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+	w.JSON(http.StatusOK, report)
+}
+
+func (h *httpHandler) updateRoom(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	version, err := etag.Expect(req.HTTP.Header.Get("If-Match"), h.roomVersion(ctx, req.Params["rid"]))
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	r := httpRoomRequest{}
+	if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	room := r.room(req.Params["rid"], version)
+	if err := h.svc.UpdateRoom(ctx, room); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Header().Set("ETag", etag.Version(room.Version))
+	w.JSON(http.StatusOK, room)
+}
+
+func (h *httpHandler) deleteRoom(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	version, err := etag.Expect(req.HTTP.Header.Get("If-Match"), h.roomVersion(ctx, req.Params["rid"]))
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	if err := h.svc.DeleteRoom(ctx, req.Params["rid"], version); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Head(http.StatusNoContent)
+}
+
 func (h *httpHandler) roomsAvailabilities(
 	ctx context.Context, w http.ResponseWriter, req *http.Request,
 ) {
@@ -73,6 +261,80 @@ func (h *httpHandler) roomsAvailabilities(
 	})
 }
 
+// streamRooms pushes reservation changes and availabilities with
+// Server-Sent Events.
+//
+// Streams end after `DefaultStreamTimeout`, or when the server drains.
+// Clients then reconnect with the last event ID (the cursor), so they resume
+// without missing any change.
+func (h *httpHandler) streamRooms(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	query := req.HTTP.URL.Query()
+	params := struct {
+		Rooms  string  `qs:"rooms"`
+		Cursor string  `qs:"cursor"`
+		From   utc.UTC `qs:"from"`
+		To     utc.UTC `qs:"to"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	if id := req.HTTP.Header.Get(sse.LastEventIDHeader); id != "" {
+		params.Cursor = id
+	}
+	cursor, err := ParseCursor(params.Cursor)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	var rooms []string
+	if params.Rooms != "" {
+		rooms = strings.Split(params.Rooms, ",")
+	}
+
+	stream, err := sse.NewWriter(ctx, w)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	if err := stream.Retry(streamRetry); err != nil {
+		return
+	}
+
+	err = h.svc.WatchRooms(ctx, rooms, cursor, params.From, params.To,
+		func(u *RoomUpdate) error {
+			e := &sse.Event{ID: u.Cursor.String()}
+			var v interface{}
+			if u.Change != nil {
+				e.Name = "reservation." + string(u.Change.Type)
+				v = u.Change
+			} else {
+				e.Name = "availabilities"
+				v = u.Availabilities
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			e.Data = data
+			return stream.Send(e)
+		},
+	)
+	if err != nil {
+		log.Warn(ctx, "booking.rooms.stream.err", "Room stream interrupted",
+			log.Error(err),
+		)
+		data, _ := json.Marshal(struct {
+			Message string `json:"message"`
+		}{
+			Message: err.Error(),
+		})
+		stream.Send(&sse.Event{Name: "error", Data: data})
+	}
+}
+
 func (h *httpHandler) roomAvailabilities(
 	ctx context.Context, w http.ResponseWriter, req *http.Request,
 ) {
@@ -93,7 +355,7 @@ func (h *httpHandler) roomAvailabilities(
 		httperrors.Marshal(req.HTTP, w, err)
 		return
 	}
-	w.JSON(http.StatusOK, struct {
+	writeConditionalJSON(w, req, struct {
 		Availabilities []*TimeInterval `json:"availabilities"`
 	}{
 		Availabilities: availabilities,
@@ -103,21 +365,70 @@ func (h *httpHandler) roomAvailabilities(
 func (h *httpHandler) listRoomReservations(
 	ctx context.Context, w http.ResponseWriter, req *http.Request,
 ) {
-	reservations, err := h.svc.ListRoomReservations(ctx, req.Params["rid"])
+	query := req.HTTP.URL.Query()
+	params := struct {
+		From   utc.UTC `qs:"from"`
+		To     utc.UTC `qs:"to"`
+		Cursor string  `qs:"cursor"`
+		Limit  int     `qs:"limit"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	reservations, next, err := h.svc.ListRoomReservations(ctx, req.Params["rid"],
+		ReservationFilter{
+			From: params.From,
+			To:   params.To,
+			Page: Page{
+				Cursor: params.Cursor,
+				Limit:  params.Limit,
+			},
+		},
+	)
 	if err != nil {
 		httperrors.Marshal(req.HTTP, w, err)
 		return
 	}
-	w.JSON(http.StatusOK, struct {
+	writeConditionalJSON(w, req, struct {
 		Reservations []*Reservation `json:"reservations"`
+		Next         string         `json:"next,omitempty"`
 	}{
 		Reservations: reservations,
+		Next:         next,
+	})
+}
+
+func (h *httpHandler) getRoomReservation(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	res, err := h.svc.GetRoomReservation(ctx, req.Params["rid"], req.Params["id"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Conditional(req.HTTP, etag.Version(res.Version), time.Time{}, func() error {
+		return w.JSON(http.StatusOK, res)
 	})
 }
 
 type httpReserveRoomRequest struct {
-	From  utc.UTC `qs:"from"`
-	Hours int64   `qs:"hours"`
+	From       utc.UTC `json:"from"`
+	Hours      int64   `json:"hours"`
+	Seats      int     `json:"seats"`
+	OnBehalfOf string  `json:"onBehalfOf"`
+}
+
+// booking returns the booking described by the request on room `roomRef`
+func (r *httpReserveRoomRequest) booking(roomRef string) BookingRequest {
+	return BookingRequest{
+		RoomRef:    roomRef,
+		From:       r.From,
+		Hours:      r.Hours,
+		Seats:      r.Seats,
+		OnBehalfOf: r.OnBehalfOf,
+	}
 }
 
 func (h *httpHandler) reserveRoom(
@@ -130,25 +441,421 @@ func (h *httpHandler) reserveRoom(
 		return
 	}
 
-	res, err := h.svc.ReserveRoom(ctx, req.Params["rid"], r.From, r.Hours)
+	key := req.HTTP.Header.Get(idempotencyKeyHeader)
+	res, replayed, err := h.svc.ReserveRoomOnce(ctx, key, r.booking(req.Params["rid"]))
 	if err != nil {
 		httperrors.Marshal(req.HTTP, w, err)
 		return
 	}
+	if replayed {
+		w.Header().Set(idempotentReplayedHeader, "true")
+	}
+	w.Header().Set("ETag", etag.Version(res.Version))
 	w.JSON(http.StatusCreated, res)
 }
 
 func (h *httpHandler) cancelRoomReservation(
 	ctx context.Context, w http.ResponseWriter, req *http.Request,
 ) {
-	err := h.svc.CancelRoomReservation(ctx, req.Params["rid"], req.Params["id"])
+	version, err := etag.Expect(req.HTTP.Header.Get("If-Match"),
+		h.reservationVersion(ctx, req.Params["rid"], req.Params["id"]),
+	)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	err = h.svc.CancelRoomReservation(ctx, req.Params["rid"], req.Params["id"], version)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Head(http.StatusNoContent)
+}
+
+func (h *httpHandler) checkInRoomReservation(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	res, err := h.svc.CheckInRoomReservation(ctx, req.Params["rid"], req.Params["id"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Header().Set("ETag", etag.Version(res.Version))
+	w.JSON(http.StatusOK, res)
+}
+
+type httpRescheduleRoomReservationRequest struct {
+	From  utc.UTC `json:"from"`
+	Hours int64   `json:"hours"`
+}
+
+func (h *httpHandler) listCancelledReservations(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	reservations, err := h.svc.ListCancelledReservations(ctx, req.Params["rid"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	writeConditionalJSON(w, req, struct {
+		Reservations []*Reservation `json:"reservations"`
+	}{
+		Reservations: reservations,
+	})
+}
+
+func (h *httpHandler) rescheduleRoomReservation(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	version, err := etag.Expect(req.HTTP.Header.Get("If-Match"),
+		h.reservationVersion(ctx, req.Params["rid"], req.Params["id"]),
+	)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	r := httpRescheduleRoomReservationRequest{}
+	if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	res, err := h.svc.RescheduleRoomReservation(
+		ctx, req.Params["rid"], req.Params["id"], version, r.From, r.Hours,
+	)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Header().Set("ETag", etag.Version(res.Version))
+	w.JSON(http.StatusOK, res)
+}
+
+// roomVersion returns a loader of the current version of room `ref`, for
+// If-Match headers which list several versions
+func (h *httpHandler) roomVersion(ctx context.Context, ref string) func() (uint64, error) {
+	return func() (uint64, error) {
+		room, err := h.svc.GetRoom(ctx, ref)
+		if err != nil {
+			return 0, err
+		}
+		return room.Version, nil
+	}
+}
+
+// reservationVersion returns a loader of the current version of reservation
+// `id`, for If-Match headers which list several versions
+func (h *httpHandler) reservationVersion(
+	ctx context.Context, roomRef, id string,
+) func() (uint64, error) {
+	return func() (uint64, error) {
+		res, err := h.svc.GetRoomReservation(ctx, roomRef, id)
+		if err != nil {
+			return 0, err
+		}
+		return res.Version, nil
+	}
+}
+
+func (h *httpHandler) listApprovalRequests(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	params := struct {
+		Status string `qs:"status"`
+	}{}
+	if err := httputil.ParseQuery(req.HTTP.URL.Query(), &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	requests, err := h.svc.ListApprovalRequests(
+		ctx, req.Params["rid"], ApprovalStatus(params.Status),
+	)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	writeConditionalJSON(w, req, struct {
+		Requests []*ApprovalRequest `json:"requests"`
+	}{
+		Requests: requests,
+	})
+}
+
+func (h *httpHandler) requestRoom(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	r := httpReserveRoomRequest{}
+	if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	request, err := h.svc.RequestRoom(ctx, r.booking(req.Params["rid"]))
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusAccepted, request)
+}
+
+func (h *httpHandler) getApprovalRequest(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	request, err := h.svc.GetApprovalRequest(ctx, req.Params["rid"], req.Params["id"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	writeConditionalJSON(w, req, request)
+}
+
+type httpDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *httpHandler) approveRequest(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	// The reason is optional when approving
+	r := httpDecisionRequest{}
+	if req.HTTP.ContentLength != 0 {
+		if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+			httperrors.Marshal(req.HTTP, w, err)
+			return
+		}
+	}
+
+	request, err := h.svc.ApproveRequest(ctx, req.Params["rid"], req.Params["id"], r.Reason)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, request)
+}
+
+func (h *httpHandler) rejectRequest(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	r := httpDecisionRequest{}
+	if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	request, err := h.svc.RejectRequest(ctx, req.Params["rid"], req.Params["id"], r.Reason)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, request)
+}
+
+func (h *httpHandler) listMyReservations(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	reservations, err := h.svc.ListMyReservations(ctx)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	writeConditionalJSON(w, req, struct {
+		Reservations []*Reservation `json:"reservations"`
+	}{
+		Reservations: reservations,
+	})
+}
+
+func (h *httpHandler) listUserReservations(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	reservations, err := h.svc.ListUserReservations(ctx, req.Params["uid"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	writeConditionalJSON(w, req, struct {
+		Reservations []*Reservation `json:"reservations"`
+	}{
+		Reservations: reservations,
+	})
+}
+
+func (h *httpHandler) listPolicies(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	policies, err := h.svc.ListPolicies(ctx)
 	if err != nil {
 		httperrors.Marshal(req.HTTP, w, err)
 		return
 	}
+	writeConditionalJSON(w, req, struct {
+		Policies []*Policy `json:"policies"`
+	}{
+		Policies: policies,
+	})
+}
+
+func (h *httpHandler) putPolicy(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	p := Policy{}
+	if err := unmarshalJSON(req.HTTP.Body, &p); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	p.ID = req.Params["id"]
+	if err := h.svc.PutPolicy(ctx, &p); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, &p)
+}
+
+func (h *httpHandler) deletePolicy(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	if err := h.svc.DeletePolicy(ctx, req.Params["id"]); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
 	w.Head(http.StatusNoContent)
 }
 
+func (h *httpHandler) listArchivedReservations(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	query := req.HTTP.URL.Query()
+	params := struct {
+		From   utc.UTC `qs:"from"`
+		To     utc.UTC `qs:"to"`
+		Cursor string  `qs:"cursor"`
+		Limit  int     `qs:"limit"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	reservations, next, err := h.svc.ListArchivedReservations(ctx, req.Params["rid"],
+		ReservationFilter{
+			From: params.From,
+			To:   params.To,
+			Page: Page{
+				Cursor: params.Cursor,
+				Limit:  params.Limit,
+			},
+		},
+	)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Reservations []*Reservation `json:"reservations"`
+		Next         string         `json:"next,omitempty"`
+	}{
+		Reservations: reservations,
+		Next:         next,
+	})
+}
+
+func (h *httpHandler) listArchiveSummaries(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	query := req.HTTP.URL.Query()
+	params := struct {
+		From utc.UTC `qs:"from"`
+		To   utc.UTC `qs:"to"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	summaries, err := h.svc.ListArchiveSummaries(ctx, req.Params["rid"], params.From, params.To)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Summaries []*ArchiveSummary `json:"summaries"`
+	}{
+		Summaries: summaries,
+	})
+}
+
+func (h *httpHandler) utilization(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	query := req.HTTP.URL.Query()
+	params := struct {
+		From    utc.UTC `qs:"from"`
+		To      utc.UTC `qs:"to"`
+		Bucket  string  `qs:"bucket"`
+		GroupBy string  `qs:"group_by"`
+		Rooms   string  `qs:"rooms"`
+		Format  string  `qs:"format"`
+	}{}
+	if err := httputil.ParseQuery(query, &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	q := UtilizationQuery{
+		From:    params.From,
+		To:      params.To,
+		Bucket:  Bucket(params.Bucket),
+		GroupBy: Grouping(params.GroupBy),
+	}
+	if params.Rooms != "" {
+		q.Rooms = strings.Split(params.Rooms, ",")
+	}
+
+	report, err := h.svc.Utilization(ctx, q)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	if params.Format != "csv" && !strings.Contains(req.HTTP.Header.Get("Accept"), "text/csv") {
+		w.JSON(http.StatusOK, struct {
+			Utilization []*Utilization `json:"utilization"`
+		}{
+			Utilization: report,
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := WriteUtilizationCSV(&buf, report); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="utilization.csv"`)
+	w.Data(http.StatusOK, "text/csv; charset=utf-8", ioutil.NopCloser(&buf))
+}
+
+// writeConditionalJSON replies with `data` tagged with a hash of its content.
+// It replies with 304 Not Modified when it matches `If-None-Match`.
+func writeConditionalJSON(w http.ResponseWriter, req *http.Request, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	sum := sha256.Sum256(encoded)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Conditional(req.HTTP, tag, time.Time{}, func() error {
+		return w.Data(http.StatusOK, "application/json; charset=utf-8", ioutil.NopCloser(bytes.NewReader(encoded)))
+	})
+}
+
 func unmarshalJSON(r io.Reader, v interface{}) error {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
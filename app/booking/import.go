@@ -0,0 +1,158 @@
+package booking
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/basgys/booking-consensys/pkg/csvimport"
+	"github.com/deixis/errors"
+	"github.com/deixis/storage/kvdb"
+)
+
+// roomRefPattern restricts room references to characters safe in URLs
+var roomRefPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{0,31}$`)
+
+// ImportRooms creates or updates the rooms listed in CSV file `r`.
+//
+// The file must have a `ref` column. The `name`, `capacity` and `tags`
+// (separated by `;`) columns are optional and all other columns are stored as
+// metadata. Rows are all validated before anything is written. When `dryRun` is set, nothing is written at all. Importing the
+// same file twice leaves rooms unchanged.
+func ImportRooms(ctx context.Context, r io.Reader, dryRun bool) (*csvimport.Report, error) {
+	rooms, err := NewRoomsRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise room repository")
+	}
+	return importRooms(ctx, rooms, r, dryRun)
+}
+
+func importRooms(
+	ctx context.Context, rooms *RoomsRepository, r io.Reader, dryRun bool,
+) (*csvimport.Report, error) {
+	report := &csvimport.Report{DryRun: dryRun}
+	rows, err := csvimport.Read(r, report, "ref")
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate all rows first
+	seen := map[string]int{}
+	var parsed []*Room
+	for _, row := range rows {
+		room := &Room{
+			Ref:  strings.ToUpper(row.Get("ref")),
+			Name: row.Get("name"),
+		}
+		for k := range row.Values {
+			if k == "ref" || k == "name" || k == "capacity" || k == "tags" || k == "" {
+				continue
+			}
+			if v := row.Get(k); v != "" {
+				if room.Metadata == nil {
+					room.Metadata = map[string]string{}
+				}
+				room.Metadata[k] = v
+			}
+		}
+
+		switch {
+		case room.Ref == "":
+			report.Fail(row.Line, "ref", "Missing room reference")
+			continue
+		case !roomRefPattern.MatchString(room.Ref):
+			report.Fail(row.Line, "ref", "Room references can only contain letters, digits, - and _ (32 max)")
+			continue
+		}
+		if v := row.Get("capacity"); v != "" {
+			capacity, err := strconv.Atoi(v)
+			if err != nil || capacity < 0 {
+				report.Fail(row.Line, "capacity", "The capacity must be a positive number")
+				continue
+			}
+			room.Capacity = capacity
+		}
+		for _, tag := range strings.Split(row.Get("tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				room.Tags = append(room.Tags, tag)
+			}
+		}
+		if line, ok := seen[room.Ref]; ok {
+			report.Fail(row.Line, "ref", "Duplicate of line "+strconv.Itoa(line))
+			continue
+		}
+		seen[room.Ref] = row.Line
+		parsed = append(parsed, room)
+	}
+	if !report.Valid() || dryRun {
+		return report, nil
+	}
+
+	err = csvimport.Batches(len(parsed), func(start, end int) error {
+		_, err := kvdb.Transact(ctx,
+			func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+				for _, room := range parsed[start:end] {
+					existing, err := rooms.Get(ctx, room.Ref)
+					switch {
+					case err == nil:
+						// Approval settings are not part of the file
+						room.RequiresApproval = existing.RequiresApproval
+						room.HoldPending = existing.HoldPending
+						room.Approvers = existing.Approvers
+						room.ApproverGroups = existing.ApproverGroups
+						if existing.Name == room.Name &&
+							existing.Capacity == room.Capacity &&
+							equalTags(existing.Tags, room.Tags) &&
+							equalMetadata(existing.Metadata, room.Metadata) {
+							report.Unchanged++
+							continue
+						}
+						if err := rooms.Update(ctx, room); err != nil {
+							return nil, err
+						}
+						report.Updated++
+					case errors.IsNotFound(err):
+						if err := rooms.Create(ctx, room); err != nil {
+							return nil, err
+						}
+						report.Created++
+					default:
+						return nil, err
+					}
+				}
+				return nil, nil
+			},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func equalMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package booking_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app/booking"
+)
+
+// TestImportRooms ensures rows are validated before anything is written and
+// that importing a file twice is a no-op
+func TestImportRooms(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	// Quoted values and blank lines shift the line numbers
+	invalid := "ref,name,floor\nC01,\"Board\nroom\",1\n\n,Missing,2\nC01,Duplicate,3\n"
+	report, err := booking.ImportRooms(ctx, strings.NewReader(invalid), false)
+	if err != nil {
+		t.Fatal("expect to import rooms, but got", err)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Line != 5 || report.Errors[1].Line != 6 {
+		t.Fatalf("expect errors on lines 5 and 6, but got %v", report.Errors)
+	}
+
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	if l, _ := rooms.List(ctx); len(l) != 0 {
+		t.Fatalf("expect nothing to be imported, but got %d rooms", len(l))
+	}
+
+	valid := "ref,name,floor\nc01,Boardroom,1\nC02,,2\n"
+	report, err = booking.ImportRooms(ctx, strings.NewReader(valid), false)
+	if err != nil {
+		t.Fatal("expect to import rooms, but got", err)
+	}
+	if report.Created != 2 || !report.Valid() {
+		t.Fatalf("expect 2 rooms to be created, but got %+v", report)
+	}
+	room, err := rooms.Get(ctx, "C01")
+	if err != nil {
+		t.Fatal("expect to get an imported room, but got", err)
+	}
+	if room.Name != "Boardroom" || room.Metadata["floor"] != "1" {
+		t.Errorf("expect room metadata to be imported, but got %+v", room)
+	}
+
+	report, err = booking.ImportRooms(ctx, strings.NewReader(valid), false)
+	if err != nil {
+		t.Fatal("expect to import rooms, but got", err)
+	}
+	if report.Unchanged != 2 {
+		t.Errorf("expect re-import to leave 2 rooms unchanged, but got %+v", report)
+	}
+
+	updated := "ref,name,floor\nC01,Boardroom,2\n"
+	report, err = booking.ImportRooms(ctx, strings.NewReader(updated), false)
+	if err != nil {
+		t.Fatal("expect to import rooms, but got", err)
+	}
+	if report.Updated != 1 {
+		t.Errorf("expect 1 room to be updated, but got %+v", report)
+	}
+}
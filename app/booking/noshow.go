@@ -0,0 +1,170 @@
+package booking
+
+import (
+	"context"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/audit"
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/basgys/booking-consensys/app/webhook"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+	"github.com/deixis/storage/kvdb"
+)
+
+const (
+	// DefaultNoShowGrace is how long after the start of a reservation its
+	// owner can check in before it is flagged as a no-show
+	DefaultNoShowGrace = 15 * time.Minute
+
+	// noShowInterval defines how often reservations are checked for no-shows.
+	// It must stay well below the minimum reservation duration, so that every
+	// reservation is checked between the end of its grace period and its end.
+	noShowInterval = 5 * time.Minute
+
+	// jobNoShow is the scheduler target of the no-show detector
+	jobNoShow = "booking.no_show"
+)
+
+// NoShowDetector flags reservations which have not been checked in by the end
+// of the grace period, and publishes a no-show event for each of them.
+//
+// It runs as a recurring job of the scheduler, so only one node runs it at a
+// time.
+type NoShowDetector struct {
+	// Grace is how long after the start of a reservation its owner can check
+	// in
+	Grace time.Duration
+
+	reservations *ReservationRepository
+	webhooks     *webhook.Service
+	audit        *audit.RecordRepository
+}
+
+func NewNoShowDetector(ctx context.Context) (*NoShowDetector, error) {
+	reservations, err := NewReservationRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise reservation repository")
+	}
+	webhooks, err := webhook.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise webhook service")
+	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise audit repository")
+	}
+
+	return &NoShowDetector{
+		Grace:        DefaultNoShowGrace,
+		reservations: reservations,
+		webhooks:     webhooks,
+		audit:        audits,
+	}, nil
+}
+
+// Schedule registers the detector on scheduler `s` and schedules it every
+// `noShowInterval`
+func (d *NoShowDetector) Schedule(ctx context.Context, s *job.Scheduler) error {
+	_, err := s.HandleFunc(jobNoShow, func(ctx context.Context, id string, data []byte) error {
+		_, err := d.Run(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.Every(ctx, noShowInterval, jobNoShow, nil); err != nil {
+		return errors.Wrapf(err, "failed to schedule %s", jobNoShow)
+	}
+	return nil
+}
+
+// Run flags all reservations in progress which grace period is over and
+// which have not been checked in. It returns the number of flagged
+// reservations.
+func (d *NoShowDetector) Run(ctx context.Context) (int, error) {
+	rooms, err := d.reservations.Rooms(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := utc.Now()
+
+	var total int
+	for _, roomRef := range rooms {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		reservations, err := d.reservations.Reservations(ctx, roomRef)
+		switch {
+		case err == nil:
+		case errors.IsNotFound(err):
+			continue
+		default:
+			return total, errors.Wrapf(err, "failed to load room %s", roomRef)
+		}
+		for _, res := range reservations {
+			if !d.missed(res, now) {
+				continue
+			}
+			flagged, err := d.mark(ctx, roomRef, res.ID, now)
+			if err != nil {
+				return total, errors.Wrapf(err, "failed to flag reservation %s", res.ID)
+			}
+			if flagged {
+				total++
+			}
+		}
+	}
+	if total > 0 {
+		log.Trace(ctx, "booking.no_show", "Reservations flagged as no-show",
+			log.Int("reservations", total),
+		)
+	}
+	return total, nil
+}
+
+// missed returns whether reservation `res` is in progress at `now` and has
+// not been checked in within the grace period
+func (d *NoShowDetector) missed(res *Reservation, now utc.UTC) bool {
+	return res.Status != ReservationCancelled &&
+		res.CheckedInAt.IsZero() &&
+		!res.NoShow &&
+		res.From.Add(d.Grace) <= now &&
+		now < res.To
+}
+
+// mark flags reservation `id` as a no-show unless it has been checked in or
+// cancelled since it was loaded. It returns whether it has been flagged.
+func (d *NoShowDetector) mark(
+	ctx context.Context, roomRef, id string, now utc.UTC,
+) (bool, error) {
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := d.reservations.Get(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+			if !d.missed(before, now) {
+				return false, nil
+			}
+			res, err := d.reservations.MarkNoShow(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+			// Flagged by the system, hence no actor
+			err = d.audit.Log(ctx, audit.Actor{}, "booking.no_show",
+				audit.RoomResource(roomRef), before, res,
+			)
+			if err != nil {
+				return nil, err
+			}
+			return true, d.webhooks.Publish(ctx, webhook.EventReservationNoShow, res)
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
@@ -0,0 +1,139 @@
+package booking
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Page restricts a listing to at most `Limit` items after `Cursor`
+type Page struct {
+	// Cursor is the opaque `next` value returned with the previous page
+	Cursor string
+	Limit  int
+}
+
+func (p *Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return defaultPageLimit
+	case p.Limit > maxPageLimit:
+		return maxPageLimit
+	default:
+		return p.Limit
+	}
+}
+
+// ReservationFilter restricts a listing to the reservations overlapping
+// [From, To). Zero values are ignored.
+type ReservationFilter struct {
+	From utc.UTC
+	To   utc.UTC
+	Page
+}
+
+func (f *ReservationFilter) match(r *Reservation) bool {
+	if f.From != 0 && r.To <= f.From {
+		return false
+	}
+	if f.To != 0 && r.From >= f.To {
+		return false
+	}
+	return true
+}
+
+// paginateReservations sorts reservations by start time and returns the page
+// requested by `f` along with the cursor of the next page.
+//
+// Reservation IDs are K-sortable and generated from the start time, but a
+// rescheduled reservation keeps its ID. So the cursor contains the start time
+// and uses the ID to break ties.
+func paginateReservations(
+	reservations []*Reservation, f ReservationFilter,
+) ([]*Reservation, string, error) {
+	var after *Reservation
+	if f.Cursor != "" {
+		var err error
+		if after, err = decodeReservationCursor(f.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return lessReservation(reservations[i], reservations[j])
+	})
+
+	limit := f.limit()
+	var page []*Reservation
+	for _, r := range reservations {
+		if after != nil && !lessReservation(after, r) {
+			continue
+		}
+		if !f.match(r) {
+			continue
+		}
+		if len(page) == limit {
+			return page, encodeReservationCursor(page[len(page)-1]), nil
+		}
+		page = append(page, r)
+	}
+	return page, "", nil
+}
+
+// encodeReservationCursor returns the cursor pointing right after `r`
+func encodeReservationCursor(r *Reservation) string {
+	c := strconv.FormatInt(int64(r.From), 10) + ":" + r.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(c))
+}
+
+// decodeReservationCursor returns the start time and ID of the reservation
+// pointed by `cursor`
+func decodeReservationCursor(cursor string) (*Reservation, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return nil, errInvalidCursor
+	}
+	from, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &Reservation{ID: parts[1], From: utc.UTC(from)}, nil
+}
+
+func lessReservation(a, b *Reservation) bool {
+	if a.From != b.From {
+		return a.From < b.From
+	}
+	return a.ID < b.ID
+}
+
+// encodeRoomCursor returns the cursor pointing right after room `ref`
+func encodeRoomCursor(ref string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(ref))
+}
+
+func decodeRoomCursor(cursor string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errInvalidCursor
+	}
+	return string(data), nil
+}
+
+var errInvalidCursor = errors.Bad(&errors.FieldViolation{
+	Field:       "cursor",
+	Description: "Invalid cursor",
+})
@@ -0,0 +1,226 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/config"
+)
+
+// defaultLateCancelPeriod is how long late cancellations are counted by default
+const defaultLateCancelPeriod = 30 * 24 * time.Hour
+
+// policyIDPattern restricts policy IDs to characters safe in URLs
+var policyIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Policy is a set of booking rules applied to a room, to the rooms with a
+// tag, or to all rooms when neither is set. Rules with a zero value are not
+// enforced.
+//
+// Policies are either declared in the config file (`[[app.booking.policies]]`)
+// or through the API.
+type Policy struct {
+	ID string `json:"id" toml:"id"`
+	// Room restricts the policy to room `Room`
+	Room string `json:"room,omitempty" toml:"room"`
+	// Tag restricts the policy to the rooms tagged with `Tag`
+	Tag string `json:"tag,omitempty" toml:"tag"`
+
+	// MaxAdvance is how far ahead a reservation can start
+	MaxAdvance Duration `json:"maxAdvance,omitempty" toml:"max_advance"`
+	// MinNotice is how long before it starts a reservation can be made
+	MinNotice Duration `json:"minNotice,omitempty" toml:"min_notice"`
+	// MaxDuration is the maximum length of a reservation
+	MaxDuration Duration `json:"maxDuration,omitempty" toml:"max_duration"`
+	// Groups are the only groups which members can book
+	Groups []string `json:"groups,omitempty" toml:"groups"`
+	// CancelNotice is how long before it starts a reservation can be cancelled
+	CancelNotice Duration `json:"cancelNotice,omitempty" toml:"cancel_notice"`
+	// AllowLateCancel allows cancellations within `CancelNotice`, which are
+	// then flagged as late
+	AllowLateCancel bool `json:"allowLateCancel,omitempty" toml:"allow_late_cancel"`
+	// MaxLateCancels is the number of late cancellations after which a user
+	// cannot book anymore, until they fall out of `LateCancelPeriod`
+	MaxLateCancels int `json:"maxLateCancels,omitempty" toml:"max_late_cancels"`
+	// LateCancelPeriod is how long late cancellations are counted
+	// (30 days by default)
+	LateCancelPeriod Duration `json:"lateCancelPeriod,omitempty" toml:"late_cancel_period"`
+
+	// Static is set on policies declared in the config file, which cannot be
+	// changed through the API
+	Static bool `json:"static,omitempty" toml:"-"`
+}
+
+// Validate ensures the policy is well-formed
+func (p *Policy) Validate() error {
+	if !policyIDPattern.MatchString(p.ID) {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "id",
+			Description: "Policy IDs can only contain lowercase letters, digits, - and _ (64 max)",
+		})
+	}
+	if p.Room != "" && p.Tag != "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "tag",
+			Description: "A policy applies either to a room or to a tag",
+		})
+	}
+	for field, d := range map[string]Duration{
+		"maxAdvance":       p.MaxAdvance,
+		"minNotice":        p.MinNotice,
+		"maxDuration":      p.MaxDuration,
+		"cancelNotice":     p.CancelNotice,
+		"lateCancelPeriod": p.LateCancelPeriod,
+	} {
+		if d < 0 {
+			return errors.Bad(&errors.FieldViolation{
+				Field:       field,
+				Description: "Durations cannot be negative",
+			})
+		}
+	}
+	if p.MaxLateCancels < 0 {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "maxLateCancels",
+			Description: "The number of late cancellations cannot be negative",
+		})
+	}
+	return nil
+}
+
+// applies returns whether the policy applies to `room`
+func (p *Policy) applies(room *Room) bool {
+	switch {
+	case p.Room != "":
+		return strings.EqualFold(p.Room, room.Ref)
+	case p.Tag != "":
+		return room.HasTag(p.Tag)
+	}
+	return true
+}
+
+// checkReservation ensures reservation `res` made at `now` by a member of
+// group `groupID` complies with the policy
+func (p *Policy) checkReservation(now utc.UTC, res *Reservation, groupID string) error {
+	if p.MaxAdvance > 0 && res.From > now.Add(time.Duration(p.MaxAdvance)) {
+		return p.violation("maxAdvance", fmt.Sprintf(
+			"Reservations cannot start more than %s ahead", p.MaxAdvance,
+		))
+	}
+	if p.MinNotice > 0 && res.From < now.Add(time.Duration(p.MinNotice)) {
+		return p.violation("minNotice", fmt.Sprintf(
+			"Reservations must be made at least %s before they start", p.MinNotice,
+		))
+	}
+	if p.MaxDuration > 0 && res.To.Distance(res.From) > time.Duration(p.MaxDuration) {
+		return p.violation("maxDuration", fmt.Sprintf(
+			"Reservations cannot be longer than %s", p.MaxDuration,
+		))
+	}
+	if len(p.Groups) > 0 && !contains(p.Groups, groupID) {
+		return p.violation("groups", "Only some groups can book this room")
+	}
+	return nil
+}
+
+// checkLateCancels ensures a user who cancelled `count` reservations late
+// within the late cancellation period can still book
+func (p *Policy) checkLateCancels(count int) error {
+	if p.MaxLateCancels > 0 && count >= p.MaxLateCancels {
+		return p.violation("maxLateCancels", fmt.Sprintf(
+			"Bookings are suspended after %d late cancellations within %s",
+			p.MaxLateCancels, p.lateCancelPeriod(),
+		))
+	}
+	return nil
+}
+
+// lateCancelPeriod returns how long late cancellations are counted
+func (p *Policy) lateCancelPeriod() Duration {
+	if p.LateCancelPeriod <= 0 {
+		return Duration(defaultLateCancelPeriod)
+	}
+	return p.LateCancelPeriod
+}
+
+// checkCancellation ensures reservation `res` can be cancelled at `now`. It
+// returns whether the cancellation is late.
+func (p *Policy) checkCancellation(now utc.UTC, res *Reservation) (late bool, err error) {
+	if p.CancelNotice > 0 && res.From < now.Add(time.Duration(p.CancelNotice)) {
+		if p.AllowLateCancel {
+			return true, nil
+		}
+		return false, p.violation("cancelNotice", fmt.Sprintf(
+			"Reservations must be cancelled at least %s before they start", p.CancelNotice,
+		))
+	}
+	return false, nil
+}
+
+// violation returns the error reported when `rule` is violated
+func (p *Policy) violation(rule, description string) error {
+	return errors.Bad(&errors.FieldViolation{
+		Field:       fmt.Sprintf("policies[%s].%s", p.ID, rule),
+		Description: description,
+	})
+}
+
+// Duration is a time.Duration which is encoded as a string (e.g. "30m")
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// loadPolicies returns the policies declared in the config file under
+// `[[app.booking.policies]]`
+func loadPolicies(ctx context.Context) ([]*Policy, error) {
+	cfg := struct {
+		Policies []*Policy `toml:"policies"`
+	}{}
+	if err := config.TreeFromContext(ctx).Get("app.booking").Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	for _, p := range cfg.Policies {
+		if err := p.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid policy %s", p.ID)
+		}
+		if ids[p.ID] {
+			return nil, errors.Errorf("policy %s is declared twice", p.ID)
+		}
+		ids[p.ID] = true
+		p.Static = true
+	}
+	return cfg.Policies, nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/basgys/booking-consensys/pkg/timeutil/timespan"
 	"github.com/deixis/errors"
 	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/eventdb"
 	"github.com/deixis/storage/kvdb"
 	"github.com/segmentio/ksuid"
 )
@@ -30,7 +33,15 @@ const (
 )
 
 type ReservationRepository struct {
-	ss kvdb.Subspace
+	ss        kvdb.Subspace
+	events    *EventRepository
+	users     *UserReservationRepository
+	archive   *ArchiveRepository
+	cancelled *CancellationRepository
+	timeline  *TimelineRepository
+	rooms     *RoomsRepository
+	approvals *ApprovalRepository
+	changes   *notifier
 }
 
 func NewReservationRepository(ctx context.Context) (*ReservationRepository, error) {
@@ -42,8 +53,44 @@ func NewReservationRepository(ctx context.Context) (*ReservationRepository, erro
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open booking/reservation dir")
 	}
+	events, err := NewEventRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := NewUserReservationRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := NewArchiveRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cancelled, err := NewCancellationRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timeline, err := NewTimelineRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rooms, err := NewRoomsRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	approvals, err := NewApprovalRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return &ReservationRepository{
-		ss: dir,
+		ss:        dir,
+		events:    events,
+		users:     users,
+		archive:   archive,
+		cancelled: cancelled,
+		timeline:  timeline,
+		rooms:     rooms,
+		approvals: approvals,
+		changes:   newNotifier(),
 	}, nil
 }
 
@@ -78,6 +125,57 @@ func (r *ReservationRepository) Reservations(
 	return reservations, nil
 }
 
+// Query returns a page of the reservations of room `roomRef` ordered by start
+// time, along with the cursor of the next page (empty on the last page)
+func (r *ReservationRepository) Query(
+	ctx context.Context, roomRef string, f ReservationFilter,
+) ([]*Reservation, string, error) {
+	reservations, err := r.Reservations(ctx, roomRef)
+	if err != nil {
+		return nil, "", err
+	}
+	return paginateReservations(reservations, f)
+}
+
+// Get returns the reservation `reservationID` booked for room `roomRef`
+func (r *ReservationRepository) Get(
+	ctx context.Context, roomRef string, reservationID string,
+) (*Reservation, error) {
+	reservations, err := r.Reservations(ctx, roomRef)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range reservations {
+		if res.ID == reservationID {
+			return res, nil
+		}
+	}
+	return r.cancelled.Get(ctx, strings.TrimSpace(strings.ToUpper(roomRef)), reservationID)
+}
+
+// Cancelled returns the cancelled reservations of room `roomRef`
+func (r *ReservationRepository) Cancelled(
+	ctx context.Context, roomRef string,
+) ([]*Reservation, error) {
+	return r.cancelled.List(ctx, strings.TrimSpace(strings.ToUpper(roomRef)))
+}
+
+// LateCancellations returns the number of reservations of user `userID`
+// which have been cancelled late since `since`
+func (r *ReservationRepository) LateCancellations(
+	ctx context.Context, userID string, since utc.UTC,
+) (int, error) {
+	return r.cancelled.LateCount(ctx, userID, since)
+}
+
+// Timeline returns all reservations ever made on room `roomRef` which overlap
+// with `from`-`to`, including cancelled and archived ones
+func (r *ReservationRepository) Timeline(
+	ctx context.Context, roomRef string, from, to utc.UTC,
+) ([]*trackedReservation, error) {
+	return r.timeline.Between(ctx, strings.TrimSpace(strings.ToUpper(roomRef)), from, to)
+}
+
 func (r *ReservationRepository) Reserve(
 	ctx context.Context, reservation *Reservation,
 ) error {
@@ -97,6 +195,13 @@ func (r *ReservationRepository) Reserve(
 			Description: "Invalid reservation interval",
 		})
 	}
+	if reservation.Seats < 0 {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "seats",
+			Description: "The number of seats cannot be negative",
+		})
+	}
+	reservation.Seats = int(reservation.seats())
 
 	// Generate a K-Sortable Unique IDentifier based on the start date
 	id, err := ksuid.NewRandomWithTime(reservation.From.Time())
@@ -104,53 +209,121 @@ func (r *ReservationRepository) Reserve(
 		return err
 	}
 	reservation.ID = id.String()
+	reservation.Status = ReservationActive
+	reservation.Version = 1
 
 	_, err = kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
-			roomKey := r.ss.Pack([]kvdb.TupleElement{reservation.RoomRef})
-			var reservations []*Reservation
-			// Load list
-			data, err := tx.Get(roomKey).Get()
+			reservations, err := r.load(tx, reservation.RoomRef)
 			if err != nil {
 				return nil, err
 			}
-			if len(data) > 0 {
-				rdr := bytes.NewReader(data)
-				if err := gob.NewDecoder(rdr).Decode(&reservations); err != nil {
-					return nil, errors.Wrap(err, "failed to unmarshal reservations")
-				}
+			capacity, held, err := r.constraints(ctx, reservation.RoomRef)
+			if err != nil {
+				return nil, err
 			}
-
-			// Ensure it is in a free range
-			timeset := timespan.Empty()
-			for _, res := range reservations {
-				timeset.Insert(res.From, res.To)
+			if reservation.seats() > capacity {
+				return nil, errors.Bad(&errors.FieldViolation{
+					Field:       "seats",
+					Description: fmt.Sprintf("The room has %d seats", capacity),
+				})
 			}
-			busy := timeset.IntervalsBetween(reservation.Interval()).Iterator().Advance()
-			if busy {
+
+			// Ensure there are enough seats left on the range
+			if !fits(append(held, reservations...), reservation, capacity) {
 				return nil, errors.Aborted(&errors.ConflictViolation{
 					Resource:    "reservation",
 					Description: "There is already a reservation on this range",
 				})
 			}
 
-			// Add reservation to the list
-			reservations = append(reservations, reservation)
+			return nil, r.record(ctx, &ReservedEvent{
+				Reservation: *reservation,
+				At:          utc.Now(),
+			})
+		},
+	)
+	return err
+}
 
-			// Persist list
-			var encoded bytes.Buffer
-			if err := gob.NewEncoder(&encoded).Encode(reservations); err != nil {
-				return nil, errors.Wrap(err, "failed to marshal reservations")
+// Reschedule moves an existing reservation to the interval [from, to)
+func (r *ReservationRepository) Reschedule(
+	ctx context.Context, roomRef string, reservationID string, from, to utc.UTC,
+) (*Reservation, error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+	from = from.Floor(minPrecision)
+	to = to.Ceil(minPrecision)
+
+	if roomRef == "" {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "roomRef",
+			Description: "A room reference is required to reschedule a reservation",
+		})
+	}
+	if from >= to {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "Invalid reservation interval",
+		})
+	}
+
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			reservations, err := r.load(tx, roomRef)
+			if err != nil {
+				return nil, err
 			}
-			tx.Set(roomKey, encoded.Bytes())
-			return nil, err
+
+			var res *Reservation
+			var others []*Reservation
+			for _, r := range reservations {
+				if r.ID == reservationID {
+					res = r
+					continue
+				}
+				others = append(others, r)
+			}
+			if res == nil {
+				return nil, errors.NotFound
+			}
+
+			rescheduled := *res
+			rescheduled.From = from
+			rescheduled.To = to
+			rescheduled.Version++
+			capacity, held, err := r.constraints(ctx, roomRef)
+			if err != nil {
+				return nil, err
+			}
+			if !fits(append(held, others...), &rescheduled, capacity) {
+				return nil, errors.Aborted(&errors.ConflictViolation{
+					Resource:    "reservation",
+					Description: "There is already a reservation on this range",
+				})
+			}
+
+			err = r.record(ctx, &RescheduledEvent{
+				RoomRef:       roomRef,
+				ReservationID: reservationID,
+				UserID:        res.UserID,
+				From:          from,
+				To:            to,
+				At:            utc.Now(),
+			})
+			return &rescheduled, err
 		},
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Reservation), nil
 }
 
+// Cancel cancels reservation `reservationID` on behalf of user `cancelledBy`.
+// The cancelled reservation is kept with its status and flagged as late when
+// `late` is set.
 func (r *ReservationRepository) Cancel(
-	ctx context.Context, roomRef string, reservationID string,
+	ctx context.Context, roomRef, reservationID, cancelledBy string, late bool,
 ) error {
 	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
 
@@ -169,151 +342,2146 @@ func (r *ReservationRepository) Cancel(
 
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
-			roomKey := r.ss.Pack([]kvdb.TupleElement{roomRef})
-			// Load list
-			var reservations []*Reservation
-			data, err := tx.Get(roomKey).Get()
+			reservations, err := r.load(tx, roomRef)
 			if err != nil {
 				return nil, err
 			}
-			if len(data) == 0 {
+			if len(reservations) == 0 {
 				return nil, errors.NotFound
 			}
-			rdr := bytes.NewReader(data)
-			if err := gob.NewDecoder(rdr).Decode(&reservations); err != nil {
-				return nil, errors.Wrap(err, "failed to unmarshal reservations")
-			}
 
-			// FIXME: It is a highly inneficient way to to cancel a reservation
-			var kept []*Reservation
 			for _, res := range reservations {
-				if res.ID != reservationID {
-					kept = append(kept, res)
+				if res.ID == reservationID {
+					return nil, r.record(ctx, &CancelledEvent{
+						RoomRef:       roomRef,
+						ReservationID: reservationID,
+						UserID:        res.UserID,
+						CancelledBy:   cancelledBy,
+						Late:          late,
+						At:            utc.Now(),
+					})
 				}
 			}
-			if len(kept) == len(reservations) {
-				return nil, errors.NotFound
-			}
-
-			// Persist list
-			var encoded bytes.Buffer
-			if err := gob.NewEncoder(&encoded).Encode(kept); err != nil {
-				return nil, errors.Wrap(err, "failed to marshal reservations")
-			}
-			tx.Set(roomKey, encoded.Bytes())
-			return nil, err
+			return nil, errors.NotFound
 		},
 	)
 	return err
 }
 
-// FreeRanges returns a disjoint set of free ranges
-func (r *ReservationRepository) FreeRanges(
-	ctx context.Context, roomRef string, from, to utc.UTC,
-) (ivals []*TimeInterval, err error) {
-	roomRef = strings.ToUpper(roomRef)
-
-	// Ensure interval is valid
-	if to < from {
-		return nil, errors.Bad(&errors.FieldViolation{
-			Field:       "to",
-			Description: "The interval is invalid. to is smaller than from",
-		})
-	}
+// CheckIn records that user `checkedInBy` showed up for reservation
+// `reservationID`
+func (r *ReservationRepository) CheckIn(
+	ctx context.Context, roomRef, reservationID, checkedInBy string,
+) (*Reservation, error) {
+	return r.mark(ctx, roomRef, reservationID, func(res *Reservation) Event {
+		return &CheckedInEvent{
+			RoomRef:       res.RoomRef,
+			ReservationID: res.ID,
+			UserID:        res.UserID,
+			CheckedInBy:   checkedInBy,
+			At:            utc.Now(),
+		}
+	})
+}
 
-	// Initialise an empty disjoint set
-	timeset := timespan.Empty()
+// MarkNoShow flags reservation `reservationID` as a no-show
+func (r *ReservationRepository) MarkNoShow(
+	ctx context.Context, roomRef, reservationID string,
+) (*Reservation, error) {
+	return r.mark(ctx, roomRef, reservationID, func(res *Reservation) Event {
+		return &NoShowEvent{
+			RoomRef:       res.RoomRef,
+			ReservationID: res.ID,
+			UserID:        res.UserID,
+			At:            utc.Now(),
+		}
+	})
+}
 
-	// Set the whole range as available by default
-	timeset.Insert(from, to)
+// mark records the event returned by `event` on reservation `reservationID`
+// and returns the reservation once the event has been applied
+func (r *ReservationRepository) mark(
+	ctx context.Context, roomRef, reservationID string, event func(*Reservation) Event,
+) (*Reservation, error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
 
-	// Load reservations
-	// Note: This step will close free ranges
-	reservations, err := r.Reservations(ctx, roomRef)
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			reservations, err := r.load(tx, roomRef)
+			if err != nil {
+				return nil, err
+			}
+			for _, res := range reservations {
+				if res.ID != reservationID {
+					continue
+				}
+				if err := r.record(ctx, event(res)); err != nil {
+					return nil, err
+				}
+				reservations, err := r.load(tx, roomRef)
+				if err != nil {
+					return nil, err
+				}
+				for _, res := range reservations {
+					if res.ID == reservationID {
+						return res, nil
+					}
+				}
+			}
+			return nil, errors.NotFound
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
-	for _, r := range reservations {
-		timeset.Remove(r.From, r.To)
-	}
+	return v.(*Reservation), nil
+}
 
-	// Convert to availabilities
-	iter := timeset.IntervalsBetween(&timespan.Span{Start: from, End: to}).Iterator()
-	for iter.Advance() {
-		iv := iter.Get().(*timespan.Span)
-		if iv.Duration() < minReservationDuration {
-			continue
-		}
+// Events returns the events recorded on room `roomRef` after version `after`
+func (r *ReservationRepository) Events(
+	ctx context.Context, roomRef string, after uint64,
+) ([]*RecordedEvent, error) {
+	return r.events.Events(ctx, strings.TrimSpace(strings.ToUpper(roomRef)), after)
+}
 
-		ivals = append(ivals, &TimeInterval{
-			From: iv.Start,
-			To:   iv.End,
-		})
+// Version returns the version of the log of room `roomRef`
+func (r *ReservationRepository) Version(ctx context.Context, roomRef string) (uint64, error) {
+	return r.events.Version(ctx, strings.TrimSpace(strings.ToUpper(roomRef)))
+}
+
+// Changed returns a channel which is closed on the next change recorded by
+// this repository
+func (r *ReservationRepository) Changed() <-chan struct{} {
+	return r.changes.wait()
+}
+
+// Archive moves reservations of room `roomRef` which ended before `before`
+// to the archive. When `summarise` is set, only daily summaries are kept.
+//
+// At most `limit` reservations are archived in a single transaction. It
+// returns the number of archived reservations.
+func (r *ReservationRepository) Archive(
+	ctx context.Context, roomRef string, before utc.UTC, summarise bool, limit int,
+) (int, error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			reservations, err := r.load(tx, roomRef)
+			if err != nil {
+				return nil, err
+			}
+
+			var n int
+			for _, res := range reservations {
+				if n == limit {
+					break
+				}
+				if res.To > before {
+					continue
+				}
+				err := r.record(ctx, &ArchivedEvent{
+					Reservation: *res,
+					Summarised:  summarise,
+					At:          utc.Now(),
+				})
+				if err != nil {
+					return nil, err
+				}
+				n++
+			}
+			return n, nil
+		},
+	)
+	if err != nil {
+		return 0, err
 	}
-	return ivals, nil
+	return v.(int), nil
 }
 
-type RoomsRepository struct {
-	ss kvdb.Subspace
+// Rooms returns the reference of all rooms which have reservations
+func (r *ReservationRepository) Rooms(ctx context.Context) ([]string, error) {
+	return r.events.Rooms(ctx)
 }
 
-func NewRoomsRepository(ctx context.Context) (*RoomsRepository, error) {
-	store, ok := kvdb.FromContext(ctx)
-	if !ok {
-		return nil, kvdb.ErrNoConnectionFound
+// UserReservations returns all reservations made by user `userID`
+func (r *ReservationRepository) UserReservations(
+	ctx context.Context, userID string,
+) ([]*Reservation, error) {
+	return r.users.List(ctx, userID)
+}
+
+// Rebuild clears all projections and rebuilds them by replaying the
+// reservation log.
+//
+// Rooms reserved before the log existed are first backfilled with one
+// ReservedEvent per reservation, so no data is lost on the first rebuild.
+// Projections are inconsistent while this is running, and bookings made in
+// the meantime would be checked against empty schedules, so it must only run
+// offline, while the API is stopped (see the migrate command).
+func (r *ReservationRepository) Rebuild(ctx context.Context) error {
+	if err := r.backfill(ctx); err != nil {
+		return errors.Wrap(err, "failed to backfill reservation log")
 	}
-	dir, err := store.CreateOrOpenDir([]string{"booking", "room"})
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			if err := r.users.Clear(ctx); err != nil {
+				return nil, err
+			}
+			if err := r.cancelled.Clear(ctx); err != nil {
+				return nil, err
+			}
+			if err := r.timeline.Clear(ctx); err != nil {
+				return nil, err
+			}
+			return nil, r.archive.Clear(ctx)
+		},
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to open booking/room dir")
+		return errors.Wrap(err, "failed to clear projections")
 	}
-	return &RoomsRepository{
-		ss: dir,
-	}, nil
+
+	rooms, err := r.events.Rooms(ctx)
+	if err != nil {
+		return err
+	}
+	for _, roomRef := range rooms {
+		_, err := kvdb.Transact(ctx,
+			func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+				events, err := r.events.Events(ctx, roomRef, 0)
+				if err != nil {
+					return nil, err
+				}
+				for _, e := range events {
+					if err := r.apply(ctx, e.Event); err != nil {
+						return nil, err
+					}
+				}
+				return nil, nil
+			},
+		)
+		if err != nil {
+			return errors.Wrapf(err, "failed to replay room %s", roomRef)
+		}
+	}
+	return nil
 }
 
-func (r *RoomsRepository) List(ctx context.Context) (rooms []*Room, err error) {
-	_, err = kvdb.ReadTransact(ctx,
+// backfill records the reservations of rooms which do not have a log yet
+func (r *ReservationRepository) backfill(ctx context.Context) error {
+	v, err := kvdb.ReadTransact(ctx,
 		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
 			rng := kvdb.KeyRange{
 				Begin: r.ss.Pack([]kvdb.TupleElement{firstKey}),
 				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
 			}
+			var rooms []string
 			iter := tx.GetRange(rng).Iterator()
 			for iter.Advance() {
 				kv, err := iter.Get()
 				if err != nil {
 					return nil, err
 				}
-				room := Room{}
-				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&room)
+				t, err := r.ss.Unpack(kv.Key)
 				if err != nil {
 					return nil, err
 				}
-				rooms = append(rooms, &room)
+				rooms = append(rooms, t[0].(string))
 			}
-
-			return nil, err
+			return rooms, nil
 		},
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return rooms, nil
+
+	for _, roomRef := range v.([]string) {
+		_, err := kvdb.Transact(ctx,
+			func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+				exists, err := r.events.Exists(ctx, roomRef)
+				if err != nil || exists {
+					return nil, err
+				}
+				reservations, err := r.load(tx, roomRef)
+				if err != nil {
+					return nil, err
+				}
+				events := make([]Event, len(reservations))
+				for i, res := range reservations {
+					events[i] = &ReservedEvent{Reservation: *res, At: utc.Now()}
+				}
+				return nil, r.events.Append(ctx, roomRef, events...)
+			},
+		)
+		if err != nil {
+			return errors.Wrapf(err, "failed to backfill room %s", roomRef)
+		}
+	}
+	return nil
 }
 
-func (r *RoomsRepository) Create(ctx context.Context, room *Room) error {
+// record appends `e` to the log and applies it to all projections
+func (r *ReservationRepository) record(ctx context.Context, e Event) error {
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
-			key := r.ss.Pack([]kvdb.TupleElement{room.Ref})
+			if err := r.events.Append(ctx, e.Room(), e); err != nil {
+				return nil, err
+			}
+			tx.AfterCommit(func(context.Context) {
+				r.changes.notify()
+			})
+			return nil, r.apply(ctx, e)
+		},
+	)
+	return err
+}
 
-			var encoded bytes.Buffer
-			err := gob.NewEncoder(&encoded).Encode(&room)
+// apply projects `e` onto the room schedule, the user index, the timeline
+// and the archive
+func (r *ReservationRepository) apply(ctx context.Context, e Event) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			reservations, err := r.load(tx, e.Room())
 			if err != nil {
 				return nil, err
 			}
 
-			tx.Set(key, encoded.Bytes())
-			return nil, err
+			switch e := e.(type) {
+			case *ReservedEvent:
+				res := e.Reservation
+				if res.Version == 0 {
+					// Recorded before reservations were versioned
+					res.Version = 1
+				}
+				if res.Status == "" {
+					res.Status = ReservationActive
+				}
+				reservations = append(reservations, &res)
+				if err := r.users.Put(ctx, &res); err != nil {
+					return nil, err
+				}
+				if err := r.timeline.Put(ctx, &res, e.At); err != nil {
+					return nil, err
+				}
+			case *CancelledEvent:
+				// FIXME: It is a highly inneficient way to to cancel a reservation
+				var kept []*Reservation
+				for _, res := range reservations {
+					if res.ID != e.ReservationID {
+						kept = append(kept, res)
+						continue
+					}
+					res.Status = ReservationCancelled
+					res.CancelledAt = e.At
+					res.CancelledBy = e.CancelledBy
+					res.LateCancel = e.Late
+					if err := r.cancelled.Put(ctx, res); err != nil {
+						return nil, err
+					}
+					if err := r.timeline.Cancel(ctx, res, e.Late); err != nil {
+						return nil, err
+					}
+				}
+				reservations = kept
+				if err := r.users.Delete(ctx, e.UserID, e.ReservationID); err != nil {
+					return nil, err
+				}
+			case *RescheduledEvent:
+				for _, res := range reservations {
+					if res.ID != e.ReservationID {
+						continue
+					}
+					if err := r.timeline.Move(ctx, res, e.From, e.To); err != nil {
+						return nil, err
+					}
+					res.From = e.From
+					res.To = e.To
+					res.Version++
+					if err := r.users.Put(ctx, res); err != nil {
+						return nil, err
+					}
+				}
+			case *CheckedInEvent:
+				for _, res := range reservations {
+					if res.ID != e.ReservationID {
+						continue
+					}
+					res.CheckedInAt = e.At
+					res.CheckedInBy = e.CheckedInBy
+					if err := r.users.Put(ctx, res); err != nil {
+						return nil, err
+					}
+				}
+			case *NoShowEvent:
+				for _, res := range reservations {
+					if res.ID != e.ReservationID {
+						continue
+					}
+					res.NoShow = true
+					if err := r.users.Put(ctx, res); err != nil {
+						return nil, err
+					}
+				}
+			case *ArchivedEvent:
+				var kept []*Reservation
+				for _, res := range reservations {
+					if res.ID != e.Reservation.ID {
+						kept = append(kept, res)
+					}
+				}
+				reservations = kept
+				err := r.users.Delete(ctx, e.Reservation.UserID, e.Reservation.ID)
+				if err != nil {
+					return nil, err
+				}
+				if err := r.archive.Put(ctx, &e.Reservation, e.Summarised); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, errors.Errorf("unsupported event %T", e)
+			}
+
+			return nil, r.save(tx, e.Room(), reservations)
+		},
+	)
+	return err
+}
+
+// load returns the reservations of room `roomRef`
+func (r *ReservationRepository) load(
+	tx kvdb.ReadTransaction, roomRef string,
+) (reservations []*Reservation, err error) {
+	data, err := tx.Get(r.ss.Pack([]kvdb.TupleElement{roomRef})).Get()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		rdr := bytes.NewReader(data)
+		if err := gob.NewDecoder(rdr).Decode(&reservations); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal reservations")
+		}
+	}
+	return reservations, nil
+}
+
+// save persists the reservations of room `roomRef`
+func (r *ReservationRepository) save(
+	tx kvdb.Transaction, roomRef string, reservations []*Reservation,
+) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(reservations); err != nil {
+		return errors.Wrap(err, "failed to marshal reservations")
+	}
+	tx.Set(r.ss.Pack([]kvdb.TupleElement{roomRef}), encoded.Bytes())
+	return nil
+}
+
+// constraints returns the number of seats of room `roomRef` along with the
+// pending requests holding some of them. Rooms which have not been created
+// are reserved as a whole.
+func (r *ReservationRepository) constraints(
+	ctx context.Context, roomRef string,
+) (capacity uint64, held []*Reservation, err error) {
+	room, err := r.rooms.Get(ctx, roomRef)
+	switch {
+	case err == nil:
+	case errors.IsNotFound(err):
+		return 1, nil, nil
+	default:
+		return 0, nil, err
+	}
+	if !room.HoldPending {
+		return room.capacity(), nil, nil
+	}
+
+	pending, err := r.approvals.Pending(ctx, roomRef, utc.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, req := range pending {
+		held = append(held, req.reservation())
+	}
+	return room.capacity(), held, nil
+}
+
+// fits returns whether there are enough seats left for `res` next to
+// `reservations` in a room of `capacity` seats
+func fits(reservations []*Reservation, res *Reservation, capacity uint64) bool {
+	used := timespan.EmptyWeighted()
+	for _, r := range reservations {
+		used.Add(r.From, r.To, r.seats())
+	}
+	return used.Max(res.From, res.To)+res.seats() <= capacity
+}
+
+// FreeRanges returns a disjoint set of free ranges
+func (r *ReservationRepository) FreeRanges(
+	ctx context.Context, roomRef string, from, to utc.UTC,
+) ([]*TimeInterval, error) {
+	roomRef = strings.ToUpper(roomRef)
+
+	// Ensure interval is valid
+	if to < from {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
+	}
+
+	capacity, held, err := r.constraints(ctx, roomRef)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := r.Reservations(ctx, roomRef)
+	if err != nil {
+		return nil, err
+	}
+	s := schedule{
+		capacity:     capacity,
+		reservations: reservations,
+		held:         held,
+	}
+	return s.freeRanges(from, to), nil
+}
+
+// schedules returns the schedules of `rooms` keyed by room ref. The
+// reservations of all rooms are read at once.
+func (r *ReservationRepository) schedules(
+	ctx context.Context, rooms []*Room,
+) (map[string]*schedule, error) {
+	now := utc.Now()
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			// Issue all reads before waiting for the first one
+			futures := make([]kvdb.FutureByteSlice, len(rooms))
+			for i, room := range rooms {
+				futures[i] = tx.Get(r.ss.Pack([]kvdb.TupleElement{room.Ref}))
+			}
+
+			schedules := make(map[string]*schedule, len(rooms))
+			for i, room := range rooms {
+				data, err := futures[i].Get()
+				if err != nil {
+					return nil, err
+				}
+				s := &schedule{capacity: room.capacity()}
+				if len(data) > 0 {
+					rdr := bytes.NewReader(data)
+					if err := gob.NewDecoder(rdr).Decode(&s.reservations); err != nil {
+						return nil, errors.Wrap(err, "failed to unmarshal reservations")
+					}
+				}
+				if room.HoldPending {
+					pending, err := r.approvals.Pending(ctx, room.Ref, now)
+					if err != nil {
+						return nil, err
+					}
+					for _, req := range pending {
+						s.held = append(s.held, req.reservation())
+					}
+				}
+				schedules[room.Ref] = s
+			}
+			return schedules, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]*schedule), nil
+}
+
+// schedule is the occupancy of a room
+type schedule struct {
+	// capacity is the number of seats of the room
+	capacity     uint64
+	reservations []*Reservation
+	// held are the pending requests holding seats
+	held []*Reservation
+}
+
+// freeRanges returns a disjoint set of free ranges between `from` and `to`
+func (s *schedule) freeRanges(from, to utc.UTC) (ivals []*TimeInterval) {
+	// Initialise an empty weighted set
+	timeset := timespan.EmptyWeighted()
+
+	// Set all seats of the whole range as available by default
+	timeset.Add(from, to, s.capacity)
+
+	// Note: This step will consume seats and close full ranges
+	for _, l := range [][]*Reservation{s.held, s.reservations} {
+		for _, r := range l {
+			timeset.Sub(r.From, r.To, r.seats())
+		}
+	}
+
+	// Convert to availabilities
+	for _, iv := range timeset.IntervalsBetween(from, to) {
+		if iv.Duration() < minReservationDuration {
+			continue
+		}
+
+		ivals = append(ivals, &TimeInterval{
+			From:     iv.Start,
+			To:       iv.End,
+			Capacity: int(iv.Weight()),
+		})
+	}
+	return ivals
+}
+
+type RoomsRepository struct {
+	ss kvdb.Subspace
+	// schedules is the subspace of ReservationRepository, which is read to
+	// check capacity changes
+	schedules kvdb.Subspace
+}
+
+func NewRoomsRepository(ctx context.Context) (*RoomsRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	dir, err := store.CreateOrOpenDir([]string{"booking", "room"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/room dir")
+	}
+	schedules, err := store.CreateOrOpenDir([]string{"booking", "reservation"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/reservation dir")
+	}
+	return &RoomsRepository{
+		ss:        dir,
+		schedules: schedules,
+	}, nil
+}
+
+func (r *RoomsRepository) List(ctx context.Context) (rooms []*Room, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				room := Room{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&room)
+				if err != nil {
+					return nil, err
+				}
+				rooms = append(rooms, &room)
+			}
+
+			return nil, err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// Query returns a page of rooms ordered by reference, along with the cursor
+// of the next page (empty on the last page)
+func (r *RoomsRepository) Query(
+	ctx context.Context, p Page,
+) (rooms []*Room, next string, err error) {
+	begin := r.ss.Pack([]kvdb.TupleElement{firstKey})
+	var after string
+	if p.Cursor != "" {
+		if after, err = decodeRoomCursor(p.Cursor); err != nil {
+			return nil, "", err
+		}
+		begin = r.ss.Pack([]kvdb.TupleElement{after})
+	}
+	limit := p.limit()
+
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: begin,
+				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
+			}
+			// Fetch one extra room to know whether there is a next page
+			iter := tx.GetRange(rng, kvdb.WithRangeLimit(limit+2)).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				room := Room{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&room)
+				if err != nil {
+					return nil, err
+				}
+				if p.Cursor != "" && room.Ref == after {
+					continue
+				}
+				if len(rooms) == limit {
+					next = encodeRoomCursor(rooms[len(rooms)-1].Ref)
+					continue
+				}
+				rooms = append(rooms, &room)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	return rooms, next, nil
+}
+
+// Get returns room `ref`
+func (r *RoomsRepository) Get(ctx context.Context, ref string) (*Room, error) {
+	ref = strings.TrimSpace(strings.ToUpper(ref))
+
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.ss.Pack([]kvdb.TupleElement{ref})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			room := Room{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&room); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal room")
+			}
+			return &room, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Room), nil
+}
+
+// Create adds `room`. It fails when the room already exists.
+func (r *RoomsRepository) Create(ctx context.Context, room *Room) error {
+	room.Ref = strings.TrimSpace(strings.ToUpper(room.Ref))
+	if room.Ref == "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "ref",
+			Description: "Missing room reference",
+		})
+	}
+	if err := validateCapacity(room); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			key := r.ss.Pack([]kvdb.TupleElement{room.Ref})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > 0 {
+				return nil, errors.Aborted(&errors.ConflictViolation{
+					Resource:    "room:" + room.Ref,
+					Description: "Room has already been created",
+				})
+			}
+
+			room.Version = 1
+			return nil, r.put(tx, room)
+		},
+	)
+	return err
+}
+
+// Update replaces room `room.Ref`. Unless it is 0, `room.Version` must match
+// the stored version. On success, the version is incremented.
+//
+// The capacity cannot be lowered below the number of seats reserved at once
+// in the room schedule.
+func (r *RoomsRepository) Update(ctx context.Context, room *Room) error {
+	room.Ref = strings.TrimSpace(strings.ToUpper(room.Ref))
+	if err := validateCapacity(room); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := r.Get(ctx, room.Ref)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkVersion("room:"+room.Ref, room.Version, before.Version); err != nil {
+				return nil, err
+			}
+			if room.capacity() < before.capacity() {
+				booked, err := r.booked(tx, room.Ref)
+				if err != nil {
+					return nil, err
+				}
+				if room.capacity() < booked {
+					return nil, errors.Aborted(&errors.ConflictViolation{
+						Resource:    "room:" + room.Ref,
+						Description: fmt.Sprintf("%d seats are already reserved at once", booked),
+					})
+				}
+			}
+
+			room.Version = before.Version + 1
+			return nil, r.put(tx, room)
+		},
+	)
+	return err
+}
+
+// Delete removes room `ref`. Unless it is 0, `version` must match the stored
+// version.
+func (r *RoomsRepository) Delete(ctx context.Context, ref string, version uint64) error {
+	ref = strings.TrimSpace(strings.ToUpper(ref))
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := r.Get(ctx, ref)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkVersion("room:"+ref, version, before.Version); err != nil {
+				return nil, err
+			}
+
+			tx.Clear(r.ss.Pack([]kvdb.TupleElement{ref}))
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// booked returns the largest number of seats reserved at once in the schedule
+// of room `ref`
+func (r *RoomsRepository) booked(tx kvdb.ReadTransaction, ref string) (uint64, error) {
+	data, err := tx.Get(r.schedules.Pack([]kvdb.TupleElement{ref})).Get()
+	if err != nil || len(data) == 0 {
+		return 0, err
+	}
+	var reservations []*Reservation
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&reservations); err != nil {
+		return 0, errors.Wrap(err, "failed to unmarshal reservations")
+	}
+	if len(reservations) == 0 {
+		return 0, nil
+	}
+
+	used := timespan.EmptyWeighted()
+	from, to := reservations[0].From, reservations[0].To
+	for _, res := range reservations {
+		used.Add(res.From, res.To, res.seats())
+		if res.From < from {
+			from = res.From
+		}
+		if res.To > to {
+			to = res.To
+		}
+	}
+	return used.Max(from, to), nil
+}
+
+func (r *RoomsRepository) put(tx kvdb.Transaction, room *Room) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(room); err != nil {
+		return errors.Wrap(err, "failed to marshal room")
+	}
+	tx.Set(r.ss.Pack([]kvdb.TupleElement{room.Ref}), encoded.Bytes())
+	return nil
+}
+
+// validateCapacity ensures the capacity of `room` is valid
+func validateCapacity(room *Room) error {
+	if room.Capacity < 0 {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "capacity",
+			Description: "The capacity cannot be negative",
+		})
+	}
+	return nil
+}
+
+// checkVersion returns a FailedPrecondition error when `expected` is set and
+// does not match `actual`
+func checkVersion(resource string, expected, actual uint64) error {
+	if expected == 0 || expected == actual {
+		return nil
+	}
+	return errors.FailedPrecondition(&errors.PreconditionViolation{
+		Type:        "VERSION",
+		Subject:     resource,
+		Description: fmt.Sprintf("Expected version %d, but it is at version %d", expected, actual),
+	})
+}
+
+// EventRepository stores the reservation log. Each room has its own stream
+// of events.
+type EventRepository struct {
+	store eventdb.Store
+}
+
+func NewEventRepository(ctx context.Context) (*EventRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	dir, err := store.CreateOrOpenDir([]string{"booking", "event"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/event dir")
+	}
+	events, err := eventdb.New(store, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open event store")
+	}
+	return &EventRepository{
+		store: events,
+	}, nil
+}
+
+// Append appends `events` to the log of room `roomRef`
+func (r *EventRepository) Append(
+	ctx context.Context, roomRef string, events ...Event,
+) error {
+	recorded := make([]*eventdb.RecordedEvent, len(events))
+	for i, e := range events {
+		data, err := e.MarshalEvent()
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal event")
+		}
+		recorded[i] = &eventdb.RecordedEvent{
+			ID:   ksuid.New().String(),
+			Name: eventdb.EventName(e),
+			Data: data,
+		}
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			etx := r.store.WithTransaction(tx)
+			stream := etx.Stream(roomRef)
+			meta, err := stream.Metadata()
+			switch {
+			case err == nil:
+				// Good
+			case errors.IsNotFound(err):
+				stream, err = etx.CreateStream(roomRef)
+				if err != nil {
+					return nil, err
+				}
+			default:
+				return nil, err
+			}
+			return nil, stream.AppendEvents(meta.Version, recorded...)
+		},
+	)
+	return err
+}
+
+// Events returns all events recorded on room `roomRef` after version `after`
+func (r *EventRepository) Events(
+	ctx context.Context, roomRef string, after uint64,
+) (events []*RecordedEvent, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			stream := r.store.WithReadTransaction(tx).ReadStream(roomRef)
+			iter := stream.Events(after + 1).Iterator()
+			for iter.Advance() {
+				rec, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				e, err := rec.Unmarshal()
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal event")
+				}
+				events = append(events, &RecordedEvent{
+					Version: rec.Number,
+					Event:   e.(Event),
+				})
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Version returns the version of the log of room `roomRef`. It returns 0
+// when the room does not have a log yet.
+func (r *EventRepository) Version(ctx context.Context, roomRef string) (uint64, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			meta, err := r.store.WithReadTransaction(tx).ReadStream(roomRef).Metadata()
+			switch {
+			case err == nil:
+				return meta.Version, nil
+			case errors.IsNotFound(err):
+				return uint64(0), nil
+			default:
+				return nil, err
+			}
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return v.(uint64), nil
+}
+
+// Exists returns whether room `roomRef` has a log
+func (r *EventRepository) Exists(ctx context.Context, roomRef string) (bool, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			_, err := r.store.WithReadTransaction(tx).ReadStream(roomRef).Metadata()
+			switch {
+			case err == nil:
+				return true, nil
+			case errors.IsNotFound(err):
+				return false, nil
+			default:
+				return false, err
+			}
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// Rooms returns the reference of all rooms which have a log
+func (r *EventRepository) Rooms(ctx context.Context) (rooms []string, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			iter := r.store.WithReadTransaction(tx).ReadStreams().Iterator()
+			for iter.Advance() {
+				stream, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				meta, err := stream.Metadata()
+				if err != nil {
+					return nil, err
+				}
+				rooms = append(rooms, meta.ID)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// ArchiveRepository is a projection of the reservation log which contains
+// past reservations moved out of room schedules, along with daily summaries
+type ArchiveRepository struct {
+	reservations kvdb.Subspace
+	summaries    kvdb.Subspace
+}
+
+func NewArchiveRepository(ctx context.Context) (*ArchiveRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	reservations, err := store.CreateOrOpenDir([]string{"booking", "archive"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/archive dir")
+	}
+	summaries, err := store.CreateOrOpenDir([]string{"booking", "archive-summary"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/archive-summary dir")
+	}
+	return &ArchiveRepository{
+		reservations: reservations,
+		summaries:    summaries,
+	}, nil
+}
+
+// Put archives `res`. When `summarise` is set, only its daily summary is
+// updated.
+func (r *ArchiveRepository) Put(ctx context.Context, res *Reservation, summarise bool) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			if !summarise {
+				var encoded bytes.Buffer
+				if err := gob.NewEncoder(&encoded).Encode(res); err != nil {
+					return nil, errors.Wrap(err, "failed to marshal reservation")
+				}
+				key := r.reservations.Pack([]kvdb.TupleElement{
+					res.RoomRef, int64(res.From), res.ID,
+				})
+				tx.Set(key, encoded.Bytes())
+			}
+
+			day := res.From.Floor(24 * time.Hour)
+			key := r.summaries.Pack([]kvdb.TupleElement{res.RoomRef, int64(day)})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			summary := ArchiveSummary{RoomRef: res.RoomRef, Day: day}
+			if len(data) > 0 {
+				err := gob.NewDecoder(bytes.NewReader(data)).Decode(&summary)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal summary")
+				}
+			}
+			summary.Reservations++
+			summary.Minutes += int64(res.To.Distance(res.From) / time.Minute)
+
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(&summary); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal summary")
+			}
+			tx.Set(key, encoded.Bytes())
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Reservations returns a page of the archived reservations of room `roomRef`
+// which started within [f.From, f.To), ordered by start time
+func (r *ArchiveRepository) Reservations(
+	ctx context.Context, roomRef string, f ReservationFilter,
+) (reservations []*Reservation, next string, err error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+	var after *Reservation
+	if f.Cursor != "" {
+		if after, err = decodeReservationCursor(f.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	begin := r.reservations.Pack([]kvdb.TupleElement{roomRef, int64(f.From)})
+	if after != nil && after.From > f.From {
+		begin = r.reservations.Pack([]kvdb.TupleElement{roomRef, int64(after.From)})
+	}
+	limit := f.limit()
+
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: begin,
+				End:   r.reservations.Pack([]kvdb.TupleElement{roomRef, lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				res := Reservation{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&res)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal reservation")
+				}
+				if f.To != 0 && res.From >= f.To {
+					break
+				}
+				if after != nil && !lessReservation(after, &res) {
+					continue
+				}
+				if len(reservations) == limit {
+					next = encodeReservationCursor(reservations[len(reservations)-1])
+					break
+				}
+				reservations = append(reservations, &res)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	return reservations, next, nil
+}
+
+// Summaries returns the daily summaries of room `roomRef` within [from, to).
+// Zero values are ignored.
+func (r *ArchiveRepository) Summaries(
+	ctx context.Context, roomRef string, from, to utc.UTC,
+) (summaries []*ArchiveSummary, err error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.summaries.Pack([]kvdb.TupleElement{roomRef, int64(from)}),
+				End:   r.summaries.Pack([]kvdb.TupleElement{roomRef, lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				summary := ArchiveSummary{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&summary)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal summary")
+				}
+				if to != 0 && summary.Day >= to {
+					break
+				}
+				summaries = append(summaries, &summary)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// Clear removes all archived reservations and summaries
+func (r *ArchiveRepository) Clear(ctx context.Context) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.reservations.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.reservations.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.summaries.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.summaries.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// CancellationRepository is a projection of the reservation log which
+// contains cancelled reservations, along with an index of late cancellations
+// per user
+type CancellationRepository struct {
+	reservations kvdb.Subspace
+	late         kvdb.Subspace
+}
+
+func NewCancellationRepository(ctx context.Context) (*CancellationRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	reservations, err := store.CreateOrOpenDir([]string{"booking", "cancelled"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/cancelled dir")
+	}
+	late, err := store.CreateOrOpenDir([]string{"booking", "cancelled-late"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/cancelled-late dir")
+	}
+	return &CancellationRepository{
+		reservations: reservations,
+		late:         late,
+	}, nil
+}
+
+// Put stores cancelled reservation `res`
+func (r *CancellationRepository) Put(ctx context.Context, res *Reservation) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(res); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal reservation")
+			}
+			tx.Set(r.reservations.Pack([]kvdb.TupleElement{res.RoomRef, res.ID}), encoded.Bytes())
+			if res.LateCancel {
+				tx.Set(r.late.Pack([]kvdb.TupleElement{
+					res.UserID, int64(res.CancelledAt), res.RoomRef, res.ID,
+				}), []byte{})
+			}
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Get returns cancelled reservation `reservationID` of room `roomRef`
+func (r *CancellationRepository) Get(
+	ctx context.Context, roomRef, reservationID string,
+) (*Reservation, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.reservations.Pack([]kvdb.TupleElement{roomRef, reservationID})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			res := Reservation{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&res); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal reservation")
+			}
+			return &res, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Reservation), nil
+}
+
+// List returns the cancelled reservations of room `roomRef`
+func (r *CancellationRepository) List(
+	ctx context.Context, roomRef string,
+) (reservations []*Reservation, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.reservations.Pack([]kvdb.TupleElement{roomRef, firstKey}),
+				End:   r.reservations.Pack([]kvdb.TupleElement{roomRef, lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				res := Reservation{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&res)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal reservation")
+				}
+				reservations = append(reservations, &res)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].From < reservations[j].From
+	})
+	return reservations, nil
+}
+
+// LateCount returns the number of reservations of user `userID` which have
+// been cancelled late since `since`
+func (r *CancellationRepository) LateCount(
+	ctx context.Context, userID string, since utc.UTC,
+) (int, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.late.Pack([]kvdb.TupleElement{userID, int64(since)}),
+				End:   r.late.Pack([]kvdb.TupleElement{userID, lastKey}),
+			}
+			var n int
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				if _, err := iter.Get(); err != nil {
+					return nil, err
+				}
+				n++
+			}
+			return n, nil
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return v.(int), nil
+}
+
+// Clear removes all entries from the projection
+func (r *CancellationRepository) Clear(ctx context.Context) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.reservations.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.reservations.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.late.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.late.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// TimelineRepository is a projection of the reservation log which contains
+// every reservation ever made, including cancelled and archived ones, sorted
+// by start time per room. It also keeps the longest reservation of each room,
+// so that reservations which started before a period can be found.
+type TimelineRepository struct {
+	reservations kvdb.Subspace
+	spans        kvdb.Subspace
+}
+
+// trackedReservation is a reservation as recorded on the timeline
+type trackedReservation struct {
+	Reservation
+	ReservedAt utc.UTC
+	Cancelled  bool
+}
+
+func NewTimelineRepository(ctx context.Context) (*TimelineRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	reservations, err := store.CreateOrOpenDir([]string{"booking", "timeline"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/timeline dir")
+	}
+	spans, err := store.CreateOrOpenDir([]string{"booking", "timeline-span"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/timeline-span dir")
+	}
+	return &TimelineRepository{
+		reservations: reservations,
+		spans:        spans,
+	}, nil
+}
+
+// Put adds reservation `res`, reserved at `at`, to the timeline
+func (r *TimelineRepository) Put(ctx context.Context, res *Reservation, at utc.UTC) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			return nil, r.put(tx, &trackedReservation{Reservation: *res, ReservedAt: at})
+		},
+	)
+	return err
+}
+
+// Cancel flags reservation `res` as cancelled. Reservations missing from the
+// timeline are ignored.
+func (r *TimelineRepository) Cancel(ctx context.Context, res *Reservation, late bool) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tracked, err := r.get(tx, res)
+			if err != nil || tracked == nil {
+				return nil, err
+			}
+			tracked.Cancelled = true
+			tracked.LateCancel = late
+			return nil, r.put(tx, tracked)
+		},
+	)
+	return err
+}
+
+// Move reschedules reservation `res` to `from`-`to`. Reservations missing
+// from the timeline are ignored.
+func (r *TimelineRepository) Move(
+	ctx context.Context, res *Reservation, from, to utc.UTC,
+) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tracked, err := r.get(tx, res)
+			if err != nil || tracked == nil {
+				return nil, err
+			}
+			tx.Clear(r.key(&tracked.Reservation))
+			tracked.From = from
+			tracked.To = to
+			return nil, r.put(tx, tracked)
+		},
+	)
+	return err
+}
+
+// Between returns the reservations of room `roomRef` which overlap with
+// `from`-`to`, sorted by start time
+func (r *TimelineRepository) Between(
+	ctx context.Context, roomRef string, from, to utc.UTC,
+) (reservations []*trackedReservation, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			span, err := r.span(tx, roomRef)
+			if err != nil {
+				return nil, err
+			}
+			rng := kvdb.KeyRange{
+				Begin: r.reservations.Pack([]kvdb.TupleElement{roomRef, int64(from.Add(-span))}),
+				End:   r.reservations.Pack([]kvdb.TupleElement{roomRef, int64(to)}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				res := trackedReservation{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&res)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal reservation")
+				}
+				if res.To <= from {
+					continue
+				}
+				reservations = append(reservations, &res)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// Clear removes all entries from the projection
+func (r *TimelineRepository) Clear(ctx context.Context) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.reservations.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.reservations.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.spans.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.spans.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			return nil, nil
+		},
+	)
+	return err
+}
+
+func (r *TimelineRepository) key(res *Reservation) kvdb.Key {
+	return r.reservations.Pack([]kvdb.TupleElement{res.RoomRef, int64(res.From), res.ID})
+}
+
+func (r *TimelineRepository) get(
+	tx kvdb.ReadTransaction, res *Reservation,
+) (*trackedReservation, error) {
+	data, err := tx.Get(r.key(res)).Get()
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	tracked := trackedReservation{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tracked); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal reservation")
+	}
+	return &tracked, nil
+}
+
+// put stores `res` and widens the span of its room when needed
+func (r *TimelineRepository) put(tx kvdb.Transaction, res *trackedReservation) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(res); err != nil {
+		return errors.Wrap(err, "failed to marshal reservation")
+	}
+	tx.Set(r.key(&res.Reservation), encoded.Bytes())
+
+	span, err := r.span(tx, res.RoomRef)
+	if err != nil {
+		return err
+	}
+	if d := res.To.Distance(res.From); d > span {
+		encoded.Reset()
+		if err := gob.NewEncoder(&encoded).Encode(d); err != nil {
+			return errors.Wrap(err, "failed to marshal timeline span")
+		}
+		tx.Set(r.spans.Pack([]kvdb.TupleElement{res.RoomRef}), encoded.Bytes())
+	}
+	return nil
+}
+
+// span returns the duration of the longest reservation of room `roomRef`
+func (r *TimelineRepository) span(
+	tx kvdb.ReadTransaction, roomRef string,
+) (time.Duration, error) {
+	data, err := tx.Get(r.spans.Pack([]kvdb.TupleElement{roomRef})).Get()
+	if err != nil || len(data) == 0 {
+		return 0, err
+	}
+	var d time.Duration
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+		return 0, errors.Wrap(err, "failed to unmarshal timeline span")
+	}
+	return d, nil
+}
+
+// IdempotencyRepository stores the reservation created for each idempotency
+// key, so that retried requests return it instead of creating a new one
+type IdempotencyRepository struct {
+	keys   kvdb.Subspace
+	expiry kvdb.Subspace
+}
+
+func NewIdempotencyRepository(ctx context.Context) (*IdempotencyRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	keys, err := store.CreateOrOpenDir([]string{"booking", "idempotency"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/idempotency dir")
+	}
+	expiry, err := store.CreateOrOpenDir([]string{"booking", "idempotency-expiry"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/idempotency-expiry dir")
+	}
+	return &IdempotencyRepository{
+		keys:   keys,
+		expiry: expiry,
+	}, nil
+}
+
+// Get returns the record stored for key `key` of user `userID`. It returns
+// NotFound when there is none, or when it has expired.
+func (r *IdempotencyRepository) Get(
+	ctx context.Context, userID, key string,
+) (*IdempotencyRecord, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.keys.Pack([]kvdb.TupleElement{userID, key})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			rec := IdempotencyRecord{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal idempotency record")
+			}
+			if rec.ExpiresAt <= utc.Now() {
+				return nil, errors.NotFound
+			}
+			return &rec, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*IdempotencyRecord), nil
+}
+
+// Put stores `rec`. It replaces any record previously stored with the same key.
+func (r *IdempotencyRepository) Put(ctx context.Context, rec *IdempotencyRecord) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			key := r.keys.Pack([]kvdb.TupleElement{rec.UserID, rec.Key})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > 0 {
+				prev := IdempotencyRecord{}
+				if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&prev); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal idempotency record")
+				}
+				tx.Clear(r.expiry.Pack([]kvdb.TupleElement{
+					int64(prev.ExpiresAt), prev.UserID, prev.Key,
+				}))
+			}
+
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(rec); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal idempotency record")
+			}
+			tx.Set(key, encoded.Bytes())
+			tx.Set(r.expiry.Pack([]kvdb.TupleElement{
+				int64(rec.ExpiresAt), rec.UserID, rec.Key,
+			}), []byte{})
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Purge removes at most `limit` records which expired before `now`. It
+// returns the number of removed records.
+func (r *IdempotencyRepository) Purge(ctx context.Context, now utc.UTC, limit int) (int, error) {
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.expiry.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.expiry.Pack([]kvdb.TupleElement{int64(now)}),
+			}
+			var expired []kvdb.Key
+			iter := tx.GetRange(rng, kvdb.WithRangeLimit(limit)).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				expired = append(expired, append(kvdb.Key{}, kv.Key...))
+			}
+
+			for _, k := range expired {
+				t, err := r.expiry.Unpack(k)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unpack idempotency expiry key")
+				}
+				tx.Clear(r.keys.Pack([]kvdb.TupleElement{t[1], t[2]}))
+				tx.Clear(k)
+			}
+			return len(expired), nil
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return v.(int), nil
+}
+
+// ApprovalRepository stores reservation requests on rooms which require
+// approval. Pending requests are indexed by room and by expiry date.
+type ApprovalRepository struct {
+	requests kvdb.Subspace
+	pending  kvdb.Subspace
+	expiry   kvdb.Subspace
+}
+
+func NewApprovalRepository(ctx context.Context) (*ApprovalRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	requests, err := store.CreateOrOpenDir([]string{"booking", "approval"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/approval dir")
+	}
+	pending, err := store.CreateOrOpenDir([]string{"booking", "approval-pending"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/approval-pending dir")
+	}
+	expiry, err := store.CreateOrOpenDir([]string{"booking", "approval-expiry"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/approval-expiry dir")
+	}
+	return &ApprovalRepository{
+		requests: requests,
+		pending:  pending,
+		expiry:   expiry,
+	}, nil
+}
+
+// Create stores `req` as a new pending request
+func (r *ApprovalRepository) Create(ctx context.Context, req *ApprovalRequest) error {
+	id, err := ksuid.NewRandomWithTime(req.From.Time())
+	if err != nil {
+		return err
+	}
+	req.ID = id.String()
+	req.Status = ApprovalPending
+	req.CreatedAt = utc.Now()
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			return nil, r.put(tx, req)
+		},
+	)
+	return err
+}
+
+// Get returns request `id` of room `roomRef`
+func (r *ApprovalRepository) Get(
+	ctx context.Context, roomRef, id string,
+) (*ApprovalRequest, error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			return r.get(tx, r.requests.Pack([]kvdb.TupleElement{roomRef, id}))
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ApprovalRequest), nil
+}
+
+// List returns the requests of room `roomRef` ordered by start time. When
+// `status` is set, only requests with that status are returned.
+func (r *ApprovalRepository) List(
+	ctx context.Context, roomRef string, status ApprovalStatus,
+) ([]*ApprovalRequest, error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.requests.Pack([]kvdb.TupleElement{roomRef, firstKey}),
+				End:   r.requests.Pack([]kvdb.TupleElement{roomRef, lastKey}),
+			}
+			var l []*ApprovalRequest
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				req := ApprovalRequest{}
+				if err := gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&req); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal approval request")
+				}
+				if status != "" && req.Status != status {
+					continue
+				}
+				l = append(l, &req)
+			}
+			return l, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*ApprovalRequest), nil
+}
+
+// Pending returns the requests of room `roomRef` which are still pending at
+// `now`
+func (r *ApprovalRepository) Pending(
+	ctx context.Context, roomRef string, now utc.UTC,
+) ([]*ApprovalRequest, error) {
+	roomRef = strings.TrimSpace(strings.ToUpper(roomRef))
+
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.pending.Pack([]kvdb.TupleElement{roomRef, firstKey}),
+				End:   r.pending.Pack([]kvdb.TupleElement{roomRef, lastKey}),
+			}
+			var keys []kvdb.Key
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				keys = append(keys, append(kvdb.Key{}, kv.Key...))
+			}
+
+			var l []*ApprovalRequest
+			for _, k := range keys {
+				t, err := r.pending.Unpack(k)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unpack approval pending key")
+				}
+				req, err := r.get(tx, r.requests.Pack(t))
+				if err != nil {
+					return nil, err
+				}
+				if req.ExpiresAt <= now {
+					continue
+				}
+				l = append(l, req)
+			}
+			return l, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*ApprovalRequest), nil
+}
+
+// Update replaces request `req`. Decided requests are removed from the
+// pending indexes.
+func (r *ApprovalRepository) Update(ctx context.Context, req *ApprovalRequest) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			return nil, r.put(tx, req)
+		},
+	)
+	return err
+}
+
+// Expire rejects at most `limit` pending requests which expired by `now`.
+// It returns the number of rejected requests.
+func (r *ApprovalRepository) Expire(ctx context.Context, now utc.UTC, limit int) (int, error) {
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.expiry.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.expiry.Pack([]kvdb.TupleElement{int64(now), lastKey}),
+			}
+			var expired []kvdb.Key
+			iter := tx.GetRange(rng, kvdb.WithRangeLimit(limit)).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				expired = append(expired, append(kvdb.Key{}, kv.Key...))
+			}
+
+			for _, k := range expired {
+				t, err := r.expiry.Unpack(k)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unpack approval expiry key")
+				}
+				req, err := r.get(tx, r.requests.Pack(t[1:]))
+				if err != nil {
+					return nil, err
+				}
+				req.Status = ApprovalRejected
+				req.Reason = "The request expired before being decided"
+				req.DecidedAt = now
+				if err := r.put(tx, req); err != nil {
+					return nil, err
+				}
+			}
+			return len(expired), nil
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return v.(int), nil
+}
+
+func (r *ApprovalRepository) get(tx kvdb.ReadTransaction, key kvdb.Key) (*ApprovalRequest, error) {
+	data, err := tx.Get(key).Get()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.NotFound
+	}
+	req := ApprovalRequest{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&req); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal approval request")
+	}
+	return &req, nil
+}
+
+func (r *ApprovalRepository) put(tx kvdb.Transaction, req *ApprovalRequest) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(req); err != nil {
+		return errors.Wrap(err, "failed to marshal approval request")
+	}
+	tx.Set(r.requests.Pack([]kvdb.TupleElement{req.RoomRef, req.ID}), encoded.Bytes())
+
+	pending := r.pending.Pack([]kvdb.TupleElement{req.RoomRef, req.ID})
+	expiry := r.expiry.Pack([]kvdb.TupleElement{int64(req.ExpiresAt), req.RoomRef, req.ID})
+	if req.Status == ApprovalPending {
+		tx.Set(pending, []byte{})
+		tx.Set(expiry, []byte{})
+	} else {
+		tx.Clear(pending)
+		tx.Clear(expiry)
+	}
+	return nil
+}
+
+// UserReservationRepository is a projection of the reservation log which
+// indexes reservations by user
+type UserReservationRepository struct {
+	ss kvdb.Subspace
+}
+
+func NewUserReservationRepository(ctx context.Context) (*UserReservationRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	dir, err := store.CreateOrOpenDir([]string{"booking", "user-reservation"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/user-reservation dir")
+	}
+	return &UserReservationRepository{
+		ss: dir,
+	}, nil
+}
+
+func (r *UserReservationRepository) List(
+	ctx context.Context, userID string,
+) (reservations []*Reservation, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{userID, firstKey}),
+				End:   r.ss.Pack([]kvdb.TupleElement{userID, lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				res := Reservation{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&res)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal reservation")
+				}
+				reservations = append(reservations, &res)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (r *UserReservationRepository) Put(ctx context.Context, res *Reservation) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(res); err != nil {
+		return errors.Wrap(err, "failed to marshal reservation")
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Set(r.ss.Pack([]kvdb.TupleElement{res.UserID, res.ID}), encoded.Bytes())
+			return nil, nil
+		},
+	)
+	return err
+}
+
+func (r *UserReservationRepository) Delete(
+	ctx context.Context, userID, reservationID string,
+) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.ss.Pack([]kvdb.TupleElement{userID, reservationID}))
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Clear removes all entries from the index
+func (r *UserReservationRepository) Clear(ctx context.Context) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.ClearRange(kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
+			})
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// PolicyRepository stores the booking policies managed through the API
+type PolicyRepository struct {
+	ss kvdb.Subspace
+}
+
+func NewPolicyRepository(ctx context.Context) (*PolicyRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	dir, err := store.CreateOrOpenDir([]string{"booking", "policy"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open booking/policy dir")
+	}
+	return &PolicyRepository{
+		ss: dir,
+	}, nil
+}
+
+// List returns all policies ordered by ID
+func (r *PolicyRepository) List(ctx context.Context) (policies []*Policy, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				p := Policy{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&p)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal policy")
+				}
+				policies = append(policies, &p)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// Get returns policy `id`
+func (r *PolicyRepository) Get(ctx context.Context, id string) (*Policy, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.ss.Pack([]kvdb.TupleElement{id})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			p := Policy{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal policy")
+			}
+			return &p, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Policy), nil
+}
+
+// Put creates or replaces policy `p.ID`
+func (r *PolicyRepository) Put(ctx context.Context, p *Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(p); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal policy")
+			}
+			tx.Set(r.ss.Pack([]kvdb.TupleElement{p.ID}), encoded.Bytes())
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Delete removes policy `id`
+func (r *PolicyRepository) Delete(ctx context.Context, id string) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			key := r.ss.Pack([]kvdb.TupleElement{id})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			tx.Clear(key)
+			return nil, nil
 		},
 	)
 	return err
@@ -2,34 +2,16 @@ package booking_test
 
 import (
 	"context"
-	"os"
-	"path"
 	"testing"
+	"time"
 
 	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
 	"github.com/deixis/errors"
 	"github.com/deixis/pkg/utc"
 	"github.com/deixis/storage/kvdb"
-	"github.com/deixis/storage/kvdb/driver/badger"
 )
 
-const (
-	storageFolder = ".storage"
-)
-
-func TestMain(m *testing.M) {
-	var code int
-	func() {
-		// Prepare storage folder
-		os.Mkdir(storageFolder, 0770)
-		defer os.RemoveAll(storageFolder)
-
-		code = m.Run()
-	}()
-
-	os.Exit(code)
-}
-
 // TestReservation_Reserve test a reservation creation on a new room
 func TestReservation_Reserve(t *testing.T) {
 	ctx, err := loadStorage(t.Name())
@@ -105,7 +87,7 @@ func TestReservation_Cancellation(t *testing.T) {
 	if err := reservations.Reserve(ctx, res); err != nil {
 		t.Error("expect to create a reservation, but got", err)
 	}
-	if err := reservations.Cancel(ctx, res.RoomRef, res.ID); err != nil {
+	if err := reservations.Cancel(ctx, res.RoomRef, res.ID, res.UserID, false); err != nil {
 		t.Error("expect to cancel a reservation, but got", err)
 	}
 	if err := reservations.Reserve(ctx, res); err != nil {
@@ -147,12 +129,493 @@ func TestReservation_RoomIsolation(t *testing.T) {
 	}
 }
 
-func loadStorage(name string) (context.Context, error) {
-	store, err := badger.Open(path.Join(storageFolder, name))
+// TestReservation_Reschedule ensures a reservation can be moved, but not
+// onto another reservation
+func TestReservation_Reschedule(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	a := &booking.Reservation{
+		RoomRef: "C01",
+		From:    utc.MustParse("2021-08-01T12:00:00Z"),
+		To:      utc.MustParse("2021-08-01T13:00:00Z"),
+		UserID:  "foo",
+	}
+	if err := reservations.Reserve(ctx, a); err != nil {
+		t.Fatal("expect to create a reservation, but got", err)
+	}
+	b := &booking.Reservation{
+		RoomRef: "C01",
+		From:    utc.MustParse("2021-08-01T14:00:00Z"),
+		To:      utc.MustParse("2021-08-01T15:00:00Z"),
+		UserID:  "bar",
+	}
+	if err := reservations.Reserve(ctx, b); err != nil {
+		t.Fatal("expect to create a reservation, but got", err)
+	}
+
+	// Overlapping with itself is fine
+	_, err = reservations.Reschedule(ctx, a.RoomRef, a.ID,
+		utc.MustParse("2021-08-01T12:00:00Z"),
+		utc.MustParse("2021-08-01T14:00:00Z"),
+	)
+	if err != nil {
+		t.Error("expect to reschedule a reservation, but got", err)
+	}
+	_, err = reservations.Reschedule(ctx, a.RoomRef, a.ID,
+		utc.MustParse("2021-08-01T14:00:00Z"),
+		utc.MustParse("2021-08-01T15:00:00Z"),
+	)
+	if !errors.IsAborted(err) {
+		t.Error("expect a conflict, but got", err)
+	}
+
+	res, err := reservations.Get(ctx, a.RoomRef, a.ID)
+	if err != nil {
+		t.Fatal("expect to get the reservation, but got", err)
+	}
+	if expect := utc.MustParse("2021-08-01T14:00:00Z"); res.To != expect {
+		t.Errorf("expect reservation to end at %s, but got %s", expect, res.To)
+	}
+}
+
+// TestReservation_Rebuild ensures projections are identical after replaying
+// the reservation log
+func TestReservation_Rebuild(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	var kept *booking.Reservation
+	for i, userID := range []string{"foo", "bar", "foo"} {
+		res := &booking.Reservation{
+			RoomRef: "C01",
+			From:    utc.MustParse("2021-08-01T12:00:00Z").Add(time.Duration(i) * time.Hour),
+			To:      utc.MustParse("2021-08-01T13:00:00Z").Add(time.Duration(i) * time.Hour),
+			UserID:  userID,
+		}
+		if err := reservations.Reserve(ctx, res); err != nil {
+			t.Fatal("expect to create a reservation, but got", err)
+		}
+		if i == 0 {
+			kept = res
+		} else if userID == "foo" {
+			if err := reservations.Cancel(ctx, res.RoomRef, res.ID, res.UserID, false); err != nil {
+				t.Fatal("expect to cancel a reservation, but got", err)
+			}
+		}
+	}
+	_, err = reservations.Reschedule(ctx, kept.RoomRef, kept.ID,
+		utc.MustParse("2021-08-01T10:00:00Z"),
+		utc.MustParse("2021-08-01T11:00:00Z"),
+	)
+	if err != nil {
+		t.Fatal("expect to reschedule a reservation, but got", err)
+	}
+
+	if err := reservations.Rebuild(ctx); err != nil {
+		t.Fatal("expect to rebuild projections, but got", err)
+	}
+
+	l, err := reservations.Reservations(ctx, "C01")
+	if err != nil {
+		t.Fatal("expect to list reservations, but got", err)
+	}
+	if len(l) != 2 {
+		t.Fatalf("expect 2 reservations, but got %d", len(l))
+	}
+	l, err = reservations.UserReservations(ctx, "foo")
+	if err != nil {
+		t.Fatal("expect to list user reservations, but got", err)
+	}
+	if len(l) != 1 {
+		t.Fatalf("expect 1 user reservation, but got %d", len(l))
+	}
+	if expect := utc.MustParse("2021-08-01T10:00:00Z"); l[0].From != expect {
+		t.Errorf("expect reservation to start at %s, but got %s", expect, l[0].From)
+	}
+
+	timeline, err := reservations.Timeline(ctx, "C01",
+		utc.MustParse("2021-08-01T00:00:00Z"),
+		utc.MustParse("2021-08-02T00:00:00Z"),
+	)
+	if err != nil {
+		t.Fatal("expect to read the timeline, but got", err)
+	}
+	if len(timeline) != 3 {
+		t.Fatalf("expect 3 reservations on the timeline, but got %d", len(timeline))
+	}
+	if timeline[0].ID != kept.ID || !timeline[2].Cancelled {
+		t.Errorf("expect rescheduled reservation first and cancelled one last")
+	}
+}
+
+// TestReservation_Archive ensures past reservations are moved out of room
+// schedules and remain queryable in the archive, even after a rebuild
+func TestReservation_Archive(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	archive, err := booking.NewArchiveRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening archive repository", err)
+	}
+
+	for _, from := range []string{
+		"2021-08-01T10:00:00Z",
+		"2021-08-01T12:00:00Z",
+		"2021-08-03T12:00:00Z",
+	} {
+		res := &booking.Reservation{
+			RoomRef: "C01",
+			From:    utc.MustParse(from),
+			To:      utc.MustParse(from).Add(time.Hour),
+			UserID:  "foo",
+		}
+		if err := reservations.Reserve(ctx, res); err != nil {
+			t.Fatal("expect to create a reservation, but got", err)
+		}
+	}
+
+	before := utc.MustParse("2021-08-02T00:00:00Z")
+	n, err := reservations.Archive(ctx, "C01", before, false, 1)
+	if err != nil {
+		t.Fatal("expect to archive reservations, but got", err)
+	}
+	if n != 1 {
+		t.Fatalf("expect archive to be limited to 1 reservation, but got %d", n)
+	}
+	n, err = reservations.Archive(ctx, "C01", before, false, 100)
+	if err != nil {
+		t.Fatal("expect to archive reservations, but got", err)
+	}
+	if n != 1 {
+		t.Fatalf("expect 1 more archived reservation, but got %d", n)
+	}
+
+	check := func(step string) {
+		l, err := reservations.Reservations(ctx, "C01")
+		if err != nil {
+			t.Fatalf("%s - expect to list reservations, but got %s", step, err)
+		}
+		if len(l) != 1 {
+			t.Fatalf("%s - expect 1 live reservation, but got %d", step, len(l))
+		}
+		l, err = reservations.UserReservations(ctx, "foo")
+		if err != nil {
+			t.Fatalf("%s - expect to list user reservations, but got %s", step, err)
+		}
+		if len(l) != 1 {
+			t.Fatalf("%s - expect 1 user reservation, but got %d", step, len(l))
+		}
+		free, err := reservations.FreeRanges(ctx, "C01", utc.MustParse("2021-08-01T00:00:00Z"), before)
+		if err != nil {
+			t.Fatalf("%s - expect to get free ranges, but got %s", step, err)
+		}
+		if len(free) != 1 {
+			t.Fatalf("%s - expect archived day to be free, but got %d ranges", step, len(free))
+		}
+
+		l, next, err := archive.Reservations(ctx, "c01", booking.ReservationFilter{
+			Page: booking.Page{Limit: 1},
+		})
+		if err != nil {
+			t.Fatalf("%s - expect to query archive, but got %s", step, err)
+		}
+		if len(l) != 1 || next == "" {
+			t.Fatalf("%s - expect a first page of archived reservations, but got %d", step, len(l))
+		}
+		l, next, err = archive.Reservations(ctx, "c01", booking.ReservationFilter{
+			Page: booking.Page{Cursor: next, Limit: 1},
+		})
+		if err != nil {
+			t.Fatalf("%s - expect to query archive, but got %s", step, err)
+		}
+		if len(l) != 1 || next != "" || l[0].From != utc.MustParse("2021-08-01T12:00:00Z") {
+			t.Fatalf("%s - expect a last page of archived reservations, but got %v", step, l)
+		}
+
+		summaries, err := archive.Summaries(ctx, "C01", 0, 0)
+		if err != nil {
+			t.Fatalf("%s - expect to list summaries, but got %s", step, err)
+		}
+		if len(summaries) != 1 {
+			t.Fatalf("%s - expect 1 summary, but got %d", step, len(summaries))
+		}
+		if summaries[0].Reservations != 2 || summaries[0].Minutes != 120 {
+			t.Errorf("%s - expect 2 reservations for 120 minutes, but got %d for %d",
+				step, summaries[0].Reservations, summaries[0].Minutes,
+			)
+		}
+	}
+	check("archive")
+
+	if err := reservations.Rebuild(ctx); err != nil {
+		t.Fatal("expect to rebuild projections, but got", err)
+	}
+	check("rebuild")
+}
+
+// TestReservation_Query ensures reservations are paginated by start time and
+// filtered by time range
+func TestReservation_Query(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
 	if err != nil {
-		return nil, err
+		t.Fatal("error loading storage", err)
 	}
+
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	// Reserve in reverse order
+	start := utc.MustParse("2021-08-01T08:00:00Z")
+	for i := 4; i >= 0; i-- {
+		res := &booking.Reservation{
+			RoomRef: "C01",
+			From:    start.Add(time.Duration(i) * time.Hour),
+			To:      start.Add(time.Duration(i+1) * time.Hour),
+			UserID:  "foo",
+		}
+		if err := reservations.Reserve(ctx, res); err != nil {
+			t.Fatal("expect to create a reservation, but got", err)
+		}
+	}
+
+	var all []*booking.Reservation
+	f := booking.ReservationFilter{Page: booking.Page{Limit: 2}}
+	for i := 0; ; i++ {
+		page, next, err := reservations.Query(ctx, "C01", f)
+		if err != nil {
+			t.Fatal("expect to query reservations, but got", err)
+		}
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+		if i > 5 {
+			t.Fatal("expect pagination to end")
+		}
+		f.Cursor = next
+	}
+	if len(all) != 5 {
+		t.Fatalf("expect 5 reservations, but got %d", len(all))
+	}
+	for i, res := range all {
+		if expect := start.Add(time.Duration(i) * time.Hour); res.From != expect {
+			t.Errorf("expect reservation %d to start at %s, but got %s", i, expect, res.From)
+		}
+	}
+
+	page, next, err := reservations.Query(ctx, "C01", booking.ReservationFilter{
+		From: start.Add(time.Hour),
+		To:   start.Add(3 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal("expect to query reservations, but got", err)
+	}
+	if len(page) != 2 || next != "" {
+		t.Errorf("expect 2 reservations on a single page, but got %d (next %q)", len(page), next)
+	}
+
+	_, _, err = reservations.Query(ctx, "C01", booking.ReservationFilter{
+		Page: booking.Page{Cursor: "not a cursor"},
+	})
+	if !errors.IsBad(err) {
+		t.Error("expect an invalid cursor to be rejected, but got", err)
+	}
+}
+
+// TestRooms_Query ensures rooms are paginated
+func TestRooms_Query(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, ref := range []string{"C03", "C01", "P01", "C02"} {
+		if err := rooms.Create(ctx, &booking.Room{Ref: ref}); err != nil {
+			t.Fatal("expect to create a room, but got", err)
+		}
+	}
+
+	page, next, err := rooms.Query(ctx, booking.Page{Limit: 3})
+	if err != nil {
+		t.Fatal("expect to query rooms, but got", err)
+	}
+	if len(page) != 3 || page[0].Ref != "C01" || next == "" {
+		t.Fatalf("expect first page to contain 3 rooms and a cursor, but got %d (next %q)", len(page), next)
+	}
+	page, next, err = rooms.Query(ctx, booking.Page{Cursor: next, Limit: 3})
+	if err != nil {
+		t.Fatal("expect to query rooms, but got", err)
+	}
+	if len(page) != 1 || page[0].Ref != "P01" || next != "" {
+		t.Errorf("expect last page to contain P01 only, but got %d (next %q)", len(page), next)
+	}
+}
+
+func loadStorage(name string) (context.Context, error) {
 	ctx := context.Background()
-	ctx = kvdb.WithContext(ctx, store)
+	ctx = kvdb.WithContext(ctx, memory.New())
 	return ctx, nil
 }
+
+// TestRooms_Version ensures stale updates and deletes are rejected
+func TestRooms_Version(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	if err := rooms.Create(ctx, &booking.Room{Ref: "c01"}); err != nil {
+		t.Fatal("expect to create a room, but got", err)
+	}
+	if err := rooms.Create(ctx, &booking.Room{Ref: "C01"}); !errors.IsAborted(err) {
+		t.Fatal("expect room to exist already, but got", err)
+	}
+
+	room := &booking.Room{Ref: "C01", Name: "Boardroom", Version: 1}
+	if err := rooms.Update(ctx, room); err != nil {
+		t.Fatal("expect to update a room, but got", err)
+	}
+	if room.Version != 2 {
+		t.Errorf("expect version 2, but got %d", room.Version)
+	}
+	stale := &booking.Room{Ref: "C01", Name: "Meeting room", Version: 1}
+	if err := rooms.Update(ctx, stale); !errors.IsFailedPrecondition(err) {
+		t.Fatal("expect stale update to fail, but got", err)
+	}
+	if err := rooms.Delete(ctx, "C01", 1); !errors.IsFailedPrecondition(err) {
+		t.Fatal("expect stale delete to fail, but got", err)
+	}
+
+	got, err := rooms.Get(ctx, "C01")
+	if err != nil {
+		t.Fatal("expect to get a room, but got", err)
+	}
+	if got.Name != room.Name {
+		t.Errorf("expect room name %s, but got %s", room.Name, got.Name)
+	}
+	if err := rooms.Delete(ctx, "C01", got.Version); err != nil {
+		t.Fatal("expect to delete a room, but got", err)
+	}
+	if _, err := rooms.Get(ctx, "C01"); !errors.IsNotFound(err) {
+		t.Error("expect room to be deleted, but got", err)
+	}
+}
+
+// TestReservation_Capacity ensures reservations consume the seats of a room
+// until there are none left, and that the capacity cannot be lowered below the
+// seats reserved
+func TestReservation_Capacity(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	if err := rooms.Create(ctx, &booking.Room{Ref: "HOTDESK", Capacity: 3}); err != nil {
+		t.Fatal("expect to create room, but got", err)
+	}
+
+	reserve := func(from, to string, seats int) error {
+		return reservations.Reserve(ctx, &booking.Reservation{
+			RoomRef: "HOTDESK",
+			From:    utc.MustParse(from),
+			To:      utc.MustParse(to),
+			UserID:  "foo",
+			Seats:   seats,
+		})
+	}
+	if err := reserve("2021-08-01T10:00:00Z", "2021-08-01T14:00:00Z", 2); err != nil {
+		t.Fatal("expect to reserve 2 seats, but got", err)
+	}
+	if err := reserve("2021-08-01T12:00:00Z", "2021-08-01T16:00:00Z", 0); err != nil {
+		t.Fatal("expect to reserve 1 seat, but got", err)
+	}
+	if err := reserve("2021-08-01T13:00:00Z", "2021-08-01T14:00:00Z", 1); !errors.IsAborted(err) {
+		t.Error("expect room to be full, but got", err)
+	}
+	if err := reserve("2021-08-01T14:00:00Z", "2021-08-01T15:00:00Z", 2); err != nil {
+		t.Error("expect to reserve 2 seats, but got", err)
+	}
+	if err := reserve("2021-08-01T08:00:00Z", "2021-08-01T09:00:00Z", 4); !errors.IsBad(err) {
+		t.Error("expect request to exceed capacity, but got", err)
+	}
+
+	free, err := reservations.FreeRanges(ctx, "HOTDESK",
+		utc.MustParse("2021-08-01T08:00:00Z"),
+		utc.MustParse("2021-08-01T18:00:00Z"),
+	)
+	if err != nil {
+		t.Fatal("expect to get free ranges, but got", err)
+	}
+	expect := []booking.TimeInterval{
+		{From: utc.MustParse("2021-08-01T08:00:00Z"), To: utc.MustParse("2021-08-01T10:00:00Z"), Capacity: 3},
+		{From: utc.MustParse("2021-08-01T10:00:00Z"), To: utc.MustParse("2021-08-01T12:00:00Z"), Capacity: 1},
+		{From: utc.MustParse("2021-08-01T15:00:00Z"), To: utc.MustParse("2021-08-01T16:00:00Z"), Capacity: 2},
+		{From: utc.MustParse("2021-08-01T16:00:00Z"), To: utc.MustParse("2021-08-01T18:00:00Z"), Capacity: 3},
+	}
+	if len(free) != len(expect) {
+		t.Fatalf("expect %d free ranges, but got %d", len(expect), len(free))
+	}
+	for i := range expect {
+		if *free[i] != expect[i] {
+			t.Errorf("expect free range %d to be %v, but got %v", i, expect[i], *free[i])
+		}
+	}
+
+	room, err := rooms.Get(ctx, "HOTDESK")
+	if err != nil {
+		t.Fatal("expect to get room, but got", err)
+	}
+	room.Capacity = 2
+	if err := rooms.Update(ctx, room); !errors.IsAborted(err) {
+		t.Error("expect capacity not to be lowered below booked seats, but got", err)
+	}
+	room.Capacity = 4
+	if err := rooms.Update(ctx, room); err != nil {
+		t.Error("expect capacity to be raised, but got", err)
+	}
+	room.Capacity = 3
+	if err := rooms.Update(ctx, room); err != nil {
+		t.Error("expect capacity to be lowered to booked seats, but got", err)
+	}
+}
@@ -2,17 +2,53 @@ package booking
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/basgys/booking-consensys/app/audit"
 	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/app/notification"
+	"github.com/basgys/booking-consensys/app/webhook"
+	"github.com/basgys/booking-consensys/pkg/csvimport"
+	"github.com/basgys/booking-consensys/pkg/sse"
 	"github.com/deixis/errors"
 	"github.com/deixis/pkg/utc"
 	"github.com/deixis/spine/log"
+	"github.com/deixis/storage/kvdb"
+)
+
+const (
+	// idempotencyTTL is how long idempotency keys are kept
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength is the maximum length of idempotency keys
+	maxIdempotencyKeyLength = 255
+	// approvalTTL is how long requests wait for a decision at most. Requests
+	// also expire when their reservation starts.
+	approvalTTL = 72 * time.Hour
+	// checkInOpening is how long before the start of a reservation its owner
+	// can check in
+	checkInOpening = 15 * time.Minute
 )
 
 type Service struct {
 	rooms        *RoomsRepository
 	reservations *ReservationRepository
+	webhooks     *webhook.Service
+	notifier     *notification.Service
+	iam          *iam.Service
+	audit        *audit.RecordRepository
+	idempotency  *IdempotencyRepository
+	approvals    *ApprovalRepository
+	policies     *PolicyRepository
+	// static are the policies declared in the config file
+	static []*Policy
+	// streams bounds the room streams served over HTTP
+	streams *sse.Streams
 }
 
 func New(ctx context.Context) (*Service, error) {
@@ -24,17 +60,154 @@ func New(ctx context.Context) (*Service, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to initialise reservation repository")
 	}
+	webhooks, err := webhook.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise webhook service")
+	}
+	notifier, err := notification.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise notification service")
+	}
+	iams, err := iam.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise iam service")
+	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise audit repository")
+	}
+	idempotency, err := NewIdempotencyRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise idempotency repository")
+	}
+	approvals, err := NewApprovalRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise approval repository")
+	}
+	policies, err := NewPolicyRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise policy repository")
+	}
+	static, err := loadPolicies(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load policies from config")
+	}
 
 	return &Service{
 		rooms:        rooms,
 		reservations: reservations,
+		webhooks:     webhooks,
+		notifier:     notifier,
+		iam:          iams,
+		audit:        audits,
+		idempotency:  idempotency,
+		approvals:    approvals,
+		policies:     policies,
+		static:       static,
+		streams:      sse.NewStreams(DefaultStreamTimeout),
 	}, nil
 }
 
+// GetRoom returns room `ref`
+func (s *Service) GetRoom(ctx context.Context, ref string) (*Room, error) {
+	return s.rooms.Get(ctx, ref)
+}
+
+// CreateRoom adds `room`. It is restricted to admins.
+func (s *Service) CreateRoom(ctx context.Context, room *Room) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			if err := s.rooms.Create(ctx, room); err != nil {
+				return nil, err
+			}
+			return nil, s.audit.Log(ctx, iam.Actor(ctx), "booking.room.create",
+				audit.RoomResource(room.Ref), nil, room,
+			)
+		},
+	)
+	return err
+}
+
+// UpdateRoom replaces the settings of room `room.Ref`. Unless it is 0,
+// `room.Version` must match the current version. It is restricted to admins.
+func (s *Service) UpdateRoom(ctx context.Context, room *Room) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.rooms.Get(ctx, room.Ref)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.rooms.Update(ctx, room); err != nil {
+				return nil, err
+			}
+			return nil, s.audit.Log(ctx, iam.Actor(ctx), "booking.room.update",
+				audit.RoomResource(room.Ref), before, room,
+			)
+		},
+	)
+	return err
+}
+
+// DeleteRoom removes room `ref`. Unless it is 0, `version` must match the
+// current version. It is restricted to admins.
+func (s *Service) DeleteRoom(ctx context.Context, ref string, version uint64) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.rooms.Get(ctx, ref)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.rooms.Delete(ctx, ref, version); err != nil {
+				return nil, err
+			}
+			return nil, s.audit.Log(ctx, iam.Actor(ctx), "booking.room.delete",
+				audit.RoomResource(ref), before, nil,
+			)
+		},
+	)
+	return err
+}
+
+// ImportRooms creates or updates the rooms listed in CSV file `r`. It is
+// restricted to admins. See ImportRooms for the file format.
+func (s *Service) ImportRooms(
+	ctx context.Context, r io.Reader, dryRun bool,
+) (*csvimport.Report, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	report, err := importRooms(ctx, s.rooms, r, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if report.DryRun || !report.Valid() {
+		return report, nil
+	}
+	err = s.audit.Log(ctx, iam.Actor(ctx), "booking.room.import", "", nil, report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ListRooms returns a page of rooms along with the cursor of the next page
 func (s *Service) ListRooms(
-	ctx context.Context,
-) ([]*Room, error) {
-	return s.rooms.List(ctx)
+	ctx context.Context, p Page,
+) ([]*Room, string, error) {
+	return s.rooms.Query(ctx, p)
 }
 
 func (s *Service) RoomsAvailabilities(
@@ -67,10 +240,114 @@ func (s *Service) RoomAvailabilities(
 	return s.reservations.FreeRanges(ctx, roomRef, from, to)
 }
 
+// ListRoomsAvailabilities returns the free ranges of `rooms` between `from`
+// and `to`, keyed by room ref. The schedules of all rooms are read at once.
+func (s *Service) ListRoomsAvailabilities(
+	ctx context.Context, rooms []*Room, from, to utc.UTC,
+) (map[string][]*TimeInterval, error) {
+	if to < from {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
+	}
+	schedules, err := s.reservations.schedules(ctx, rooms)
+	if err != nil {
+		return nil, err
+	}
+	availabilities := make(map[string][]*TimeInterval, len(schedules))
+	for ref, sch := range schedules {
+		availabilities[ref] = sch.freeRanges(from, to)
+	}
+	return availabilities, nil
+}
+
+// ListRoomsReservations returns the reservations of `rooms` overlapping
+// [from, to), keyed by room ref and ordered by start time. Zero bounds are
+// ignored. The reservations of all rooms are read at once.
+func (s *Service) ListRoomsReservations(
+	ctx context.Context, rooms []*Room, from, to utc.UTC,
+) (map[string][]*Reservation, error) {
+	f := ReservationFilter{From: from, To: to}
+	if f.From != 0 && f.To != 0 && f.To < f.From {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
+	}
+	schedules, err := s.reservations.schedules(ctx, rooms)
+	if err != nil {
+		return nil, err
+	}
+	reservations := make(map[string][]*Reservation, len(schedules))
+	for ref, sch := range schedules {
+		var l []*Reservation
+		for _, res := range sch.reservations {
+			if f.match(res) {
+				l = append(l, res)
+			}
+		}
+		sort.Slice(l, func(i, j int) bool {
+			return lessReservation(l[i], l[j])
+		})
+		reservations[ref] = l
+	}
+	return reservations, nil
+}
+
+// ListRoomReservations returns a page of reservations of room `roomRef`
+// ordered by start time, along with the cursor of the next page
 func (s *Service) ListRoomReservations(
+	ctx context.Context, roomRef string, f ReservationFilter,
+) ([]*Reservation, string, error) {
+	if f.From != 0 && f.To != 0 && f.To < f.From {
+		return nil, "", errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
+	}
+	return s.reservations.Query(ctx, roomRef, f)
+}
+
+// GetRoomReservation returns reservation `id` of room `roomRef`. Cancelled
+// reservations are returned with their cancellation details.
+func (s *Service) GetRoomReservation(
+	ctx context.Context, roomRef, id string,
+) (*Reservation, error) {
+	return s.reservations.Get(ctx, roomRef, id)
+}
+
+// ListCancelledReservations returns the cancelled reservations of room
+// `roomRef` ordered by start time. It is restricted to admins.
+func (s *Service) ListCancelledReservations(
 	ctx context.Context, roomRef string,
 ) ([]*Reservation, error) {
-	return s.reservations.Reservations(ctx, roomRef)
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.reservations.Cancelled(ctx, roomRef)
+}
+
+// ListMyReservations returns all reservations made by the current user
+func (s *Service) ListMyReservations(
+	ctx context.Context,
+) ([]*Reservation, error) {
+	acc, ok := iam.FromContext(ctx)
+	if !ok {
+		return nil, errors.PermissionDenied
+	}
+	return s.reservations.UserReservations(ctx, acc.UserID)
+}
+
+// ListUserReservations returns all reservations owned by user `userID`. It is
+// restricted to that user, their delegates and admins.
+func (s *Service) ListUserReservations(
+	ctx context.Context, userID string,
+) ([]*Reservation, error) {
+	if err := s.requireOwner(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.reservations.UserReservations(ctx, userID)
 }
 
 func (s *Service) ReserveRoom(
@@ -79,30 +356,909 @@ func (s *Service) ReserveRoom(
 	from utc.UTC,
 	hours int64,
 ) (*Reservation, error) {
+	res, _, err := s.ReserveRoomOnce(ctx, "", BookingRequest{
+		RoomRef: roomRef,
+		From:    from,
+		Hours:   hours,
+	})
+	return res, err
+}
+
+// ReserveRoomOnce makes the reservation described by `b`. When `key` is set,
+// a retried request with the same key returns the original reservation and
+// `replayed` is true. Reusing a key for another request fails.
+func (s *Service) ReserveRoomOnce(
+	ctx context.Context, key string, b BookingRequest,
+) (res *Reservation, replayed bool, err error) {
 	acc, ok := iam.FromContext(ctx)
 	if !ok {
-		return nil, errors.PermissionDenied
+		return nil, false, errors.PermissionDenied
+	}
+	owner, err := s.bookingOwner(ctx, b.OnBehalfOf)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, false, errors.Bad(&errors.FieldViolation{
+			Field:       "Idempotency-Key",
+			Description: fmt.Sprintf("The key cannot be longer than %d characters", maxIdempotencyKeyLength),
+		})
 	}
 
-	res := Reservation{
-		From:    from,
-		To:      from.Add(time.Duration(hours) * time.Hour),
-		RoomRef: roomRef,
-		UserID:  acc.UserID,
+	res = &Reservation{
+		From:      b.From,
+		To:        b.From.Add(time.Duration(b.Hours) * time.Hour),
+		RoomRef:   b.RoomRef,
+		UserID:    owner,
+		CreatedBy: acc.UserID,
+		Seats:     b.Seats,
 	}
-	if err := s.reservations.Reserve(ctx, &res); err != nil {
-		return nil, err
+	fingerprint := requestFingerprint(b, owner)
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			if key != "" {
+				rec, err := s.idempotency.Get(ctx, acc.UserID, key)
+				switch {
+				case err == nil:
+					if rec.Fingerprint != fingerprint {
+						return nil, errors.Bad(&errors.FieldViolation{
+							Field:       "Idempotency-Key",
+							Description: "The key has already been used with a different request",
+						})
+					}
+					*res = rec.Reservation
+					replayed = true
+					return nil, nil
+				case errors.IsNotFound(err):
+				default:
+					return nil, err
+				}
+			}
+
+			room, err := s.rooms.Get(ctx, b.RoomRef)
+			switch {
+			case err == nil:
+				if room.RequiresApproval {
+					return nil, errors.Aborted(&errors.ConflictViolation{
+						Resource:    "room:" + room.Ref,
+						Description: "Reservations of this room must be requested for approval",
+					})
+				}
+			case errors.IsNotFound(err):
+				room = &Room{Ref: strings.TrimSpace(strings.ToUpper(b.RoomRef))}
+			default:
+				return nil, err
+			}
+			if err := s.checkReservationPolicies(ctx, room, res); err != nil {
+				return nil, err
+			}
+
+			if err := s.reservations.Reserve(ctx, res); err != nil {
+				return nil, err
+			}
+			err = s.audit.Log(ctx, iam.Actor(ctx), "booking.reserve",
+				audit.RoomResource(res.RoomRef), nil, res,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if key != "" {
+				err := s.idempotency.Put(ctx, &IdempotencyRecord{
+					UserID:      acc.UserID,
+					Key:         key,
+					Fingerprint: fingerprint,
+					Reservation: *res,
+					ExpiresAt:   utc.Now().Add(idempotencyTTL),
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+			if err := s.notify(ctx, notification.KindCreated, res); err != nil {
+				return nil, err
+			}
+			return nil, s.webhooks.Publish(ctx, webhook.EventReservationCreated, res)
+		},
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, replayed, nil
+}
+
+// requestFingerprint identifies a reservation request
+func requestFingerprint(b BookingRequest, owner string) string {
+	seats := b.Seats
+	if seats < 1 {
+		seats = 1
+	}
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%s",
+		strings.TrimSpace(strings.ToUpper(b.RoomRef)), int64(b.From), b.Hours, seats, owner,
+	)))
+	return hex.EncodeToString(h[:])
+}
+
+// bookingOwner returns the user who owns a reservation made by the current
+// user on behalf of `onBehalfOf`
+func (s *Service) bookingOwner(ctx context.Context, onBehalfOf string) (string, error) {
+	acc, ok := iam.FromContext(ctx)
+	if !ok {
+		return "", errors.PermissionDenied
+	}
+	if onBehalfOf == "" || onBehalfOf == acc.UserID {
+		return acc.UserID, nil
+	}
+	if err := s.iam.RequireDelegate(ctx, onBehalfOf); err != nil {
+		return "", err
+	}
+	return onBehalfOf, nil
+}
+
+// requireOwner ensures the current user owns the reservations of user
+// `ownerID`, either directly, as a delegate or as an admin
+func (s *Service) requireOwner(ctx context.Context, ownerID string) error {
+	err := s.iam.RequireDelegate(ctx, ownerID)
+	if !errors.IsPermissionDenied(err) {
+		return err
 	}
-	return &res, nil
+	return s.iam.RequireRole(ctx, iam.RoleAdmin)
 }
 
+// CancelRoomReservation cancels reservation `id`. Unless it is 0, `version`
+// must match the current version of the reservation.
 func (s *Service) CancelRoomReservation(
 	ctx context.Context,
 	roomRef string,
 	id string,
+	version uint64,
 ) error {
+	acc, ok := iam.FromContext(ctx)
+	if !ok {
+		return errors.PermissionDenied
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			res, err := s.reservations.Get(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.requireOwner(ctx, res.UserID); err != nil {
+				return nil, err
+			}
+			if err := checkVersion("reservation:"+id, version, res.Version); err != nil {
+				return nil, err
+			}
+			if err := requireActive(res); err != nil {
+				return nil, err
+			}
+			late, err := s.checkCancellationPolicies(ctx, res)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.reservations.Cancel(ctx, roomRef, id, acc.UserID, late); err != nil {
+				return nil, err
+			}
+			cancelled, err := s.reservations.Get(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+			err = s.audit.Log(ctx, iam.Actor(ctx), "booking.cancel",
+				audit.RoomResource(roomRef), res, cancelled,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.notify(ctx, notification.KindCancelled, cancelled); err != nil {
+				return nil, err
+			}
+			return nil, s.webhooks.Publish(ctx, webhook.EventReservationCancelled, cancelled)
+		},
+	)
+	return err
+}
+
+// CheckInRoomReservation records that the owner of reservation `id` showed
+// up. Check-in opens `checkInOpening` before the reservation starts and
+// closes when it ends or when it has been flagged as a no-show.
+func (s *Service) CheckInRoomReservation(
+	ctx context.Context, roomRef string, id string,
+) (*Reservation, error) {
+	acc, ok := iam.FromContext(ctx)
+	if !ok {
+		return nil, errors.PermissionDenied
+	}
+
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.reservations.Get(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.requireOwner(ctx, before.UserID); err != nil {
+				return nil, err
+			}
+			if err := requireActive(before); err != nil {
+				return nil, err
+			}
+			if err := checkInOpen(before, utc.Now()); err != nil {
+				return nil, err
+			}
+			res, err := s.reservations.CheckIn(ctx, roomRef, id, acc.UserID)
+			if err != nil {
+				return nil, err
+			}
+			err = s.audit.Log(ctx, iam.Actor(ctx), "booking.check_in",
+				audit.RoomResource(roomRef), before, res,
+			)
+			if err != nil {
+				return nil, err
+			}
+			return res, s.webhooks.Publish(ctx, webhook.EventReservationCheckedIn, res)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Reservation), nil
+}
+
+// checkInOpen ensures reservation `res` can be checked in at `now`
+func checkInOpen(res *Reservation, now utc.UTC) error {
+	var reason string
+	switch {
+	case !res.CheckedInAt.IsZero():
+		reason = "The reservation has already been checked in"
+	case res.NoShow:
+		reason = "The reservation has been flagged as a no-show"
+	case now < res.From.Add(-checkInOpening):
+		reason = fmt.Sprintf(
+			"Check-in opens %s before the reservation starts", checkInOpening,
+		)
+	case now >= res.To:
+		reason = "The reservation has ended"
+	default:
+		return nil
+	}
+	return errors.Aborted(&errors.ConflictViolation{
+		Resource:    "reservation:" + res.ID,
+		Description: reason,
+	})
+}
+
+// requireActive ensures reservation `res` has not been cancelled
+func requireActive(res *Reservation) error {
+	if res.Status == ReservationCancelled {
+		return errors.Aborted(&errors.ConflictViolation{
+			Resource:    "reservation:" + res.ID,
+			Description: "The reservation has been cancelled",
+		})
+	}
+	return nil
+}
+
+// notify emails the owner of reservation `res` about change `k`
+func (s *Service) notify(ctx context.Context, k notification.Kind, res *Reservation) error {
+	return s.notifier.Notify(ctx, k, notification.Booking{
+		ID:      res.ID,
+		RoomRef: res.RoomRef,
+		From:    res.From,
+		To:      res.To,
+		Seats:   res.Seats,
+		UserID:  res.UserID,
+		Version: res.Version,
+	})
+}
+
+// RescheduleRoomReservation moves reservation `id` to start at `from` for
+// `hours`. Unless it is 0, `version` must match the current version of the
+// reservation.
+func (s *Service) RescheduleRoomReservation(
+	ctx context.Context,
+	roomRef string,
+	id string,
+	version uint64,
+	from utc.UTC,
+	hours int64,
+) (*Reservation, error) {
 	if _, ok := iam.FromContext(ctx); !ok {
+		return nil, errors.PermissionDenied
+	}
+
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.reservations.Get(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.requireOwner(ctx, before.UserID); err != nil {
+				return nil, err
+			}
+			if err := checkVersion("reservation:"+id, version, before.Version); err != nil {
+				return nil, err
+			}
+			if err := requireActive(before); err != nil {
+				return nil, err
+			}
+			// Only approvers can move reservations of rooms which require approval
+			room, err := s.rooms.Get(ctx, roomRef)
+			switch {
+			case err == nil:
+				if room.RequiresApproval {
+					if err := s.requireApprover(ctx, room); err != nil {
+						return nil, err
+					}
+				}
+			case errors.IsNotFound(err):
+				room = &Room{Ref: before.RoomRef}
+			default:
+				return nil, err
+			}
+			to := from.Add(time.Duration(hours) * time.Hour)
+			moved := *before
+			moved.From, moved.To = from, to
+			if err := s.checkReservationPolicies(ctx, room, &moved); err != nil {
+				return nil, err
+			}
+			res, err := s.reservations.Reschedule(ctx, roomRef, id, from, to)
+			if err != nil {
+				return nil, err
+			}
+			err = s.audit.Log(ctx, iam.Actor(ctx), "booking.reschedule",
+				audit.RoomResource(roomRef), before, res,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.notify(ctx, notification.KindUpdated, res); err != nil {
+				return nil, err
+			}
+			return res, s.webhooks.Publish(ctx, webhook.EventReservationUpdated, res)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Reservation), nil
+}
+
+// RequestRoom requests the reservation described by `b` on a room which
+// requires approval. The request stays pending until an approver decides on
+// it, or until it expires.
+func (s *Service) RequestRoom(
+	ctx context.Context, b BookingRequest,
+) (*ApprovalRequest, error) {
+	acc, ok := iam.FromContext(ctx)
+	if !ok {
+		return nil, errors.PermissionDenied
+	}
+	owner, err := s.bookingOwner(ctx, b.OnBehalfOf)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &ApprovalRequest{
+		RoomRef:   strings.TrimSpace(strings.ToUpper(b.RoomRef)),
+		UserID:    owner,
+		CreatedBy: acc.UserID,
+		From:      b.From.Floor(minPrecision),
+		To:        b.From.Add(time.Duration(b.Hours) * time.Hour).Ceil(minPrecision),
+		Seats:     b.Seats,
+	}
+	if req.From >= req.To {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "hours",
+			Description: "Invalid reservation interval",
+		})
+	}
+	if req.From <= utc.Now() {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "from",
+			Description: "Only future reservations can be requested",
+		})
+	}
+	if b.Seats < 0 {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "seats",
+			Description: "The number of seats cannot be negative",
+		})
+	}
+	req.Seats = int(req.reservation().seats())
+	req.ExpiresAt = minUTC(req.From, utc.Now().Add(approvalTTL))
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			room, err := s.rooms.Get(ctx, req.RoomRef)
+			if err != nil {
+				return nil, err
+			}
+			if !room.RequiresApproval {
+				return nil, errors.Aborted(&errors.ConflictViolation{
+					Resource:    "room:" + room.Ref,
+					Description: "This room does not require approval and can be reserved directly",
+				})
+			}
+			if err := s.checkReservationPolicies(ctx, room, req.reservation()); err != nil {
+				return nil, err
+			}
+
+			// Fail early when the slot is already taken
+			reservations, err := s.reservations.Reservations(ctx, req.RoomRef)
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, err
+			}
+			capacity, held, err := s.reservations.constraints(ctx, req.RoomRef)
+			if err != nil {
+				return nil, err
+			}
+			if req.reservation().seats() > capacity {
+				return nil, errors.Bad(&errors.FieldViolation{
+					Field:       "seats",
+					Description: fmt.Sprintf("The room has %d seats", capacity),
+				})
+			}
+			if !fits(append(held, reservations...), req.reservation(), capacity) {
+				return nil, errors.Aborted(&errors.ConflictViolation{
+					Resource:    "reservation",
+					Description: "There is already a reservation on this range",
+				})
+			}
+
+			if err := s.approvals.Create(ctx, req); err != nil {
+				return nil, err
+			}
+			return nil, s.audit.Log(ctx, iam.Actor(ctx), "booking.approval.request",
+				audit.RoomResource(req.RoomRef), nil, req,
+			)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// GetApprovalRequest returns request `id` of room `roomRef`. It is restricted
+// to the requester, their delegates and the room approvers.
+func (s *Service) GetApprovalRequest(
+	ctx context.Context, roomRef, id string,
+) (*ApprovalRequest, error) {
+	req, err := s.approvals.Get(ctx, roomRef, id)
+	if err != nil {
+		return nil, err
+	}
+	err = s.iam.RequireDelegate(ctx, req.UserID)
+	switch {
+	case err == nil:
+		return req, nil
+	case errors.IsPermissionDenied(err):
+	default:
+		return nil, err
+	}
+	room, err := s.rooms.Get(ctx, roomRef)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireApprover(ctx, room); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ListApprovalRequests returns the requests of room `roomRef`, optionally
+// filtered by `status`. Approvers see all requests, other users only see
+// their own and the ones of the users they are delegates of.
+func (s *Service) ListApprovalRequests(
+	ctx context.Context, roomRef string, status ApprovalStatus,
+) ([]*ApprovalRequest, error) {
+	if _, ok := iam.FromContext(ctx); !ok {
+		return nil, errors.PermissionDenied
+	}
+	switch status {
+	case "", ApprovalPending, ApprovalApproved, ApprovalRejected:
+	default:
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "status",
+			Description: "The status must be pending, approved or rejected",
+		})
+	}
+
+	room, err := s.rooms.Get(ctx, roomRef)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := s.approvals.List(ctx, room.Ref, status)
+	if err != nil {
+		return nil, err
+	}
+	err = s.requireApprover(ctx, room)
+	switch {
+	case err == nil:
+		return requests, nil
+	case errors.IsPermissionDenied(err):
+	default:
+		return nil, err
+	}
+
+	var own []*ApprovalRequest
+	for _, req := range requests {
+		err := s.iam.RequireDelegate(ctx, req.UserID)
+		switch {
+		case err == nil:
+			own = append(own, req)
+		case errors.IsPermissionDenied(err):
+		default:
+			return nil, err
+		}
+	}
+	return own, nil
+}
+
+// ApproveRequest approves pending request `id` and reserves the room on
+// behalf of the requester. It is restricted to the room approvers.
+func (s *Service) ApproveRequest(
+	ctx context.Context, roomRef, id, reason string,
+) (*ApprovalRequest, error) {
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.decidable(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+
+			req := *before
+			decide(ctx, &req, ApprovalApproved, reason)
+			// Release the tentative hold before reserving the slot
+			if err := s.approvals.Update(ctx, &req); err != nil {
+				return nil, err
+			}
+			res := req.reservation()
+			if err := s.reservations.Reserve(ctx, res); err != nil {
+				return nil, err
+			}
+			req.ReservationID = res.ID
+			if err := s.approvals.Update(ctx, &req); err != nil {
+				return nil, err
+			}
+
+			err = s.audit.Log(ctx, iam.Actor(ctx), "booking.approval.approve",
+				audit.RoomResource(req.RoomRef), before, &req,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.notify(ctx, notification.KindCreated, res); err != nil {
+				return nil, err
+			}
+			return &req, s.webhooks.Publish(ctx, webhook.EventReservationCreated, res)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ApprovalRequest), nil
+}
+
+// RejectRequest rejects pending request `id` for `reason`. It is restricted
+// to the room approvers.
+func (s *Service) RejectRequest(
+	ctx context.Context, roomRef, id, reason string,
+) (*ApprovalRequest, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "reason",
+			Description: "A reason is required to reject a request",
+		})
+	}
+
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.decidable(ctx, roomRef, id)
+			if err != nil {
+				return nil, err
+			}
+
+			req := *before
+			decide(ctx, &req, ApprovalRejected, reason)
+			if err := s.approvals.Update(ctx, &req); err != nil {
+				return nil, err
+			}
+			return &req, s.audit.Log(ctx, iam.Actor(ctx), "booking.approval.reject",
+				audit.RoomResource(req.RoomRef), before, &req,
+			)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ApprovalRequest), nil
+}
+
+// decidable returns request `id` when it is still pending and the current
+// user is allowed to decide on it
+func (s *Service) decidable(
+	ctx context.Context, roomRef, id string,
+) (*ApprovalRequest, error) {
+	room, err := s.rooms.Get(ctx, roomRef)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireApprover(ctx, room); err != nil {
+		return nil, err
+	}
+	req, err := s.approvals.Get(ctx, room.Ref, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != ApprovalPending || req.ExpiresAt <= utc.Now() {
+		return nil, errors.Aborted(&errors.ConflictViolation{
+			Resource:    "request:" + id,
+			Description: "Only pending requests can be decided",
+		})
+	}
+	return req, nil
+}
+
+// decide records the decision of the current user on `req`
+func decide(
+	ctx context.Context, req *ApprovalRequest, status ApprovalStatus, reason string,
+) {
+	req.Status = status
+	req.Reason = strings.TrimSpace(reason)
+	req.DecidedAt = utc.Now()
+	if acc, ok := iam.FromContext(ctx); ok {
+		req.DecidedBy = acc.UserID
+	}
+}
+
+// requireApprover ensures the current user is allowed to decide on requests
+// of `room`. Admins are approvers of all rooms.
+func (s *Service) requireApprover(ctx context.Context, room *Room) error {
+	acc, ok := iam.FromContext(ctx)
+	if !ok {
+		return errors.PermissionDenied
+	}
+	for _, id := range room.Approvers {
+		if id == acc.UserID {
+			return nil
+		}
+	}
+
+	usr, err := s.iam.GetUser(ctx, acc.UserID)
+	switch {
+	case err == nil:
+	case errors.IsNotFound(err):
 		return errors.PermissionDenied
+	default:
+		return err
+	}
+	if usr.HasRole(iam.RoleAdmin) {
+		return nil
+	}
+	for _, id := range room.ApproverGroups {
+		if usr.GroupID != "" && id == usr.GroupID {
+			return nil
+		}
+	}
+	return errors.PermissionDenied
+}
+
+// ListPolicies returns the policies declared in the config file, followed by
+// the ones managed through the API
+func (s *Service) ListPolicies(ctx context.Context) ([]*Policy, error) {
+	if _, ok := iam.FromContext(ctx); !ok {
+		return nil, errors.PermissionDenied
+	}
+	return s.allPolicies(ctx)
+}
+
+// PutPolicy creates or replaces policy `p.ID`. Policies declared in the config
+// file cannot be replaced. It is restricted to admins.
+func (s *Service) PutPolicy(ctx context.Context, p *Policy) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+	if err := s.requireDynamicPolicy(p.ID); err != nil {
+		return err
+	}
+	p.Static = false
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.policies.Get(ctx, p.ID)
+			switch {
+			case err == nil:
+			case errors.IsNotFound(err):
+				before = nil
+			default:
+				return nil, err
+			}
+			if err := s.policies.Put(ctx, p); err != nil {
+				return nil, err
+			}
+			return nil, s.audit.Log(ctx, iam.Actor(ctx), "booking.policy.put",
+				"policy:"+p.ID, before, p,
+			)
+		},
+	)
+	return err
+}
+
+// DeletePolicy removes policy `id`. Policies declared in the config file
+// cannot be removed. It is restricted to admins.
+func (s *Service) DeletePolicy(ctx context.Context, id string) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+	if err := s.requireDynamicPolicy(id); err != nil {
+		return err
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := s.policies.Get(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.policies.Delete(ctx, id); err != nil {
+				return nil, err
+			}
+			return nil, s.audit.Log(ctx, iam.Actor(ctx), "booking.policy.delete",
+				"policy:"+id, before, nil,
+			)
+		},
+	)
+	return err
+}
+
+// requireDynamicPolicy ensures policy `id` is not declared in the config file
+func (s *Service) requireDynamicPolicy(id string) error {
+	for _, p := range s.static {
+		if p.ID == id {
+			return errors.Aborted(&errors.ConflictViolation{
+				Resource:    "policy:" + id,
+				Description: "Policies declared in the config file cannot be changed through the API",
+			})
+		}
+	}
+	return nil
+}
+
+// allPolicies returns the static policies followed by the stored ones
+func (s *Service) allPolicies(ctx context.Context) ([]*Policy, error) {
+	stored, err := s.policies.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]*Policy{}, s.static...), stored...), nil
+}
+
+// checkReservationPolicies ensures reservation `res` of `room` complies with
+// all the policies which apply to the room. The group rule is evaluated
+// against the group of the reservation owner.
+func (s *Service) checkReservationPolicies(
+	ctx context.Context, room *Room, res *Reservation,
+) error {
+	policies, err := s.allPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	var groupID string
+	usr, err := s.iam.GetUser(ctx, res.UserID)
+	switch {
+	case err == nil:
+		groupID = usr.GroupID
+	case errors.IsNotFound(err):
+	default:
+		return err
+	}
+
+	now := utc.Now()
+	for _, p := range policies {
+		if !p.applies(room) {
+			continue
+		}
+		if err := p.checkReservation(now, res, groupID); err != nil {
+			return err
+		}
+		if p.MaxLateCancels > 0 {
+			since := now.Add(-time.Duration(p.lateCancelPeriod()))
+			n, err := s.reservations.LateCancellations(ctx, res.UserID, since)
+			if err != nil {
+				return err
+			}
+			if err := p.checkLateCancels(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCancellationPolicies ensures reservation `res` can be cancelled
+// according to all the policies which apply to its room. It returns whether
+// the cancellation is late. Admins can always cancel.
+//
+// Late cancellations count towards the limits of the owner, so they are only
+// flagged when the owner or one of their delegates cancels, and never when an
+// admin cancels on their behalf.
+func (s *Service) checkCancellationPolicies(
+	ctx context.Context, res *Reservation,
+) (late bool, err error) {
+	policies, err := s.allPolicies(ctx)
+	if err != nil {
+		return false, err
+	}
+	room, err := s.rooms.Get(ctx, res.RoomRef)
+	switch {
+	case err == nil:
+	case errors.IsNotFound(err):
+		room = &Room{Ref: res.RoomRef}
+	default:
+		return false, err
+	}
+
+	now := utc.Now()
+	for _, p := range policies {
+		if !p.applies(room) {
+			continue
+		}
+		l, err := p.checkCancellation(now, res)
+		if err != nil {
+			if aerr := s.iam.RequireRole(ctx, iam.RoleAdmin); aerr != nil {
+				if errors.IsPermissionDenied(aerr) {
+					return false, err
+				}
+				return false, aerr
+			}
+			l = true
+		}
+		late = late || l
+	}
+	if !late {
+		return false, nil
+	}
+
+	switch err := s.iam.RequireDelegate(ctx, res.UserID); {
+	case err == nil:
+		return true, nil
+	case errors.IsPermissionDenied(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// ListArchivedReservations returns archived reservations of room `roomRef`
+// which started within the filter interval. It is restricted to admins.
+func (s *Service) ListArchivedReservations(
+	ctx context.Context, roomRef string, f ReservationFilter,
+) ([]*Reservation, string, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, "", err
+	}
+	if f.From != 0 && f.To != 0 && f.To < f.From {
+		return nil, "", errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
+	}
+	return s.reservations.archive.Reservations(ctx, roomRef, f)
+}
+
+// ListArchiveSummaries returns the daily summaries of archived reservations
+// of room `roomRef` within [from, to). It is restricted to admins.
+func (s *Service) ListArchiveSummaries(
+	ctx context.Context, roomRef string, from, to utc.UTC,
+) ([]*ArchiveSummary, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+	if from != 0 && to != 0 && to < from {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
 	}
-	return s.reservations.Cancel(ctx, roomRef, id)
+	return s.reservations.archive.Summaries(ctx, roomRef, from, to)
 }
@@ -0,0 +1,798 @@
+package booking_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/audit"
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/app/webhook"
+	"github.com/basgys/booking-consensys/pkg/kvdb/kvretry"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/config"
+	"github.com/deixis/storage/kvdb"
+)
+
+// TestService_ReserveRoomOnce ensures a retried reservation returns the
+// original one and a reused key is rejected
+func TestService_ReserveRoomOnce(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	from := utc.MustParse("2021-08-01T12:00:00Z")
+
+	res, replayed, err := svc.ReserveRoomOnce(ctx, "k1", booking.BookingRequest{RoomRef: "C01", From: from, Hours: 1})
+	if err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	if replayed {
+		t.Error("expect first request not to be replayed")
+	}
+
+	retry, replayed, err := svc.ReserveRoomOnce(ctx, "k1", booking.BookingRequest{RoomRef: "c01", From: from, Hours: 1})
+	if err != nil {
+		t.Fatal("expect retry to succeed, but got", err)
+	}
+	if !replayed || retry.ID != res.ID {
+		t.Errorf("expect original reservation %s to be replayed, but got %s", res.ID, retry.ID)
+	}
+
+	_, _, err = svc.ReserveRoomOnce(ctx, "k1", booking.BookingRequest{RoomRef: "C01", From: from, Hours: 2})
+	if !errors.IsBad(err) {
+		t.Error("expect key reused with a different request to be rejected, but got", err)
+	}
+
+	// Keys are scoped to users
+	bar := iam.WithContext(ctx, &iam.Account{UserID: "bar"})
+	_, _, err = svc.ReserveRoomOnce(bar, "k1", booking.BookingRequest{RoomRef: "C01", From: from, Hours: 1})
+	if !errors.IsAborted(err) {
+		t.Error("expect another user to conflict with the reservation, but got", err)
+	}
+
+	l, err := svc.ListMyReservations(ctx)
+	if err != nil {
+		t.Fatal("expect to list reservations, but got", err)
+	}
+	if len(l) != 1 {
+		t.Errorf("expect 1 reservation, but got %d", len(l))
+	}
+}
+
+// TestService_ConcurrentReserve ensures bookings of different rooms made at
+// the same time both succeed, although they are appended to the same audit
+// chain
+func TestService_ConcurrentReserve(t *testing.T) {
+	store := &interleavedStore{Store: memory.New()}
+	store.gate.Add(2)
+	ctx := kvdb.WithContext(context.Background(), kvretry.Wrap(store, func(err error) bool {
+		return err == memory.ErrConflict
+	}))
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+
+	from := utc.MustParse("2021-08-01T12:00:00Z")
+	errs := make(chan error, 2)
+	for _, roomRef := range []string{"C01", "C02"} {
+		go func(roomRef string) {
+			_, err := svc.ReserveRoom(ctx, roomRef, from, 1)
+			errs <- err
+		}(roomRef)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal("expect to reserve a room, but got", err)
+		}
+	}
+
+	records, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening audit repository", err)
+	}
+	l, err := records.Query(ctx, audit.Query{})
+	if err != nil {
+		t.Fatal("expect to query records, but got", err)
+	}
+	if len(l) != 2 {
+		t.Errorf("expect 2 audit records, but got %d", len(l))
+	}
+}
+
+// interleavedStore holds the first two transactions until both have run, so
+// that they commit concurrently
+type interleavedStore struct {
+	kvdb.Store
+	gate  sync.WaitGroup
+	calls int32
+}
+
+func (s *interleavedStore) Transact(
+	ctx context.Context,
+	f func(kvdb.Transaction) (interface{}, error),
+) (interface{}, error) {
+	gated := atomic.AddInt32(&s.calls, 1) <= 2
+	return s.Store.Transact(ctx, func(tx kvdb.Transaction) (interface{}, error) {
+		v, err := f(tx)
+		if gated {
+			s.gate.Done()
+			s.gate.Wait()
+		}
+		return v, err
+	})
+}
+
+// TestIdempotency_Purge ensures expired keys are removed
+func TestIdempotency_Purge(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	keys, err := booking.NewIdempotencyRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	now := utc.Now()
+	for i, key := range []string{"k1", "k2", "k3"} {
+		err := keys.Put(ctx, &booking.IdempotencyRecord{
+			UserID:    "foo",
+			Key:       key,
+			ExpiresAt: now.Add(time.Duration(i-1) * time.Hour),
+		})
+		if err != nil {
+			t.Fatal("expect to put a record, but got", err)
+		}
+	}
+
+	if _, err := keys.Get(ctx, "foo", "k1"); !errors.IsNotFound(err) {
+		t.Error("expect expired key to be ignored, but got", err)
+	}
+	n, err := keys.Purge(ctx, now.Add(time.Second), 10)
+	if err != nil {
+		t.Fatal("expect to purge records, but got", err)
+	}
+	if n != 2 {
+		t.Errorf("expect 2 purged records, but got %d", n)
+	}
+	if _, err := keys.Get(ctx, "foo", "k3"); err != nil {
+		t.Error("expect unexpired key to be kept, but got", err)
+	}
+}
+
+// TestService_RescheduleVersion ensures a reservation cannot be changed based
+// on a stale version
+func TestService_RescheduleVersion(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	from := utc.MustParse("2021-08-01T12:00:00Z")
+
+	res, err := svc.ReserveRoom(ctx, "C01", from, 1)
+	if err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	if res.Version != 1 {
+		t.Fatalf("expect version 1, but got %d", res.Version)
+	}
+
+	res, err = svc.RescheduleRoomReservation(ctx, "C01", res.ID, res.Version, from.Add(time.Hour), 1)
+	if err != nil {
+		t.Fatal("expect to reschedule a reservation, but got", err)
+	}
+	if res.Version != 2 {
+		t.Fatalf("expect version 2, but got %d", res.Version)
+	}
+	_, err = svc.RescheduleRoomReservation(ctx, "C01", res.ID, 1, from.Add(2*time.Hour), 1)
+	if !errors.IsFailedPrecondition(err) {
+		t.Fatal("expect stale reschedule to fail, but got", err)
+	}
+	if err := svc.CancelRoomReservation(ctx, "C01", res.ID, 1); !errors.IsFailedPrecondition(err) {
+		t.Fatal("expect stale cancellation to fail, but got", err)
+	}
+
+	got, err := svc.GetRoomReservation(ctx, "C01", res.ID)
+	if err != nil {
+		t.Fatal("expect to get a reservation, but got", err)
+	}
+	if got.Version != 2 || got.From != from.Add(time.Hour) {
+		t.Errorf("expect reservation at version 2, but got %d", got.Version)
+	}
+	if err := svc.CancelRoomReservation(ctx, "C01", res.ID, got.Version); err != nil {
+		t.Fatal("expect to cancel a reservation, but got", err)
+	}
+}
+
+// TestService_Approval ensures reservations of restricted rooms are pending
+// until an approver decides on them, and that pending requests can hold their
+// slot
+func TestService_Approval(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, u := range []*iam.User{
+		{ID: "admin", Roles: []iam.Role{iam.RoleAdmin}},
+		{ID: "assistant", GroupID: "board"},
+		{ID: "foo", GroupID: "staff"},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal("expect to create user, but got", err)
+		}
+	}
+	admin := iam.WithContext(ctx, &iam.Account{UserID: "admin"})
+	assistant := iam.WithContext(ctx, &iam.Account{UserID: "assistant"})
+	foo := iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+	bar := iam.WithContext(ctx, &iam.Account{UserID: "bar"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	err = svc.CreateRoom(admin, &booking.Room{
+		Ref:              "BOARD",
+		RequiresApproval: true,
+		HoldPending:      true,
+		ApproverGroups:   []string{"board"},
+	})
+	if err != nil {
+		t.Fatal("expect to create room, but got", err)
+	}
+	from := utc.Now().Add(48 * time.Hour).Floor(time.Hour)
+
+	_, _, err = svc.ReserveRoomOnce(foo, "", booking.BookingRequest{RoomRef: "BOARD", From: from, Hours: 1})
+	if !errors.IsAborted(err) {
+		t.Fatal("expect reservation to require approval, but got", err)
+	}
+
+	req, err := svc.RequestRoom(foo, booking.BookingRequest{RoomRef: "board", From: from, Hours: 2})
+	if err != nil {
+		t.Fatal("expect to request room, but got", err)
+	}
+	if req.Status != booking.ApprovalPending {
+		t.Errorf("expect request to be pending, but got %s", req.Status)
+	}
+
+	// The pending request holds its slot
+	_, err = svc.RequestRoom(bar, booking.BookingRequest{
+		RoomRef: "BOARD", From: from.Add(time.Hour), Hours: 1,
+	})
+	if !errors.IsAborted(err) {
+		t.Error("expect slot to be held, but got", err)
+	}
+	other, err := svc.RequestRoom(bar, booking.BookingRequest{
+		RoomRef: "BOARD", From: from.Add(2 * time.Hour), Hours: 1,
+	})
+	if err != nil {
+		t.Fatal("expect to request room, but got", err)
+	}
+
+	// Only approvers can decide
+	if _, err := svc.ApproveRequest(bar, "BOARD", req.ID, ""); !errors.IsPermissionDenied(err) {
+		t.Error("expect requester not to be an approver, but got", err)
+	}
+	l, err := svc.ListApprovalRequests(bar, "BOARD", booking.ApprovalPending)
+	if err != nil {
+		t.Fatal("expect to list requests, but got", err)
+	}
+	if len(l) != 1 || l[0].ID != other.ID {
+		t.Errorf("expect requester to only see their request, but got %v", l)
+	}
+
+	approved, err := svc.ApproveRequest(assistant, "BOARD", req.ID, "")
+	if err != nil {
+		t.Fatal("expect group member to approve, but got", err)
+	}
+	if approved.Status != booking.ApprovalApproved || approved.ReservationID == "" {
+		t.Errorf("expect request to be approved with a reservation, but got %+v", approved)
+	}
+	res, err := svc.GetRoomReservation(foo, "BOARD", approved.ReservationID)
+	if err != nil {
+		t.Fatal("expect reservation to exist, but got", err)
+	}
+	if res.UserID != "foo" || res.From != from || res.To != from.Add(2*time.Hour) {
+		t.Errorf("expect reservation to match request, but got %+v", res)
+	}
+	if _, err := svc.ApproveRequest(admin, "BOARD", req.ID, ""); !errors.IsAborted(err) {
+		t.Error("expect decided request not to be decidable again, but got", err)
+	}
+
+	if _, err := svc.RejectRequest(admin, "BOARD", other.ID, ""); !errors.IsBad(err) {
+		t.Error("expect rejection to require a reason, but got", err)
+	}
+	rejected, err := svc.RejectRequest(admin, "BOARD", other.ID, "Board meeting")
+	if err != nil {
+		t.Fatal("expect admin to reject, but got", err)
+	}
+	if rejected.Status != booking.ApprovalRejected || rejected.Reason != "Board meeting" ||
+		rejected.DecidedBy != "admin" {
+		t.Errorf("expect rejection to be recorded, but got %+v", rejected)
+	}
+}
+
+// TestApproval_Expire ensures expired pending requests are rejected
+func TestApproval_Expire(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	approvals, err := booking.NewApprovalRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	now := utc.Now()
+	for i := 0; i < 3; i++ {
+		err := approvals.Create(ctx, &booking.ApprovalRequest{
+			RoomRef:   "BOARD",
+			UserID:    "foo",
+			From:      now.Add(time.Duration(i+1) * 24 * time.Hour),
+			To:        now.Add(time.Duration(i+1)*24*time.Hour + time.Hour),
+			ExpiresAt: now.Add(time.Duration(i-1) * time.Hour),
+		})
+		if err != nil {
+			t.Fatal("expect to create request, but got", err)
+		}
+	}
+
+	n, err := approvals.Expire(ctx, now, 10)
+	if err != nil {
+		t.Fatal("expect to expire requests, but got", err)
+	}
+	if n != 2 {
+		t.Errorf("expect 2 expired requests, but got %d", n)
+	}
+	pending, err := approvals.Pending(ctx, "BOARD", now)
+	if err != nil {
+		t.Fatal("expect to list pending requests, but got", err)
+	}
+	if len(pending) != 1 {
+		t.Errorf("expect 1 pending request, but got %d", len(pending))
+	}
+	rejected, err := approvals.List(ctx, "BOARD", booking.ApprovalRejected)
+	if err != nil {
+		t.Fatal("expect to list rejected requests, but got", err)
+	}
+	if len(rejected) != 2 || rejected[0].Reason == "" {
+		t.Errorf("expect 2 rejected requests with a reason, but got %v", rejected)
+	}
+}
+
+// TestService_Delegation ensures delegates can book and cancel on behalf of
+// the user who granted them the right to
+func TestService_Delegation(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, id := range []string{"exec", "assistant", "bar"} {
+		if err := users.Create(ctx, &iam.User{ID: id}); err != nil {
+			t.Fatal("expect to create user, but got", err)
+		}
+	}
+	exec := iam.WithContext(ctx, &iam.Account{UserID: "exec"})
+	assistant := iam.WithContext(ctx, &iam.Account{UserID: "assistant"})
+	bar := iam.WithContext(ctx, &iam.Account{UserID: "bar"})
+
+	iams, err := iam.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising iam service", err)
+	}
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	b := booking.BookingRequest{
+		RoomRef:    "C01",
+		From:       utc.MustParse("2021-08-01T12:00:00Z"),
+		Hours:      1,
+		OnBehalfOf: "exec",
+	}
+
+	if _, _, err := svc.ReserveRoomOnce(assistant, "", b); !errors.IsPermissionDenied(err) {
+		t.Fatal("expect booking without delegation to be denied, but got", err)
+	}
+	if _, err := iams.GrantDelegation(exec, "assistant"); err != nil {
+		t.Fatal("expect to grant delegation, but got", err)
+	}
+
+	res, _, err := svc.ReserveRoomOnce(assistant, "", b)
+	if err != nil {
+		t.Fatal("expect delegate to book, but got", err)
+	}
+	if res.UserID != "exec" || res.CreatedBy != "assistant" {
+		t.Errorf("expect reservation owned by exec and created by assistant, but got %+v", res)
+	}
+	l, err := svc.ListMyReservations(exec)
+	if err != nil {
+		t.Fatal("expect to list reservations, but got", err)
+	}
+	if len(l) != 1 {
+		t.Errorf("expect owner to have 1 reservation, but got %d", len(l))
+	}
+	if _, err := svc.ListUserReservations(bar, "exec"); !errors.IsPermissionDenied(err) {
+		t.Error("expect other users not to list reservations, but got", err)
+	}
+
+	if err := svc.CancelRoomReservation(bar, "C01", res.ID, 0); !errors.IsPermissionDenied(err) {
+		t.Error("expect other users not to cancel, but got", err)
+	}
+	if err := svc.CancelRoomReservation(assistant, "C01", res.ID, 0); err != nil {
+		t.Error("expect delegate to cancel, but got", err)
+	}
+
+	if err := iams.RevokeDelegation(exec, "assistant"); err != nil {
+		t.Fatal("expect to revoke delegation, but got", err)
+	}
+	if _, _, err := svc.ReserveRoomOnce(assistant, "", b); !errors.IsPermissionDenied(err) {
+		t.Error("expect booking after revocation to be denied, but got", err)
+	}
+}
+
+// TestService_Policies ensures reservations and cancellations are checked
+// against the policies declared in the config file and through the API
+func TestService_Policies(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	tree, err := config.LoadTree(strings.NewReader(`
+[[app.booking.policies]]
+  id = "boardroom"
+  tag = "board"
+  groups = ["board"]
+  max_duration = "2h"
+`))
+	if err != nil {
+		t.Fatal("error loading config", err)
+	}
+	ctx = config.TreeWithContext(ctx, tree)
+
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, u := range []*iam.User{
+		{ID: "admin", Roles: []iam.Role{iam.RoleAdmin}},
+		{ID: "assistant", GroupID: "board"},
+		{ID: "foo", GroupID: "staff"},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal("expect to create user, but got", err)
+		}
+	}
+	admin := iam.WithContext(ctx, &iam.Account{UserID: "admin"})
+	assistant := iam.WithContext(ctx, &iam.Account{UserID: "assistant"})
+	foo := iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	if err := svc.CreateRoom(admin, &booking.Room{Ref: "BOARD", Tags: []string{"board"}}); err != nil {
+		t.Fatal("expect to create room, but got", err)
+	}
+	err = svc.PutPolicy(foo, &booking.Policy{ID: "global"})
+	if !errors.IsPermissionDenied(err) {
+		t.Error("expect policies to be restricted to admins, but got", err)
+	}
+	err = svc.PutPolicy(admin, &booking.Policy{ID: "boardroom"})
+	if !errors.IsAborted(err) {
+		t.Error("expect static policies to be read-only, but got", err)
+	}
+	err = svc.PutPolicy(admin, &booking.Policy{
+		ID:           "global",
+		MaxAdvance:   booking.Duration(30 * 24 * time.Hour),
+		MinNotice:    booking.Duration(15 * time.Minute),
+		CancelNotice: booking.Duration(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal("expect to put policy, but got", err)
+	}
+	policies, err := svc.ListPolicies(foo)
+	if err != nil {
+		t.Fatal("expect to list policies, but got", err)
+	}
+	if len(policies) != 2 || !policies[0].Static || policies[0].MaxDuration != booking.Duration(2*time.Hour) {
+		t.Errorf("expect static policy followed by global, but got %+v", policies)
+	}
+
+	now := utc.Now()
+	table := []struct {
+		ctx  context.Context
+		b    booking.BookingRequest
+		rule string
+	}{
+		{foo, booking.BookingRequest{RoomRef: "C01", From: now.Add(5 * time.Minute), Hours: 1}, "policies[global].minNotice"},
+		{foo, booking.BookingRequest{RoomRef: "C01", From: now.Add(40 * 24 * time.Hour), Hours: 1}, "policies[global].maxAdvance"},
+		{foo, booking.BookingRequest{RoomRef: "BOARD", From: now.Add(48 * time.Hour), Hours: 1}, "policies[boardroom].groups"},
+		{assistant, booking.BookingRequest{RoomRef: "BOARD", From: now.Add(48 * time.Hour), Hours: 3}, "policies[boardroom].maxDuration"},
+		{assistant, booking.BookingRequest{RoomRef: "BOARD", From: now.Add(48 * time.Hour), Hours: 2}, ""},
+		{foo, booking.BookingRequest{RoomRef: "C01", From: now.Add(48 * time.Hour), Hours: 1}, ""},
+	}
+	for _, test := range table {
+		_, _, err := svc.ReserveRoomOnce(test.ctx, "", test.b)
+		if rule := violatedField(err); rule != test.rule {
+			t.Errorf("expect %s on %s to violate %q, but got %v", test.b.From, test.b.RoomRef, test.rule, err)
+		}
+	}
+
+	soon, _, err := svc.ReserveRoomOnce(foo, "", booking.BookingRequest{
+		RoomRef: "C01", From: now.Add(2 * time.Hour), Hours: 1,
+	})
+	if err != nil {
+		t.Fatal("expect to reserve, but got", err)
+	}
+	err = svc.CancelRoomReservation(foo, "C01", soon.ID, 0)
+	if rule := violatedField(err); rule != "policies[global].cancelNotice" {
+		t.Error("expect late cancellation to be rejected, but got", err)
+	}
+
+	if err := svc.DeletePolicy(admin, "global"); err != nil {
+		t.Fatal("expect to delete policy, but got", err)
+	}
+	if err := svc.CancelRoomReservation(foo, "C01", soon.ID, 0); err != nil {
+		t.Error("expect to cancel without policy, but got", err)
+	}
+}
+
+// violatedField returns the first field violated by `err`
+func violatedField(err error) string {
+	bad, ok := err.(*errors.BadRequest)
+	if !ok || len(bad.Violations) == 0 {
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+	return bad.Violations[0].Field
+}
+
+// TestService_LateCancel ensures cancelled reservations are kept, late
+// cancellations are flagged and counted towards quotas
+func TestService_LateCancel(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, u := range []*iam.User{
+		{ID: "admin", Roles: []iam.Role{iam.RoleAdmin}},
+		{ID: "foo"},
+		{ID: "bar"},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal("expect to create user, but got", err)
+		}
+	}
+	admin := iam.WithContext(ctx, &iam.Account{UserID: "admin"})
+	foo := iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+	bar := iam.WithContext(ctx, &iam.Account{UserID: "bar"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	for _, p := range []*booking.Policy{
+		{
+			ID:              "flexible",
+			Room:            "C01",
+			CancelNotice:    booking.Duration(time.Hour),
+			AllowLateCancel: true,
+			MaxLateCancels:  2,
+		},
+		{ID: "strict", Room: "C02", CancelNotice: booking.Duration(time.Hour)},
+	} {
+		if err := svc.PutPolicy(admin, p); err != nil {
+			t.Fatal("expect to put policy, but got", err)
+		}
+	}
+	b := booking.BookingRequest{RoomRef: "C01", From: utc.Now().Add(30 * time.Minute), Hours: 1}
+
+	for i := 0; i < 2; i++ {
+		res, _, err := svc.ReserveRoomOnce(foo, "", b)
+		if err != nil {
+			t.Fatal("expect to reserve, but got", err)
+		}
+		if err := svc.CancelRoomReservation(foo, "C01", res.ID, 0); err != nil {
+			t.Fatal("expect late cancellation to be allowed, but got", err)
+		}
+		cancelled, err := svc.GetRoomReservation(foo, "C01", res.ID)
+		if err != nil {
+			t.Fatal("expect cancelled reservation to be kept, but got", err)
+		}
+		if cancelled.Status != booking.ReservationCancelled || !cancelled.LateCancel ||
+			cancelled.CancelledBy != "foo" || cancelled.CancelledAt == 0 {
+			t.Errorf("expect reservation to be flagged as late cancelled, but got %+v", cancelled)
+		}
+		err = svc.CancelRoomReservation(foo, "C01", res.ID, 0)
+		if !errors.IsAborted(err) {
+			t.Error("expect cancelled reservation not to be cancelled again, but got", err)
+		}
+	}
+	_, _, err = svc.ReserveRoomOnce(foo, "", b)
+	if rule := violatedField(err); rule != "policies[flexible].maxLateCancels" {
+		t.Error("expect bookings to be suspended after 2 late cancellations, but got", err)
+	}
+	l, err := svc.ListCancelledReservations(admin, "C01")
+	if err != nil {
+		t.Fatal("expect to list cancelled reservations, but got", err)
+	}
+	if len(l) != 2 {
+		t.Errorf("expect 2 cancelled reservations, but got %d", len(l))
+	}
+
+	// Only admins can cancel within a strict window
+	b.RoomRef = "C02"
+	res, _, err := svc.ReserveRoomOnce(bar, "", b)
+	if err != nil {
+		t.Fatal("expect to reserve, but got", err)
+	}
+	err = svc.CancelRoomReservation(bar, "C02", res.ID, 0)
+	if rule := violatedField(err); rule != "policies[strict].cancelNotice" {
+		t.Error("expect late cancellation to be rejected, but got", err)
+	}
+	if err := svc.CancelRoomReservation(admin, "C02", res.ID, 0); err != nil {
+		t.Fatal("expect admin to cancel, but got", err)
+	}
+	cancelled, err := svc.GetRoomReservation(bar, "C02", res.ID)
+	if err != nil {
+		t.Fatal("expect cancelled reservation to be kept, but got", err)
+	}
+	if cancelled.LateCancel || cancelled.CancelledBy != "admin" {
+		t.Errorf("expect admin cancellation not to be flagged as late, but got %+v", cancelled)
+	}
+}
+
+// TestService_CheckIn ensures reservations can only be checked in by their
+// owner while they are open, and the ones which have not been checked in are
+// flagged as no-shows. Both are published to webhook endpoints.
+func TestService_CheckIn(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	for _, u := range []*iam.User{
+		{ID: "admin", Roles: []iam.Role{iam.RoleAdmin}},
+		{ID: "foo"},
+		{ID: "bar"},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal("expect to create user, but got", err)
+		}
+	}
+	admin := iam.WithContext(ctx, &iam.Account{UserID: "admin"})
+	foo := iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+	bar := iam.WithContext(ctx, &iam.Account{UserID: "bar"})
+
+	webhooks, err := webhook.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising webhook service", err)
+	}
+	_, err = webhooks.RegisterEndpoint(admin, "https://example.com/hook", []webhook.EventType{
+		webhook.EventReservationCheckedIn, webhook.EventReservationNoShow,
+	}, "")
+	if err != nil {
+		t.Fatal("expect to register endpoint, but got", err)
+	}
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	reserve := func(room string, from utc.UTC) *booking.Reservation {
+		res, _, err := svc.ReserveRoomOnce(foo, "", booking.BookingRequest{RoomRef: room, From: from, Hours: 2})
+		if err != nil {
+			t.Fatal("expect to reserve, but got", err)
+		}
+		return res
+	}
+	started := reserve("C01", utc.Now().Add(-30*time.Minute))
+	upcoming := reserve("C02", utc.Now().Add(2*time.Hour))
+	missed := reserve("C03", utc.Now().Add(-30*time.Minute))
+
+	_, err = svc.CheckInRoomReservation(bar, "C01", started.ID)
+	if !errors.IsPermissionDenied(err) {
+		t.Error("expect other users not to check in, but got", err)
+	}
+	_, err = svc.CheckInRoomReservation(foo, "C02", upcoming.ID)
+	if !errors.IsAborted(err) {
+		t.Error("expect check-in not to be open yet, but got", err)
+	}
+	res, err := svc.CheckInRoomReservation(foo, "C01", started.ID)
+	if err != nil {
+		t.Fatal("expect to check in, but got", err)
+	}
+	if res.CheckedInAt.IsZero() || res.CheckedInBy != "foo" {
+		t.Errorf("expect reservation to be checked in, but got %+v", res)
+	}
+	_, err = svc.CheckInRoomReservation(foo, "C01", started.ID)
+	if !errors.IsAborted(err) {
+		t.Error("expect reservation not to be checked in twice, but got", err)
+	}
+
+	detector, err := booking.NewNoShowDetector(ctx)
+	if err != nil {
+		t.Fatal("error initialising no-show detector", err)
+	}
+	for i, expect := range []int{1, 0} {
+		n, err := detector.Run(ctx)
+		if err != nil {
+			t.Fatal("expect to detect no-shows, but got", err)
+		}
+		if n != expect {
+			t.Errorf("#%d - expect %d no-shows, but got %d", i, expect, n)
+		}
+	}
+	res, err = svc.GetRoomReservation(foo, "C03", missed.ID)
+	if err != nil {
+		t.Fatal("expect to get reservation, but got", err)
+	}
+	if !res.NoShow {
+		t.Errorf("expect reservation to be flagged as no-show, but got %+v", res)
+	}
+	_, err = svc.CheckInRoomReservation(foo, "C03", missed.ID)
+	if !errors.IsAborted(err) {
+		t.Error("expect no-show not to be checked in, but got", err)
+	}
+
+	deliveries, err := webhook.NewDeliveryRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	due, err := deliveries.Due(ctx, utc.Now().Add(time.Second), 10)
+	if err != nil {
+		t.Fatal("error loading due deliveries", err)
+	}
+	published := map[webhook.EventType]int{}
+	for _, d := range due {
+		published[d.Event.Type]++
+	}
+	if len(due) != 2 ||
+		published[webhook.EventReservationCheckedIn] != 1 ||
+		published[webhook.EventReservationNoShow] != 1 {
+		t.Errorf("expect check-in and no-show events, but got %v", published)
+	}
+}
@@ -0,0 +1,319 @@
+package booking
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+)
+
+const (
+	// watchPollInterval defines how often room logs are scanned while
+	// watching. Changes made on this node are pushed immediately, so it
+	// mostly matters for changes made on other nodes.
+	watchPollInterval = 2 * time.Second
+	// watchWindow is the default period of time covered by availabilities
+	watchWindow = 7 * 24 * time.Hour
+)
+
+// RoomChangeType describes what happened to a reservation
+type RoomChangeType string
+
+const (
+	RoomChangeReserved    RoomChangeType = "reserved"
+	RoomChangeCancelled   RoomChangeType = "cancelled"
+	RoomChangeRescheduled RoomChangeType = "rescheduled"
+	RoomChangeArchived    RoomChangeType = "archived"
+)
+
+// RoomChange is a change recorded on the reservation log of a room
+type RoomChange struct {
+	Type          RoomChangeType `json:"type"`
+	Version       uint64         `json:"version"`
+	RoomRef       string         `json:"roomRef"`
+	ReservationID string         `json:"reservationId"`
+	UserID        string         `json:"userId"`
+	From          utc.UTC        `json:"from,omitempty"`
+	To            utc.UTC        `json:"to,omitempty"`
+	At            utc.UTC        `json:"at"`
+}
+
+// RoomAvailabilities contains the free ranges of a room
+type RoomAvailabilities struct {
+	RoomRef        string          `json:"roomRef"`
+	Version        uint64          `json:"version"`
+	Availabilities []*TimeInterval `json:"availabilities"`
+}
+
+// RoomUpdate is sent to room watchers. It contains either a change or the
+// availabilities of a room.
+type RoomUpdate struct {
+	// Cursor can be used to resume watching right after this update
+	Cursor         Cursor
+	Change         *RoomChange
+	Availabilities *RoomAvailabilities
+}
+
+// Cursor contains the last version seen of each room log
+type Cursor map[string]uint64
+
+// ParseCursor decodes a cursor returned by Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	c := Cursor{}
+	if s == "" {
+		return c, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "cursor",
+			Description: "Invalid cursor",
+		})
+	}
+	for _, part := range strings.Split(string(data), ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Bad(&errors.FieldViolation{
+				Field:       "cursor",
+				Description: "Invalid cursor",
+			})
+		}
+		v, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			return nil, errors.Bad(&errors.FieldViolation{
+				Field:       "cursor",
+				Description: "Invalid cursor version",
+			})
+		}
+		c[kv[0]] = v
+	}
+	return c, nil
+}
+
+// String returns an opaque representation of the cursor
+func (c Cursor) String() string {
+	if len(c) == 0 {
+		return ""
+	}
+	rooms := make([]string, 0, len(c))
+	for room := range c {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	parts := make([]string, len(rooms))
+	for i, room := range rooms {
+		parts[i] = room + "=" + strconv.FormatUint(c[room], 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, ";")))
+}
+
+func (c Cursor) clone() Cursor {
+	n := make(Cursor, len(c))
+	for k, v := range c {
+		n[k] = v
+	}
+	return n
+}
+
+// WatchRooms sends changes made on rooms `roomRefs` to `send` until `ctx` is
+// done. When `roomRefs` is empty, all rooms are watched.
+//
+// Changes recorded after `cursor` are replayed first. Rooms missing from the
+// cursor start from their current version. The availabilities of each room
+// within [from, to) are sent after every batch of changes and once on start.
+func (s *Service) WatchRooms(
+	ctx context.Context,
+	roomRefs []string,
+	cursor Cursor,
+	from, to utc.UTC,
+	send func(*RoomUpdate) error,
+) error {
+	if from == 0 {
+		from = utc.Now().Floor(minPrecision)
+	}
+	if to == 0 {
+		to = from.Add(watchWindow)
+	}
+	if to < from {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "to",
+			Description: "The interval is invalid. to is smaller than from",
+		})
+	}
+
+	if len(roomRefs) == 0 {
+		rooms, err := s.rooms.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, room := range rooms {
+			roomRefs = append(roomRefs, room.Ref)
+		}
+	}
+
+	log.Trace(ctx, "booking.rooms.watch", "Watch rooms",
+		log.Int("rooms", len(roomRefs)),
+		log.Stringer("from", from),
+		log.Stringer("to", to),
+	)
+
+	// Only keep subscribed rooms
+	c := Cursor{}
+	for _, ref := range roomRefs {
+		ref = strings.TrimSpace(strings.ToUpper(ref))
+		if v, ok := cursor[ref]; ok {
+			c[ref] = v
+			continue
+		}
+		v, err := s.reservations.Version(ctx, ref)
+		if err != nil {
+			return err
+		}
+		c[ref] = v
+	}
+
+	// Start with a snapshot of all rooms
+	dirty := map[string]bool{}
+	for ref := range c {
+		dirty[ref] = true
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		// Subscribe before reading logs to avoid missing a change
+		changed := s.reservations.Changed()
+
+		for ref := range c {
+			events, err := s.reservations.Events(ctx, ref, c[ref])
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				c[ref] = e.Version
+				dirty[ref] = true
+				err := send(&RoomUpdate{
+					Cursor: c.clone(),
+					Change: roomChange(e),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for ref := range dirty {
+			slots, err := s.reservations.FreeRanges(ctx, ref, from, to)
+			switch {
+			case err == nil:
+			case errors.IsNotFound(err):
+				// No reservations yet
+				slots = []*TimeInterval{{From: from, To: to}}
+			default:
+				return err
+			}
+			err = send(&RoomUpdate{
+				Cursor: c.clone(),
+				Availabilities: &RoomAvailabilities{
+					RoomRef:        ref,
+					Version:        c[ref],
+					Availabilities: slots,
+				},
+			})
+			if err != nil {
+				return err
+			}
+			delete(dirty, ref)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+func roomChange(e *RecordedEvent) *RoomChange {
+	switch evt := e.Event.(type) {
+	case *ReservedEvent:
+		return &RoomChange{
+			Type:          RoomChangeReserved,
+			Version:       e.Version,
+			RoomRef:       evt.Reservation.RoomRef,
+			ReservationID: evt.Reservation.ID,
+			UserID:        evt.Reservation.UserID,
+			From:          evt.Reservation.From,
+			To:            evt.Reservation.To,
+			At:            evt.At,
+		}
+	case *CancelledEvent:
+		return &RoomChange{
+			Type:          RoomChangeCancelled,
+			Version:       e.Version,
+			RoomRef:       evt.RoomRef,
+			ReservationID: evt.ReservationID,
+			UserID:        evt.UserID,
+			At:            evt.At,
+		}
+	case *RescheduledEvent:
+		return &RoomChange{
+			Type:          RoomChangeRescheduled,
+			Version:       e.Version,
+			RoomRef:       evt.RoomRef,
+			ReservationID: evt.ReservationID,
+			UserID:        evt.UserID,
+			From:          evt.From,
+			To:            evt.To,
+			At:            evt.At,
+		}
+	case *ArchivedEvent:
+		return &RoomChange{
+			Type:          RoomChangeArchived,
+			Version:       e.Version,
+			RoomRef:       evt.Reservation.RoomRef,
+			ReservationID: evt.Reservation.ID,
+			UserID:        evt.Reservation.UserID,
+			From:          evt.Reservation.From,
+			To:            evt.Reservation.To,
+			At:            evt.At,
+		}
+	}
+	return &RoomChange{
+		Version: e.Version,
+		RoomRef: e.Event.Room(),
+	}
+}
+
+// notifier wakes up all waiters when a change occurs
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{ch: make(chan struct{})}
+}
+
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *notifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
@@ -0,0 +1,96 @@
+package booking_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/pkg/utc"
+)
+
+// TestService_WatchRooms ensures watchers receive changes as they happen and
+// can resume from a cursor
+func TestService_WatchRooms(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "foo"})
+
+	svc, err := booking.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	from := utc.MustParse("2021-08-01T00:00:00Z")
+	to := utc.MustParse("2021-08-02T00:00:00Z")
+
+	updates := make(chan *booking.RoomUpdate, 16)
+	watch := func(ctx context.Context, cursor booking.Cursor) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- svc.WatchRooms(ctx, []string{"c01"}, cursor, from, to,
+				func(u *booking.RoomUpdate) error {
+					updates <- u
+					return nil
+				},
+			)
+		}()
+		return done
+	}
+	next := func() *booking.RoomUpdate {
+		select {
+		case u := <-updates:
+			return u
+		case <-time.After(time.Second):
+			t.Fatal("expect an update")
+			return nil
+		}
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	done := watch(wctx, nil)
+
+	// Snapshot
+	u := next()
+	if u.Availabilities == nil || len(u.Availabilities.Availabilities) != 1 {
+		t.Fatal("expect a snapshot of a free room, but got", u)
+	}
+
+	res, err := svc.ReserveRoom(ctx, "C01", utc.MustParse("2021-08-01T12:00:00Z"), 1)
+	if err != nil {
+		t.Fatal("expect to reserve a room, but got", err)
+	}
+	u = next()
+	if u.Change == nil || u.Change.ReservationID != res.ID {
+		t.Fatal("expect a reservation change, but got", u)
+	}
+	cursor := u.Cursor
+	u = next()
+	if u.Availabilities == nil || len(u.Availabilities.Availabilities) != 2 {
+		t.Fatal("expect availabilities to be split in two, but got", u)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal("expect watch to end without error, but got", err)
+	}
+
+	// Resume while disconnected
+	if err := svc.CancelRoomReservation(ctx, "C01", res.ID, 0); err != nil {
+		t.Fatal("expect to cancel a reservation, but got", err)
+	}
+	parsed, err := booking.ParseCursor(cursor.String())
+	if err != nil {
+		t.Fatal("expect to parse cursor, but got", err)
+	}
+	wctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	watch(wctx, parsed)
+
+	u = next()
+	if u.Change == nil || u.Change.Type != booking.RoomChangeCancelled {
+		t.Fatal("expect missed cancellation to be replayed, but got", u)
+	}
+}
@@ -0,0 +1,265 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/deixis/errors"
+)
+
+const (
+	// EnvDevelopment enables settings which are only safe on a workstation
+	EnvDevelopment = "development"
+	// EnvProduction rejects insecure settings at startup
+	EnvProduction = "production"
+
+	// StorageMemory keeps data in memory instead of a Badger store. Data is
+	// lost when the API stops.
+	StorageMemory = "memory"
+
+	// minSecretLength is the minimum length of the JWT secret in production
+	minSecretLength = 32
+)
+
+// insecureSecrets are secrets which have been published, and must never be
+// used in production
+var insecureSecrets = []string{
+	"a-hardcoded-secret-is-the-safest-secret",
+}
+
+// Config is the configuration of the app, declared under `[app]` in the
+// config file. Every setting can be overridden with an environment variable.
+type Config struct {
+	// Env is either "development" or "production" (APP_ENV)
+	Env string `toml:"env"`
+	// Storage is the folder of the Badger store, or "memory" (STORAGE_PATH)
+	Storage string `toml:"storage"`
+	// Seed loads random demo data when the API starts (SEED)
+	Seed bool `toml:"seed"`
+	// CORSOrigins are the origins allowed to call the API from a browser, or
+	// "*" for any origin (CORS_ORIGINS, separated by commas)
+	CORSOrigins  []string           `toml:"cors_origins"`
+	Auth         AuthConfig         `toml:"auth"`
+	Booking      BookingConfig      `toml:"booking"`
+	Notification NotificationConfig `toml:"notification"`
+}
+
+// AuthConfig is declared under `[app.auth]`
+type AuthConfig struct {
+	// Secret signs session tokens (JWT_SECRET)
+	Secret string `toml:"secret"`
+	// SessionTTL is how long sessions are valid (SESSION_TTL)
+	SessionTTL booking.Duration `toml:"session_ttl"`
+}
+
+// BookingConfig is declared under `[app.booking]`, next to the booking
+// policies
+type BookingConfig struct {
+	// ArchiveHorizon is how long reservations stay in room schedules after
+	// they ended (ARCHIVE_HORIZON)
+	ArchiveHorizon booking.Duration `toml:"archive_horizon"`
+	// ArchiveSummarise only keeps daily summaries of archived reservations
+	// (ARCHIVE_SUMMARISE)
+	ArchiveSummarise bool `toml:"archive_summarise"`
+}
+
+// NotificationConfig is declared under `[app.notification]`. Emails are only
+// logged when no SMTP server is configured.
+type NotificationConfig struct {
+	// SMTPAddr is the address of the SMTP server, as host:port (SMTP_ADDR)
+	SMTPAddr string `toml:"smtp_addr"`
+	// SMTPUsername enables authentication with the SMTP server
+	// (SMTP_USERNAME)
+	SMTPUsername string `toml:"smtp_username"`
+	// SMTPPassword is the password of SMTPUsername (SMTP_PASSWORD)
+	SMTPPassword string `toml:"smtp_password"`
+	// SMTPStartTLS requires the SMTP server to support STARTTLS. Without it,
+	// credentials can be sent in plain text (SMTP_STARTTLS)
+	SMTPStartTLS bool `toml:"smtp_starttls"`
+	// From is the sender address of emails (NOTIFICATION_FROM)
+	From string `toml:"from"`
+}
+
+// DefaultConfig returns the configuration used for settings missing from the
+// config file. It is a production configuration without secret, so it does
+// not pass validation on its own.
+func DefaultConfig() *Config {
+	return &Config{
+		Env:     EnvProduction,
+		Storage: ".storage",
+		Auth: AuthConfig{
+			SessionTTL: booking.Duration(2 * time.Hour),
+		},
+		Booking: BookingConfig{
+			ArchiveHorizon: booking.Duration(booking.DefaultArchiveHorizon),
+		},
+		Notification: NotificationConfig{
+			SMTPStartTLS: true,
+		},
+	}
+}
+
+// LoadEnv overrides settings with the environment variables returned by
+// `lookup` (e.g. os.LookupEnv)
+func (c *Config) LoadEnv(lookup func(string) (string, bool)) error {
+	if v, ok := lookup("APP_ENV"); ok {
+		c.Env = v
+	}
+	if v, ok := lookup("STORAGE_PATH"); ok {
+		c.Storage = v
+	}
+	if v, ok := lookup("SEED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid SEED")
+		}
+		c.Seed = b
+	}
+	if v, ok := lookup("CORS_ORIGINS"); ok {
+		c.CORSOrigins = nil
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				c.CORSOrigins = append(c.CORSOrigins, o)
+			}
+		}
+	}
+	if v, ok := lookup("JWT_SECRET"); ok {
+		c.Auth.Secret = v
+	}
+	if v, ok := lookup("SESSION_TTL"); ok {
+		if err := c.Auth.SessionTTL.UnmarshalText([]byte(v)); err != nil {
+			return errors.Wrap(err, "invalid SESSION_TTL")
+		}
+	}
+	if v, ok := lookup("ARCHIVE_HORIZON"); ok {
+		if err := c.Booking.ArchiveHorizon.UnmarshalText([]byte(v)); err != nil {
+			return errors.Wrap(err, "invalid ARCHIVE_HORIZON")
+		}
+	}
+	if v, ok := lookup("ARCHIVE_SUMMARISE"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid ARCHIVE_SUMMARISE")
+		}
+		c.Booking.ArchiveSummarise = b
+	}
+	if v, ok := lookup("SMTP_ADDR"); ok {
+		c.Notification.SMTPAddr = v
+	}
+	if v, ok := lookup("SMTP_USERNAME"); ok {
+		c.Notification.SMTPUsername = v
+	}
+	if v, ok := lookup("SMTP_PASSWORD"); ok {
+		c.Notification.SMTPPassword = v
+	}
+	if v, ok := lookup("SMTP_STARTTLS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid SMTP_STARTTLS")
+		}
+		c.Notification.SMTPStartTLS = b
+	}
+	if v, ok := lookup("NOTIFICATION_FROM"); ok {
+		c.Notification.From = v
+	}
+	return nil
+}
+
+// Validate ensures the configuration is usable. In production, it also
+// rejects settings which are insecure or unsafe: a weak or published secret,
+// demo data, CORS requests from any origin, data kept in memory, and SMTP
+// credentials sent in plain text to another host.
+func (c *Config) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch c.Env {
+	case EnvDevelopment, EnvProduction:
+	default:
+		fail("env must be %q or %q", EnvDevelopment, EnvProduction)
+	}
+	if c.Storage == "" {
+		fail("storage is missing")
+	}
+	if c.Auth.Secret == "" {
+		fail("auth.secret is missing")
+	}
+	if c.Auth.SessionTTL <= 0 {
+		fail("auth.session_ttl must be positive")
+	}
+	if c.Booking.ArchiveHorizon < 0 {
+		fail("booking.archive_horizon cannot be negative")
+	}
+	for _, o := range c.CORSOrigins {
+		if o != "*" && !validOrigin(o) {
+			fail("cors_origins: %q is not an origin (e.g. https://example.com)", o)
+		}
+	}
+	smtpHost, _, err := net.SplitHostPort(c.Notification.SMTPAddr)
+	switch {
+	case c.Notification.SMTPAddr == "":
+		if c.Notification.SMTPUsername != "" {
+			fail("notification.smtp_username requires notification.smtp_addr")
+		}
+	case err != nil || smtpHost == "":
+		fail("notification.smtp_addr must be host:port")
+	}
+
+	if c.Env == EnvProduction {
+		if len(c.Auth.Secret) < minSecretLength {
+			fail("auth.secret must have at least %d characters in production", minSecretLength)
+		}
+		for _, s := range insecureSecrets {
+			if c.Auth.Secret == s {
+				fail("auth.secret has been published and cannot be used in production")
+			}
+		}
+		if c.Seed {
+			fail("seed cannot be enabled in production")
+		}
+		if c.Storage == StorageMemory {
+			fail("storage cannot be in memory in production")
+		}
+		for _, o := range c.CORSOrigins {
+			if o == "*" {
+				fail("cors_origins cannot allow any origin in production")
+			}
+		}
+		if c.Notification.SMTPUsername != "" && !c.Notification.SMTPStartTLS &&
+			!localHost(smtpHost) {
+			fail("notification.smtp_starttls is required to authenticate with %s in production", smtpHost)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validOrigin returns whether `s` is a scheme and a host without path
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.User == nil
+}
+
+// localHost returns whether `host` is the local machine, where credentials
+// can be sent in plain text
+func localHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,204 @@
+package app_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app"
+	"github.com/deixis/spine/config"
+)
+
+// TestConfig_Load ensures settings are read from the config file, then
+// overridden by environment variables
+func TestConfig_Load(t *testing.T) {
+	tree, err := config.LoadTree(strings.NewReader(`
+[app]
+  env = "development"
+  cors_origins = ["https://example.com"]
+
+[app.auth]
+  secret = "file-secret"
+
+[app.booking]
+  archive_horizon = "48h"
+
+[[app.booking.policies]]
+  id = "global"
+`))
+	if err != nil {
+		t.Fatal("error loading config tree", err)
+	}
+	cfg := app.DefaultConfig()
+	if err := tree.Get("app").Unmarshal(cfg); err != nil {
+		t.Fatal("error unmarshalling config", err)
+	}
+
+	env := map[string]string{
+		"JWT_SECRET":    "env-secret",
+		"CORS_ORIGINS":  "http://localhost:3000, https://example.com",
+		"SEED":          "true",
+		"SMTP_ADDR":     "smtp.example.com:587",
+		"SMTP_STARTTLS": "false",
+	}
+	err = cfg.LoadEnv(func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal("error loading environment", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal("expect config to be valid, but got", err)
+	}
+
+	if cfg.Env != app.EnvDevelopment {
+		t.Errorf("expect env %q, but got %q", app.EnvDevelopment, cfg.Env)
+	}
+	if cfg.Auth.Secret != "env-secret" {
+		t.Errorf("expect secret to be overridden, but got %q", cfg.Auth.Secret)
+	}
+	if time.Duration(cfg.Auth.SessionTTL) != 2*time.Hour {
+		t.Errorf("expect default session TTL, but got %s", cfg.Auth.SessionTTL)
+	}
+	if time.Duration(cfg.Booking.ArchiveHorizon) != 48*time.Hour {
+		t.Errorf("expect archive horizon 48h, but got %s", cfg.Booking.ArchiveHorizon)
+	}
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[0] != "http://localhost:3000" {
+		t.Errorf("expect CORS origins to be overridden, but got %v", cfg.CORSOrigins)
+	}
+	if cfg.Storage != ".storage" || !cfg.Seed {
+		t.Errorf("expect default storage and seeding, but got %q %t", cfg.Storage, cfg.Seed)
+	}
+	if cfg.Notification.SMTPAddr != "smtp.example.com:587" || cfg.Notification.SMTPStartTLS {
+		t.Errorf("expect SMTP server without STARTTLS, but got %+v", cfg.Notification)
+	}
+
+	err = cfg.LoadEnv(func(k string) (string, bool) {
+		if k == "SESSION_TTL" {
+			return "forever", true
+		}
+		return "", false
+	})
+	if err == nil {
+		t.Error("expect invalid SESSION_TTL to fail")
+	}
+}
+
+// TestConfig_Validate ensures insecure settings are rejected in production
+func TestConfig_Validate(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name   string
+		modify func(c *app.Config)
+		expect string
+	}{
+		{
+			name:   "production",
+			modify: func(c *app.Config) {},
+		},
+		{
+			name:   "default",
+			modify: func(c *app.Config) { c.Auth.Secret = "" },
+			expect: "auth.secret is missing",
+		},
+		{
+			name:   "short secret",
+			modify: func(c *app.Config) { c.Auth.Secret = "short" },
+			expect: "at least 32 characters",
+		},
+		{
+			name: "published secret",
+			modify: func(c *app.Config) {
+				c.Auth.Secret = "a-hardcoded-secret-is-the-safest-secret"
+			},
+			expect: "has been published",
+		},
+		{
+			name:   "seed",
+			modify: func(c *app.Config) { c.Seed = true },
+			expect: "seed cannot be enabled",
+		},
+		{
+			name:   "memory storage",
+			modify: func(c *app.Config) { c.Storage = app.StorageMemory },
+			expect: "storage cannot be in memory",
+		},
+		{
+			name:   "any origin",
+			modify: func(c *app.Config) { c.CORSOrigins = []string{"*"} },
+			expect: "cannot allow any origin",
+		},
+		{
+			name: "development",
+			modify: func(c *app.Config) {
+				c.Env = app.EnvDevelopment
+				c.Auth.Secret = "short"
+				c.Seed = true
+				c.CORSOrigins = []string{"*"}
+				c.Storage = app.StorageMemory
+			},
+		},
+		{
+			name:   "unknown env",
+			modify: func(c *app.Config) { c.Env = "staging" },
+			expect: "env must be",
+		},
+		{
+			name:   "invalid origin",
+			modify: func(c *app.Config) { c.CORSOrigins = []string{"example.com/path"} },
+			expect: "is not an origin",
+		},
+		{
+			name: "plain-text SMTP auth",
+			modify: func(c *app.Config) {
+				c.Notification.SMTPAddr = "smtp.example.com:587"
+				c.Notification.SMTPUsername = "booking"
+				c.Notification.SMTPStartTLS = false
+			},
+			expect: "smtp_starttls is required",
+		},
+		{
+			name: "local plain-text SMTP auth",
+			modify: func(c *app.Config) {
+				c.Notification.SMTPAddr = "127.0.0.1:1025"
+				c.Notification.SMTPUsername = "booking"
+				c.Notification.SMTPStartTLS = false
+			},
+		},
+		{
+			name:   "invalid SMTP address",
+			modify: func(c *app.Config) { c.Notification.SMTPAddr = "smtp.example.com" },
+			expect: "smtp_addr must be host:port",
+		},
+		{
+			name:   "SMTP username without server",
+			modify: func(c *app.Config) { c.Notification.SMTPUsername = "booking" },
+			expect: "smtp_username requires",
+		},
+		{
+			name:   "session TTL",
+			modify: func(c *app.Config) { c.Auth.SessionTTL = 0 },
+			expect: "session_ttl must be positive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := app.DefaultConfig()
+			cfg.Auth.Secret = secret
+			test.modify(cfg)
+
+			err := cfg.Validate()
+			switch {
+			case test.expect == "" && err != nil:
+				t.Errorf("expect config to be valid, but got %s", err)
+			case test.expect != "" && err == nil:
+				t.Errorf("expect error %q", test.expect)
+			case test.expect != "" && !strings.Contains(err.Error(), test.expect):
+				t.Errorf("expect error %q, but got %s", test.expect, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+)
+
+// Fixture is a JSON snapshot of groups, users, accounts, rooms and active
+// reservations. Exports are fixtures, so they can be loaded in another store.
+type Fixture struct {
+	Groups       []*iam.Group           `json:"groups,omitempty"`
+	Users        []*iam.User            `json:"users,omitempty"`
+	Accounts     []*iam.Account         `json:"accounts,omitempty"`
+	Rooms        []*booking.Room        `json:"rooms,omitempty"`
+	Reservations []*booking.Reservation `json:"reservations,omitempty"`
+}
+
+// FixtureReport sums up how a fixture has been loaded
+type FixtureReport struct {
+	// Created is the number of records created
+	Created int
+	// Skipped is the number of records which already existed
+	Skipped int
+}
+
+// LoadFixture creates the records of fixture `f` which do not exist yet.
+//
+// Existing records are left unchanged, so a fixture can be loaded several
+// times. Reservations are identified by room, user and time, since their ID is
+// generated on creation, and the ones conflicting with existing reservations
+// are skipped.
+func LoadFixture(ctx context.Context, f *Fixture) (*FixtureReport, error) {
+	groups, err := iam.NewGroupRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising group repository")
+	}
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising user repository")
+	}
+	accounts, err := iam.NewAccountRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising account repository")
+	}
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising room repository")
+	}
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising reservation repository")
+	}
+
+	report := &FixtureReport{}
+	create := func(resource string, err error) error {
+		switch {
+		case err == nil:
+			report.Created++
+		case errors.IsAborted(err):
+			report.Skipped++
+		default:
+			return errors.Wrapf(err, "failed to create %s", resource)
+		}
+		return nil
+	}
+
+	for _, g := range f.Groups {
+		if err := create("group "+g.ID, groups.Create(ctx, g)); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range f.Users {
+		if err := create("user "+u.ID, users.Create(ctx, u)); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range f.Accounts {
+		if err := create("account "+a.ID(), accounts.Create(ctx, a)); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range f.Rooms {
+		if err := create("room "+r.Ref, rooms.Create(ctx, r)); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range f.Reservations {
+		existing, err := reservations.Reservations(ctx, r.RoomRef)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if hasReservation(existing, r) {
+			report.Skipped++
+			continue
+		}
+		resource := fmt.Sprintf("reservation of room %s from %s", r.RoomRef, r.From)
+		if err := create(resource, reservations.Reserve(ctx, r)); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// hasReservation returns whether `l` contains an active reservation matching
+// `r`
+func hasReservation(l []*booking.Reservation, r *booking.Reservation) bool {
+	for _, e := range l {
+		if e.Status == booking.ReservationCancelled {
+			continue
+		}
+		if e.UserID == r.UserID && e.From == r.From && e.To == r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportFixture returns all groups, users, accounts and rooms, along with the
+// active reservations
+func ExportFixture(ctx context.Context) (*Fixture, error) {
+	groups, err := iam.NewGroupRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising group repository")
+	}
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising user repository")
+	}
+	accounts, err := iam.NewAccountRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising account repository")
+	}
+	rooms, err := booking.NewRoomsRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising room repository")
+	}
+	reservations, err := booking.NewReservationRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising reservation repository")
+	}
+
+	f := &Fixture{}
+	if f.Groups, err = groups.List(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to list groups")
+	}
+	if f.Users, err = users.List(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to list users")
+	}
+	if f.Accounts, err = accounts.List(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+	if f.Rooms, err = rooms.List(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to list rooms")
+	}
+	for _, room := range f.Rooms {
+		l, err := reservations.Reservations(ctx, room.Ref)
+		switch {
+		case err == nil:
+		case errors.IsNotFound(err):
+			continue
+		default:
+			return nil, errors.Wrapf(err, "failed to list reservations of room %s", room.Ref)
+		}
+		for _, r := range l {
+			if r.Status != booking.ReservationCancelled {
+				f.Reservations = append(f.Reservations, r)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Seed loads random demo data, and prints a JWT for an admin user to `w`
+func Seed(ctx context.Context, cfg *Config, w io.Writer) error {
+	auths, err := newAuth(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		return errors.Wrap(err, "failed to generate private key for seed user")
+	}
+	addr := iam.Address(crypto.PubkeyToAddress(privKey.PublicKey))
+
+	// Create groups
+	coke := &iam.Group{
+		ID:  uuid.New().String(),
+		Ref: "COKE",
+	}
+	pepsi := &iam.Group{
+		ID:  uuid.New().String(),
+		Ref: "PEPSI",
+	}
+
+	// Create user with its account
+	usr := &iam.User{
+		ID:      uuid.New().String(),
+		GroupID: coke.ID,
+		Roles:   []iam.Role{iam.RoleAdmin},
+	}
+	acc := &iam.Account{
+		Address: addr,
+		UserID:  usr.ID,
+	}
+	f := &Fixture{
+		Groups:   []*iam.Group{coke, pepsi},
+		Users:    []*iam.User{usr},
+		Accounts: []*iam.Account{acc},
+	}
+
+	// Create some rooms, each with a reservation
+	for _, g := range []string{"C", "P"} {
+		for i := 1; i <= 10; i++ {
+			roomRef := fmt.Sprintf("%s%02d", g, i)
+			f.Rooms = append(f.Rooms, &booking.Room{
+				Ref: roomRef,
+			})
+			f.Reservations = append(f.Reservations, &booking.Reservation{
+				RoomRef: roomRef,
+				From:    utc.MustParse("2021-07-26T12:00:00Z"),
+				To:      utc.MustParse("2021-07-26T13:00:00Z"),
+				UserID:  usr.ID,
+			})
+		}
+	}
+	if _, err := LoadFixture(ctx, f); err != nil {
+		return err
+	}
+
+	// Create session
+	signedToken, err := auths.IssueToken(acc, 30*24*time.Hour)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign JWT")
+	}
+
+	fmt.Fprintln(w, "====================================")
+	fmt.Fprintln(w, "Seed data")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "JWT:", signedToken)
+	fmt.Fprintln(w, "Account:", addr.String())
+	fmt.Fprintln(w, "User:", usr.ID)
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Groups:")
+	fmt.Fprintln(w, "Coke:", coke.ID)
+	fmt.Fprintln(w, "Pepsi:", pepsi.ID)
+	fmt.Fprintln(w, "====================================")
+	return nil
+}
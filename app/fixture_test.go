@@ -0,0 +1,111 @@
+package app_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app"
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+)
+
+// TestFixture_RoundTrip ensures fixtures can be loaded several times, and that
+// exports can be loaded in another store
+func TestFixture_RoundTrip(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	addr, err := iam.ParseAddress("0x4dbB8110AF77963396E8b000C16E74B4c44eE72d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &app.Fixture{
+		Groups: []*iam.Group{{ID: "g1", Ref: "COKE"}},
+		Users: []*iam.User{
+			{ID: "u1", GroupID: "g1", Roles: []iam.Role{iam.RoleAdmin}},
+			{ID: "u2", GroupID: "g1"},
+		},
+		Accounts: []*iam.Account{{Address: addr, UserID: "u1"}},
+		Rooms: []*booking.Room{
+			{Ref: "C01", Name: "Boardroom"},
+			{Ref: "C02", Capacity: 4},
+		},
+		Reservations: []*booking.Reservation{
+			{
+				RoomRef: "C01",
+				From:    utc.MustParse("2021-08-01T12:00:00Z"),
+				To:      utc.MustParse("2021-08-01T13:00:00Z"),
+				UserID:  "u1",
+			},
+			{
+				RoomRef: "C02",
+				From:    utc.MustParse("2021-08-01T12:00:00Z"),
+				To:      utc.MustParse("2021-08-01T13:00:00Z"),
+				UserID:  "u2",
+				Seats:   2,
+			},
+		},
+	}
+
+	report, err := app.LoadFixture(ctx, f)
+	if err != nil {
+		t.Fatal("error loading fixture", err)
+	}
+	if report.Created != 8 || report.Skipped != 0 {
+		t.Errorf("expect 8 records created, but got %+v", report)
+	}
+	report, err = app.LoadFixture(ctx, f)
+	if err != nil {
+		t.Fatal("error loading fixture again", err)
+	}
+	if report.Created != 0 || report.Skipped != 8 {
+		t.Errorf("expect 8 records skipped, but got %+v", report)
+	}
+
+	exported, err := app.ExportFixture(ctx)
+	if err != nil {
+		t.Fatal("error exporting fixture", err)
+	}
+	if len(exported.Groups) != 1 || len(exported.Users) != 2 ||
+		len(exported.Accounts) != 1 || len(exported.Rooms) != 2 ||
+		len(exported.Reservations) != 2 {
+		t.Fatalf("expect all records to be exported, but got %+v", exported)
+	}
+
+	other, err := loadStorage(t.Name() + "_other")
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	if _, err := app.LoadFixture(other, exported); err != nil {
+		t.Fatal("error loading export", err)
+	}
+	reexported, err := app.ExportFixture(other)
+	if err != nil {
+		t.Fatal("error exporting fixture", err)
+	}
+	if !reflect.DeepEqual(exported.Users, reexported.Users) {
+		t.Errorf("expect users %v, but got %v", exported.Users, reexported.Users)
+	}
+	if !reflect.DeepEqual(exported.Rooms, reexported.Rooms) {
+		t.Errorf("expect rooms %v, but got %v", exported.Rooms, reexported.Rooms)
+	}
+	for i, r := range reexported.Reservations {
+		e := exported.Reservations[i]
+		if r.RoomRef != e.RoomRef || r.UserID != e.UserID || r.From != e.From ||
+			r.To != e.To || r.Seats != e.Seats {
+			t.Errorf("expect reservation %v, but got %v", e, r)
+		}
+	}
+}
+
+func loadStorage(name string) (context.Context, error) {
+	ctx := context.Background()
+	ctx = kvdb.WithContext(ctx, memory.New())
+	return ctx, nil
+}
@@ -2,13 +2,64 @@ package iam
 
 import (
 	"errors"
+	"time"
 
+	"github.com/deixis/pkg/utc"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 type User struct {
 	ID      string `json:"id"`
 	GroupID string `json:"groupId"`
+	Roles   []Role `json:"roles,omitempty"`
+	// Email is where notifications are sent. Users without email do not
+	// receive any notification.
+	Email         string                  `json:"email,omitempty"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+// NotificationPreferences lets users choose which emails they receive. The
+// zero value enables all notifications.
+type NotificationPreferences struct {
+	// MuteUpdates stops emails sent when reservations are created, cancelled
+	// or rescheduled
+	MuteUpdates bool `json:"muteUpdates,omitempty"`
+	// MuteReminders stops reminders sent before reservations start
+	MuteReminders bool `json:"muteReminders,omitempty"`
+	// ReminderMinutes is how long before a reservation starts the reminder is
+	// sent (15 minutes by default)
+	ReminderMinutes int `json:"reminderMinutes,omitempty"`
+}
+
+// defaultReminderMinutes is how long before a reservation starts reminders
+// are sent by default
+const defaultReminderMinutes = 15
+
+// ReminderLead returns how long before a reservation starts the reminder is
+// sent
+func (p *NotificationPreferences) ReminderLead() time.Duration {
+	if p.ReminderMinutes <= 0 {
+		return defaultReminderMinutes * time.Minute
+	}
+	return time.Duration(p.ReminderMinutes) * time.Minute
+}
+
+// HasRole returns whether the user has been granted role `r`
+func (u *User) HasRole(r Role) bool {
+	for _, role := range u.Roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Delegation grants user `DelegateID` the right to book and cancel on behalf
+// of user `OwnerID`
+type Delegation struct {
+	OwnerID    string  `json:"ownerId"`
+	DelegateID string  `json:"delegateId"`
+	CreatedAt  utc.UTC `json:"createdAt"`
 }
 
 type Account struct {
@@ -23,8 +74,22 @@ func (a *Account) ID() string {
 type Group struct {
 	ID  string `json:"id"`
 	Ref string `json:"ref"`
+	// Version is incremented on every change
+	Version uint64 `json:"version"`
+}
+
+// Role grants a user extra privileges
+type Role string
+
+func (r Role) String() string {
+	return string(r)
 }
 
+const (
+	// RoleAdmin grants access to administration endpoints
+	RoleAdmin Role = "admin"
+)
+
 type AccountProvider string
 
 func (p AccountProvider) String() string {
@@ -2,6 +2,8 @@ package iam
 
 import (
 	"context"
+
+	"github.com/basgys/booking-consensys/app/audit"
 )
 
 type contextKey struct{}
@@ -21,3 +23,19 @@ func FromContext(ctx context.Context) (*Account, bool) {
 func WithContext(ctx context.Context, a *Account) context.Context {
 	return context.WithValue(ctx, activeContextKey, a)
 }
+
+// Actor returns the audit actor associated with `ctx`. It is empty when no
+// account is attached to the context (e.g. system tasks).
+func Actor(ctx context.Context) audit.Actor {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return audit.Actor{}
+	}
+	a := audit.Actor{
+		UserID: acc.UserID,
+	}
+	if acc.Address != (Address{}) {
+		a.Address = acc.Address.String()
+	}
+	return a
+}
@@ -0,0 +1,112 @@
+package iam
+
+import (
+	"context"
+
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
+	"github.com/basgys/booking-consensys/pkg/pb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *Service) HandleGRPC(srv *grpcutil.Server) {
+	pb.RegisterIAMServiceServer(srv, &grpcHandler{
+		svc: s,
+	})
+}
+
+type grpcHandler struct {
+	pb.UnimplementedIAMServiceServer
+
+	svc *Service
+}
+
+func (h *grpcHandler) GetProfile(
+	ctx context.Context, req *pb.GetProfileRequest,
+) (*pb.User, error) {
+	u, err := h.svc.GetProfile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return userToPB(u), nil
+}
+
+func (h *grpcHandler) UpdateProfile(
+	ctx context.Context, req *pb.UpdateProfileRequest,
+) (*pb.User, error) {
+	prefs := NotificationPreferences{
+		MuteUpdates:     req.Notifications.GetMuteUpdates(),
+		MuteReminders:   req.Notifications.GetMuteReminders(),
+		ReminderMinutes: int(req.Notifications.GetReminderMinutes()),
+	}
+	u, err := h.svc.UpdateProfile(ctx, req.Email, prefs)
+	if err != nil {
+		return nil, err
+	}
+	return userToPB(u), nil
+}
+
+func (h *grpcHandler) ListDelegations(
+	ctx context.Context, req *pb.ListDelegationsRequest,
+) (*pb.ListDelegationsResponse, error) {
+	granted, received, err := h.svc.ListDelegations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListDelegationsResponse{
+		Granted:  delegationsToPB(granted),
+		Received: delegationsToPB(received),
+	}, nil
+}
+
+func (h *grpcHandler) GrantDelegation(
+	ctx context.Context, req *pb.GrantDelegationRequest,
+) (*pb.Delegation, error) {
+	d, err := h.svc.GrantDelegation(ctx, req.DelegateId)
+	if err != nil {
+		return nil, err
+	}
+	return delegationToPB(d), nil
+}
+
+func (h *grpcHandler) RevokeDelegation(
+	ctx context.Context, req *pb.RevokeDelegationRequest,
+) (*emptypb.Empty, error) {
+	if err := h.svc.RevokeDelegation(ctx, req.DelegateId); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func userToPB(u *User) *pb.User {
+	roles := make([]string, len(u.Roles))
+	for i, r := range u.Roles {
+		roles[i] = r.String()
+	}
+	return &pb.User{
+		Id:      u.ID,
+		GroupId: u.GroupID,
+		Roles:   roles,
+		Email:   u.Email,
+		Notifications: &pb.NotificationPreferences{
+			MuteUpdates:     u.Notifications.MuteUpdates,
+			MuteReminders:   u.Notifications.MuteReminders,
+			ReminderMinutes: int32(u.Notifications.ReminderMinutes),
+		},
+	}
+}
+
+func delegationToPB(d *Delegation) *pb.Delegation {
+	return &pb.Delegation{
+		OwnerId:    d.OwnerID,
+		DelegateId: d.DelegateID,
+		CreatedAt:  pb.Timestamp(d.CreatedAt),
+	}
+}
+
+func delegationsToPB(l []*Delegation) []*pb.Delegation {
+	delegations := make([]*pb.Delegation, len(l))
+	for i, d := range l {
+		delegations[i] = delegationToPB(d)
+	}
+	return delegations
+}
@@ -0,0 +1,206 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/basgys/booking-consensys/pkg/etag"
+	"github.com/deixis/errors"
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/pkg/httputil"
+	"github.com/deixis/spine/net/http"
+)
+
+func (s *Service) HandleHTTP(srv *http.Server) {
+	h := httpHandler{
+		svc: s,
+	}
+
+	srv.HandleFunc("/iam/users/import", http.POST, h.importUsers)
+	srv.HandleFunc("/iam/groups/{gid}", http.GET, h.getGroup)
+	srv.HandleFunc("/iam/groups/{gid}", http.PUT, h.updateGroup)
+	srv.HandleFunc("/iam/groups/{gid}", http.DELETE, h.deleteGroup)
+	srv.HandleFunc("/iam/me", http.GET, h.getProfile)
+	srv.HandleFunc("/iam/me", http.PUT, h.updateProfile)
+	srv.HandleFunc("/iam/me/delegations", http.GET, h.listDelegations)
+	srv.HandleFunc("/iam/me/delegations", http.POST, h.grantDelegation)
+	srv.HandleFunc("/iam/me/delegations/{uid}", http.DELETE, h.revokeDelegation)
+}
+
+type httpHandler struct {
+	svc *Service
+}
+
+func (h *httpHandler) importUsers(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	params := struct {
+		DryRun bool `qs:"dry_run"`
+	}{}
+	if err := httputil.ParseQuery(req.HTTP.URL.Query(), &params); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	report, err := h.svc.ImportUsers(ctx, req.HTTP.Body, params.DryRun)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	if !report.Valid() {
+		w.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+	w.JSON(http.StatusOK, report)
+}
+
+func (h *httpHandler) getGroup(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	if err := h.svc.RequireRole(ctx, RoleAdmin); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	g, err := h.svc.GetGroup(ctx, req.Params["gid"])
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Conditional(req.HTTP, etag.Version(g.Version), time.Time{}, func() error {
+		return w.JSON(http.StatusOK, g)
+	})
+}
+
+func (h *httpHandler) updateGroup(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	version, err := etag.Expect(req.HTTP.Header.Get("If-Match"), h.groupVersion(ctx, req.Params["gid"]))
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	r := struct {
+		Ref string `json:"ref"`
+	}{}
+	if err := json.NewDecoder(req.HTTP.Body).Decode(&r); err != nil {
+		httperrors.Marshal(req.HTTP, w, errors.WithBad(err))
+		return
+	}
+
+	g := &Group{ID: req.Params["gid"], Ref: r.Ref, Version: version}
+	if err := h.svc.UpdateGroup(ctx, g); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Header().Set("ETag", etag.Version(g.Version))
+	w.JSON(http.StatusOK, g)
+}
+
+func (h *httpHandler) deleteGroup(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	version, err := etag.Expect(req.HTTP.Header.Get("If-Match"), h.groupVersion(ctx, req.Params["gid"]))
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	if err := h.svc.DeleteGroup(ctx, req.Params["gid"], version); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Head(http.StatusNoContent)
+}
+
+// groupVersion returns a loader of the current version of group `id`, for
+// If-Match headers which list several versions
+func (h *httpHandler) groupVersion(ctx context.Context, id string) func() (uint64, error) {
+	return func() (uint64, error) {
+		g, err := h.svc.GetGroup(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		return g.Version, nil
+	}
+}
+
+func (h *httpHandler) getProfile(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	u, err := h.svc.GetProfile(ctx)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, u)
+}
+
+func (h *httpHandler) updateProfile(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	r := struct {
+		Email         string                  `json:"email"`
+		Notifications NotificationPreferences `json:"notifications"`
+	}{}
+	if err := json.NewDecoder(req.HTTP.Body).Decode(&r); err != nil {
+		httperrors.Marshal(req.HTTP, w, errors.WithBad(err))
+		return
+	}
+
+	u, err := h.svc.UpdateProfile(ctx, r.Email, r.Notifications)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, u)
+}
+
+func (h *httpHandler) listDelegations(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	granted, received, err := h.svc.ListDelegations(ctx)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Granted  []*Delegation `json:"granted"`
+		Received []*Delegation `json:"received"`
+	}{
+		Granted:  granted,
+		Received: received,
+	})
+}
+
+func (h *httpHandler) grantDelegation(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	r := struct {
+		DelegateID string `json:"delegateId"`
+	}{}
+	if err := json.NewDecoder(req.HTTP.Body).Decode(&r); err != nil {
+		httperrors.Marshal(req.HTTP, w, errors.WithBad(err))
+		return
+	}
+
+	d, err := h.svc.GrantDelegation(ctx, r.DelegateID)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusCreated, d)
+}
+
+func (h *httpHandler) revokeDelegation(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	if err := h.svc.RevokeDelegation(ctx, req.Params["uid"]); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Head(http.StatusNoContent)
+}
@@ -0,0 +1,278 @@
+package iam
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/basgys/booking-consensys/pkg/csvimport"
+	"github.com/deixis/errors"
+	"github.com/deixis/storage/kvdb"
+)
+
+// roles contains all roles that can be granted
+var roles = []Role{RoleAdmin}
+
+type importRow struct {
+	user    *User
+	group   *Group
+	account *Account
+}
+
+// ImportUsers creates or updates the users listed in CSV file `r`, along with
+// their group and account.
+//
+// The file must have a `user_id` column. The optional columns are `group_id`,
+// `group_ref`, `roles` (separated with `;`), `email` and `address` (Ethereum
+// account). Notification preferences are kept, as well as the email of users
+// when the `email` column is empty.
+// Rows are all validated before anything is written. When `dryRun` is set,
+// nothing is written at all. Importing the same file twice leaves records
+// unchanged.
+func ImportUsers(ctx context.Context, r io.Reader, dryRun bool) (*csvimport.Report, error) {
+	users, err := NewUserRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising user repository")
+	}
+	groups, err := NewGroupRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising group repository")
+	}
+	accounts, err := NewAccountRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising account repository")
+	}
+	i := &importer{users: users, groups: groups, accounts: accounts}
+	return i.importUsers(ctx, r, dryRun)
+}
+
+type importer struct {
+	users    *UserRepository
+	groups   *GroupRepository
+	accounts *AccountRepository
+}
+
+func (i *importer) importUsers(
+	ctx context.Context, r io.Reader, dryRun bool,
+) (*csvimport.Report, error) {
+	report := &csvimport.Report{DryRun: dryRun}
+	rows, err := csvimport.Read(r, report, "user_id")
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate all rows first
+	users := map[string]int{}
+	addresses := map[string]int{}
+	groupRefs := map[string]string{}
+	var parsed []*importRow
+	for _, row := range rows {
+		ir, ok := parseImportRow(report, row)
+		if !ok {
+			continue
+		}
+		if line, ok := users[ir.user.ID]; ok {
+			report.Fail(row.Line, "user_id", "Duplicate of line "+strconv.Itoa(line))
+			continue
+		}
+		users[ir.user.ID] = row.Line
+		if ir.account != nil {
+			addr := ir.account.Address.String()
+			if line, ok := addresses[addr]; ok {
+				report.Fail(row.Line, "address", "Duplicate of line "+strconv.Itoa(line))
+				continue
+			}
+			addresses[addr] = row.Line
+		}
+		if ir.group != nil && ir.group.Ref != "" {
+			if ref, ok := groupRefs[ir.group.ID]; ok && ref != ir.group.Ref {
+				report.Fail(row.Line, "group_ref", "Group "+ir.group.ID+" already has reference "+ref)
+				continue
+			}
+			groupRefs[ir.group.ID] = ir.group.Ref
+		}
+		parsed = append(parsed, ir)
+	}
+	if !report.Valid() || dryRun {
+		return report, nil
+	}
+
+	err = csvimport.Batches(len(parsed), func(start, end int) error {
+		_, err := kvdb.Transact(ctx,
+			func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+				for _, ir := range parsed[start:end] {
+					created, updated, err := i.importRow(ctx, ir)
+					if err != nil {
+						return nil, errors.Wrapf(err, "failed to import user %s", ir.user.ID)
+					}
+					switch {
+					case created:
+						report.Created++
+					case updated:
+						report.Updated++
+					default:
+						report.Unchanged++
+					}
+				}
+				return nil, nil
+			},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func parseImportRow(report *csvimport.Report, row *csvimport.Row) (*importRow, bool) {
+	ir := &importRow{
+		user: &User{ID: row.Get("user_id")},
+	}
+	valid := true
+	if ir.user.ID == "" {
+		report.Fail(row.Line, "user_id", "Missing user ID")
+		valid = false
+	}
+
+	groupID, groupRef := row.Get("group_id"), row.Get("group_ref")
+	switch {
+	case groupID != "":
+		ir.user.GroupID = groupID
+		ir.group = &Group{ID: groupID, Ref: groupRef}
+	case groupRef != "":
+		report.Fail(row.Line, "group_id", "A group reference requires a group ID")
+		valid = false
+	}
+
+	for _, s := range strings.Split(row.Get("roles"), ";") {
+		role := Role(strings.ToLower(strings.TrimSpace(s)))
+		if role == "" {
+			continue
+		}
+		if !knownRole(role) {
+			report.Fail(row.Line, "roles", "Unknown role "+role.String())
+			valid = false
+			continue
+		}
+		if !ir.user.HasRole(role) {
+			ir.user.Roles = append(ir.user.Roles, role)
+		}
+	}
+
+	email, err := parseEmail(row.Get("email"))
+	if err != nil {
+		report.Fail(row.Line, "email", "Invalid email address")
+		valid = false
+	}
+	ir.user.Email = email
+
+	if s := row.Get("address"); s != "" {
+		addr, err := ParseAddress(s)
+		if err != nil {
+			report.Fail(row.Line, "address", "Invalid Ethereum address")
+			valid = false
+		} else {
+			ir.account = &Account{Address: addr, UserID: ir.user.ID}
+		}
+	}
+	return ir, valid
+}
+
+// importRow upserts the group, the user and the account of `ir`
+func (i *importer) importRow(ctx context.Context, ir *importRow) (created, updated bool, err error) {
+	if ir.group != nil {
+		g, err := i.groups.Get(ctx, ir.group.ID)
+		switch {
+		case err == nil:
+			if ir.group.Ref != "" && g.Ref != ir.group.Ref {
+				g.Ref = ir.group.Ref
+				if err := i.groups.Update(ctx, g); err != nil {
+					return false, false, err
+				}
+				updated = true
+			}
+		case errors.IsNotFound(err):
+			if err := i.groups.Create(ctx, &Group{ID: ir.group.ID, Ref: ir.group.Ref}); err != nil {
+				return false, false, err
+			}
+			updated = true
+		default:
+			return false, false, err
+		}
+	}
+
+	u, err := i.users.Get(ctx, ir.user.ID)
+	switch {
+	case err == nil:
+		// Notification preferences are not part of the file
+		ir.user.Notifications = u.Notifications
+		if ir.user.Email == "" {
+			ir.user.Email = u.Email
+		}
+		if u.GroupID != ir.user.GroupID || !sameRoles(u.Roles, ir.user.Roles) ||
+			u.Email != ir.user.Email {
+			if err := i.users.Update(ctx, ir.user); err != nil {
+				return false, false, err
+			}
+			updated = true
+		}
+	case errors.IsNotFound(err):
+		if err := i.users.Create(ctx, ir.user); err != nil {
+			return false, false, err
+		}
+		created = true
+	default:
+		return false, false, err
+	}
+
+	if ir.account != nil {
+		acc, err := i.accounts.Get(ctx, ir.account.Address)
+		switch {
+		case err == nil:
+			if acc.UserID != ir.account.UserID {
+				if err := i.accounts.Update(ctx, ir.account); err != nil {
+					return false, false, err
+				}
+				updated = true
+			}
+		case errors.IsNotFound(err):
+			if err := i.accounts.Create(ctx, ir.account); err != nil {
+				return false, false, err
+			}
+			updated = true
+		default:
+			return false, false, err
+		}
+	}
+	return created, updated, nil
+}
+
+func knownRole(r Role) bool {
+	for _, role := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+func sameRoles(a, b []Role) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, r := range a {
+		found := false
+		for _, o := range b {
+			if r == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package iam_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app/iam"
+)
+
+// TestImportUsers ensures users are imported with their group and account,
+// and that importing a file twice is a no-op
+func TestImportUsers(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	invalid := "user_id,group_id,roles,address\nu1,g1,admin,0x123\nu2,,owner,\n"
+	report, err := iam.ImportUsers(ctx, strings.NewReader(invalid), false)
+	if err != nil {
+		t.Fatal("expect to import users, but got", err)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Field != "address" || report.Errors[1].Line != 3 {
+		t.Fatalf("expect an address error and a role error, but got %v", report.Errors)
+	}
+
+	valid := "user_id,group_id,group_ref,roles,address\n" +
+		"u1,g1,Coke,admin,0x35F659Ec81bb9A38ae576140107a6c5C8AE55900\n" +
+		"u2,g1,Coke,,\n"
+	for i, expect := range []int{2, 0} {
+		report, err = iam.ImportUsers(ctx, strings.NewReader(valid), false)
+		if err != nil {
+			t.Fatal("expect to import users, but got", err)
+		}
+		if report.Created != expect || report.Created+report.Unchanged != 2 {
+			t.Fatalf("import %d - expect %d users to be created, but got %+v", i, expect, report)
+		}
+	}
+
+	users, _ := iam.NewUserRepository(ctx)
+	u, err := users.Get(ctx, "u1")
+	if err != nil {
+		t.Fatal("expect to get an imported user, but got", err)
+	}
+	if u.GroupID != "g1" || !u.HasRole(iam.RoleAdmin) {
+		t.Errorf("expect user to be an admin of g1, but got %+v", u)
+	}
+	accounts, _ := iam.NewAccountRepository(ctx)
+	addr, _ := iam.ParseAddress("0x35F659Ec81bb9A38ae576140107a6c5C8AE55900")
+	acc, err := accounts.Get(ctx, addr)
+	if err != nil {
+		t.Fatal("expect to get an imported account, but got", err)
+	}
+	if acc.UserID != "u1" {
+		t.Errorf("expect account to belong to u1, but got %s", acc.UserID)
+	}
+	groups, _ := iam.NewGroupRepository(ctx)
+	if g, err := groups.Get(ctx, "g1"); err != nil || g.Ref != "Coke" {
+		t.Errorf("expect group g1 to be imported, but got %v (%v)", g, err)
+	}
+
+	// Importing again keeps the email and preferences set by users
+	svc, err := iam.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	u2 := iam.WithContext(ctx, &iam.Account{UserID: "u2"})
+	prefs := iam.NotificationPreferences{MuteReminders: true}
+	if _, err := svc.UpdateProfile(u2, "u2@example.com", prefs); err != nil {
+		t.Fatal("expect to update profile, but got", err)
+	}
+	report, err = iam.ImportUsers(ctx, strings.NewReader(valid), false)
+	if err != nil {
+		t.Fatal("expect to import users, but got", err)
+	}
+	if report.Unchanged != 2 {
+		t.Errorf("expect users to be unchanged, but got %+v", report)
+	}
+	u, err = users.Get(ctx, "u2")
+	if err != nil {
+		t.Fatal("expect to get an imported user, but got", err)
+	}
+	if u.Email != "u2@example.com" || u.Notifications != prefs {
+		t.Errorf("expect profile to be kept, but got %+v", u)
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"fmt"
 
+	"github.com/basgys/booking-consensys/app/audit"
 	"github.com/deixis/errors"
 	"github.com/deixis/storage/kvdb"
 )
 
 type GroupRepository struct {
-	ss kvdb.Subspace
+	ss    kvdb.Subspace
+	audit *audit.RecordRepository
 }
 
 func NewGroupRepository(ctx context.Context) (*GroupRepository, error) {
@@ -22,8 +25,13 @@ func NewGroupRepository(ctx context.Context) (*GroupRepository, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open iam/group dir")
 	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return &GroupRepository{
-		ss: dir,
+		ss:    dir,
+		audit: audits,
 	}, nil
 }
 
@@ -37,11 +45,6 @@ func (r *GroupRepository) Create(
 		})
 	}
 
-	var encoded bytes.Buffer
-	if err := gob.NewEncoder(&encoded).Encode(g); err != nil {
-		return errors.Wrap(err, "failed to marshal group")
-	}
-
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
 			key := r.ss.Pack([]kvdb.TupleElement{g.ID})
@@ -56,8 +59,13 @@ func (r *GroupRepository) Create(
 				})
 			}
 
+			g.Version = 1
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(g); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal group")
+			}
 			tx.Set(key, encoded.Bytes())
-			return nil, nil
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.group.create", "group:"+g.ID, nil, g)
 		},
 	)
 	return err
@@ -89,6 +97,38 @@ func (r *GroupRepository) Get(
 	return g, nil
 }
 
+// List returns all groups
+func (r *GroupRepository) List(ctx context.Context) ([]*Group, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{nil}),
+				End:   r.ss.Pack([]kvdb.TupleElement{kvdb.UUID{0xFF}}),
+			}
+			var l []*Group
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				g := &Group{}
+				if err := gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(g); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal group")
+				}
+				l = append(l, g)
+			}
+			return l, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Group), nil
+}
+
+// Update replaces group `g.ID`. Unless it is 0, `g.Version` must match the
+// stored version. On success, the version is incremented.
 func (r *GroupRepository) Update(
 	ctx context.Context, g *Group,
 ) error {
@@ -99,11 +139,6 @@ func (r *GroupRepository) Update(
 		})
 	}
 
-	var encoded bytes.Buffer
-	if err := gob.NewEncoder(&encoded).Encode(g); err != nil {
-		return errors.Wrap(err, "failed to marshal group")
-	}
-
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
 			key := r.ss.Pack([]kvdb.TupleElement{g.ID})
@@ -114,16 +149,30 @@ func (r *GroupRepository) Update(
 			if len(data) == 0 {
 				return nil, errors.NotFound
 			}
+			before := &Group{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(before); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal group")
+			}
+			if err := checkVersion("group:"+g.ID, g.Version, before.Version); err != nil {
+				return nil, err
+			}
 
+			g.Version = before.Version + 1
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(g); err != nil {
+				return nil, errors.Wrap(err, "failed to marshal group")
+			}
 			tx.Set(key, encoded.Bytes())
-			return nil, nil
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.group.update", "group:"+g.ID, before, g)
 		},
 	)
 	return err
 }
 
+// Delete removes group `id`. Unless it is 0, `version` must match the stored
+// version.
 func (r *GroupRepository) Delete(
-	ctx context.Context, id string,
+	ctx context.Context, id string, version uint64,
 ) error {
 	if id == "" {
 		return errors.Bad(&errors.FieldViolation{
@@ -133,15 +182,45 @@ func (r *GroupRepository) Delete(
 	}
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
-			tx.Clear(r.ss.Pack([]kvdb.TupleElement{id}))
-			return nil, nil
+			key := r.ss.Pack([]kvdb.TupleElement{id})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, nil // Nothing to delete
+			}
+			before := &Group{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(before); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal group")
+			}
+			if err := checkVersion("group:"+id, version, before.Version); err != nil {
+				return nil, err
+			}
+
+			tx.Clear(key)
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.group.delete", "group:"+id, before, nil)
 		},
 	)
 	return err
 }
 
+// checkVersion returns a FailedPrecondition error when `expected` is set and
+// does not match `actual`
+func checkVersion(resource string, expected, actual uint64) error {
+	if expected == 0 || expected == actual {
+		return nil
+	}
+	return errors.FailedPrecondition(&errors.PreconditionViolation{
+		Type:        "VERSION",
+		Subject:     resource,
+		Description: fmt.Sprintf("Expected version %d, but it is at version %d", expected, actual),
+	})
+}
+
 type UserRepository struct {
-	ss kvdb.Subspace
+	ss    kvdb.Subspace
+	audit *audit.RecordRepository
 }
 
 func NewUserRepository(ctx context.Context) (*UserRepository, error) {
@@ -153,8 +232,13 @@ func NewUserRepository(ctx context.Context) (*UserRepository, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open iam/user dir")
 	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return &UserRepository{
-		ss: dir,
+		ss:    dir,
+		audit: audits,
 	}, nil
 }
 
@@ -188,7 +272,7 @@ func (r *UserRepository) Create(
 			}
 
 			tx.Set(key, encoded.Bytes())
-			return nil, nil
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.user.create", "user:"+u.ID, nil, u)
 		},
 	)
 	return err
@@ -220,6 +304,36 @@ func (r *UserRepository) Get(
 	return u, nil
 }
 
+// List returns all users
+func (r *UserRepository) List(ctx context.Context) ([]*User, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{nil}),
+				End:   r.ss.Pack([]kvdb.TupleElement{kvdb.UUID{0xFF}}),
+			}
+			var l []*User
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				u := &User{}
+				if err := gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(u); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal user")
+				}
+				l = append(l, u)
+			}
+			return l, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*User), nil
+}
+
 func (r *UserRepository) Update(
 	ctx context.Context, u *User,
 ) error {
@@ -245,9 +359,13 @@ func (r *UserRepository) Update(
 			if len(data) == 0 {
 				return nil, errors.NotFound
 			}
+			before := &User{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(before); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal user")
+			}
 
 			tx.Set(key, encoded.Bytes())
-			return nil, nil
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.user.update", "user:"+u.ID, before, u)
 		},
 	)
 	return err
@@ -264,15 +382,29 @@ func (r *UserRepository) Delete(
 	}
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
-			tx.Clear(r.ss.Pack([]kvdb.TupleElement{id}))
-			return nil, nil
+			key := r.ss.Pack([]kvdb.TupleElement{id})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, nil // Nothing to delete
+			}
+			before := &User{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(before); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal user")
+			}
+
+			tx.Clear(key)
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.user.delete", "user:"+id, before, nil)
 		},
 	)
 	return err
 }
 
 type AccountRepository struct {
-	ss kvdb.Subspace
+	ss    kvdb.Subspace
+	audit *audit.RecordRepository
 }
 
 func NewAccountRepository(ctx context.Context) (*AccountRepository, error) {
@@ -284,8 +416,13 @@ func NewAccountRepository(ctx context.Context) (*AccountRepository, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open iam/account dir")
 	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return &AccountRepository{
-		ss: dir,
+		ss:    dir,
+		audit: audits,
 	}, nil
 }
 
@@ -320,7 +457,7 @@ func (r *AccountRepository) Create(
 			}
 
 			tx.Set(key, encoded.Bytes())
-			return nil, nil
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.account.create", "account:"+id, nil, a)
 		},
 	)
 	return err
@@ -353,6 +490,36 @@ func (r *AccountRepository) Get(
 	return u, nil
 }
 
+// List returns all accounts
+func (r *AccountRepository) List(ctx context.Context) ([]*Account, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{nil}),
+				End:   r.ss.Pack([]kvdb.TupleElement{kvdb.UUID{0xFF}}),
+			}
+			var l []*Account
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				a := &Account{}
+				if err := gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(a); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal account")
+				}
+				l = append(l, a)
+			}
+			return l, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Account), nil
+}
+
 func (r *AccountRepository) Update(
 	ctx context.Context, a *Account,
 ) error {
@@ -379,9 +546,13 @@ func (r *AccountRepository) Update(
 			if len(data) == 0 {
 				return nil, errors.NotFound
 			}
+			before := &Account{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(before); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal account")
+			}
 
 			tx.Set(key, encoded.Bytes())
-			return nil, nil
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.account.update", "account:"+id, before, a)
 		},
 	)
 	return err
@@ -399,9 +570,191 @@ func (r *AccountRepository) Delete(
 	}
 	_, err := kvdb.Transact(ctx,
 		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
-			tx.Clear(r.ss.Pack([]kvdb.TupleElement{id}))
-			return nil, nil
+			key := r.ss.Pack([]kvdb.TupleElement{id})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, nil // Nothing to delete
+			}
+			before := &Account{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(before); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal account")
+			}
+
+			tx.Clear(key)
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.account.delete", "account:"+id, before, nil)
+		},
+	)
+	return err
+}
+
+// DelegationRepository stores delegations, indexed both by owner and by
+// delegate
+type DelegationRepository struct {
+	owners    kvdb.Subspace
+	delegates kvdb.Subspace
+	audit     *audit.RecordRepository
+}
+
+func NewDelegationRepository(ctx context.Context) (*DelegationRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	owners, err := store.CreateOrOpenDir([]string{"iam", "delegation"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open iam/delegation dir")
+	}
+	delegates, err := store.CreateOrOpenDir([]string{"iam", "delegation-delegate"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open iam/delegation-delegate dir")
+	}
+	audits, err := audit.NewRecordRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DelegationRepository{
+		owners:    owners,
+		delegates: delegates,
+		audit:     audits,
+	}, nil
+}
+
+func (r *DelegationRepository) Create(
+	ctx context.Context, d *Delegation,
+) error {
+	if d.OwnerID == "" || d.DelegateID == "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "delegateId",
+			Description: "Missing user ID",
+		})
+	}
+	if d.OwnerID == d.DelegateID {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "delegateId",
+			Description: "Users cannot delegate to themselves",
+		})
+	}
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(d); err != nil {
+		return errors.Wrap(err, "failed to marshal delegation")
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			key := r.owners.Pack([]kvdb.TupleElement{d.OwnerID, d.DelegateID})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > 0 {
+				return nil, errors.Aborted(&errors.ConflictViolation{
+					Resource:    "delegation:" + d.OwnerID + ":" + d.DelegateID,
+					Description: "Delegation has already been granted",
+				})
+			}
+
+			tx.Set(key, encoded.Bytes())
+			tx.Set(r.delegates.Pack([]kvdb.TupleElement{d.DelegateID, d.OwnerID}), encoded.Bytes())
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.delegation.create",
+				"user:"+d.OwnerID, nil, d,
+			)
 		},
 	)
 	return err
 }
+
+// Get returns the delegation of user `ownerID` to user `delegateID`
+func (r *DelegationRepository) Get(
+	ctx context.Context, ownerID, delegateID string,
+) (*Delegation, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.owners.Pack([]kvdb.TupleElement{ownerID, delegateID})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			return data, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Delegation{}
+	if err := gob.NewDecoder(bytes.NewReader(v.([]byte))).Decode(d); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal delegation")
+	}
+	return d, nil
+}
+
+// ByOwner returns the delegations granted by user `ownerID`
+func (r *DelegationRepository) ByOwner(
+	ctx context.Context, ownerID string,
+) ([]*Delegation, error) {
+	return r.list(ctx, r.owners, ownerID)
+}
+
+// ByDelegate returns the delegations granted to user `delegateID`
+func (r *DelegationRepository) ByDelegate(
+	ctx context.Context, delegateID string,
+) ([]*Delegation, error) {
+	return r.list(ctx, r.delegates, delegateID)
+}
+
+func (r *DelegationRepository) Delete(
+	ctx context.Context, ownerID, delegateID string,
+) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			before, err := r.Get(ctx, ownerID, delegateID)
+			if err != nil {
+				return nil, err
+			}
+
+			tx.Clear(r.owners.Pack([]kvdb.TupleElement{ownerID, delegateID}))
+			tx.Clear(r.delegates.Pack([]kvdb.TupleElement{delegateID, ownerID}))
+			return nil, r.audit.Log(ctx, Actor(ctx), "iam.delegation.delete",
+				"user:"+ownerID, before, nil,
+			)
+		},
+	)
+	return err
+}
+
+func (r *DelegationRepository) list(
+	ctx context.Context, ss kvdb.Subspace, userID string,
+) ([]*Delegation, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: ss.Pack([]kvdb.TupleElement{userID, nil}),
+				End:   ss.Pack([]kvdb.TupleElement{userID, kvdb.UUID{0xFF}}),
+			}
+			var l []*Delegation
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				d := &Delegation{}
+				if err := gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(d); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal delegation")
+				}
+				l = append(l, d)
+			}
+			return l, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Delegation), nil
+}
@@ -2,32 +2,14 @@ package iam_test
 
 import (
 	"context"
-	"os"
-	"path"
 	"testing"
 
 	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/errors"
 	"github.com/deixis/storage/kvdb"
-	"github.com/deixis/storage/kvdb/driver/badger"
 )
 
-const (
-	storageFolder = ".storage"
-)
-
-func TestMain(m *testing.M) {
-	var code int
-	func() {
-		// Prepare storage folder
-		os.Mkdir(storageFolder, 0770)
-		defer os.RemoveAll(storageFolder)
-
-		code = m.Run()
-	}()
-
-	os.Exit(code)
-}
-
 // TestGroup_CRUD calls each CRUD operation to make sure nothing returns
 // an error.
 //
@@ -57,7 +39,55 @@ func TestGroup_CRUD(t *testing.T) {
 	if err != nil {
 		t.Fatal("error loading group", err)
 	}
-	if err := groups.Delete(ctx, g.ID); err != nil {
+	if err := groups.Delete(ctx, g.ID, g.Version); err != nil {
+		t.Fatal("error deleting group", err)
+	}
+}
+
+// TestGroup_Version ensures a stale update or delete does not overwrite a
+// newer version
+func TestGroup_Version(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	groups, err := iam.NewGroupRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	g := &iam.Group{ID: "coke-123", Ref: "Coke"}
+	if err := groups.Create(ctx, g); err != nil {
+		t.Fatal("error creating group", err)
+	}
+	first := *g
+	second := *g
+
+	first.Ref = "Coca-Cola"
+	if err := groups.Update(ctx, &first); err != nil {
+		t.Fatal("error updating group", err)
+	}
+	if first.Version != 2 {
+		t.Errorf("expect version 2, but got %d", first.Version)
+	}
+	second.Ref = "Coke Zero"
+	if err := groups.Update(ctx, &second); !errors.IsFailedPrecondition(err) {
+		t.Fatal("expect stale update to fail, but got", err)
+	}
+
+	g, err = groups.Get(ctx, g.ID)
+	if err != nil {
+		t.Fatal("error loading group", err)
+	}
+	if g.Ref != first.Ref {
+		t.Errorf("expect group ref %s, but got %s", first.Ref, g.Ref)
+	}
+
+	if err := groups.Delete(ctx, g.ID, 1); !errors.IsFailedPrecondition(err) {
+		t.Fatal("expect stale delete to fail, but got", err)
+	}
+	if err := groups.Delete(ctx, g.ID, g.Version); err != nil {
 		t.Fatal("error deleting group", err)
 	}
 }
@@ -133,11 +163,7 @@ func TestAccount_CRUD(t *testing.T) {
 }
 
 func loadStorage(name string) (context.Context, error) {
-	store, err := badger.Open(path.Join(storageFolder, name))
-	if err != nil {
-		return nil, err
-	}
 	ctx := context.Background()
-	ctx = kvdb.WithContext(ctx, store)
+	ctx = kvdb.WithContext(ctx, memory.New())
 	return ctx, nil
 }
@@ -2,13 +2,21 @@ package iam
 
 import (
 	"context"
+	"io"
+	"net/mail"
+	"strings"
 
+	"github.com/basgys/booking-consensys/pkg/csvimport"
 	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
 )
 
 type Service struct {
-	users  *UserRepository
-	groups *GroupRepository
+	users       *UserRepository
+	groups      *GroupRepository
+	accounts    *AccountRepository
+	delegations *DelegationRepository
 }
 
 func New(ctx context.Context) (*Service, error) {
@@ -20,9 +28,308 @@ func New(ctx context.Context) (*Service, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "error initialising group repository")
 	}
+	accounts, err := NewAccountRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising account repository")
+	}
+	delegations, err := NewDelegationRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initialising delegation repository")
+	}
 
 	return &Service{
-		users:  users,
-		groups: groups,
+		users:       users,
+		groups:      groups,
+		accounts:    accounts,
+		delegations: delegations,
 	}, nil
 }
+
+// GetUser returns user `id`
+func (s *Service) GetUser(ctx context.Context, id string) (*User, error) {
+	return s.users.Get(ctx, id)
+}
+
+// GetGroup returns group `id`
+func (s *Service) GetGroup(ctx context.Context, id string) (*Group, error) {
+	return s.groups.Get(ctx, id)
+}
+
+// UpdateGroup renames group `g.ID` (admin only). Unless it is 0, `g.Version`
+// must match the stored version.
+func (s *Service) UpdateGroup(ctx context.Context, g *Group) error {
+	if err := s.RequireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if strings.TrimSpace(g.Ref) == "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "ref",
+			Description: "Missing group reference",
+		})
+	}
+	return s.groups.Update(ctx, g)
+}
+
+// DeleteGroup deletes group `id` (admin only). Unless it is 0, `version` must
+// match the stored version.
+func (s *Service) DeleteGroup(ctx context.Context, id string, version uint64) error {
+	if err := s.RequireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	return s.groups.Delete(ctx, id, version)
+}
+
+// GetProfile returns the user of the current account
+func (s *Service) GetProfile(ctx context.Context) (*User, error) {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return nil, errors.PermissionDenied
+	}
+	return s.users.Get(ctx, acc.UserID)
+}
+
+// UpdateProfile sets the email and notification preferences of the user of
+// the current account. An empty `email` stops all notifications.
+func (s *Service) UpdateProfile(
+	ctx context.Context, email string, prefs NotificationPreferences,
+) (*User, error) {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return nil, errors.PermissionDenied
+	}
+	email, err := parseEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if prefs.ReminderMinutes < 0 {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "notifications.reminderMinutes",
+			Description: "The reminder delay cannot be negative",
+		})
+	}
+
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			u, err := s.users.Get(ctx, acc.UserID)
+			if err != nil {
+				return nil, err
+			}
+			u.Email = email
+			u.Notifications = prefs
+			if err := s.users.Update(ctx, u); err != nil {
+				return nil, err
+			}
+			return u, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*User), nil
+}
+
+// parseEmail validates and normalises email address `s`
+func parseEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Name != "" {
+		return "", errors.Bad(&errors.FieldViolation{
+			Field:       "email",
+			Description: "Invalid email address",
+		})
+	}
+	return addr.Address, nil
+}
+
+// RequireRole ensures the account attached to `ctx` belongs to a user that
+// has been granted role `r`.
+func (s *Service) RequireRole(ctx context.Context, r Role) error {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return errors.PermissionDenied
+	}
+	usr, err := s.users.Get(ctx, acc.UserID)
+	switch {
+	case err == nil:
+		// Good
+	case errors.IsNotFound(err):
+		return errors.PermissionDenied
+	default:
+		return err
+	}
+	if !usr.HasRole(r) {
+		return errors.PermissionDenied
+	}
+	return nil
+}
+
+// ImportUsers creates or updates the users listed in CSV file `r`. It is
+// restricted to admins. See ImportUsers for the file format.
+func (s *Service) ImportUsers(
+	ctx context.Context, r io.Reader, dryRun bool,
+) (*csvimport.Report, error) {
+	if err := s.RequireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	i := &importer{users: s.users, groups: s.groups, accounts: s.accounts}
+	return i.importUsers(ctx, r, dryRun)
+}
+
+// CreateUser creates user `u` and, unless `addr` is nil, an account for
+// Ethereum address `addr`. The group of the user must exist.
+//
+// It does not check permissions, and is meant for operational tools which
+// have direct access to the store.
+func CreateUser(ctx context.Context, u *User, addr *Address) error {
+	users, err := NewUserRepository(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error initialising user repository")
+	}
+	groups, err := NewGroupRepository(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error initialising group repository")
+	}
+	accounts, err := NewAccountRepository(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error initialising account repository")
+	}
+
+	var violations []*errors.FieldViolation
+	if u.ID == "" {
+		violations = append(violations, &errors.FieldViolation{
+			Field:       "id",
+			Description: "Missing user ID",
+		})
+	}
+	for _, r := range u.Roles {
+		if !knownRole(r) {
+			violations = append(violations, &errors.FieldViolation{
+				Field:       "roles",
+				Description: "Unknown role " + r.String(),
+			})
+		}
+	}
+	email, err := parseEmail(u.Email)
+	if err != nil {
+		violations = append(violations, &errors.FieldViolation{
+			Field:       "email",
+			Description: "Invalid email address",
+		})
+	}
+	if len(violations) > 0 {
+		return errors.Bad(violations...)
+	}
+	u.Email = email
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			if u.GroupID != "" {
+				_, err := groups.Get(ctx, u.GroupID)
+				switch {
+				case err == nil:
+				case errors.IsNotFound(err):
+					return nil, errors.Bad(&errors.FieldViolation{
+						Field:       "groupId",
+						Description: "Group does not exist",
+					})
+				default:
+					return nil, err
+				}
+			}
+			if err := users.Create(ctx, u); err != nil {
+				return nil, err
+			}
+			if addr != nil {
+				return nil, accounts.Create(ctx, &Account{Address: *addr, UserID: u.ID})
+			}
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// RequireDelegate ensures the account attached to `ctx` belongs to user
+// `ownerID`, or to a user `ownerID` delegated to.
+func (s *Service) RequireDelegate(ctx context.Context, ownerID string) error {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return errors.PermissionDenied
+	}
+	if acc.UserID == ownerID {
+		return nil
+	}
+	_, err := s.delegations.Get(ctx, ownerID, acc.UserID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.IsNotFound(err):
+		return errors.PermissionDenied
+	default:
+		return err
+	}
+}
+
+// GrantDelegation allows user `delegateID` to book and cancel on behalf of
+// the current user
+func (s *Service) GrantDelegation(
+	ctx context.Context, delegateID string,
+) (*Delegation, error) {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return nil, errors.PermissionDenied
+	}
+	_, err := s.users.Get(ctx, delegateID)
+	switch {
+	case err == nil:
+	case errors.IsNotFound(err):
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "delegateId",
+			Description: "User does not exist",
+		})
+	default:
+		return nil, err
+	}
+
+	d := &Delegation{
+		OwnerID:    acc.UserID,
+		DelegateID: delegateID,
+		CreatedAt:  utc.Now(),
+	}
+	if err := s.delegations.Create(ctx, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RevokeDelegation withdraws the delegation of the current user to user
+// `delegateID`
+func (s *Service) RevokeDelegation(ctx context.Context, delegateID string) error {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return errors.PermissionDenied
+	}
+	return s.delegations.Delete(ctx, acc.UserID, delegateID)
+}
+
+// ListDelegations returns the delegations granted by the current user, and
+// the ones granted to them
+func (s *Service) ListDelegations(
+	ctx context.Context,
+) (granted, received []*Delegation, err error) {
+	acc, ok := FromContext(ctx)
+	if !ok {
+		return nil, nil, errors.PermissionDenied
+	}
+	granted, err = s.delegations.ByOwner(ctx, acc.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	received, err = s.delegations.ByDelegate(ctx, acc.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return granted, received, nil
+}
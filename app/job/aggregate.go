@@ -0,0 +1,86 @@
+package job
+
+import (
+	"time"
+
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/schedule"
+)
+
+// Job is a task persisted until it has been executed
+type Job struct {
+	ID string
+	// Target is the name of the handler executing the job
+	Target string
+	Data   []byte
+	// Due is when the job is bound to be executed. While a node is running
+	// the job, it is when its lease expires.
+	Due utc.UTC
+	// Attempt is the number of the next execution (starting at 1)
+	Attempt uint32
+	// Interval is set on recurring jobs, which are scheduled again `Interval`
+	// after every execution
+	Interval time.Duration
+	// Created is when the job was scheduled. Its age is measured from then.
+	Created utc.UTC
+	Options Options
+	// Owner is the node running the job (if any)
+	Owner     string
+	LastError string
+}
+
+// Options are the retry rules of a job (see schedule.JobOptions)
+type Options struct {
+	RetryLimit uint32
+	MinBackOff time.Duration
+	MaxBackOff time.Duration
+	// AgeLimit is how long a failed job is retried (0 for no limit)
+	AgeLimit time.Duration
+	// Consistency is schedule.AtMostOnce by default (zero value)
+	Consistency schedule.Consistency
+}
+
+// Leased returns whether a node is running the job
+func (j *Job) Leased() bool {
+	return j.Owner != ""
+}
+
+// Recurring returns whether the job is scheduled again after every execution
+func (j *Job) Recurring() bool {
+	return j.Interval > 0
+}
+
+// backoff returns the delay to apply before the next attempt
+func (j *Job) backoff() time.Duration {
+	delay := j.Options.MinBackOff
+	for i := uint32(1); i < j.Attempt; i++ {
+		delay *= 2
+		if delay >= j.Options.MaxBackOff {
+			return j.Options.MaxBackOff
+		}
+	}
+	return delay
+}
+
+// expired returns whether the job cannot be retried anymore at `next`
+func (j *Job) expired(next utc.UTC) bool {
+	if j.Options.RetryLimit > 0 && j.Attempt >= j.Options.RetryLimit {
+		return true
+	}
+	return j.Options.AgeLimit > 0 && next.Distance(j.Created) > j.Options.AgeLimit
+}
+
+// buildOptions applies `o` on the default options of spine schedulers
+func buildOptions(o ...schedule.JobOption) Options {
+	sj := schedule.BuildJob(o...)
+	opts := Options{
+		RetryLimit:  sj.Options.RetryLimit,
+		MinBackOff:  sj.Options.MinBackOff,
+		MaxBackOff:  sj.Options.MaxBackOff,
+		Consistency: sj.Options.Consistency,
+	}
+	if sj.Options.AgeLimit != nil {
+		opts.AgeLimit = *sj.Options.AgeLimit
+	}
+	return opts
+}
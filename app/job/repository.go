@@ -0,0 +1,227 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/schedule"
+	"github.com/deixis/storage/kvdb"
+)
+
+var firstKey kvdb.TupleElement
+
+// JobRepository persists jobs until they have been executed.
+//
+// Jobs are keyed by due time, so schedulers can scan what needs to run with a
+// single range read, and indexed by ID. A node running a job holds a lease on
+// it, which moves the job to when the lease expires. When a node dies while
+// running a job, the job is due again once its lease expired.
+type JobRepository struct {
+	queue kvdb.Subspace
+	index kvdb.Subspace
+}
+
+func NewJobRepository(ctx context.Context) (*JobRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	queue, err := store.CreateOrOpenDir([]string{"job", "queue"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open job/queue dir")
+	}
+	index, err := store.CreateOrOpenDir([]string{"job", "index"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open job/index dir")
+	}
+	return &JobRepository{
+		queue: queue,
+		index: index,
+	}, nil
+}
+
+// Put creates or replaces job `j`
+func (r *JobRepository) Put(ctx context.Context, j *Job) error {
+	if j.ID == "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "id",
+			Description: "Missing job ID",
+		})
+	}
+	data, err := encode(j)
+	if err != nil {
+		return err
+	}
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			if err := r.Delete(ctx, j.ID); err != nil {
+				return nil, err
+			}
+			tx.Set(r.queueKey(j), data)
+			tx.Set(r.index.Pack([]kvdb.TupleElement{j.ID}), data)
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Get returns job `id`
+func (r *JobRepository) Get(ctx context.Context, id string) (*Job, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.index.Pack([]kvdb.TupleElement{id})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			return data, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return decode(v.([]byte))
+}
+
+// Delete removes job `id` (if any)
+func (r *JobRepository) Delete(ctx context.Context, id string) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			j, err := r.Get(ctx, id)
+			switch {
+			case err == nil:
+				// Good
+			case errors.IsNotFound(err):
+				return nil, nil
+			default:
+				return nil, err
+			}
+			tx.Clear(r.queueKey(j))
+			tx.Clear(r.index.Pack([]kvdb.TupleElement{id}))
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Due returns at most `limit` jobs that are due before `now`, after job
+// `after` in the queue (or from the start when nil)
+func (r *JobRepository) Due(
+	ctx context.Context, now utc.UTC, after *Job, limit int,
+) (jobs []*Job, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.queue.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.queue.Pack([]kvdb.TupleElement{int64(now)}),
+			}
+			if after != nil {
+				rng.Begin = append(r.queueKey(after), 0x00)
+			}
+			iter := tx.GetRange(rng, kvdb.WithRangeLimit(limit)).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				j, err := decode(kv.Value)
+				if err != nil {
+					return nil, err
+				}
+				jobs = append(jobs, j)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Claim gives node `owner` a lease on job `j` until `until`. It returns the
+// leased job, or NotFound when the job has been claimed, changed or deleted
+// since it was loaded.
+//
+// Jobs executed at most once are removed (or scheduled again when they are
+// recurring) instead, so they are never executed twice, even if the node dies.
+func (r *JobRepository) Claim(
+	ctx context.Context, j *Job, owner string, until utc.UTC,
+) (*Job, error) {
+	v, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			current, err := r.Get(ctx, j.ID)
+			if err != nil {
+				return nil, err
+			}
+			if current.Due != j.Due || current.Owner != j.Owner {
+				return nil, errors.NotFound
+			}
+
+			leased := *current
+			leased.Owner = owner
+			leased.Due = until
+			if current.Options.Consistency == schedule.AtMostOnce {
+				if current.Recurring() {
+					next := *current
+					next.Due = utc.Now().Add(current.Interval)
+					return &leased, r.Put(ctx, &next)
+				}
+				return &leased, r.Delete(ctx, current.ID)
+			}
+			return &leased, r.Put(ctx, &leased)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Job), nil
+}
+
+// Release ends the lease on job `leased` and replaces it with `next`, or
+// removes it when `next` is nil. It returns NotFound when the lease expired
+// and the job has been claimed, changed or deleted since.
+func (r *JobRepository) Release(ctx context.Context, leased, next *Job) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			current, err := r.Get(ctx, leased.ID)
+			if err != nil {
+				return nil, err
+			}
+			if current.Due != leased.Due || current.Owner != leased.Owner {
+				return nil, errors.NotFound
+			}
+			if next == nil {
+				return nil, r.Delete(ctx, leased.ID)
+			}
+			return nil, r.Put(ctx, next)
+		},
+	)
+	return err
+}
+
+func (r *JobRepository) queueKey(j *Job) kvdb.Key {
+	return r.queue.Pack([]kvdb.TupleElement{int64(j.Due), j.ID})
+}
+
+func encode(j *Job) ([]byte, error) {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(j); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal job")
+	}
+	return encoded.Bytes(), nil
+}
+
+func decode(data []byte) (*Job, error) {
+	j := &Job{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(j); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal job")
+	}
+	return j, nil
+}
@@ -0,0 +1,395 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+	"github.com/deixis/spine/schedule"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// pollInterval defines how often the queue is scanned for due jobs
+	pollInterval = 5 * time.Second
+	// batchSize is the maximum number of jobs loaded per scan
+	batchSize = 50
+	// defaultLeaseTimeout is how long a node can run a job by default
+	defaultLeaseTimeout = 5 * time.Minute
+	// leaseMargin is the share of a lease (1/leaseMargin) kept for a job to
+	// return and release its lease once its context has been cancelled
+	leaseMargin = 5
+)
+
+// Scheduler is a schedule.Scheduler which persists jobs on the KV store, so
+// they survive restarts and can be shared by several nodes. Jobs are executed
+// at least once (unless they are scheduled with schedule.AtMostOnce) and only
+// one node runs a given job at a time.
+//
+// A node leases the jobs it runs for `LeaseTimeout`. The context of a job is
+// cancelled before its lease expires, so jobs must return when it is done and
+// resume on their next run. When the node dies, another node runs the job
+// again once the lease expired.
+type Scheduler struct {
+	// Node identifies this node on job leases
+	Node string
+	// LeaseTimeout is how long a node can run a job before other nodes run it
+	// again
+	LeaseTimeout time.Duration
+
+	ctx  context.Context
+	jobs *JobRepository
+
+	mu       sync.RWMutex
+	handlers map[string]schedule.Fn
+	started  bool
+	drained  bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewScheduler(ctx context.Context) (*Scheduler, error) {
+	jobs, err := NewJobRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise job repository")
+	}
+	host, _ := os.Hostname()
+
+	return &Scheduler{
+		Node:         fmt.Sprintf("%s-%s", host, ksuid.New().String()),
+		LeaseTimeout: defaultLeaseTimeout,
+		ctx:          ctx,
+		jobs:         jobs,
+		handlers:     map[string]schedule.Fn{},
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// Start implements schedule.Scheduler. Jobs are executed in the background
+// with `ctx` until the scheduler is drained.
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.drained {
+		return nil
+	}
+	s.started = true
+	s.ctx = ctx
+
+	go s.run()
+	return nil
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Dispatch(s.ctx); err != nil {
+			log.Warn(s.ctx, "job.dispatch.err", "Failed to dispatch jobs",
+				log.Error(err),
+			)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HandleFunc implements schedule.Scheduler
+func (s *Scheduler) HandleFunc(
+	target string, fn schedule.Fn,
+) (deregister func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.handlers[target]; ok {
+		return nil, errors.Errorf("duplicate registration for target %s", target)
+	}
+	s.handlers[target] = fn
+	return func() {
+		s.mu.Lock()
+		delete(s.handlers, target)
+		s.mu.Unlock()
+	}, nil
+}
+
+// At implements schedule.Scheduler
+func (s *Scheduler) At(
+	ctx context.Context,
+	t time.Time,
+	target string,
+	data []byte,
+	o ...schedule.JobOption,
+) (string, error) {
+	if target == "" {
+		return "", errors.Bad(&errors.FieldViolation{
+			Field:       "target",
+			Description: "Missing job target",
+		})
+	}
+
+	j := &Job{
+		ID:      ksuid.New().String(),
+		Target:  target,
+		Data:    data,
+		Due:     utc.Convert(t),
+		Attempt: 1,
+		Created: utc.Now(),
+		Options: buildOptions(o...),
+	}
+	if err := s.jobs.Put(ctx, j); err != nil {
+		return "", err
+	}
+	return j.ID, nil
+}
+
+// In implements schedule.Scheduler
+func (s *Scheduler) In(
+	ctx context.Context,
+	d time.Duration,
+	target string,
+	data []byte,
+	o ...schedule.JobOption,
+) (string, error) {
+	return s.At(ctx, time.Now().Add(d), target, data, o...)
+}
+
+// Every schedules target `target` every `interval`, starting now. There is
+// only one recurring job per target across all nodes, so it can be called
+// every time a node starts. The job ID is the target.
+func (s *Scheduler) Every(
+	ctx context.Context,
+	interval time.Duration,
+	target string,
+	data []byte,
+	o ...schedule.JobOption,
+) (string, error) {
+	if target == "" {
+		return "", errors.Bad(&errors.FieldViolation{
+			Field:       "target",
+			Description: "Missing job target",
+		})
+	}
+	if interval <= 0 {
+		return "", errors.Bad(&errors.FieldViolation{
+			Field:       "interval",
+			Description: "The interval must be positive",
+		})
+	}
+
+	j := &Job{
+		ID:       target,
+		Target:   target,
+		Data:     data,
+		Due:      utc.Now(),
+		Attempt:  1,
+		Interval: interval,
+		Created:  utc.Now(),
+		Options:  buildOptions(o...),
+	}
+	existing, err := s.jobs.Get(ctx, j.ID)
+	switch {
+	case err == nil:
+		// Keep the next occurrence of the job, unless its interval is shorter
+		if existing.Leased() {
+			return j.ID, nil
+		}
+		j.Due = utc.Min(existing.Due, utc.Now().Add(interval))
+	case errors.IsNotFound(err):
+	default:
+		return "", err
+	}
+	if err := s.jobs.Put(ctx, j); err != nil {
+		return "", err
+	}
+	return j.ID, nil
+}
+
+// Cancel removes job `id`. A job which is currently running is not
+// interrupted.
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	return s.jobs.Delete(ctx, id)
+}
+
+// Drain implements schedule.Scheduler. It cancels the context of the running
+// job, waits for it to return and stops executing jobs.
+func (s *Scheduler) Drain() {
+	s.mu.Lock()
+	if s.drained {
+		s.mu.Unlock()
+		return
+	}
+	s.drained = true
+	close(s.stop)
+	started := s.started
+	s.mu.Unlock()
+
+	if started {
+		<-s.done
+	}
+}
+
+// Close implements schedule.Scheduler and io.Closer. Jobs are persisted, so
+// closing only drains the scheduler.
+func (s *Scheduler) Close() error {
+	s.Drain()
+	return nil
+}
+
+// Dispatch executes all jobs that are currently due. Jobs for targets which
+// are not handled by this node are skipped. The context of jobs is cancelled
+// when the scheduler is drained.
+func (s *Scheduler) Dispatch(ctx context.Context) error {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-jobCtx.Done():
+		}
+	}()
+
+	now := utc.Now()
+	var after *Job
+	for {
+		jobs, err := s.jobs.Due(ctx, now, after, batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, j := range jobs {
+			if jobCtx.Err() != nil {
+				return nil
+			}
+
+			fn, ok := s.handler(j.Target)
+			if !ok {
+				// Left for the nodes handling it
+				continue
+			}
+			if err := s.execute(ctx, jobCtx, j, fn); err != nil {
+				return err
+			}
+		}
+		if len(jobs) < batchSize {
+			return nil
+		}
+		after = jobs[len(jobs)-1]
+	}
+}
+
+// execute claims job `j` and executes it with `fn` and `jobCtx`
+func (s *Scheduler) execute(
+	ctx, jobCtx context.Context, j *Job, fn schedule.Fn,
+) error {
+	now := utc.Now()
+	leased, err := s.jobs.Claim(ctx, j, s.Node, now.Add(s.LeaseTimeout))
+	switch {
+	case err == nil:
+		// Good
+	case errors.IsNotFound(err):
+		// Claimed by another node in the meantime
+		return nil
+	default:
+		// Most likely a conflict with another node claiming it
+		log.Warn(ctx, "job.claim.err", "Failed to claim job",
+			log.String("job", j.ID),
+			log.Error(err),
+		)
+		return nil
+	}
+
+	// Stop the job before its lease expires, so no other node runs it at the
+	// same time
+	deadline := now.Add(s.LeaseTimeout - s.LeaseTimeout/leaseMargin)
+	runCtx, cancel := context.WithDeadline(jobCtx, deadline.Time())
+	err = call(runCtx, fn, leased)
+	cancel()
+	next := nextRun(ctx, leased, err)
+	if leased.Options.Consistency == schedule.AtMostOnce {
+		// The job is no longer leased
+		if err == nil || next == nil || leased.Recurring() {
+			return nil
+		}
+		return s.jobs.Put(ctx, next)
+	}
+
+	err = s.jobs.Release(ctx, leased, next)
+	if errors.IsNotFound(err) {
+		log.Warn(ctx, "job.lease.lost", "Job ran longer than its lease",
+			log.String("job", j.ID),
+			log.String("target", j.Target),
+		)
+		return nil
+	}
+	return err
+}
+
+// nextRun returns the job to schedule after `j` has been executed, or nil when
+// it is done
+func nextRun(ctx context.Context, j *Job, err error) *Job {
+	next := *j
+	next.Owner = ""
+	if err == nil {
+		if !j.Recurring() {
+			return nil
+		}
+		next.Due = utc.Now().Add(j.Interval)
+		next.Attempt = 1
+		next.LastError = ""
+		return &next
+	}
+
+	next.LastError = err.Error()
+	next.Due = utc.Now().Add(j.backoff())
+	log.Warn(ctx, "job.run.err", "Job failed",
+		log.String("job", j.ID),
+		log.String("target", j.Target),
+		log.Uint("attempt", uint(j.Attempt)),
+		log.Error(err),
+	)
+	if j.expired(next.Due) {
+		if !j.Recurring() {
+			log.Warn(ctx, "job.run.abandon", "Job abandoned after its last attempt",
+				log.String("job", j.ID),
+				log.String("target", j.Target),
+			)
+			return nil
+		}
+		// Wait for the next occurrence
+		next.Due = utc.Now().Add(j.Interval)
+		next.Attempt = 1
+		return &next
+	}
+	next.Attempt++
+	return &next
+}
+
+func (s *Scheduler) handler(target string) (schedule.Fn, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, ok := s.handlers[target]
+	return fn, ok
+}
+
+// call executes `fn` and turns panics into errors
+func call(ctx context.Context, fn schedule.Fn, j *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(ctx, j.ID, j.Data)
+}
@@ -0,0 +1,339 @@
+package job_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/schedule"
+	"github.com/deixis/storage/kvdb"
+)
+
+// TestScheduler_Run ensures due jobs are executed once and removed, and that
+// jobs which are not due yet are left
+func TestScheduler_Run(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	s, err := job.NewScheduler(ctx)
+	if err != nil {
+		t.Fatal("error initialising scheduler", err)
+	}
+
+	var got []string
+	s.HandleFunc("echo", func(ctx context.Context, id string, data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	if _, err := s.In(ctx, -time.Second, "echo", []byte("now")); err != nil {
+		t.Fatal("error scheduling job", err)
+	}
+	later, err := s.In(ctx, time.Hour, "echo", []byte("later"))
+	if err != nil {
+		t.Fatal("error scheduling job", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Dispatch(ctx); err != nil {
+			t.Fatal("error dispatching", err)
+		}
+	}
+	if len(got) != 1 || got[0] != "now" {
+		t.Errorf("expect only the due job to run once, but got %v", got)
+	}
+
+	jobs, err := job.NewJobRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	due, err := jobs.Due(ctx, utc.Now().Add(2*time.Hour), nil, 10)
+	if err != nil {
+		t.Fatal("error loading due jobs", err)
+	}
+	if len(due) != 1 || due[0].ID != later {
+		t.Errorf("expect only job %s to be left, but got %v", later, due)
+	}
+}
+
+// TestScheduler_Retry ensures failed jobs are retried with a backoff until
+// their retry limit
+func TestScheduler_Retry(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	s, err := job.NewScheduler(ctx)
+	if err != nil {
+		t.Fatal("error initialising scheduler", err)
+	}
+	jobs, err := job.NewJobRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	var attempts int
+	s.HandleFunc("fail", func(ctx context.Context, id string, data []byte) error {
+		attempts++
+		return errors.New("boom")
+	})
+	id, err := s.In(ctx, -time.Second, "fail", nil,
+		schedule.WithRetryLimit(2), schedule.MinBackOff(time.Minute),
+	)
+	if err != nil {
+		t.Fatal("error scheduling job", err)
+	}
+
+	if err := s.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+	j, err := jobs.Get(ctx, id)
+	if err != nil {
+		t.Fatal("expect job to be retried", err)
+	}
+	if j.Attempt != 2 || j.LastError != "boom" || j.Leased() {
+		t.Errorf("expect attempt 2 to be scheduled, but got %+v", j)
+	}
+	if d := j.Due.Distance(utc.Now()); d < 50*time.Second || d > time.Minute {
+		t.Errorf("expect retry in about 1m, but got %s", d)
+	}
+
+	// Last attempt
+	j.Due = utc.Now().Add(-time.Second)
+	if err := jobs.Put(ctx, j); err != nil {
+		t.Fatal("error moving job", err)
+	}
+	if err := s.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expect 2 attempts, but got %d", attempts)
+	}
+	if _, err := jobs.Get(ctx, id); err == nil {
+		t.Error("expect job to be abandoned after its last attempt")
+	}
+}
+
+// TestScheduler_Lease ensures only one node runs a job at a time, and that
+// another node runs it again when its lease expired
+func TestScheduler_Lease(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	jobs, err := job.NewJobRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	err = jobs.Put(ctx, &job.Job{
+		ID:      "j1",
+		Target:  "noop",
+		Due:     utc.Now().Add(-time.Second),
+		Attempt: 1,
+		Options: job.Options{Consistency: schedule.AtLeastOnce},
+	})
+	if err != nil {
+		t.Fatal("error creating job", err)
+	}
+
+	due, err := jobs.Due(ctx, utc.Now(), nil, 10)
+	if err != nil || len(due) != 1 {
+		t.Fatal("expect job to be due", err)
+	}
+	leased, err := jobs.Claim(ctx, due[0], "node-a", utc.Now().Add(-time.Millisecond))
+	if err != nil {
+		t.Fatal("error claiming job", err)
+	}
+	if _, err := jobs.Claim(ctx, due[0], "node-b", utc.Now().Add(time.Minute)); err == nil {
+		t.Error("expect job to be claimed by only one node")
+	}
+
+	// The lease of node-a expired
+	due, err = jobs.Due(ctx, utc.Now(), nil, 10)
+	if err != nil || len(due) != 1 || due[0].Owner != "node-a" {
+		t.Fatal("expect job to be due again", err, due)
+	}
+	if _, err := jobs.Claim(ctx, due[0], "node-b", utc.Now().Add(time.Minute)); err != nil {
+		t.Fatal("expect node-b to claim the job", err)
+	}
+	if err := jobs.Release(ctx, leased, nil); err == nil {
+		t.Error("expect node-a to have lost its lease")
+	}
+}
+
+// TestScheduler_LongRun ensures a job which runs longer than its lease is
+// cancelled before the lease expires, so it is released and not run again by
+// another node
+func TestScheduler_LongRun(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	jobs, err := job.NewJobRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	var runs int
+	for _, node := range []string{"node-a", "node-b"} {
+		s, err := job.NewScheduler(ctx)
+		if err != nil {
+			t.Fatal("error initialising scheduler", err)
+		}
+		s.Node = node
+		s.LeaseTimeout = 200 * time.Millisecond
+		s.HandleFunc("slow", func(ctx context.Context, id string, data []byte) error {
+			runs++
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+				return errors.New("expect context to be cancelled")
+			}
+		})
+		if _, err := s.Every(ctx, time.Hour, "slow", nil); err != nil {
+			t.Fatal("error scheduling job", err)
+		}
+
+		start := time.Now()
+		if err := s.Dispatch(ctx); err != nil {
+			t.Fatal("error dispatching", err)
+		}
+		if d := time.Since(start); d >= s.LeaseTimeout {
+			t.Errorf("expect job to be cancelled before its lease expires, but ran %s", d)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("expect job to run once, but got %d", runs)
+	}
+
+	j, err := jobs.Get(ctx, "slow")
+	if err != nil {
+		t.Fatal("expect job to be scheduled again", err)
+	}
+	if j.Owner != "" || j.LastError != "" {
+		t.Errorf("expect job to be released, but got %+v", j)
+	}
+}
+
+// TestScheduler_Every ensures recurring jobs are registered once and scheduled
+// again after every execution
+func TestScheduler_Every(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	jobs, err := job.NewJobRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+
+	var runs int
+	for _, node := range []string{"node-a", "node-b"} {
+		s, err := job.NewScheduler(ctx)
+		if err != nil {
+			t.Fatal("error initialising scheduler", err)
+		}
+		s.Node = node
+		s.HandleFunc("tick", func(ctx context.Context, id string, data []byte) error {
+			runs++
+			return nil
+		})
+		if _, err := s.Every(ctx, time.Hour, "tick", nil); err != nil {
+			t.Fatal("error scheduling job", err)
+		}
+		if err := s.Dispatch(ctx); err != nil {
+			t.Fatal("error dispatching", err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("expect recurring job to run once, but got %d", runs)
+	}
+
+	j, err := jobs.Get(ctx, "tick")
+	if err != nil {
+		t.Fatal("expect job to be scheduled again", err)
+	}
+	if d := j.Due.Distance(utc.Now()); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expect next run in 1h, but got %s", d)
+	}
+}
+
+// TestScheduler_Skip ensures due jobs are executed even when they are queued
+// after more jobs than a batch for targets this node does not handle
+func TestScheduler_Skip(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	s, err := job.NewScheduler(ctx)
+	if err != nil {
+		t.Fatal("error initialising scheduler", err)
+	}
+
+	var ran bool
+	s.HandleFunc("mine", func(ctx context.Context, id string, data []byte) error {
+		ran = true
+		return nil
+	})
+	for i := 0; i < 60; i++ {
+		if _, err := s.In(ctx, -time.Hour, "other", nil); err != nil {
+			t.Fatal("error scheduling job", err)
+		}
+	}
+	if _, err := s.In(ctx, -time.Minute, "mine", nil); err != nil {
+		t.Fatal("error scheduling job", err)
+	}
+
+	if err := s.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+	if !ran {
+		t.Error("expect job to run after the jobs of other nodes")
+	}
+}
+
+// TestScheduler_Drain ensures draining cancels the context of the running job
+// and waits for it to return
+func TestScheduler_Drain(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	s, err := job.NewScheduler(ctx)
+	if err != nil {
+		t.Fatal("error initialising scheduler", err)
+	}
+
+	started := make(chan struct{})
+	var finished bool
+	s.HandleFunc("slow", func(ctx context.Context, id string, data []byte) error {
+		close(started)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			return errors.New("expect context to be cancelled")
+		}
+		finished = true
+		return nil
+	})
+	if _, err := s.In(ctx, -time.Second, "slow", nil); err != nil {
+		t.Fatal("error scheduling job", err)
+	}
+	if err := s.Start(ctx); err != nil {
+		t.Fatal("error starting scheduler", err)
+	}
+	<-started
+	s.Drain()
+	if !finished {
+		t.Error("expect drain to wait for the running job")
+	}
+}
+
+func loadStorage(name string) (context.Context, error) {
+	return kvdb.WithContext(context.Background(), memory.New()), nil
+}
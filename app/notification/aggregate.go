@@ -0,0 +1,136 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/deixis/pkg/utc"
+)
+
+// Kind identifies why a notification is sent
+type Kind string
+
+func (k Kind) String() string {
+	return string(k)
+}
+
+const (
+	KindCreated   Kind = "created"
+	KindUpdated   Kind = "updated"
+	KindCancelled Kind = "cancelled"
+	KindReminder  Kind = "reminder"
+)
+
+// Booking is the snapshot of the reservation a notification is about
+type Booking struct {
+	ID      string  `json:"id"`
+	RoomRef string  `json:"roomRef"`
+	From    utc.UTC `json:"from"`
+	To      utc.UTC `json:"to"`
+	Seats   int     `json:"seats,omitempty"`
+	UserID  string  `json:"userId"`
+	Version uint64  `json:"version"`
+}
+
+// Notification is an email waiting to be sent to the owner of a booking
+type Notification struct {
+	ID        string  `json:"id"`
+	Kind      Kind    `json:"kind"`
+	Booking   Booking `json:"booking"`
+	Attempts  int     `json:"attempts"`
+	DueAt     utc.UTC `json:"dueAt"`
+	LastError string  `json:"lastError,omitempty"`
+}
+
+// Message is an email
+type Message struct {
+	From        string
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Bytes encodes the message as a MIME message (RFC 5322/2045)
+func (m *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	h := func(k, v string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	h("From", m.From)
+	h("To", strings.Join(m.To, ", "))
+	h("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	h("Date", time.Now().UTC().Format(time.RFC1123Z))
+	h("MIME-Version", "1.0")
+	h("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(m.Body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range m.Attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition": {
+				mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(encodeBase64(a.Data)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// base64LineLength is the maximum length of base64 lines in MIME bodies
+const base64LineLength = 76
+
+// encodeBase64 encodes `data` in base64, split into lines of 76 characters
+func encodeBase64(data []byte) []byte {
+	s := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(s) > base64LineLength {
+		buf.WriteString(s[:base64LineLength])
+		buf.WriteString("\r\n")
+		s = s[base64LineLength:]
+	}
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
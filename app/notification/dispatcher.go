@@ -0,0 +1,174 @@
+package notification
+
+import (
+	"context"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+)
+
+const (
+	// pollInterval defines how often the outbox is scanned for due notifications
+	pollInterval = 30 * time.Second
+	// batchSize is the maximum number of notifications sent per scan
+	batchSize = 50
+
+	// maxAttempts is the number of failed attempts after which a notification
+	// is dropped
+	maxAttempts = 5
+	// baseRetryDelay is the delay applied after the first failed attempt.
+	// It doubles after every subsequent failure until it reaches maxRetryDelay.
+	baseRetryDelay = 1 * time.Minute
+	maxRetryDelay  = 30 * time.Minute
+
+	// defaultFrom is the sender of emails when none is configured
+	defaultFrom = "booking@localhost"
+
+	// jobDispatch is the scheduler target of the dispatcher
+	jobDispatch = "notification.dispatch"
+)
+
+// Dispatcher emails notifications from the outbox. It runs as a recurring job
+// of the scheduler.
+type Dispatcher struct {
+	// Sender sends emails (emails are only logged by default)
+	Sender Sender
+	// From is the sender address of emails
+	From string
+
+	outbox *OutboxRepository
+	iam    *iam.Service
+}
+
+func NewDispatcher(ctx context.Context) (*Dispatcher, error) {
+	outbox, err := NewOutboxRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise outbox repository")
+	}
+	iams, err := iam.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise iam service")
+	}
+
+	return &Dispatcher{
+		Sender: &LogSender{},
+		From:   defaultFrom,
+		outbox: outbox,
+		iam:    iams,
+	}, nil
+}
+
+// Schedule registers the dispatcher on scheduler `s` and schedules it every
+// `pollInterval`. Only one node dispatches at a time.
+func (d *Dispatcher) Schedule(ctx context.Context, s *job.Scheduler) error {
+	_, err := s.HandleFunc(jobDispatch, func(ctx context.Context, id string, data []byte) error {
+		return d.Dispatch(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.Every(ctx, pollInterval, jobDispatch, nil); err != nil {
+		return errors.Wrapf(err, "failed to schedule %s", jobDispatch)
+	}
+	return nil
+}
+
+// Dispatch sends all notifications that are currently due
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	for {
+		notifications, err := d.outbox.Due(ctx, utc.Now(), batchSize)
+		if err != nil {
+			return err
+		}
+		if len(notifications) == 0 {
+			return nil
+		}
+
+		for _, n := range notifications {
+			if ctx.Err() != nil {
+				// Out of time, or the scheduler is draining. The rest is sent
+				// on the next run.
+				return nil
+			}
+
+			if err := d.deliver(ctx, n); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, n *Notification) error {
+	now := utc.Now()
+	if n.Kind == KindReminder && n.Booking.From <= now {
+		// Too late to remind anyone
+		return d.outbox.Ack(ctx, n)
+	}
+
+	u, err := d.iam.GetUser(ctx, n.Booking.UserID)
+	switch {
+	case err == nil:
+		// Good
+	case errors.IsNotFound(err):
+		// User has been deleted in the meantime
+		return d.outbox.Ack(ctx, n)
+	default:
+		return err
+	}
+	if !wants(u, n.Kind) {
+		return d.outbox.Ack(ctx, n)
+	}
+
+	m, err := render(n, d.From, u, now.Time())
+	if err != nil {
+		return err
+	}
+	err = d.Sender.Send(ctx, m)
+	if err == nil {
+		return d.outbox.Ack(ctx, n)
+	}
+	if ctx.Err() != nil {
+		// Interrupted by the scheduler draining. It is sent again on the next run.
+		return nil
+	}
+
+	n.Attempts++
+	n.LastError = err.Error()
+	log.Warn(ctx, "notification.deliver.err", "Failed to send notification",
+		log.String("notification", n.ID),
+		log.String("user", u.ID),
+		log.Int("attempts", n.Attempts),
+		log.Error(err),
+	)
+	if n.Attempts >= maxAttempts {
+		return d.outbox.Ack(ctx, n)
+	}
+	return d.outbox.Reschedule(ctx, n, now.Add(backoff(n.Attempts)))
+}
+
+// wants returns whether user `u` wants to receive notifications of kind `k`
+func wants(u *iam.User, k Kind) bool {
+	if u.Email == "" {
+		return false
+	}
+	if k == KindReminder {
+		return !u.Notifications.MuteReminders
+	}
+	return !u.Notifications.MuteUpdates
+}
+
+// backoff returns the delay to apply after `attempts` failed attempts
+func backoff(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
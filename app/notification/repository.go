@@ -0,0 +1,220 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+)
+
+var firstKey kvdb.TupleElement
+
+// OutboxRepository contains the notifications waiting to be sent.
+//
+// Notifications are keyed by due time, so the dispatcher can scan what needs
+// to be sent with a single range read. Reminders are due before their
+// booking starts and are also indexed by reservation ID, so they can be
+// replaced when the reservation is rescheduled or cancelled.
+type OutboxRepository struct {
+	outbox    kvdb.Subspace
+	reminders kvdb.Subspace
+}
+
+func NewOutboxRepository(ctx context.Context) (*OutboxRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	outbox, err := store.CreateOrOpenDir([]string{"notification", "outbox"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open notification/outbox dir")
+	}
+	reminders, err := store.CreateOrOpenDir([]string{"notification", "reminder"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open notification/reminder dir")
+	}
+	return &OutboxRepository{
+		outbox:    outbox,
+		reminders: reminders,
+	}, nil
+}
+
+// Enqueue adds `n` to the outbox.
+//
+// When `ctx` carries a transaction, the notification is only visible once
+// that transaction commits.
+func (r *OutboxRepository) Enqueue(ctx context.Context, n *Notification) error {
+	data, err := encode(n)
+	if err != nil {
+		return err
+	}
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Set(r.outboxKey(n), data)
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// ScheduleReminder adds reminder `n` to the outbox and replaces the previous
+// reminder of the same booking
+func (r *OutboxRepository) ScheduleReminder(ctx context.Context, n *Notification) error {
+	data, err := encode(n)
+	if err != nil {
+		return err
+	}
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			if err := r.CancelReminder(ctx, n.Booking.ID); err != nil {
+				return nil, err
+			}
+			tx.Set(r.outboxKey(n), data)
+			tx.Set(r.reminders.Pack([]kvdb.TupleElement{n.Booking.ID}), data)
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Reminder returns the reminder scheduled for reservation `reservationID`
+func (r *OutboxRepository) Reminder(
+	ctx context.Context, reservationID string,
+) (*Notification, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.reminders.Pack([]kvdb.TupleElement{reservationID})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			return data, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return decode(v.([]byte))
+}
+
+// CancelReminder removes the reminder of reservation `reservationID` (if any)
+func (r *OutboxRepository) CancelReminder(ctx context.Context, reservationID string) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			n, err := r.Reminder(ctx, reservationID)
+			switch {
+			case err == nil:
+				// Good
+			case errors.IsNotFound(err):
+				return nil, nil
+			default:
+				return nil, err
+			}
+			tx.Clear(r.outboxKey(n))
+			tx.Clear(r.reminders.Pack([]kvdb.TupleElement{reservationID}))
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Due returns at most `limit` notifications that are due before `now`
+func (r *OutboxRepository) Due(
+	ctx context.Context, now utc.UTC, limit int,
+) (notifications []*Notification, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.outbox.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.outbox.Pack([]kvdb.TupleElement{int64(now)}),
+			}
+			iter := tx.GetRange(rng, kvdb.WithRangeLimit(limit)).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				n, err := decode(kv.Value)
+				if err != nil {
+					return nil, err
+				}
+				notifications = append(notifications, n)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// Ack removes a sent (or dropped) notification from the outbox
+func (r *OutboxRepository) Ack(ctx context.Context, n *Notification) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.outboxKey(n))
+			if n.Kind != KindReminder {
+				return nil, nil
+			}
+
+			// Only clear the index when it still points to this reminder
+			current, err := r.Reminder(ctx, n.Booking.ID)
+			switch {
+			case err == nil:
+				if current.ID == n.ID {
+					tx.Clear(r.reminders.Pack([]kvdb.TupleElement{n.Booking.ID}))
+				}
+			case errors.IsNotFound(err):
+			default:
+				return nil, err
+			}
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Reschedule moves a notification in the outbox to its new due date `dueAt`
+func (r *OutboxRepository) Reschedule(
+	ctx context.Context, n *Notification, dueAt utc.UTC,
+) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.outboxKey(n))
+			n.DueAt = dueAt
+			if n.Kind == KindReminder {
+				return nil, r.ScheduleReminder(ctx, n)
+			}
+			return nil, r.Enqueue(ctx, n)
+		},
+	)
+	return err
+}
+
+func (r *OutboxRepository) outboxKey(n *Notification) kvdb.Key {
+	return r.outbox.Pack([]kvdb.TupleElement{int64(n.DueAt), n.ID})
+}
+
+func encode(n *Notification) ([]byte, error) {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(n); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal notification")
+	}
+	return encoded.Bytes(), nil
+}
+
+func decode(data []byte) (*Notification, error) {
+	n := &Notification{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(n); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal notification")
+	}
+	return n, nil
+}
@@ -0,0 +1,150 @@
+package notification
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/spine/log"
+)
+
+// defaultSMTPTimeout bounds the time it takes to send a message when no
+// timeout is configured
+const defaultSMTPTimeout = 30 * time.Second
+
+// Sender sends email messages
+type Sender interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// SMTPSender sends messages through an SMTP server
+type SMTPSender struct {
+	// Addr is the address of the SMTP server (host:port)
+	Addr string
+	// Auth is optional. smtp.PlainAuth refuses to send credentials over
+	// unencrypted connections, except to localhost.
+	Auth smtp.Auth
+	// RequireTLS refuses to send messages when the server does not support
+	// STARTTLS
+	RequireTLS bool
+	// Timeout bounds the whole SMTP session (30s by default). The session is
+	// aborted earlier when the context is done.
+	Timeout time.Duration
+}
+
+// Send implements Sender. It behaves like smtp.SendMail, but gives up when
+// the timeout expires or when `ctx` is done.
+func (s *SMTPSender) Send(ctx context.Context, m *Message) (err error) {
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	// Unblock reads and writes when the context is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	} else if s.RequireTLS {
+		return errors.Errorf("SMTP server %s does not support STARTTLS", s.Addr)
+	}
+	if s.Auth != nil {
+		if err := c.Auth(s.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(address(m.From)); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// InsecurePlainAuth returns an smtp.PlainAuth which also sends credentials in
+// plain text over unencrypted connections to other hosts than localhost. It
+// must not be used in production.
+func InsecurePlainAuth(username, password, host string) smtp.Auth {
+	return &insecureAuth{Auth: smtp.PlainAuth("", username, password, host)}
+}
+
+type insecureAuth struct {
+	smtp.Auth
+}
+
+func (a *insecureAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	s := *server
+	s.TLS = true
+	return a.Auth.Start(&s)
+}
+
+// LogSender only logs messages. It is used when no SMTP server is configured.
+type LogSender struct{}
+
+// Send implements Sender
+func (s *LogSender) Send(ctx context.Context, m *Message) error {
+	log.Trace(ctx, "notification.send", "Email not sent (no SMTP server)",
+		log.String("to", strings.Join(m.To, ",")),
+		log.String("subject", m.Subject),
+	)
+	return nil
+}
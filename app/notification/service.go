@@ -0,0 +1,79 @@
+package notification
+
+import (
+	"context"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+	"github.com/segmentio/ksuid"
+)
+
+type Service struct {
+	outbox *OutboxRepository
+	iam    *iam.Service
+}
+
+func New(ctx context.Context) (*Service, error) {
+	outbox, err := NewOutboxRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise outbox repository")
+	}
+	iams, err := iam.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise iam service")
+	}
+
+	return &Service{
+		outbox: outbox,
+		iam:    iams,
+	}, nil
+}
+
+// Notify emails the owner of booking `b` about change `k`, and schedules (or
+// clears) the reminder of the booking.
+//
+// It joins the transaction carried by `ctx` (if any), so the email is only
+// sent when the change it describes is committed. Preferences are checked
+// when the email is sent.
+func (s *Service) Notify(ctx context.Context, k Kind, b Booking) error {
+	now := utc.Now()
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			err := s.outbox.Enqueue(ctx, &Notification{
+				ID:      ksuid.New().String(),
+				Kind:    k,
+				Booking: b,
+				DueAt:   now,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if k == KindCancelled {
+				return nil, s.outbox.CancelReminder(ctx, b.ID)
+			}
+			lead := (&iam.NotificationPreferences{}).ReminderLead()
+			u, err := s.iam.GetUser(ctx, b.UserID)
+			switch {
+			case err == nil:
+				lead = u.Notifications.ReminderLead()
+			case errors.IsNotFound(err):
+			default:
+				return nil, err
+			}
+			dueAt := b.From.Sub(lead)
+			if dueAt <= now {
+				return nil, s.outbox.CancelReminder(ctx, b.ID)
+			}
+			return nil, s.outbox.ScheduleReminder(ctx, &Notification{
+				ID:      ksuid.New().String(),
+				Kind:    KindReminder,
+				Booking: b,
+				DueAt:   dueAt,
+			})
+		},
+	)
+	return err
+}
@@ -0,0 +1,328 @@
+package notification_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/app/notification"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+)
+
+// TestDispatcher_Send ensures a notification is emailed with its invite, and
+// that the reminder is scheduled and cleared when the reservation is cancelled
+func TestDispatcher_Send(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	if err := createUser(ctx, &iam.User{ID: "foo", Email: "foo@example.com"}); err != nil {
+		t.Fatal("error creating user", err)
+	}
+	smtpd, err := startSMTP()
+	if err != nil {
+		t.Fatal("error starting SMTP server", err)
+	}
+	defer smtpd.Close()
+
+	svc, err := notification.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	dispatcher, err := notification.NewDispatcher(ctx)
+	if err != nil {
+		t.Fatal("error initialising dispatcher", err)
+	}
+	dispatcher.Sender = &notification.SMTPSender{Addr: smtpd.Addr().String()}
+	dispatcher.From = "Booking <booking@example.com>"
+
+	b := notification.Booking{
+		ID:      "r1",
+		RoomRef: "C01",
+		From:    utc.Now().Add(2 * time.Hour).Floor(time.Hour),
+		To:      utc.Now().Add(3 * time.Hour).Floor(time.Hour),
+		UserID:  "foo",
+	}
+	if err := svc.Notify(ctx, notification.KindCreated, b); err != nil {
+		t.Fatal("error notifying", err)
+	}
+	if err := dispatcher.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+
+	select {
+	case m := <-smtpd.received:
+		if got, want := m.Header.Get("To"), "foo@example.com"; got != want {
+			t.Errorf("expect email to %s, but got %s", want, got)
+		}
+		invite := findInvite(t, m)
+		for _, want := range []string{
+			"METHOD:REQUEST",
+			"UID:r1@booking-api",
+			"DTSTART:" + b.From.Time().Format("20060102T150405Z"),
+			"ORGANIZER:mailto:booking@example.com",
+		} {
+			if !strings.Contains(invite, want+"\r\n") {
+				t.Errorf("expect invite to contain %s, but got\n%s", want, invite)
+			}
+		}
+	default:
+		t.Fatal("expect email to be sent")
+	}
+
+	outbox, err := notification.NewOutboxRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	reminder, err := outbox.Reminder(ctx, b.ID)
+	if err != nil {
+		t.Fatal("expect reminder to be scheduled", err)
+	}
+	if got, want := reminder.DueAt, b.From.Sub(15*time.Minute); got != want {
+		t.Errorf("expect reminder at %s, but got %s", want, got)
+	}
+
+	if err := svc.Notify(ctx, notification.KindCancelled, b); err != nil {
+		t.Fatal("error notifying", err)
+	}
+	due, err := outbox.Due(ctx, b.From, 10)
+	if err != nil {
+		t.Fatal("error loading due notifications", err)
+	}
+	if len(due) != 1 || due[0].Kind != notification.KindCancelled {
+		t.Fatalf("expect only the cancellation to be due, but got %v", due)
+	}
+	if err := dispatcher.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+	select {
+	case m := <-smtpd.received:
+		if invite := findInvite(t, m); !strings.Contains(invite, "METHOD:CANCEL\r\n") {
+			t.Errorf("expect cancellation invite, but got\n%s", invite)
+		}
+	default:
+		t.Fatal("expect cancellation email to be sent")
+	}
+}
+
+// TestSMTPSender_Timeout ensures sending gives up when the server does not
+// answer, instead of blocking the dispatcher
+func TestSMTPSender_Timeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("error listening", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// Never greet the client
+			defer conn.Close()
+		}
+	}()
+
+	m := &notification.Message{
+		From:    "booking@example.com",
+		To:      []string{"foo@example.com"},
+		Subject: "Test",
+	}
+	sender := &notification.SMTPSender{Addr: l.Addr().String(), Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	if err := sender.Send(context.Background(), m); err != context.DeadlineExceeded {
+		t.Errorf("expect send to time out, but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	sender.Timeout = time.Minute
+	if err := sender.Send(ctx, m); err != context.Canceled {
+		t.Errorf("expect send to be cancelled, but got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expect send to give up quickly, but took %s", d)
+	}
+}
+
+// TestDispatcher_Muted ensures users who muted notifications or have no email
+// do not receive any
+func TestDispatcher_Muted(t *testing.T) {
+	ctx, err := loadStorage(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	users := []*iam.User{
+		{
+			ID:            "muted",
+			Email:         "muted@example.com",
+			Notifications: iam.NotificationPreferences{MuteUpdates: true},
+		},
+		{ID: "anonymous"},
+	}
+	for _, u := range users {
+		if err := createUser(ctx, u); err != nil {
+			t.Fatal("error creating user", err)
+		}
+	}
+	smtpd, err := startSMTP()
+	if err != nil {
+		t.Fatal("error starting SMTP server", err)
+	}
+	defer smtpd.Close()
+
+	svc, err := notification.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	dispatcher, err := notification.NewDispatcher(ctx)
+	if err != nil {
+		t.Fatal("error initialising dispatcher", err)
+	}
+	dispatcher.Sender = &notification.SMTPSender{Addr: smtpd.Addr().String()}
+
+	for _, u := range users {
+		err := svc.Notify(ctx, notification.KindCreated, notification.Booking{
+			ID:      "r-" + u.ID,
+			RoomRef: "C01",
+			From:    utc.Now().Add(2 * time.Hour),
+			To:      utc.Now().Add(3 * time.Hour),
+			UserID:  u.ID,
+		})
+		if err != nil {
+			t.Fatal("error notifying", err)
+		}
+	}
+	if err := dispatcher.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+
+	select {
+	case m := <-smtpd.received:
+		t.Error("expect no email, but got one to", m.Header.Get("To"))
+	default:
+	}
+}
+
+// findInvite returns the calendar attached to message `m`
+func findInvite(t *testing.T, m *mail.Message) string {
+	t.Helper()
+
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal("error parsing content type", err)
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			t.Fatal("expect invite to be attached", err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), "text/calendar") {
+			continue
+		}
+		data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatal("error decoding invite", err)
+		}
+		return string(data)
+	}
+}
+
+// smtpServer is a local SMTP stand-in, which accepts every message
+type smtpServer struct {
+	net.Listener
+	received chan *mail.Message
+}
+
+func startSMTP() (*smtpServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &smtpServer{
+		Listener: l,
+		received: make(chan *mail.Message, 10),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			m, err := mail.ReadMessage(strings.NewReader(data.String()))
+			if err != nil {
+				reply("554 Invalid message")
+				continue
+			}
+			s.received <- m
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// createUser creates user `u`
+func createUser(ctx context.Context, u *iam.User) error {
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		return err
+	}
+	return users.Create(ctx, u)
+}
+
+func loadStorage(name string) (context.Context, error) {
+	ctx := context.Background()
+	ctx = kvdb.WithContext(ctx, memory.New())
+	return iam.WithContext(ctx, &iam.Account{UserID: "admin"}), nil
+}
@@ -0,0 +1,158 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"text/template"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/pkg/ical"
+	"github.com/deixis/errors"
+)
+
+// uidDomain is appended to reservation IDs to build calendar event UIDs
+const uidDomain = "booking-api"
+
+// timeLayout is how times are displayed in emails
+const timeLayout = "Mon 2 Jan 2006 15:04 MST"
+
+var templateFuncs = template.FuncMap{
+	"time": func(t time.Time) string { return t.UTC().Format(timeLayout) },
+}
+
+// templates contains the subject and the body of each kind of notification
+var templates = map[Kind]struct{ subject, body *template.Template }{
+	KindCreated: {
+		subject: parse("Reservation confirmed: {{.Booking.RoomRef}} on {{time .From}}"),
+		body: parse(`Your reservation is confirmed.
+
+Room: {{.Booking.RoomRef}}
+From: {{time .From}}
+To:   {{time .To}}
+{{- if .Booking.Seats}}
+Seats: {{.Booking.Seats}}
+{{- end}}
+
+The invite is attached.
+`),
+	},
+	KindUpdated: {
+		subject: parse("Reservation rescheduled: {{.Booking.RoomRef}} on {{time .From}}"),
+		body: parse(`Your reservation has been rescheduled.
+
+Room: {{.Booking.RoomRef}}
+From: {{time .From}}
+To:   {{time .To}}
+
+The updated invite is attached.
+`),
+	},
+	KindCancelled: {
+		subject: parse("Reservation cancelled: {{.Booking.RoomRef}} on {{time .From}}"),
+		body: parse(`Your reservation has been cancelled.
+
+Room: {{.Booking.RoomRef}}
+From: {{time .From}}
+To:   {{time .To}}
+`),
+	},
+	KindReminder: {
+		subject: parse("Reminder: {{.Booking.RoomRef}} at {{time .From}}"),
+		body: parse(`Your reservation starts soon.
+
+Room: {{.Booking.RoomRef}}
+From: {{time .From}}
+To:   {{time .To}}
+`),
+	},
+}
+
+func parse(text string) *template.Template {
+	return template.Must(template.New("").Funcs(templateFuncs).Parse(text))
+}
+
+// templateData is what templates are rendered with
+type templateData struct {
+	Booking Booking
+	From    time.Time
+	To      time.Time
+}
+
+// render builds the message sent for notification `n` to user `u`
+func render(n *Notification, from string, u *iam.User, now time.Time) (*Message, error) {
+	t, ok := templates[n.Kind]
+	if !ok {
+		return nil, errors.Errorf("unknown notification kind %s", n.Kind)
+	}
+
+	data := templateData{
+		Booking: n.Booking,
+		From:    n.Booking.From.Time(),
+		To:      n.Booking.To.Time(),
+	}
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, errors.Wrap(err, "failed to render subject")
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return nil, errors.Wrap(err, "failed to render body")
+	}
+
+	m := &Message{
+		From:    from,
+		To:      []string{u.Email},
+		Subject: subject.String(),
+		Body:    body.String(),
+	}
+	if method, ok := inviteMethod(n.Kind); ok {
+		m.Attachments = append(m.Attachments, Attachment{
+			Filename:    "invite.ics",
+			ContentType: fmt.Sprintf("text/calendar; charset=utf-8; method=%s", method),
+			Data:        ical.Invite(method, invite(&n.Booking, n.Kind, address(from), u.Email), now),
+		})
+	}
+	return m, nil
+}
+
+// inviteMethod returns the method of the invite attached to notifications of
+// kind `k`. Reminders do not have any invite.
+func inviteMethod(k Kind) (ical.Method, bool) {
+	switch k {
+	case KindCreated, KindUpdated:
+		return ical.MethodRequest, true
+	case KindCancelled:
+		return ical.MethodCancel, true
+	}
+	return "", false
+}
+
+// invite returns the calendar event of booking `b`
+func invite(b *Booking, k Kind, organizer, attendee string) *ical.Event {
+	// Reservations are versioned on every change but the cancellation, which
+	// must still supersede the last invite
+	seq := b.Version
+	if k == KindCancelled {
+		seq++
+	}
+	return &ical.Event{
+		UID:       b.ID + "@" + uidDomain,
+		Sequence:  seq,
+		Start:     b.From.Time(),
+		End:       b.To.Time(),
+		Summary:   "Room " + b.RoomRef,
+		Location:  b.RoomRef,
+		Organizer: organizer,
+		Attendee:  attendee,
+	}
+}
+
+// address returns the email address of `s`, which may contain a display name
+// (e.g. "Booking <booking@example.com>")
+func address(s string) string {
+	if a, err := mail.ParseAddress(s); err == nil {
+		return a.Address
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	_ "embed" // OpenAPI specification
+	"io/ioutil"
+
+	"github.com/basgys/booking-consensys/pkg/openapi"
+	"github.com/deixis/errors"
+	"github.com/deixis/spine/net/http"
+)
+
+// openAPISpec describes every route registered by HandleHTTP. Requests are
+// validated against it, so it must be updated along with the handlers.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPI returns the OpenAPI specification of the HTTP API
+func OpenAPI() (*openapi.Document, error) {
+	return openapi.Parse(openAPISpec)
+}
+
+// mustOpenAPIValidator returns a request validator for the OpenAPI
+// specification. The specification is embedded, so any error is a bug.
+func mustOpenAPIValidator() *openapi.Validator {
+	doc, err := OpenAPI()
+	if err != nil {
+		panic(errors.Wrap(err, "invalid OpenAPI specification"))
+	}
+	v, err := openapi.NewValidator(doc)
+	if err != nil {
+		panic(errors.Wrap(err, "invalid OpenAPI specification"))
+	}
+	return v
+}
+
+func httpOpenAPI(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	w.Data(http.StatusOK, "application/json; charset=utf-8",
+		ioutil.NopCloser(bytes.NewReader(openAPISpec)),
+	)
+}
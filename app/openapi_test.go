@@ -0,0 +1,243 @@
+package app_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app"
+	"github.com/basgys/booking-consensys/pkg/openapi"
+	"github.com/deixis/errors"
+	"github.com/deixis/spine/net/http"
+)
+
+// TestOpenAPI_Routes ensures every route registered by the HTTP handlers is
+// described by the OpenAPI specification, and vice versa
+func TestOpenAPI_Routes(t *testing.T) {
+	doc, err := app.OpenAPI()
+	if err != nil {
+		t.Fatal("error loading OpenAPI specification", err)
+	}
+	if _, err := openapi.NewValidator(doc); err != nil {
+		t.Fatal("error initialising validator", err)
+	}
+
+	files, err := filepath.Glob("*/http.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "app.go", "openapi.go")
+
+	registered := map[string]bool{}
+	for _, file := range files {
+		routes, err := parseRoutes(file)
+		if err != nil {
+			t.Fatal("error parsing routes", err)
+		}
+		for _, r := range routes {
+			registered[r] = true
+		}
+	}
+	if len(registered) == 0 {
+		t.Fatal("expect routes to be registered")
+	}
+
+	for r := range registered {
+		parts := strings.SplitN(r, " ", 2)
+		if doc.Operation(parts[1], parts[0]) == nil {
+			t.Errorf("expect route %s to be described in openapi.json", r)
+		}
+	}
+	for path, item := range doc.Paths {
+		for method := range *item {
+			r := strings.ToUpper(method) + " " + path
+			if !registered[r] {
+				t.Errorf("expect operation %s to be registered", r)
+			}
+		}
+	}
+}
+
+// TestOpenAPI_Validate ensures invalid requests are rejected with all their
+// field violations
+func TestOpenAPI_Validate(t *testing.T) {
+	doc, err := app.OpenAPI()
+	if err != nil {
+		t.Fatal("error loading OpenAPI specification", err)
+	}
+	v, err := openapi.NewValidator(doc)
+	if err != nil {
+		t.Fatal("error initialising validator", err)
+	}
+
+	const reservations = "/booking/rooms/{rid}/reservations"
+	table := []struct {
+		method string
+		url    string
+		path   string
+		params map[string]string
+		body   string
+		expect []string
+	}{
+		{
+			method: http.POST,
+			url:    "/booking/rooms/C01/reservations",
+			path:   reservations,
+			params: map[string]string{"rid": "C01"},
+			body:   `{"from":"2021-08-01T12:00:00Z","hours":2,"onBehalfOf":"foo"}`,
+		},
+		{
+			method: http.POST,
+			url:    "/booking/rooms/C01/reservations",
+			path:   reservations,
+			params: map[string]string{"rid": "C01"},
+			body:   `{"from":"tomorrow","hours":"2"}`,
+			expect: []string{"from", "hours"},
+		},
+		{
+			method: http.POST,
+			url:    "/booking/rooms/C01/reservations",
+			path:   reservations,
+			params: map[string]string{"rid": "C01"},
+			body:   `{"hours":0}`,
+			expect: []string{"from", "hours"},
+		},
+		{
+			method: http.POST,
+			url:    "/booking/rooms/C01/reservations",
+			path:   reservations,
+			params: map[string]string{"rid": "C01"},
+			body:   `{"from":`,
+			expect: []string{"body"},
+		},
+		{
+			method: http.GET,
+			url:    "/booking/rooms/C01/reservations?from=2021-08-01T12:00:00Z&limit=10",
+			path:   reservations,
+			params: map[string]string{"rid": "C01"},
+		},
+		{
+			method: http.GET,
+			url:    "/booking/rooms/C01/reservations?from=yesterday&limit=ten",
+			path:   reservations,
+			params: map[string]string{"rid": "C01"},
+			expect: []string{"from", "limit"},
+		},
+		{
+			method: http.PUT,
+			url:    "/iam/me",
+			path:   "/iam/me",
+			body:   `{"notifications":{"reminderMinutes":-5}}`,
+			expect: []string{"notifications.reminderMinutes"},
+		},
+		{
+			method: http.POST,
+			url:    "/webhooks/endpoints",
+			path:   "/webhooks/endpoints",
+			body:   `{"url":"https://example.com","events":["reservation.created","foo"]}`,
+			expect: []string{"events[1]"},
+		},
+		{
+			method: http.POST,
+			url:    "/booking/rooms/C01/requests/1/approve",
+			path:   "/booking/rooms/{rid}/requests/{id}/approve",
+			params: map[string]string{"rid": "C01", "id": "1"},
+		},
+		{
+			method: http.POST,
+			url:    "/booking/rooms/import",
+			path:   "/booking/rooms/import",
+			body:   "ref,name\nC01,Boardroom\n",
+		},
+	}
+
+	for i, test := range table {
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if strings.HasPrefix(test.body, "{") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		err := v.Validate(req, test.path, test.params)
+		if len(test.expect) == 0 {
+			if err != nil {
+				t.Errorf("#%d - expect request to be valid, but got %v", i, err)
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.body {
+				t.Errorf("#%d - expect body %q to be readable, but got %q", i, test.body, body)
+			}
+			continue
+		}
+
+		bad, ok := errors.Cause(err).(*errors.BadRequest)
+		if !ok {
+			t.Errorf("#%d - expect bad request, but got %v", i, err)
+			continue
+		}
+		var fields []string
+		for _, violation := range bad.Violations {
+			fields = append(fields, violation.Field)
+		}
+		sort.Strings(fields)
+		if strings.Join(fields, ",") != strings.Join(test.expect, ",") {
+			t.Errorf("#%d - expect violations on %v, but got %v", i, test.expect, fields)
+		}
+	}
+}
+
+// parseRoutes returns the routes (e.g. "GET /booking/rooms") registered in
+// Go source file `file` with HandleFunc or sse.Endpoint
+func parseRoutes(file string) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		path, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return true
+		}
+
+		switch {
+		case sel.Sel.Name == "HandleFunc":
+			method, ok := call.Args[1].(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			routes = append(routes, method.Sel.Name+" "+path)
+		case sel.Sel.Name == "Endpoint" && isIdent(sel.X, "sse"):
+			routes = append(routes, http.GET+" "+path)
+		}
+		return true
+	})
+	return routes, nil
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"encoding/json"
+
+	"github.com/deixis/pkg/utc"
+)
+
+// EventType identifies a reservation lifecycle event
+type EventType string
+
+func (t EventType) String() string {
+	return string(t)
+}
+
+const (
+	EventReservationCreated   EventType = "reservation.created"
+	EventReservationUpdated   EventType = "reservation.updated"
+	EventReservationCancelled EventType = "reservation.cancelled"
+	EventReservationCheckedIn EventType = "reservation.checked_in"
+	EventReservationNoShow    EventType = "reservation.no_show"
+)
+
+// EventTypes contains all event types an endpoint can subscribe to. Only
+// events which are published by the booking service are declared.
+var EventTypes = []EventType{
+	EventReservationCreated,
+	EventReservationUpdated,
+	EventReservationCancelled,
+	EventReservationCheckedIn,
+	EventReservationNoShow,
+}
+
+// Endpoint is a URL registered by an admin to receive events
+type Endpoint struct {
+	ID     string      `json:"id"`
+	URL    string      `json:"url"`
+	Secret string      `json:"secret,omitempty"`
+	Events []EventType `json:"events"`
+}
+
+// Subscribed returns whether the endpoint wants to receive events of type `t`
+func (e *Endpoint) Subscribed(t EventType) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, et := range e.Events {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the payload sent to endpoints
+type Event struct {
+	ID        string          `json:"id"`
+	Type      EventType       `json:"type"`
+	CreatedAt utc.UTC         `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Delivery is an event waiting to be sent to an endpoint
+type Delivery struct {
+	ID         string  `json:"id"`
+	EndpointID string  `json:"endpointId"`
+	Event      Event   `json:"event"`
+	Attempts   int     `json:"attempts"`
+	DueAt      utc.UTC `json:"dueAt"`
+	LastError  string  `json:"lastError,omitempty"`
+}
@@ -0,0 +1,183 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+)
+
+const (
+	// pollInterval defines how often the outbox is scanned for due deliveries
+	pollInterval = 5 * time.Second
+	// batchSize is the maximum number of deliveries sent per scan
+	batchSize = 50
+
+	// maxAttempts is the number of failed attempts after which a delivery is
+	// moved to the dead letter subspace
+	maxAttempts = 8
+	// baseRetryDelay is the delay applied after the first failed attempt.
+	// It doubles after every subsequent failure until it reaches maxRetryDelay.
+	baseRetryDelay = 30 * time.Second
+	maxRetryDelay  = 1 * time.Hour
+
+	deliveryTimeout = 10 * time.Second
+	// dispatchBudget is how long a single run sends deliveries. What is left
+	// is sent on the next run, so other jobs are not held up by a large
+	// outbox or slow endpoints.
+	dispatchBudget = 1 * time.Minute
+
+	// jobDispatch is the scheduler target of the dispatcher
+	jobDispatch = "webhook.dispatch"
+)
+
+// Dispatcher sends deliveries from the outbox to their endpoint. It runs as a
+// recurring job of the scheduler.
+type Dispatcher struct {
+	Client *nethttp.Client
+
+	endpoints  *EndpointRepository
+	deliveries *DeliveryRepository
+}
+
+func NewDispatcher(ctx context.Context) (*Dispatcher, error) {
+	endpoints, err := NewEndpointRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise endpoint repository")
+	}
+	deliveries, err := NewDeliveryRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise delivery repository")
+	}
+
+	return &Dispatcher{
+		Client:     &nethttp.Client{Timeout: deliveryTimeout},
+		endpoints:  endpoints,
+		deliveries: deliveries,
+	}, nil
+}
+
+// Schedule registers the dispatcher on scheduler `s` and schedules it every
+// `pollInterval`. Only one node dispatches at a time.
+func (d *Dispatcher) Schedule(ctx context.Context, s *job.Scheduler) error {
+	_, err := s.HandleFunc(jobDispatch, func(ctx context.Context, id string, data []byte) error {
+		return d.Dispatch(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.Every(ctx, pollInterval, jobDispatch, nil); err != nil {
+		return errors.Wrapf(err, "failed to schedule %s", jobDispatch)
+	}
+	return nil
+}
+
+// Dispatch sends the deliveries that are currently due, until the outbox is
+// empty or `dispatchBudget` is spent
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dispatchBudget)
+	defer cancel()
+
+	for {
+		deliveries, err := d.deliveries.Due(ctx, utc.Now(), batchSize)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				// Out of time, or the scheduler is draining. The rest is sent
+				// on the next run.
+				return nil
+			}
+
+			if err := d.deliver(ctx, delivery); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) error {
+	e, err := d.endpoints.Get(ctx, delivery.EndpointID)
+	switch {
+	case err == nil:
+		// Good
+	case errors.IsNotFound(err):
+		// Endpoint has been deleted in the meantime
+		return d.deliveries.Ack(ctx, delivery)
+	default:
+		return err
+	}
+
+	err = d.send(ctx, e, &delivery.Event)
+	if err == nil {
+		return d.deliveries.Ack(ctx, delivery)
+	}
+	if ctx.Err() != nil {
+		// Interrupted before the endpoint answered. It is sent again on the
+		// next run.
+		return nil
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	log.Warn(ctx, "webhook.deliver.err", "Failed to deliver event",
+		log.String("delivery", delivery.ID),
+		log.String("endpoint", e.ID),
+		log.Int("attempts", delivery.Attempts),
+		log.Error(err),
+	)
+	if delivery.Attempts >= maxAttempts {
+		return d.deliveries.DeadLetter(ctx, delivery)
+	}
+	return d.deliveries.Reschedule(ctx, delivery, utc.Now().Add(backoff(delivery.Attempts)))
+}
+
+func (d *Dispatcher) send(ctx context.Context, e *Endpoint, evt *Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal event")
+	}
+
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Webhook-Id", evt.ID)
+	req.Header.Set("Webhook-Event", evt.Type.String())
+	req.Header.Set("Webhook-Signature", Sign(e.Secret, body))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// backoff returns the delay to apply after `attempts` failed attempts
+func backoff(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
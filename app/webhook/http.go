@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/spine/net/http"
+)
+
+func (s *Service) HandleHTTP(srv *http.Server) {
+	h := httpHandler{
+		svc: s,
+	}
+
+	srv.HandleFunc("/webhooks/endpoints", http.GET, h.listEndpoints)
+	srv.HandleFunc("/webhooks/endpoints", http.POST, h.registerEndpoint)
+	srv.HandleFunc("/webhooks/endpoints/{id}", http.DELETE, h.deleteEndpoint)
+	srv.HandleFunc("/webhooks/dead-letters", http.GET, h.listDeadLetters)
+	srv.HandleFunc("/webhooks/dead-letters/{id}/retry", http.POST, h.retryDeadLetter)
+}
+
+type httpHandler struct {
+	svc *Service
+}
+
+func (h *httpHandler) listEndpoints(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	endpoints, err := h.svc.ListEndpoints(ctx)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Endpoints []*Endpoint `json:"endpoints"`
+	}{
+		Endpoints: endpoints,
+	})
+}
+
+type httpRegisterEndpointRequest struct {
+	URL    string      `json:"url"`
+	Events []EventType `json:"events"`
+	Secret string      `json:"secret"`
+}
+
+func (h *httpHandler) registerEndpoint(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	defer req.HTTP.Body.Close()
+	r := httpRegisterEndpointRequest{}
+	if err := unmarshalJSON(req.HTTP.Body, &r); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+
+	e, err := h.svc.RegisterEndpoint(ctx, r.URL, r.Events, r.Secret)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusCreated, e)
+}
+
+func (h *httpHandler) deleteEndpoint(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	if err := h.svc.DeleteEndpoint(ctx, req.Params["id"]); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Head(http.StatusNoContent)
+}
+
+func (h *httpHandler) listDeadLetters(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	deliveries, err := h.svc.ListDeadLetters(ctx)
+	if err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.JSON(http.StatusOK, struct {
+		Deliveries []*Delivery `json:"deliveries"`
+	}{
+		Deliveries: deliveries,
+	})
+}
+
+func (h *httpHandler) retryDeadLetter(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+) {
+	if err := h.svc.RetryDeadLetter(ctx, req.Params["id"]); err != nil {
+		httperrors.Marshal(req.HTTP, w, err)
+		return
+	}
+	w.Head(http.StatusAccepted)
+}
+
+func unmarshalJSON(r io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.WithBad(err)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+)
+
+var (
+	firstKey kvdb.TupleElement
+	lastKey  = kvdb.UUID{0xFF}
+)
+
+type EndpointRepository struct {
+	ss kvdb.Subspace
+}
+
+func NewEndpointRepository(ctx context.Context) (*EndpointRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	dir, err := store.CreateOrOpenDir([]string{"webhook", "endpoint"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open webhook/endpoint dir")
+	}
+	return &EndpointRepository{
+		ss: dir,
+	}, nil
+}
+
+func (r *EndpointRepository) Create(
+	ctx context.Context, e *Endpoint,
+) error {
+	if e.ID == "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "id",
+			Description: "Missing endpoint ID",
+		})
+	}
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(e); err != nil {
+		return errors.Wrap(err, "failed to marshal endpoint")
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			key := r.ss.Pack([]kvdb.TupleElement{e.ID})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > 0 {
+				return nil, errors.Aborted(&errors.ConflictViolation{
+					Resource:    "endpoint:" + e.ID,
+					Description: "Endpoint has already been created",
+				})
+			}
+
+			tx.Set(key, encoded.Bytes())
+			return nil, nil
+		},
+	)
+	return err
+}
+
+func (r *EndpointRepository) Get(
+	ctx context.Context, id string,
+) (*Endpoint, error) {
+	v, err := kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			data, err := tx.Get(r.ss.Pack([]kvdb.TupleElement{id})).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			return data, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Endpoint{}
+	if err := gob.NewDecoder(bytes.NewReader(v.([]byte))).Decode(e); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal endpoint")
+	}
+	return e, nil
+}
+
+func (r *EndpointRepository) List(
+	ctx context.Context,
+) (endpoints []*Endpoint, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.ss.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.ss.Pack([]kvdb.TupleElement{lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				e := Endpoint{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&e)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal endpoint")
+				}
+				endpoints = append(endpoints, &e)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (r *EndpointRepository) Delete(
+	ctx context.Context, id string,
+) error {
+	if id == "" {
+		return errors.Bad(&errors.FieldViolation{
+			Field:       "id",
+			Description: "Missing endpoint ID",
+		})
+	}
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.ss.Pack([]kvdb.TupleElement{id}))
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// DeliveryRepository is the outbox of events waiting to be delivered.
+//
+// Pending deliveries are keyed by due time, so the dispatcher can scan
+// what needs to be sent with a single range read. Deliveries which ran out of
+// attempts are moved to a dead letter subspace keyed by delivery ID.
+type DeliveryRepository struct {
+	outbox     kvdb.Subspace
+	deadLetter kvdb.Subspace
+}
+
+func NewDeliveryRepository(ctx context.Context) (*DeliveryRepository, error) {
+	store, ok := kvdb.FromContext(ctx)
+	if !ok {
+		return nil, kvdb.ErrNoConnectionFound
+	}
+	outbox, err := store.CreateOrOpenDir([]string{"webhook", "outbox"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open webhook/outbox dir")
+	}
+	deadLetter, err := store.CreateOrOpenDir([]string{"webhook", "dead-letter"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open webhook/dead-letter dir")
+	}
+	return &DeliveryRepository{
+		outbox:     outbox,
+		deadLetter: deadLetter,
+	}, nil
+}
+
+// Enqueue adds `d` to the outbox.
+//
+// When `ctx` carries a transaction, the delivery is only visible once that
+// transaction commits.
+func (r *DeliveryRepository) Enqueue(ctx context.Context, d *Delivery) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(d); err != nil {
+		return errors.Wrap(err, "failed to marshal delivery")
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Set(r.outboxKey(d), encoded.Bytes())
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Due returns at most `limit` deliveries that are due before `now`
+func (r *DeliveryRepository) Due(
+	ctx context.Context, now utc.UTC, limit int,
+) (deliveries []*Delivery, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.outbox.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.outbox.Pack([]kvdb.TupleElement{int64(now)}),
+			}
+			iter := tx.GetRange(rng, kvdb.WithRangeLimit(limit)).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				d := Delivery{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&d)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal delivery")
+				}
+				deliveries = append(deliveries, &d)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Ack removes a delivered event from the outbox
+func (r *DeliveryRepository) Ack(ctx context.Context, d *Delivery) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.outboxKey(d))
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// Reschedule moves a delivery in the outbox to its new due date `dueAt`
+func (r *DeliveryRepository) Reschedule(
+	ctx context.Context, d *Delivery, dueAt utc.UTC,
+) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.outboxKey(d))
+			d.DueAt = dueAt
+			return nil, r.Enqueue(ctx, d)
+		},
+	)
+	return err
+}
+
+// DeadLetter moves a delivery from the outbox to the dead letter subspace
+func (r *DeliveryRepository) DeadLetter(ctx context.Context, d *Delivery) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(d); err != nil {
+		return errors.Wrap(err, "failed to marshal delivery")
+	}
+
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			tx.Clear(r.outboxKey(d))
+			tx.Set(r.deadLetter.Pack([]kvdb.TupleElement{d.ID}), encoded.Bytes())
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// DeadLetters returns all deliveries that could not be delivered
+func (r *DeliveryRepository) DeadLetters(
+	ctx context.Context,
+) (deliveries []*Delivery, err error) {
+	_, err = kvdb.ReadTransact(ctx,
+		func(ctx context.Context, tx kvdb.ReadTransaction) (interface{}, error) {
+			rng := kvdb.KeyRange{
+				Begin: r.deadLetter.Pack([]kvdb.TupleElement{firstKey}),
+				End:   r.deadLetter.Pack([]kvdb.TupleElement{lastKey}),
+			}
+			iter := tx.GetRange(rng).Iterator()
+			for iter.Advance() {
+				kv, err := iter.Get()
+				if err != nil {
+					return nil, err
+				}
+				d := Delivery{}
+				err = gob.NewDecoder(bytes.NewReader(kv.Value)).Decode(&d)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal delivery")
+				}
+				deliveries = append(deliveries, &d)
+			}
+			return nil, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Requeue moves a dead letter back to the outbox with a fresh set of attempts
+func (r *DeliveryRepository) Requeue(
+	ctx context.Context, id string, dueAt utc.UTC,
+) error {
+	_, err := kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			key := r.deadLetter.Pack([]kvdb.TupleElement{id})
+			data, err := tx.Get(key).Get()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.NotFound
+			}
+			d := Delivery{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal delivery")
+			}
+
+			tx.Clear(key)
+			d.Attempts = 0
+			d.DueAt = dueAt
+			return nil, r.Enqueue(ctx, &d)
+		},
+	)
+	return err
+}
+
+func (r *DeliveryRepository) outboxKey(d *Delivery) kvdb.Key {
+	return r.outbox.Pack([]kvdb.TupleElement{int64(d.DueAt), d.ID})
+}
@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/spine/log"
+	"github.com/deixis/storage/kvdb"
+	"github.com/segmentio/ksuid"
+)
+
+// secretLength is the number of random bytes used to generate endpoint secrets
+const secretLength = 32
+
+type Service struct {
+	endpoints  *EndpointRepository
+	deliveries *DeliveryRepository
+	iam        *iam.Service
+}
+
+func New(ctx context.Context) (*Service, error) {
+	endpoints, err := NewEndpointRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise endpoint repository")
+	}
+	deliveries, err := NewDeliveryRepository(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise delivery repository")
+	}
+	iams, err := iam.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise iam service")
+	}
+
+	return &Service{
+		endpoints:  endpoints,
+		deliveries: deliveries,
+		iam:        iams,
+	}, nil
+}
+
+// Publish adds event `t` with payload `v` to the outbox of every endpoint
+// subscribed to it.
+//
+// It joins the transaction carried by `ctx` (if any), so the event is only
+// published when the change it describes is committed.
+func (s *Service) Publish(ctx context.Context, t EventType, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal event data")
+	}
+	evt := Event{
+		ID:        ksuid.New().String(),
+		Type:      t,
+		CreatedAt: utc.Now(),
+		Data:      data,
+	}
+
+	_, err = kvdb.Transact(ctx,
+		func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+			endpoints, err := s.endpoints.List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range endpoints {
+				if !e.Subscribed(t) {
+					continue
+				}
+				err := s.deliveries.Enqueue(ctx, &Delivery{
+					ID:         ksuid.New().String(),
+					EndpointID: e.ID,
+					Event:      evt,
+					DueAt:      evt.CreatedAt,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		},
+	)
+	return err
+}
+
+func (s *Service) RegisterEndpoint(
+	ctx context.Context, rawURL string, events []EventType, secret string,
+) (*Endpoint, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "url",
+			Description: "The endpoint URL must be an absolute http(s) URL",
+		})
+	}
+	for _, t := range events {
+		if !isEventType(t) {
+			return nil, errors.Bad(&errors.FieldViolation{
+				Field:       "events",
+				Description: "Unknown event type " + t.String(),
+			})
+		}
+	}
+	if secret == "" {
+		secret, err = generateSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Trace(ctx, "webhook.endpoint.register", "Register endpoint",
+		log.String("url", u.String()),
+	)
+
+	e := &Endpoint{
+		ID:     ksuid.New().String(),
+		URL:    u.String(),
+		Secret: secret,
+		Events: events,
+	}
+	if err := s.endpoints.Create(ctx, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *Service) ListEndpoints(ctx context.Context) ([]*Endpoint, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	endpoints, err := s.endpoints.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Secrets are only revealed on creation
+	for _, e := range endpoints {
+		e.Secret = ""
+	}
+	return endpoints, nil
+}
+
+func (s *Service) DeleteEndpoint(ctx context.Context, id string) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+	return s.endpoints.Delete(ctx, id)
+}
+
+func (s *Service) ListDeadLetters(ctx context.Context) ([]*Delivery, error) {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.deliveries.DeadLetters(ctx)
+}
+
+// RetryDeadLetter schedules a dead letter for an immediate delivery
+func (s *Service) RetryDeadLetter(ctx context.Context, id string) error {
+	if err := s.iam.RequireRole(ctx, iam.RoleAdmin); err != nil {
+		return err
+	}
+	return s.deliveries.Requeue(ctx, id, utc.Now())
+}
+
+// Sign returns the signature of `body` sent on the `Webhook-Signature` header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func isEventType(t EventType) bool {
+	for _, et := range EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, secretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate secret")
+	}
+	return hex.EncodeToString(b), nil
+}
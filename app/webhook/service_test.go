@@ -0,0 +1,241 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/app/job"
+	"github.com/basgys/booking-consensys/app/webhook"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/pkg/utc"
+	"github.com/deixis/storage/kvdb"
+)
+
+// TestDispatcher_Deliver ensures a published event is delivered and signed
+// with the endpoint secret
+func TestDispatcher_Deliver(t *testing.T) {
+	ctx, err := loadAdmin(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	received := make(chan *webhook.Event, 1)
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := r.Header.Get("Webhook-Signature"), webhook.Sign("s3cr3t", body); got != want {
+			t.Errorf("expect signature %s, but got %s", want, got)
+		}
+		evt := webhook.Event{}
+		if err := json.Unmarshal(body, &evt); err != nil {
+			t.Error("failed to unmarshal event", err)
+		}
+		received <- &evt
+	}))
+	defer srv.Close()
+
+	svc, err := webhook.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	_, err = svc.RegisterEndpoint(ctx, srv.URL, []webhook.EventType{
+		webhook.EventReservationCreated,
+	}, "s3cr3t")
+	if err != nil {
+		t.Fatal("error registering endpoint", err)
+	}
+
+	if err := svc.Publish(ctx, webhook.EventReservationCancelled, "ignored"); err != nil {
+		t.Fatal("error publishing event", err)
+	}
+	if err := svc.Publish(ctx, webhook.EventReservationCreated, "foo"); err != nil {
+		t.Fatal("error publishing event", err)
+	}
+
+	dispatcher, err := webhook.NewDispatcher(ctx)
+	if err != nil {
+		t.Fatal("error initialising dispatcher", err)
+	}
+	if err := dispatcher.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+
+	select {
+	case evt := <-received:
+		if evt.Type != webhook.EventReservationCreated {
+			t.Errorf("expect event %s, but got %s", webhook.EventReservationCreated, evt.Type)
+		}
+	default:
+		t.Fatal("expect event to be delivered")
+	}
+	select {
+	case evt := <-received:
+		t.Error("expect unsubscribed event to be skipped, but got", evt.Type)
+	default:
+	}
+}
+
+// TestDispatcher_Retry ensures a failed delivery is kept in the outbox and
+// retried later
+func TestDispatcher_Retry(t *testing.T) {
+	ctx, err := loadAdmin(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	svc, err := webhook.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	if _, err = svc.RegisterEndpoint(ctx, srv.URL, nil, ""); err != nil {
+		t.Fatal("error registering endpoint", err)
+	}
+	if err := svc.Publish(ctx, webhook.EventReservationCreated, "foo"); err != nil {
+		t.Fatal("error publishing event", err)
+	}
+
+	dispatcher, err := webhook.NewDispatcher(ctx)
+	if err != nil {
+		t.Fatal("error initialising dispatcher", err)
+	}
+	if err := dispatcher.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+
+	deliveries, err := webhook.NewDeliveryRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	due, err := deliveries.Due(ctx, utc.Now(), 10)
+	if err != nil {
+		t.Fatal("error loading due deliveries", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("expect no due deliveries, but got %d", len(due))
+	}
+	due, err = deliveries.Due(ctx, utc.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal("error loading due deliveries", err)
+	}
+	if len(due) != 1 {
+		t.Fatalf("expect 1 delivery to be retried, but got %d", len(due))
+	}
+	if due[0].Attempts != 1 {
+		t.Errorf("expect 1 attempt, but got %d", due[0].Attempts)
+	}
+}
+
+// TestDispatcher_LongRun ensures a run which outlasts the lease of the
+// dispatcher job is interrupted in time, and the deliveries left are kept for
+// the next run without counting as failed attempts
+func TestDispatcher_LongRun(t *testing.T) {
+	ctx, err := loadAdmin(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+
+	stop := make(chan struct{})
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	svc, err := webhook.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+	if _, err = svc.RegisterEndpoint(ctx, srv.URL, nil, ""); err != nil {
+		t.Fatal("error registering endpoint", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := svc.Publish(ctx, webhook.EventReservationCreated, i); err != nil {
+			t.Fatal("error publishing event", err)
+		}
+	}
+
+	scheduler, err := job.NewScheduler(ctx)
+	if err != nil {
+		t.Fatal("error initialising scheduler", err)
+	}
+	scheduler.LeaseTimeout = 300 * time.Millisecond
+	dispatcher, err := webhook.NewDispatcher(ctx)
+	if err != nil {
+		t.Fatal("error initialising dispatcher", err)
+	}
+	if err := dispatcher.Schedule(ctx, scheduler); err != nil {
+		t.Fatal("error scheduling dispatcher", err)
+	}
+
+	start := time.Now()
+	if err := scheduler.Dispatch(ctx); err != nil {
+		t.Fatal("error dispatching", err)
+	}
+	if d := time.Since(start); d >= scheduler.LeaseTimeout {
+		t.Errorf("expect run to stop before the lease expires, but took %s", d)
+	}
+
+	deliveries, err := webhook.NewDeliveryRepository(ctx)
+	if err != nil {
+		t.Fatal("error opening repository", err)
+	}
+	due, err := deliveries.Due(ctx, utc.Now(), 10)
+	if err != nil {
+		t.Fatal("error loading due deliveries", err)
+	}
+	if len(due) != 5 {
+		t.Fatalf("expect 5 deliveries left, but got %d", len(due))
+	}
+	for _, d := range due {
+		if d.Attempts != 0 {
+			t.Errorf("expect interrupted delivery not to count as an attempt, but got %d", d.Attempts)
+		}
+	}
+}
+
+// TestService_AdminOnly ensures endpoints can only be managed by admins
+func TestService_AdminOnly(t *testing.T) {
+	ctx, err := loadAdmin(t.Name())
+	if err != nil {
+		t.Fatal("error loading storage", err)
+	}
+	svc, err := webhook.New(ctx)
+	if err != nil {
+		t.Fatal("error initialising service", err)
+	}
+
+	ctx = iam.WithContext(ctx, &iam.Account{UserID: "nobody"})
+	_, err = svc.RegisterEndpoint(ctx, "https://example.com", nil, "")
+	if err == nil {
+		t.Error("expect non-admin to be denied")
+	}
+}
+
+// loadAdmin opens a storage and attaches an admin account to the context
+func loadAdmin(name string) (context.Context, error) {
+	ctx := context.Background()
+	ctx = kvdb.WithContext(ctx, memory.New())
+
+	users, err := iam.NewUserRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	usr := &iam.User{ID: "admin", Roles: []iam.Role{iam.RoleAdmin}}
+	if err := users.Create(ctx, usr); err != nil {
+		return nil, err
+	}
+	return iam.WithContext(ctx, &iam.Account{UserID: usr.ID}), nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+
+	"github.com/basgys/booking-consensys/app/auth/ethereum"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/ethereum/go-ethereum/crypto"
+	"net/http"
+)
+
+// Challenge returns a login challenge for account `address`, which must be
+// signed and sent back with Authorise
+func (c *Client) Challenge(ctx context.Context, address iam.Address) (string, error) {
+	res := struct {
+		Challenge string `json:"challenge"`
+	}{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   "/auth/challenge",
+		body: struct {
+			Address iam.Address `json:"address"`
+		}{Address: address},
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Challenge, nil
+}
+
+// Authorise exchanges the `signature` of the last challenge of `address` for
+// a JWT
+func (c *Client) Authorise(
+	ctx context.Context, address iam.Address, signature string,
+) (string, error) {
+	res := struct {
+		Token string `json:"token"`
+	}{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   "/auth/authorise",
+		body: struct {
+			Address   iam.Address `json:"address"`
+			Signature string      `json:"signature"`
+		}{Address: address, Signature: signature},
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Token, nil
+}
+
+// Login signs a challenge with private key `key` to obtain a JWT. The token
+// is sent on all subsequent requests.
+func (c *Client) Login(ctx context.Context, key *ecdsa.PrivateKey) (string, error) {
+	address := iam.Address(crypto.PubkeyToAddress(key.PublicKey))
+	challenge, err := c.Challenge(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	signature, err := ethereum.Sign(challenge, key)
+	if err != nil {
+		return "", err
+	}
+	token, err := c.Authorise(ctx, address, signature)
+	if err != nil {
+		return "", err
+	}
+	c.Token = token
+	return token, nil
+}
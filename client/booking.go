@@ -0,0 +1,412 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/deixis/pkg/utc"
+)
+
+// ListRooms returns a page of rooms and the cursor of the next page
+func (c *Client) ListRooms(
+	ctx context.Context, p booking.Page,
+) ([]*booking.Room, string, error) {
+	res := struct {
+		Rooms []*booking.Room `json:"rooms"`
+		Next  string          `json:"next"`
+	}{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   "/booking/rooms",
+		query:  pageQuery(url.Values{}, p),
+	}, &res)
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Rooms, res.Next, nil
+}
+
+// GetRoom returns room `ref`
+func (c *Client) GetRoom(ctx context.Context, ref string) (*booking.Room, error) {
+	room := &booking.Room{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   roomPath(ref),
+	}, room)
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// CreateRoom creates `room` (admins only)
+func (c *Client) CreateRoom(ctx context.Context, room *booking.Room) (*booking.Room, error) {
+	created := &booking.Room{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   "/booking/rooms",
+		body:   room,
+	}, created)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateRoom replaces `room` (admins only). The update is rejected when the
+// room has changed since `room.Version`, unless it is 0.
+func (c *Client) UpdateRoom(ctx context.Context, room *booking.Room) (*booking.Room, error) {
+	updated := &booking.Room{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPut,
+		path:   roomPath(room.Ref),
+		header: ifMatch(room.Version),
+		body:   room,
+	}, updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteRoom deletes room `ref` at `version` (admins only). Any version
+// matches when `version` is 0.
+func (c *Client) DeleteRoom(ctx context.Context, ref string, version uint64) error {
+	_, err := c.do(ctx, &request{
+		method: http.MethodDelete,
+		path:   roomPath(ref),
+		header: ifMatch(version),
+	}, nil)
+	return err
+}
+
+// RoomsAvailabilities returns the free slots of at least `d` across all rooms
+// between `from` and `to`
+func (c *Client) RoomsAvailabilities(
+	ctx context.Context, from, to utc.UTC, d time.Duration,
+) ([]*booking.TimeInterval, error) {
+	res := struct {
+		Slots []*booking.TimeInterval `json:"slots"`
+	}{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   "/booking/rooms/availabilities",
+		query:  availabilitiesQuery(from, to, d),
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Slots, nil
+}
+
+// RoomAvailabilities returns the free slots of at least `d` of room `ref`
+// between `from` and `to`
+func (c *Client) RoomAvailabilities(
+	ctx context.Context, ref string, from, to utc.UTC, d time.Duration,
+) ([]*booking.TimeInterval, error) {
+	res := struct {
+		Availabilities []*booking.TimeInterval `json:"availabilities"`
+	}{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   roomPath(ref) + "/availabilities",
+		query:  availabilitiesQuery(from, to, d),
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Availabilities, nil
+}
+
+// ListRoomReservations returns a page of the reservations of room `ref` and
+// the cursor of the next page
+func (c *Client) ListRoomReservations(
+	ctx context.Context, ref string, f booking.ReservationFilter,
+) ([]*booking.Reservation, string, error) {
+	res := struct {
+		Reservations []*booking.Reservation `json:"reservations"`
+		Next         string                 `json:"next"`
+	}{}
+	q := pageQuery(url.Values{}, f.Page)
+	setTime(q, "from", f.From)
+	setTime(q, "to", f.To)
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   roomPath(ref) + "/reservations",
+		query:  q,
+	}, &res)
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Reservations, res.Next, nil
+}
+
+// GetRoomReservation returns reservation `id` of room `ref`
+func (c *Client) GetRoomReservation(
+	ctx context.Context, ref, id string,
+) (*booking.Reservation, error) {
+	res := &booking.Reservation{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   reservationPath(ref, id),
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReserveRoom reserves a room
+func (c *Client) ReserveRoom(
+	ctx context.Context, r booking.BookingRequest,
+) (*booking.Reservation, error) {
+	res, _, err := c.ReserveRoomOnce(ctx, "", r)
+	return res, err
+}
+
+// ReserveRoomOnce reserves a room with idempotency key `key`. Retrying with
+// the same key returns the reservation already created, and `replayed` is
+// then set.
+func (c *Client) ReserveRoomOnce(
+	ctx context.Context, key string, r booking.BookingRequest,
+) (res *booking.Reservation, replayed bool, err error) {
+	var header http.Header
+	if key != "" {
+		header = http.Header{"Idempotency-Key": []string{key}}
+	}
+	res = &booking.Reservation{}
+	resp, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   roomPath(r.RoomRef) + "/reservations",
+		header: header,
+		body:   bookingBody(r),
+	}, res)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, resp.Header.Get("Idempotent-Replayed") == "true", nil
+}
+
+// RescheduleRoomReservation moves reservation `id` of room `ref` at
+// `version` to `from` for `hours`. Any version matches when `version` is 0.
+func (c *Client) RescheduleRoomReservation(
+	ctx context.Context, ref, id string, version uint64, from utc.UTC, hours int64,
+) (*booking.Reservation, error) {
+	res := &booking.Reservation{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPut,
+		path:   reservationPath(ref, id),
+		header: ifMatch(version),
+		body: struct {
+			From  utc.UTC `json:"from"`
+			Hours int64   `json:"hours"`
+		}{From: from, Hours: hours},
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CancelRoomReservation cancels reservation `id` of room `ref` at `version`.
+// Any version matches when `version` is 0.
+func (c *Client) CancelRoomReservation(
+	ctx context.Context, ref, id string, version uint64,
+) error {
+	_, err := c.do(ctx, &request{
+		method: http.MethodDelete,
+		path:   reservationPath(ref, id),
+		header: ifMatch(version),
+	}, nil)
+	return err
+}
+
+// CheckInRoomReservation checks in reservation `id` of room `ref`
+func (c *Client) CheckInRoomReservation(
+	ctx context.Context, ref, id string,
+) (*booking.Reservation, error) {
+	res := &booking.Reservation{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   reservationPath(ref, id) + "/check-in",
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListCancelledReservations returns the cancelled reservations of room `ref`
+func (c *Client) ListCancelledReservations(
+	ctx context.Context, ref string,
+) ([]*booking.Reservation, error) {
+	return c.listReservations(ctx, roomPath(ref)+"/cancellations")
+}
+
+// ListMyReservations returns the reservations of the current user
+func (c *Client) ListMyReservations(ctx context.Context) ([]*booking.Reservation, error) {
+	return c.listReservations(ctx, "/booking/me/reservations")
+}
+
+// ListUserReservations returns the reservations of user `id`
+func (c *Client) ListUserReservations(
+	ctx context.Context, id string,
+) ([]*booking.Reservation, error) {
+	return c.listReservations(ctx, "/booking/users/"+url.PathEscape(id)+"/reservations")
+}
+
+func (c *Client) listReservations(
+	ctx context.Context, path string,
+) ([]*booking.Reservation, error) {
+	res := struct {
+		Reservations []*booking.Reservation `json:"reservations"`
+	}{}
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: path}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Reservations, nil
+}
+
+// RequestRoom requests a room which requires approval
+func (c *Client) RequestRoom(
+	ctx context.Context, r booking.BookingRequest,
+) (*booking.ApprovalRequest, error) {
+	req := &booking.ApprovalRequest{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   roomPath(r.RoomRef) + "/requests",
+		body:   bookingBody(r),
+	}, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ListApprovalRequests returns the approval requests of room `ref` with
+// `status` (all of them when empty)
+func (c *Client) ListApprovalRequests(
+	ctx context.Context, ref string, status booking.ApprovalStatus,
+) ([]*booking.ApprovalRequest, error) {
+	q := url.Values{}
+	if status != "" {
+		q.Set("status", string(status))
+	}
+	res := struct {
+		Requests []*booking.ApprovalRequest `json:"requests"`
+	}{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   roomPath(ref) + "/requests",
+		query:  q,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Requests, nil
+}
+
+// GetApprovalRequest returns approval request `id` of room `ref`
+func (c *Client) GetApprovalRequest(
+	ctx context.Context, ref, id string,
+) (*booking.ApprovalRequest, error) {
+	req := &booking.ApprovalRequest{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodGet,
+		path:   requestPath(ref, id),
+	}, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ApproveRequest approves request `id` of room `ref`
+func (c *Client) ApproveRequest(
+	ctx context.Context, ref, id, reason string,
+) (*booking.ApprovalRequest, error) {
+	return c.decide(ctx, requestPath(ref, id)+"/approve", reason)
+}
+
+// RejectRequest rejects request `id` of room `ref`
+func (c *Client) RejectRequest(
+	ctx context.Context, ref, id, reason string,
+) (*booking.ApprovalRequest, error) {
+	return c.decide(ctx, requestPath(ref, id)+"/reject", reason)
+}
+
+func (c *Client) decide(
+	ctx context.Context, path, reason string,
+) (*booking.ApprovalRequest, error) {
+	req := &booking.ApprovalRequest{}
+	_, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   path,
+		body: struct {
+			Reason string `json:"reason,omitempty"`
+		}{Reason: reason},
+	}, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// bookingBody returns the request body of booking `r`
+func bookingBody(r booking.BookingRequest) interface{} {
+	return struct {
+		From       utc.UTC `json:"from"`
+		Hours      int64   `json:"hours"`
+		Seats      int     `json:"seats,omitempty"`
+		OnBehalfOf string  `json:"onBehalfOf,omitempty"`
+	}{
+		From:       r.From,
+		Hours:      r.Hours,
+		Seats:      r.Seats,
+		OnBehalfOf: r.OnBehalfOf,
+	}
+}
+
+func roomPath(ref string) string {
+	return "/booking/rooms/" + url.PathEscape(ref)
+}
+
+func reservationPath(ref, id string) string {
+	return roomPath(ref) + "/reservations/" + url.PathEscape(id)
+}
+
+func requestPath(ref, id string) string {
+	return roomPath(ref) + "/requests/" + url.PathEscape(id)
+}
+
+func pageQuery(q url.Values, p booking.Page) url.Values {
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if p.Limit > 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	return q
+}
+
+func availabilitiesQuery(from, to utc.UTC, d time.Duration) url.Values {
+	q := url.Values{}
+	setTime(q, "from", from)
+	setTime(q, "to", to)
+	if d > 0 {
+		q.Set("duration", strconv.FormatInt(int64(d/time.Minute), 10))
+	}
+	return q
+}
+
+// setTime sets parameter `k` to time `t`, unless it is zero
+func setTime(q url.Values, k string, t utc.UTC) {
+	if !t.IsZero() {
+		q.Set(k, t.String())
+	}
+}
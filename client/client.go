@@ -0,0 +1,201 @@
+// Package client is a Go client for the booking REST API
+//
+// It uses the same types as the services (e.g. booking.Room), and returns
+// API errors as deixis errors, so that callers can check them with
+// errors.IsNotFound, errors.IsAborted, etc.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/httputil"
+)
+
+// Client sends requests to the booking API
+type Client struct {
+	// URL is the base URL of the API (e.g. http://localhost:8484)
+	URL string
+	// Token is the JWT sent on every request. It is set by Login.
+	Token string
+	// HTTP sends the requests (http.DefaultClient when nil)
+	HTTP *http.Client
+}
+
+// New returns a client for the API served on `baseURL`
+func New(baseURL string) *Client {
+	return &Client{URL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// request describes an API call
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	// body is encoded to JSON
+	body interface{}
+}
+
+// do sends `r` and decodes the JSON response body to `v` (unless nil). It
+// returns the response, whose body has already been closed.
+func (c *Client) do(ctx context.Context, r *request, v interface{}) (*http.Response, error) {
+	u := c.URL + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	var body io.Reader
+	if r.body != nil {
+		data, err := json.Marshal(r.body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode request body")
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(r.method, u, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	req = req.WithContext(ctx)
+	for k, values := range r.header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	if r.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpc := c.HTTP
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+	res, err := httpc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return res, decodeError(res)
+	}
+	if v == nil || res.StatusCode == http.StatusNoContent {
+		return res, nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return res, errors.Wrap(err, "failed to decode response body")
+	}
+	return res, nil
+}
+
+// errorPayload is the body of error responses
+type errorPayload struct {
+	Error struct {
+		Message string `json:"message"`
+		Details []struct {
+			FieldViolations []struct {
+				Field       string `json:"field"`
+				Description string `json:"description"`
+			} `json:"field_violations"`
+			Violations []struct {
+				Type        string `json:"type"`
+				Subject     string `json:"subject"`
+				Description string `json:"description"`
+			} `json:"violations"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// decodeError returns the error described by error response `res`
+func decodeError(res *http.Response) error {
+	p := errorPayload{}
+	data, _ := ioutil.ReadAll(res.Body) // Ignore errors
+	json.Unmarshal(data, &p)            // Bodies are not always JSON (e.g. 404)
+	msg := p.Error.Message
+	if msg == "" {
+		msg = http.StatusText(res.StatusCode)
+	}
+	parent := errors.New(msg)
+
+	switch res.StatusCode {
+	case http.StatusBadRequest:
+		var violations []*errors.FieldViolation
+		for _, d := range p.Error.Details {
+			for _, v := range d.FieldViolations {
+				violations = append(violations, &errors.FieldViolation{
+					Field:       v.Field,
+					Description: v.Description,
+				})
+			}
+		}
+		if len(violations) > 0 {
+			return errors.Bad(violations...)
+		}
+		return errors.WithBad(parent)
+	case http.StatusUnauthorized:
+		return errors.WithUnauthenticated(parent)
+	case http.StatusForbidden:
+		return errors.WithPermissionDenied(parent)
+	case http.StatusNotFound:
+		return errors.WithNotFound(parent)
+	case http.StatusConflict:
+		return errors.WithAborted(parent)
+	case http.StatusPreconditionFailed:
+		var violations []*errors.PreconditionViolation
+		for _, d := range p.Error.Details {
+			for _, v := range d.Violations {
+				violations = append(violations, &errors.PreconditionViolation{
+					Type:        v.Type,
+					Subject:     v.Subject,
+					Description: v.Description,
+				})
+			}
+		}
+		if len(violations) > 0 {
+			return errors.FailedPrecondition(violations...)
+		}
+		return errors.WithFailedPrecondition(parent)
+	case http.StatusTooManyRequests:
+		var violations []*errors.QuotaViolation
+		for _, d := range p.Error.Details {
+			for _, v := range d.Violations {
+				violations = append(violations, &errors.QuotaViolation{
+					Subject:     v.Subject,
+					Description: v.Description,
+				})
+			}
+		}
+		if len(violations) > 0 {
+			return errors.ResourceExhausted(violations...)
+		}
+		return errors.WithResourceExhausted(parent)
+	case http.StatusServiceUnavailable:
+		d, _ := httputil.ParseRetryAfter(res.Header)
+		return errors.WithUnavailable(parent, d)
+	case http.StatusGatewayTimeout:
+		return context.DeadlineExceeded
+	default:
+		return errors.Errorf("unexpected status %d: %s", res.StatusCode, msg)
+	}
+}
+
+// ifMatch returns the header to change version `v` of a record. Any
+// version matches when `v` is 0.
+func ifMatch(v uint64) http.Header {
+	if v == 0 {
+		return nil
+	}
+	return http.Header{"If-Match": []string{`"` + strconv.FormatUint(v, 10) + `"`}}
+}
@@ -0,0 +1,191 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app/auth/ethereum"
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/basgys/booking-consensys/client"
+	"github.com/deixis/errors"
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/pkg/utc"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestLogin ensures challenges are signed with the private key to obtain a
+// token, which is then sent on every request
+func TestLogin(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal("expect to generate key, but got", err)
+	}
+
+	auth := ethereum.Auth{}
+	var mu sync.Mutex
+	challenges := map[iam.Address]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/challenge", func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Address iam.Address `json:"address"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		ch, _ := auth.Challenge(req.Address)
+		mu.Lock()
+		challenges[req.Address] = ch
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"challenge": ch})
+	})
+	mux.HandleFunc("/auth/authorise", func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Address   iam.Address `json:"address"`
+			Signature string      `json:"signature"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		ch := challenges[req.Address]
+		mu.Unlock()
+		if err := auth.Verify(req.Address, ch, req.Signature); err != nil {
+			httperrors.Marshal(r, w, errors.PermissionDenied)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+	})
+	mux.HandleFunc("/booking/me/reservations", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			httperrors.Marshal(r, w, errors.PermissionDenied)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"reservations": []*booking.Reservation{{ID: "1", RoomRef: "C01"}},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	if _, err := c.ListMyReservations(ctx); !errors.IsPermissionDenied(err) {
+		t.Errorf("expect anonymous request to be denied, but got %v", err)
+	}
+
+	token, err := c.Login(ctx, key)
+	if err != nil {
+		t.Fatal("expect login to succeed, but got", err)
+	}
+	if token != "secret" || c.Token != token {
+		t.Errorf("expect token to be set, but got %q", c.Token)
+	}
+
+	reservations, err := c.ListMyReservations(ctx)
+	if err != nil {
+		t.Fatal("expect to list reservations, but got", err)
+	}
+	if len(reservations) != 1 || reservations[0].RoomRef != "C01" {
+		t.Errorf("expect 1 reservation, but got %v", reservations)
+	}
+
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal("expect to generate key, but got", err)
+	}
+	ch, err := c.Challenge(ctx, iam.Address(crypto.PubkeyToAddress(key.PublicKey)))
+	if err != nil {
+		t.Fatal("expect challenge, but got", err)
+	}
+	signature, _ := ethereum.Sign(ch, other)
+	_, err = c.Authorise(ctx, iam.Address(crypto.PubkeyToAddress(key.PublicKey)), signature)
+	if !errors.IsPermissionDenied(err) {
+		t.Errorf("expect signature of another key to be denied, but got %v", err)
+	}
+}
+
+// TestReserveRoom ensures reservations are sent as the API expects them, and
+// that error payloads are decoded to error kinds
+func TestReserveRoom(t *testing.T) {
+	var got struct {
+		From       utc.UTC `json:"from"`
+		Hours      int64   `json:"hours"`
+		OnBehalfOf string  `json:"onBehalfOf"`
+	}
+	var key string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/booking/rooms/C01/reservations":
+			json.NewDecoder(r.Body).Decode(&got)
+			key = r.Header.Get("Idempotency-Key")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&booking.Reservation{
+				ID:      "1",
+				RoomRef: "C01",
+				From:    got.From,
+				Version: 1,
+			})
+		case "/booking/rooms/C02/reservations":
+			httperrors.Marshal(r, w, errors.Aborted())
+		case "/booking/rooms/C03/reservations":
+			httperrors.Marshal(r, w, errors.Bad(
+				&errors.FieldViolation{Field: "from", Description: "Invalid"},
+				&errors.FieldViolation{Field: "hours", Description: "Invalid"},
+			))
+		case "/booking/rooms/C04/reservations":
+			httperrors.Marshal(r, w, errors.FailedPrecondition(
+				&errors.PreconditionViolation{Type: "policy", Subject: "min_notice", Description: "Too late"},
+			))
+		default:
+			httperrors.Marshal(r, w, errors.NotFound)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	from := utc.MustParse("2021-08-01T12:00:00Z")
+
+	res, replayed, err := c.ReserveRoomOnce(ctx, "abc", booking.BookingRequest{
+		RoomRef:    "C01",
+		From:       from,
+		Hours:      2,
+		OnBehalfOf: "foo",
+	})
+	if err != nil {
+		t.Fatal("expect reservation to succeed, but got", err)
+	}
+	if got.From != from || got.Hours != 2 || got.OnBehalfOf != "foo" || key != "abc" {
+		t.Errorf("unexpected request %+v with key %q", got, key)
+	}
+	if res.ID != "1" || res.From != from || !replayed {
+		t.Errorf("unexpected reservation %+v (replayed %v)", res, replayed)
+	}
+
+	_, err = c.ReserveRoom(ctx, booking.BookingRequest{RoomRef: "C02", From: from, Hours: 1})
+	if !errors.IsAborted(err) {
+		t.Errorf("expect conflict, but got %v", err)
+	}
+
+	_, err = c.ReserveRoom(ctx, booking.BookingRequest{RoomRef: "C03"})
+	if bad, ok := err.(*errors.BadRequest); !ok || len(bad.Violations) != 2 {
+		t.Errorf("expect 2 field violations, but got %v", err)
+	} else if bad.Violations[1].Field != "hours" {
+		t.Errorf("expect violation on hours, but got %v", bad.Violations[1])
+	}
+
+	_, err = c.ReserveRoom(ctx, booking.BookingRequest{RoomRef: "C04", From: from, Hours: 1})
+	if pf, ok := err.(*errors.PreconditionFailure); !ok || len(pf.Violations) != 1 {
+		t.Errorf("expect precondition violation, but got %v", err)
+	} else if pf.Violations[0].Subject != "min_notice" {
+		t.Errorf("expect violation on min_notice, but got %v", pf.Violations[0])
+	}
+
+	if _, err := c.GetRoom(ctx, "C05"); !errors.IsNotFound(err) {
+		t.Errorf("expect room not to be found, but got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+)
+
+// timeLayouts are the formats accepted for times. Times without a zone are
+// in local time.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+func login(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("login", "[-keystore file] [-passphrase-file file] [-token jwt]")
+	keyFile := fs.String("keystore", os.Getenv("BOOKING_KEYSTORE"), "Ethereum keystore file of the account")
+	passFile := fs.String("passphrase-file", "", "File containing the keystore passphrase (default: $BOOKING_PASSPHRASE or prompt)")
+	token := fs.String("token", "", "Save an existing token instead of signing a challenge")
+	fs.Parse(args)
+
+	if *token != "" {
+		e.cfg.Token = *token
+		if err := e.cfg.save(e.cfgPath); err != nil {
+			return err
+		}
+		e.out.message("Token saved")
+		return nil
+	}
+	if *keyFile == "" {
+		return errors.New("missing -keystore (or -token)")
+	}
+
+	data, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return errors.Wrap(err, "error reading keystore")
+	}
+	passphrase, err := readPassphrase(*passFile)
+	if err != nil {
+		return err
+	}
+	key, err := keystore.DecryptKey(data, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "error decrypting keystore")
+	}
+
+	session, err := e.client.Login(ctx, key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	e.cfg.Token = session
+	if err := e.cfg.save(e.cfgPath); err != nil {
+		return err
+	}
+	e.out.message("Logged in as " + key.Address.Hex())
+	return nil
+}
+
+// readPassphrase reads the keystore passphrase from `file`, the environment
+// or the standard input
+func readPassphrase(file string) (string, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrap(err, "error reading passphrase")
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if p, ok := os.LookupEnv("BOOKING_PASSPHRASE"); ok {
+		return p, nil
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "error reading passphrase")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func logout(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("logout", "")
+	fs.Parse(args)
+
+	e.cfg.Token = ""
+	if err := e.cfg.save(e.cfgPath); err != nil {
+		return err
+	}
+	e.out.message("Logged out")
+	return nil
+}
+
+func listRooms(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("rooms", "")
+	fs.Parse(args)
+
+	rooms, err := allRooms(ctx, e)
+	if err != nil {
+		return err
+	}
+	return e.out.rooms(rooms)
+}
+
+// allRooms returns the rooms of all pages
+func allRooms(ctx context.Context, e *env) ([]*booking.Room, error) {
+	var rooms []*booking.Room
+	page := booking.Page{}
+	for {
+		l, next, err := e.client.ListRooms(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, l...)
+		if next == "" {
+			return rooms, nil
+		}
+		page.Cursor = next
+	}
+}
+
+func availability(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("availability", "[-day YYYY-MM-DD] [-start hour] [-end hour] [room...]")
+	day := fs.String("day", time.Now().Format("2006-01-02"), "Day to show (local time)")
+	start := fs.Int("start", 8, "First hour of the timeline")
+	end := fs.Int("end", 18, "Last hour of the timeline")
+	fs.Parse(args)
+
+	d, err := time.ParseInLocation("2006-01-02", *day, time.Local)
+	if err != nil {
+		return errors.Bad(&errors.FieldViolation{Field: "day", Description: "Must be YYYY-MM-DD"})
+	}
+	if *start < 0 || *end > 24 || *start >= *end {
+		return errors.Bad(&errors.FieldViolation{Field: "start", Description: "Must be before end, between 0 and 24"})
+	}
+
+	refs := fs.Args()
+	if len(refs) == 0 {
+		rooms, err := allRooms(ctx, e)
+		if err != nil {
+			return err
+		}
+		for _, r := range rooms {
+			refs = append(refs, r.Ref)
+		}
+	}
+
+	t := &timeline{
+		From: time.Date(d.Year(), d.Month(), d.Day(), *start, 0, 0, 0, time.Local),
+		To:   time.Date(d.Year(), d.Month(), d.Day(), *end, 0, 0, 0, time.Local),
+	}
+	for _, ref := range refs {
+		free, err := e.client.RoomAvailabilities(ctx, ref,
+			utc.Convert(t.From), utc.Convert(t.To), 0,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "error loading availabilities of %s", ref)
+		}
+		t.Rooms = append(t.Rooms, &roomAvailabilities{Ref: ref, Availabilities: free})
+	}
+	return e.out.timeline(t)
+}
+
+func reserve(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("reserve", "-from time [-hours n] [-seats n] [-for user] <room>")
+	from := fs.String("from", "", "Start of the reservation, on the hour (e.g. 2021-08-02 10:00)")
+	hours := fs.Int64("hours", 1, "Length of the reservation")
+	seats := fs.Int("seats", 0, "Seats to reserve in a shared room")
+	onBehalfOf := fs.String("for", "", "User to reserve for (you must be their delegate)")
+	key := fs.String("key", "", "Idempotency key to safely retry the reservation")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	t, err := parseTime(*from)
+	if err != nil {
+		return errors.Bad(&errors.FieldViolation{Field: "from", Description: err.Error()})
+	}
+	res, _, err := e.client.ReserveRoomOnce(ctx, *key, booking.BookingRequest{
+		RoomRef:    fs.Arg(0),
+		From:       utc.Convert(t),
+		Hours:      *hours,
+		Seats:      *seats,
+		OnBehalfOf: *onBehalfOf,
+	})
+	if err != nil {
+		return err
+	}
+	return e.out.reservations([]*booking.Reservation{res})
+}
+
+func cancel(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("cancel", "[-version n] <room> <reservation>")
+	version := fs.Uint64("version", 0, "Only cancel this version of the reservation")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := e.client.CancelRoomReservation(ctx, fs.Arg(0), fs.Arg(1), *version); err != nil {
+		return err
+	}
+	e.out.message("Reservation " + fs.Arg(1) + " cancelled")
+	return nil
+}
+
+func listMine(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("mine", "")
+	fs.Parse(args)
+
+	reservations, err := e.client.ListMyReservations(ctx)
+	if err != nil {
+		return err
+	}
+	return e.out.reservations(reservations)
+}
+
+// newFlagSet returns the flag set of command `name` with arguments `args`
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: booking %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseTime parses `s` with one of the accepted layouts
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Must be a time like 2021-08-02 10:00 or RFC 3339")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/basgys/booking-consensys/app/auth/ethereum"
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/basgys/booking-consensys/app/iam"
+	"github.com/deixis/errors"
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/pkg/utc"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestLogin ensures the token obtained by signing a challenge with a keystore
+// is cached, sent by subsequent commands and forgotten on logout
+func TestLogin(t *testing.T) {
+	dir := t.TempDir()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal("expect to generate key, but got", err)
+	}
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.ImportECDSA(key, "p4ss")
+	if err != nil {
+		t.Fatal("expect to import key, but got", err)
+	}
+	passFile := filepath.Join(dir, "passphrase")
+	if err := ioutil.WriteFile(passFile, []byte("p4ss\n"), 0600); err != nil {
+		t.Fatal("expect to write passphrase, but got", err)
+	}
+
+	auth := ethereum.Auth{}
+	var mu sync.Mutex
+	challenges := map[iam.Address]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/challenge", func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Address iam.Address `json:"address"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		ch, _ := auth.Challenge(req.Address)
+		mu.Lock()
+		challenges[req.Address] = ch
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"challenge": ch})
+	})
+	mux.HandleFunc("/auth/authorise", func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Address   iam.Address `json:"address"`
+			Signature string      `json:"signature"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		ch := challenges[req.Address]
+		mu.Unlock()
+		if err := auth.Verify(req.Address, ch, req.Signature); err != nil {
+			httperrors.Marshal(r, w, errors.PermissionDenied)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+	})
+	mux.HandleFunc("/booking/me/reservations", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			httperrors.Marshal(r, w, errors.PermissionDenied)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"reservations": []*booking.Reservation{{ID: "r1", RoomRef: "C01"}},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	cfgPath := filepath.Join(dir, "booking", "config.json")
+
+	e, out := newTestEnv(t, cfgPath, srv.URL, "table")
+	if err := listMine(ctx, e, nil); !errors.IsPermissionDenied(err) {
+		t.Error("expect anonymous command to be denied, but got", err)
+	}
+	err = login(ctx, e, []string{"-keystore", account.URL.Path, "-passphrase-file", passFile})
+	if err != nil {
+		t.Fatal("expect to log in, but got", err)
+	}
+	if want := "Logged in as " + account.Address.Hex(); !strings.Contains(out.String(), want) {
+		t.Errorf("expect %q, but got %q", want, out.String())
+	}
+	fi, err := os.Stat(cfgPath)
+	if err != nil {
+		t.Fatal("expect config to be saved, but got", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expect config to be private, but got %s", fi.Mode())
+	}
+
+	// The next command loads the cached token and URL
+	e, out = newTestEnv(t, cfgPath, "", "table")
+	if e.cfg.URL != srv.URL || e.cfg.Token != "secret" {
+		t.Errorf("expect session to be cached, but got %+v", e.cfg)
+	}
+	if err := listMine(ctx, e, nil); err != nil {
+		t.Fatal("expect cached token to be sent, but got", err)
+	}
+	if !strings.Contains(out.String(), "r1") {
+		t.Errorf("expect reservation r1 to be listed, but got %q", out.String())
+	}
+
+	if err := logout(ctx, e, nil); err != nil {
+		t.Fatal("expect to log out, but got", err)
+	}
+	e, _ = newTestEnv(t, cfgPath, "", "table")
+	if e.cfg.Token != "" {
+		t.Errorf("expect token to be forgotten, but got %q", e.cfg.Token)
+	}
+	if err := listMine(ctx, e, nil); !errors.IsPermissionDenied(err) {
+		t.Error("expect command to be denied after logout, but got", err)
+	}
+
+	// Existing tokens can be saved as is
+	if err := login(ctx, e, []string{"-token", "secret"}); err != nil {
+		t.Fatal("expect to save token, but got", err)
+	}
+	e, _ = newTestEnv(t, cfgPath, "", "table")
+	if e.cfg.Token != "secret" {
+		t.Errorf("expect token to be saved, but got %q", e.cfg.Token)
+	}
+}
+
+// TestReserve ensures reservations are sent as requested and that errors of
+// the API are returned
+func TestReserve(t *testing.T) {
+	var got struct {
+		From       utc.UTC `json:"from"`
+		Hours      int64   `json:"hours"`
+		Seats      int     `json:"seats"`
+		OnBehalfOf string  `json:"onBehalfOf"`
+	}
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/booking/rooms/C01/reservations":
+			json.NewDecoder(r.Body).Decode(&got)
+			key = r.Header.Get("Idempotency-Key")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&booking.Reservation{
+				ID:      "r1",
+				RoomRef: "C01",
+				From:    got.From,
+				Seats:   got.Seats,
+				Status:  booking.ReservationActive,
+				Version: 1,
+			})
+		case r.Method == http.MethodPost && r.URL.Path == "/booking/rooms/C02/reservations":
+			httperrors.Marshal(r, w, errors.Aborted(&errors.ConflictViolation{
+				Resource:    "reservation",
+				Description: "There is already a reservation on this range",
+			}))
+		default:
+			httperrors.Marshal(r, w, errors.NotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e, out := newTestEnv(t, filepath.Join(t.TempDir(), "config.json"), srv.URL, "table")
+
+	err := reserve(ctx, e, []string{
+		"-from", "2021-08-02 10:00", "-hours", "2", "-seats", "3", "-for", "foo", "-key", "k1", "C01",
+	})
+	if err != nil {
+		t.Fatal("expect to reserve, but got", err)
+	}
+	from, _ := parseTime("2021-08-02 10:00")
+	if got.From != utc.Convert(from) || got.Hours != 2 || got.Seats != 3 || got.OnBehalfOf != "foo" {
+		t.Errorf("unexpected request %+v", got)
+	}
+	if key != "k1" {
+		t.Errorf("expect idempotency key k1, but got %q", key)
+	}
+	if !strings.Contains(out.String(), "r1") || !strings.Contains(out.String(), "C01") {
+		t.Errorf("expect reservation to be printed, but got %q", out.String())
+	}
+
+	err = reserve(ctx, e, []string{"-from", "2021-08-02 10:00", "C02"})
+	if !errors.IsAborted(err) {
+		t.Error("expect conflict, but got", err)
+	}
+	err = reserve(ctx, e, []string{"-from", "tomorrow", "C01"})
+	if bad, ok := err.(*errors.BadRequest); !ok || bad.Violations[0].Field != "from" {
+		t.Error("expect invalid time to be rejected, but got", err)
+	}
+}
+
+// TestCancel ensures the version to cancel is sent as a precondition
+func TestCancel(t *testing.T) {
+	var method, path, ifMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, ifMatch = r.Method, r.URL.Path, r.Header.Get("If-Match")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e, out := newTestEnv(t, filepath.Join(t.TempDir(), "config.json"), srv.URL, "table")
+
+	if err := cancel(ctx, e, []string{"-version", "3", "C01", "r1"}); err != nil {
+		t.Fatal("expect to cancel, but got", err)
+	}
+	if method != http.MethodDelete || path != "/booking/rooms/C01/reservations/r1" || ifMatch != `"3"` {
+		t.Errorf("unexpected request %s %s (If-Match %s)", method, path, ifMatch)
+	}
+	if out.String() != "Reservation r1 cancelled\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+// TestRooms ensures all pages of rooms are listed, and availabilities of all
+// rooms are loaded when none is given
+func TestRooms(t *testing.T) {
+	var availabilities []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/booking/rooms":
+			if r.URL.Query().Get("cursor") == "" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"rooms": []*booking.Room{{Ref: "C01", Name: "Boardroom", Capacity: 12}},
+					"next":  "p2",
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"rooms": []*booking.Room{{Ref: "C02", RequiresApproval: true}},
+			})
+		case strings.HasSuffix(r.URL.Path, "/availabilities"):
+			availabilities = append(availabilities, r.URL.Path)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"availabilities": []*booking.TimeInterval{},
+			})
+		default:
+			httperrors.Marshal(r, w, errors.NotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+
+	e, out := newTestEnv(t, cfgPath, srv.URL, "json")
+	if err := listRooms(ctx, e, nil); err != nil {
+		t.Fatal("expect to list rooms, but got", err)
+	}
+	var rooms []*booking.Room
+	if err := json.Unmarshal(out.Bytes(), &rooms); err != nil {
+		t.Fatal("expect JSON output, but got", err)
+	}
+	if len(rooms) != 2 || rooms[0].Ref != "C01" || rooms[1].Ref != "C02" {
+		t.Errorf("expect rooms of both pages, but got %v", rooms)
+	}
+
+	e, _ = newTestEnv(t, cfgPath, srv.URL, "table")
+	if err := availability(ctx, e, []string{"-day", "2021-08-02"}); err != nil {
+		t.Fatal("expect to show availabilities, but got", err)
+	}
+	want := []string{"/booking/rooms/C01/availabilities", "/booking/rooms/C02/availabilities"}
+	if strings.Join(availabilities, ",") != strings.Join(want, ",") {
+		t.Errorf("expect availabilities of %v, but got %v", want, availabilities)
+	}
+	err := availability(ctx, e, []string{"-start", "18", "-end", "8", "C01"})
+	if bad, ok := err.(*errors.BadRequest); !ok || bad.Violations[0].Field != "start" {
+		t.Error("expect invalid hours to be rejected, but got", err)
+	}
+}
+
+// newTestEnv returns the environment of a command and the buffer it writes to
+func newTestEnv(t *testing.T, cfgPath, url, format string) (*env, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	e, err := newEnv(cfgPath, url, format, out)
+	if err != nil {
+		t.Fatal("expect to load environment, but got", err)
+	}
+	return e, out
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/deixis/errors"
+)
+
+// defaultURL is the API used when none has been configured
+const defaultURL = "http://localhost:8484"
+
+// config is cached between commands
+type config struct {
+	// URL is the base URL of the API
+	URL string `json:"url"`
+	// Token is the JWT of the current session
+	Token string `json:"token,omitempty"`
+}
+
+// defaultConfigPath returns the config file in the user config directory
+// (e.g. ~/.config/booking/config.json)
+func defaultConfigPath() string {
+	if p := os.Getenv("BOOKING_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".booking.json"
+	}
+	return filepath.Join(dir, "booking", "config.json")
+}
+
+// loadConfig reads config file `path`. A missing file gives an empty config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return cfg, nil
+	default:
+		return nil, errors.Wrap(err, "error reading config")
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrapf(err, "invalid config %s", path)
+	}
+	return cfg, nil
+}
+
+// save writes `cfg` to `path`. It is only readable by the current user,
+// because it contains the session token.
+func (cfg *config) save(path string) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "error creating config directory")
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return errors.Wrap(err, "error writing config")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Command booking reserves meeting rooms from the terminal
+//
+// It calls the REST API with the client package. The session token obtained
+// with `booking login` is cached in the user config directory, so that
+// subsequent commands are authenticated.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/basgys/booking-consensys/client"
+	"github.com/deixis/errors"
+)
+
+const usage = `Usage: booking [flags] <command> [arguments]
+
+Commands:
+  login         Sign in with a keystore file (or save an existing token)
+  logout        Forget the cached token
+  rooms         List rooms
+  availability  Show the availability of rooms as a timeline
+  reserve       Reserve a room
+  cancel        Cancel a reservation
+  mine          List my reservations
+
+Run "booking <command> -h" for the arguments of a command.
+
+Flags:
+`
+
+// command runs a subcommand with its arguments
+type command func(ctx context.Context, e *env, args []string) error
+
+var commands = map[string]command{
+	"login":        login,
+	"logout":       logout,
+	"rooms":        listRooms,
+	"availability": availability,
+	"reserve":      reserve,
+	"cancel":       cancel,
+	"mine":         listMine,
+}
+
+// env is shared by all commands
+type env struct {
+	cfg     *config
+	cfgPath string
+	client  *client.Client
+	out     *printer
+}
+
+func main() {
+	flags := flag.NewFlagSet("booking", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	cfgPath := flags.String("config", defaultConfigPath(), "Config file where the session is cached")
+	url := flags.String("url", os.Getenv("BOOKING_URL"), "Base URL of the API (default: cached URL or "+defaultURL+")")
+	format := flags.String("o", "table", "Output format (table or json)")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "booking: unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+	e, err := newEnv(*cfgPath, *url, *format, os.Stdout)
+	if err != nil {
+		fail(err)
+	}
+	if err := cmd(context.Background(), e, flags.Args()[1:]); err != nil {
+		fail(err)
+	}
+}
+
+// newEnv loads the config cached in `cfgPath` and returns an environment
+// which calls the API on `url` (unless empty) and writes results to `w` in
+// `format`
+func newEnv(cfgPath, url, format string, w io.Writer) (*env, error) {
+	out, err := newPrinter(w, format)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if url != "" {
+		cfg.URL = url
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	c := client.New(cfg.URL)
+	c.Token = cfg.Token
+
+	return &env{cfg: cfg, cfgPath: cfgPath, client: c, out: out}, nil
+}
+
+// fail prints `err` and exits
+func fail(err error) {
+	switch err := err.(type) {
+	case *errors.BadRequest:
+		var s []string
+		for _, v := range err.Violations {
+			s = append(s, v.Field+": "+v.Description)
+		}
+		fmt.Fprintln(os.Stderr, "booking: invalid request:", strings.Join(s, ", "))
+	default:
+		if errors.IsPermissionDenied(err) {
+			fmt.Fprintln(os.Stderr, "booking: permission denied (run booking login)")
+			break
+		}
+		fmt.Fprintln(os.Stderr, "booking:", err)
+	}
+	os.Exit(1)
+}
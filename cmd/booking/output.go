@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+)
+
+// displayTime is the layout of times in tables
+const displayTime = "2006-01-02 15:04"
+
+// printer writes results as tables or JSON
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "table":
+		return &printer{w: w}, nil
+	case "json":
+		return &printer{w: w, json: true}, nil
+	default:
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "o",
+			Description: "Must be table or json",
+		})
+	}
+}
+
+// print writes `v` as JSON, or calls `table` to write it as a table
+func (p *printer) print(v interface{}, table func(w io.Writer)) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// message writes `msg` on table outputs only
+func (p *printer) message(msg string) {
+	if !p.json {
+		fmt.Fprintln(p.w, msg)
+	}
+}
+
+func (p *printer) rooms(rooms []*booking.Room) error {
+	return p.print(rooms, func(w io.Writer) {
+		fmt.Fprintln(w, "REF\tNAME\tCAPACITY\tTAGS\tAPPROVAL")
+		for _, r := range rooms {
+			capacity := "whole"
+			if r.Capacity > 0 {
+				capacity = fmt.Sprint(r.Capacity)
+			}
+			approval := ""
+			if r.RequiresApproval {
+				approval = "required"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				r.Ref, r.Name, capacity, strings.Join(r.Tags, ","), approval,
+			)
+		}
+	})
+}
+
+func (p *printer) reservations(reservations []*booking.Reservation) error {
+	return p.print(reservations, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tROOM\tFROM\tTO\tSEATS\tSTATUS\tVERSION")
+		for _, r := range reservations {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%d\n",
+				r.ID, r.RoomRef, localTime(r.From), localTime(r.To),
+				r.Seats, r.Status, r.Version,
+			)
+		}
+	})
+}
+
+// timeline contains the availabilities of rooms over a period
+type timeline struct {
+	From  time.Time             `json:"from"`
+	To    time.Time             `json:"to"`
+	Rooms []*roomAvailabilities `json:"rooms"`
+}
+
+type roomAvailabilities struct {
+	Ref            string                  `json:"ref"`
+	Availabilities []*booking.TimeInterval `json:"availabilities"`
+}
+
+// free returns whether the room is free for the whole hour starting at `h`
+func (r *roomAvailabilities) free(h time.Time) bool {
+	from, to := utc.Convert(h), utc.Convert(h.Add(time.Hour))
+	for _, a := range r.Availabilities {
+		if a.From <= from && to <= a.To {
+			return true
+		}
+	}
+	return false
+}
+
+// timeline writes `t` with one cell per hour, e.g.
+//
+//	        08  09  10  11
+//	C01     ··  ██  ██  ··
+func (p *printer) timeline(t *timeline) error {
+	return p.print(t, func(w io.Writer) {
+		fmt.Fprintf(w, "%s\n\n", t.From.Format("Monday 2 January 2006 (MST)"))
+
+		var header strings.Builder
+		for h := t.From; h.Before(t.To); h = h.Add(time.Hour) {
+			fmt.Fprintf(&header, "%02d  ", h.Hour())
+		}
+		fmt.Fprintf(w, "\t%s\n", header.String())
+
+		for _, r := range t.Rooms {
+			var row strings.Builder
+			for h := t.From; h.Before(t.To); h = h.Add(time.Hour) {
+				if r.free(h) {
+					row.WriteString("··  ")
+				} else {
+					row.WriteString("██  ")
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\n", r.Ref, row.String())
+		}
+		fmt.Fprintln(w, "\n··  free    ██  reserved")
+	})
+}
+
+// localTime formats `t` in local time
+func localTime(t utc.UTC) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Time().In(time.Local).Format(displayTime)
+}
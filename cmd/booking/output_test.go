@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/app/booking"
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/utc"
+)
+
+// TestPrinter_Reservations ensures reservations are written as a table with
+// local times, or as JSON
+func TestPrinter_Reservations(t *testing.T) {
+	reservations := []*booking.Reservation{{
+		ID:      "r1",
+		RoomRef: "C01",
+		From:    utc.MustParse("2021-08-02T10:00:00Z"),
+		To:      utc.MustParse("2021-08-02T12:00:00Z"),
+		Seats:   2,
+		Status:  booking.ReservationActive,
+		Version: 3,
+	}}
+
+	var out bytes.Buffer
+	p, err := newPrinter(&out, "table")
+	if err != nil {
+		t.Fatal("expect table printer, but got", err)
+	}
+	if err := p.reservations(reservations); err != nil {
+		t.Fatal("expect to print reservations, but got", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expect header and 1 row, but got %q", out.String())
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "ID ROOM FROM TO SEATS STATUS VERSION" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	from := reservations[0].From.Time().In(time.Local).Format(displayTime)
+	for _, v := range []string{"r1", "C01", from, "active"} {
+		if !strings.Contains(lines[1], v) {
+			t.Errorf("expect row to contain %q, but got %q", v, lines[1])
+		}
+	}
+	p.message("Done")
+	if !strings.HasSuffix(out.String(), "Done\n") {
+		t.Errorf("expect message on table output, but got %q", out.String())
+	}
+
+	out.Reset()
+	p, err = newPrinter(&out, "json")
+	if err != nil {
+		t.Fatal("expect JSON printer, but got", err)
+	}
+	if err := p.reservations(reservations); err != nil {
+		t.Fatal("expect to print reservations, but got", err)
+	}
+	p.message("Done")
+	var got []*booking.Reservation
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal("expect JSON output only, but got", err)
+	}
+	if len(got) != 1 || *got[0] != *reservations[0] {
+		t.Errorf("expect reservation %+v, but got %v", reservations[0], got)
+	}
+
+	if _, err := newPrinter(&out, "yaml"); !errors.IsBad(err) {
+		t.Error("expect unknown format to be rejected, but got", err)
+	}
+}
+
+// TestPrinter_Timeline ensures every hour of the timeline is shown as free
+// only when the room is free for the whole hour
+func TestPrinter_Timeline(t *testing.T) {
+	from := time.Date(2021, 8, 2, 8, 0, 0, 0, time.UTC)
+	tl := &timeline{
+		From: from,
+		To:   from.Add(4 * time.Hour),
+		Rooms: []*roomAvailabilities{{
+			Ref: "C01",
+			Availabilities: []*booking.TimeInterval{
+				// 08:00-09:30 is free, so only 08 is a free hour
+				{From: utc.Convert(from), To: utc.Convert(from.Add(90 * time.Minute))},
+				{From: utc.Convert(from.Add(3 * time.Hour)), To: utc.Convert(from.Add(5 * time.Hour))},
+			},
+		}},
+	}
+
+	var out bytes.Buffer
+	p, err := newPrinter(&out, "table")
+	if err != nil {
+		t.Fatal("expect table printer, but got", err)
+	}
+	if err := p.timeline(tl); err != nil {
+		t.Fatal("expect to print timeline, but got", err)
+	}
+	var header, row string
+	for _, l := range strings.Split(out.String(), "\n") {
+		switch {
+		case strings.Contains(l, "08  09"):
+			header = l
+		case strings.HasPrefix(l, "C01"):
+			row = l
+		}
+	}
+	if strings.Join(strings.Fields(header), " ") != "08 09 10 11" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if strings.Join(strings.Fields(row), " ") != "C01 ·· ██ ██ ··" {
+		t.Errorf("unexpected row %q", row)
+	}
+}
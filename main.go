@@ -1,46 +1,156 @@
+// Command booking-api serves the booking API, and runs maintenance tasks
+// against its store
+//
+// Maintenance commands open the store directly and exit without starting any
+// server. The store can only be opened by one process at a time, so the API
+// must be stopped first.
+//
+// With -storage=memory, the API keeps its data in memory and loses it when it
+// stops, which is handy for demos.
 package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/basgys/booking-consensys/app"
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
+	"github.com/basgys/booking-consensys/pkg/kvdb/kvretry"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/basgys/booking-consensys/pkg/logutil"
+	"github.com/deixis/errors"
 	"github.com/deixis/spine"
+	"github.com/deixis/spine/config"
 	"github.com/deixis/spine/net/http"
 	"github.com/deixis/storage/kvdb"
 	"github.com/deixis/storage/kvdb/driver/badger"
-	"github.com/pkg/errors"
+	badgerdb "github.com/dgraph-io/badger/v2"
+	toml "github.com/pelletier/go-toml"
 )
 
 var (
 	version = "dirty"
 )
 
+const usage = `Usage: booking-api [-storage path] [command] [arguments]
+
+Options:
+  -storage     Folder of the store, or "memory" to keep data in memory
+               (overrides STORAGE_PATH)
+
+Commands:
+  serve        Serve the HTTP and gRPC APIs (default)
+  seed         Load demo data or a fixture file
+  migrate      Rebuild projections from the reservation log
+  create-user  Create a user, with an account
+  issue-token  Issue a session token for an account
+  export       Write all records to a fixture file
+  import       Import rooms or users from a CSV file
+
+Run "booking-api <command> -h" for the arguments of a command.
+`
+
+// command runs a subcommand with its arguments
+type command func(ctx context.Context, e *env, args []string) error
+
+var commands = map[string]command{
+	"serve":       serve,
+	"seed":        seed,
+	"migrate":     migrate,
+	"create-user": createUser,
+	"issue-token": issueToken,
+	"export":      export,
+	"import":      importCSV,
+}
+
+// env is shared by all commands
+type env struct {
+	cfg   *app.Config
+	block *spine.App
+	store kvdb.Store
+	// out is where results are printed
+	out io.Writer
+}
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	storage := flag.String("storage", "", "")
+	flag.Parse()
+
+	name := "serve"
+	if flag.NArg() > 0 {
+		name = flag.Arg(0)
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "booking-api: unknown command %q\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	var args []string
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
+	}
+
 	// Create spine
-	block, err := spine.New("booking-api", nil)
+	// The app config is read from `[app]`, then overridden by the environment.
+	// Services can also read their own section of `[app]` from the config tree.
+	// Maintenance commands log to stderr, so that their output stays clean.
+	cfg := app.DefaultConfig()
+	block, err := newSpine(cfg, name != "serve")
 	if err != nil {
-		panic(errors.Wrap(err, "error initialising spine"))
+		fail(errors.Wrap(err, "error initialising spine"))
 	}
 	block.Config().Version = version
+	if err := cfg.LoadEnv(os.LookupEnv); err != nil {
+		fail(err)
+	}
+	if *storage != "" {
+		cfg.Storage = *storage
+	}
+	if err := cfg.Validate(); err != nil {
+		fail(err)
+	}
 
 	// Refer to spine instance as the main context
 	var ctx context.Context
 	ctx = block
 
 	// Initialise storage
-	os.Mkdir(".storage", 0770)
-	store, err := badger.Open(".storage")
+	if cfg.Storage == app.StorageMemory && name != "serve" {
+		fail(errors.Errorf("%s requires a persistent storage", name))
+	}
+	store, err := openStore(cfg.Storage)
 	if err != nil {
-		panic(err)
+		fail(errors.Wrap(err, "error opening storage"))
 	}
 	ctx = kvdb.WithContext(ctx, store)
 
+	err = cmd(ctx, &env{cfg: cfg, block: block, store: store, out: os.Stdout}, args)
+	store.Close()
+	if err != nil {
+		fail(err)
+	}
+}
+
+func serve(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("serve", "")
+	fs.Parse(args)
+
 	// Initialises HTTP handler
-	httpPort := parsePort(os.Getenv("HTTP_PORT"))
+	httpPort, err := parsePort("HTTP_PORT")
+	if err != nil {
+		return err
+	}
 	httpServer := http.NewServer()
-	block.RegisterService(&spine.ServiceRegistration{
+	e.block.RegisterService(&spine.ServiceRegistration{
 		Name:   "http.booking-api",
 		Host:   os.Getenv("IP"),
 		Port:   httpPort,
@@ -48,32 +158,126 @@ func main() {
 		Tags:   []string{"http"},
 	})
 
+	// Initialises gRPC handler. It is optional, so the API can still be served
+	// over HTTP only.
+	var grpcServer *grpcutil.Server
+	if os.Getenv("GRPC_PORT") != "" {
+		grpcPort, err := parsePort("GRPC_PORT")
+		if err != nil {
+			return err
+		}
+		grpcServer = grpcutil.NewServer()
+		e.block.RegisterService(&spine.ServiceRegistration{
+			Name:   "grpc.booking-api",
+			Host:   os.Getenv("IP"),
+			Port:   grpcPort,
+			Server: grpcServer,
+			Tags:   []string{"grpc"},
+		})
+	}
+
 	// Init the application
-	app, err := app.New(ctx)
+	a, err := app.New(ctx, e.cfg)
 	if err != nil {
-		panic(errors.Wrap(err, "error initialising app"))
+		return errors.Wrap(err, "error initialising app")
 	}
-	defer app.Close()
+	defer a.Close()
+	e.block.RegisterDrainHandler(func(context.Context) { a.Drain() })
 
-	// Initialise HTTP middlewares and endpoints
-	app.HandleHTTP(httpServer)
+	// Load demo data (development only)
+	if e.cfg.Seed {
+		if err := app.Seed(ctx, e.cfg, e.out); err != nil {
+			return errors.Wrap(err, "error seeding")
+		}
+	}
 
-	// Seed data
-	// TODO: Only run in dev env
-	if err := app.Seed(ctx); err != nil {
-		panic(errors.Wrap(err, "error seeding"))
+	// Initialise HTTP middlewares and endpoints
+	a.HandleHTTP(httpServer)
+	if grpcServer != nil {
+		a.HandleGRPC(grpcServer)
 	}
 
 	// Start serving requests
-	if err := block.Serve(); err != nil {
-		panic(err)
+	return e.block.Serve()
+}
+
+// newSpine loads the config tree from CONFIG_URI and creates spine with it.
+// When `logToStderr` is set, the log printer of the config is replaced with
+// the stderr printer.
+func newSpine(cfg *app.Config, logToStderr bool) (*spine.App, error) {
+	store, err := config.NewStore(os.Getenv("CONFIG_URI"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating config store")
+	}
+	r, err := store.Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading config")
 	}
+	defer r.Close()
+	tree, err := toml.LoadReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing config")
+	}
+
+	if logToStderr {
+		printer, err := toml.TreeFromMap(map[string]interface{}{
+			logutil.StderrName: map[string]interface{}{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		tree.SetPath([]string{"log", "printer"}, printer)
+	}
+	return spine.NewWithConfig("booking-api", strings.NewReader(tree.String()), cfg)
+}
+
+// openStore opens the Badger store in folder `path`, or an empty in-memory
+// store. Transactions which conflict are retried.
+func openStore(path string) (kvdb.Store, error) {
+	if path == app.StorageMemory {
+		return kvretry.Wrap(memory.New(), func(err error) bool {
+			return err == memory.ErrConflict
+		}), nil
+	}
+	os.MkdirAll(path, 0770)
+	store, err := badger.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return kvretry.Wrap(store, func(err error) bool {
+		return err == badgerdb.ErrConflict
+	}), nil
 }
 
-func parsePort(s string) uint16 {
-	p, err := strconv.ParseUint(s, 10, 16)
+// parsePort parses the port set in environment variable `name`
+func parsePort(name string) (uint16, error) {
+	p, err := strconv.ParseUint(os.Getenv(name), 10, 16)
 	if err != nil {
-		panic(err)
+		return 0, errors.Errorf("invalid %s", name)
+	}
+	return uint16(p), nil
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: booking-api %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// fail prints `err` and exits
+func fail(err error) {
+	switch err := err.(type) {
+	case *errors.BadRequest:
+		var s []string
+		for _, v := range err.Violations {
+			s = append(s, v.Field+": "+v.Description)
+		}
+		fmt.Fprintln(os.Stderr, "booking-api: invalid arguments:", strings.Join(s, ", "))
+	default:
+		fmt.Fprintln(os.Stderr, "booking-api:", err)
 	}
-	return uint16(p)
+	os.Exit(1)
 }
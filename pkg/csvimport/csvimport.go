@@ -0,0 +1,192 @@
+// Package csvimport reads CSV files with a header row and collects
+// validation errors by line number
+package csvimport
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/deixis/errors"
+)
+
+// BatchSize is the recommended number of rows written per transaction
+const BatchSize = 100
+
+// Row is a line of a CSV file
+type Row struct {
+	// Line is the line number in the file (the header is on line 1)
+	Line int
+	// Values contains the row values keyed by column name
+	Values map[string]string
+}
+
+// Get returns the trimmed value of column `name`
+func (r *Row) Get(name string) string {
+	return strings.TrimSpace(r.Values[name])
+}
+
+// Read parses all rows from `r`. Column names are read from the first line
+// and normalised to lower case. It fails when the file is malformed or when one
+// of the `required` columns is missing. Rows with too many values are
+// recorded on `report` and skipped.
+func Read(r io.Reader, report *Report, required ...string) ([]*Row, error) {
+	rr := &recordReader{br: bufio.NewReader(r)}
+
+	header, _, err := rr.Read()
+	if err == io.EOF {
+		return nil, errors.Bad(&errors.FieldViolation{
+			Field:       "header",
+			Description: "The file is empty",
+		})
+	}
+	if err != nil {
+		return nil, errors.WithBad(err)
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+	for _, name := range required {
+		if !contains(columns, name) {
+			return nil, errors.Bad(&errors.FieldViolation{
+				Field:       "header",
+				Description: "Missing column " + name,
+			})
+		}
+	}
+
+	var rows []*Row
+	for {
+		record, line, err := rr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, errors.WithBad(err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue // Blank line
+		}
+		report.Rows++
+		if len(record) > len(columns) {
+			report.Fail(line, "", "Expected "+strconv.Itoa(len(columns))+" values, but got "+strconv.Itoa(len(record)))
+			continue
+		}
+
+		row := &Row{Line: line, Values: make(map[string]string, len(columns))}
+		for i, v := range record {
+			row.Values[columns[i]] = v
+		}
+		rows = append(rows, row)
+	}
+}
+
+// recordReader reads CSV records and counts the lines they start on.
+//
+// csv.Reader only reports positions from Go 1.17 (FieldPos), so records are
+// split on line breaks outside of quoted fields, then parsed one at a time.
+type recordReader struct {
+	br *bufio.Reader
+	// line is the number of lines read so far
+	line int
+}
+
+// Read returns the next record and the line it starts on. Empty lines are
+// skipped.
+func (r *recordReader) Read() (record []string, line int, err error) {
+	for {
+		var buf strings.Builder
+		line = r.line + 1
+		quotes := 0
+		for {
+			l, err := r.br.ReadString('\n')
+			if l != "" {
+				r.line++
+				buf.WriteString(l)
+				quotes += strings.Count(l, `"`)
+			}
+			if err == io.EOF && buf.Len() > 0 {
+				break
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			// An odd number of quotes means that a quoted field continues on
+			// the next line
+			if quotes%2 == 0 {
+				break
+			}
+		}
+
+		cr := csv.NewReader(strings.NewReader(buf.String()))
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		record, err = cr.Read()
+		switch e := err.(type) {
+		case nil:
+			return record, line, nil
+		case *csv.ParseError:
+			e.StartLine += line - 1
+			e.Line += line - 1
+			return nil, 0, e
+		}
+		if err != io.EOF {
+			return nil, 0, err
+		}
+	}
+}
+
+// Error is a validation error found on a row
+type Error struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report describes the outcome of an import
+type Report struct {
+	Rows      int      `json:"rows"`
+	Created   int      `json:"created"`
+	Updated   int      `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+	Errors    []*Error `json:"errors,omitempty"`
+	// DryRun is set when rows have only been validated
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Fail records a validation error on line `line`
+func (r *Report) Fail(line int, field, message string) {
+	r.Errors = append(r.Errors, &Error{Line: line, Field: field, Message: message})
+}
+
+// Valid returns whether no validation errors have been recorded
+func (r *Report) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Batches splits `n` rows in batches of BatchSize and calls `f` with the
+// bounds of each batch. It stops on the first error.
+func Batches(n int, f func(start, end int) error) error {
+	for start := 0; start < n; start += BatchSize {
+		end := start + BatchSize
+		if end > n {
+			end = n
+		}
+		if err := f(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
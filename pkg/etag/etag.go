@@ -0,0 +1,112 @@
+// Package etag converts record versions to entity tags and back
+//
+// Versions are exposed as strong entity tags on single records, and
+// `If-Match` preconditions are parsed back to the versions they expect.
+package etag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/deixis/errors"
+)
+
+// Version returns the entity tag of a record at version `v`
+func Version(v uint64) string {
+	return `"` + strconv.FormatUint(v, 10) + `"`
+}
+
+// IfMatch contains the versions listed by an If-Match header. It is empty
+// when any version matches.
+type IfMatch []uint64
+
+// ParseIfMatch parses If-Match header `h`, which is either `*` or a
+// comma-separated list of entity tags (RFC 7232 §3.1).
+//
+// If-Match requires a strong comparison, so weak tags never match. They are
+// skipped, and a list of weak tags only is rejected with a FailedPrecondition
+// error.
+func ParseIfMatch(h string) (IfMatch, error) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return nil, nil
+	}
+
+	var m IfMatch
+	var weak bool
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			weak = true
+			tag = tag[2:]
+		}
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return nil, invalid()
+		}
+		version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, invalid()
+		}
+		if !weak {
+			m = append(m, version)
+		}
+		weak = false
+	}
+	if len(m) == 0 {
+		return nil, errors.FailedPrecondition(&errors.PreconditionViolation{
+			Type:        "VERSION",
+			Subject:     "If-Match",
+			Description: "Weak entity tags cannot match",
+		})
+	}
+	return m, nil
+}
+
+// Resolve returns the version to expect from the record, or 0 when any
+// version matches.
+//
+// When several versions are listed, `current` loads the current version of
+// the record, which is expected when it is listed. Otherwise, it fails with a
+// FailedPrecondition error. The version returned must still be checked when
+// the record is changed, since it could have changed in the meantime.
+func (m IfMatch) Resolve(current func() (uint64, error)) (uint64, error) {
+	switch len(m) {
+	case 0:
+		return 0, nil
+	case 1:
+		return m[0], nil
+	}
+
+	v, err := current()
+	if err != nil {
+		return 0, err
+	}
+	for _, expected := range m {
+		if expected == v {
+			return v, nil
+		}
+	}
+	return 0, errors.FailedPrecondition(&errors.PreconditionViolation{
+		Type:        "VERSION",
+		Subject:     "If-Match",
+		Description: fmt.Sprintf("No entity tag matches version %d", v),
+	})
+}
+
+// Expect parses If-Match header `h` and resolves the version to expect from
+// the record (see IfMatch.Resolve)
+func Expect(h string, current func() (uint64, error)) (uint64, error) {
+	m, err := ParseIfMatch(h)
+	if err != nil {
+		return 0, err
+	}
+	return m.Resolve(current)
+}
+
+func invalid() error {
+	return errors.Bad(&errors.FieldViolation{
+		Field:       "If-Match",
+		Description: "Invalid entity tag",
+	})
+}
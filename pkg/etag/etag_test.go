@@ -0,0 +1,96 @@
+package etag_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/basgys/booking-consensys/pkg/etag"
+	"github.com/deixis/errors"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	table := []struct {
+		header   string
+		versions []uint64
+		check    func(error) bool
+	}{
+		{header: ""},
+		{header: "*"},
+		{header: `"3"`, versions: []uint64{3}},
+		{header: etag.Version(42), versions: []uint64{42}},
+		{header: `"1", "3"`, versions: []uint64{1, 3}},
+		{header: `"1","3" , "4"`, versions: []uint64{1, 3, 4}},
+		{header: `W/"2", "3"`, versions: []uint64{3}},
+		{header: `W/"3"`, check: errors.IsFailedPrecondition},
+		{header: `W/"3", W/"4"`, check: errors.IsFailedPrecondition},
+		{header: `3`, check: errors.IsBad},
+		{header: `"0"`, check: errors.IsBad},
+		{header: `"abc"`, check: errors.IsBad},
+		{header: `"1", abc`, check: errors.IsBad},
+		{header: `"1",`, check: errors.IsBad},
+	}
+
+	for i, test := range table {
+		m, err := etag.ParseIfMatch(test.header)
+		if test.check != nil {
+			if !test.check(err) {
+				t.Errorf("#%d - unexpected error for %q: %v", i, test.header, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d - unexpected error for %q: %v", i, test.header, err)
+			continue
+		}
+		if fmt.Sprint([]uint64(m)) != fmt.Sprint(test.versions) {
+			t.Errorf("#%d - expect versions %v, but got %v", i, test.versions, m)
+		}
+	}
+}
+
+func TestIfMatch_Resolve(t *testing.T) {
+	table := []struct {
+		m       etag.IfMatch
+		current uint64
+		version uint64
+		loaded  bool
+		check   func(error) bool
+	}{
+		{m: nil, current: 5, version: 0},
+		{m: etag.IfMatch{3}, current: 5, version: 3},
+		{m: etag.IfMatch{3, 5}, current: 5, version: 5, loaded: true},
+		{m: etag.IfMatch{3, 4}, current: 5, loaded: true, check: errors.IsFailedPrecondition},
+	}
+
+	for i, test := range table {
+		var loaded bool
+		version, err := test.m.Resolve(func() (uint64, error) {
+			loaded = true
+			return test.current, nil
+		})
+		if loaded != test.loaded {
+			t.Errorf("#%d - expect loaded %t, but got %t", i, test.loaded, loaded)
+		}
+		if test.check != nil {
+			if !test.check(err) {
+				t.Errorf("#%d - unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d - unexpected error: %v", i, err)
+			continue
+		}
+		if version != test.version {
+			t.Errorf("#%d - expect version %d, but got %d", i, test.version, version)
+		}
+	}
+
+	// Errors of the loader are returned as is
+	_, err := etag.IfMatch{3, 4}.Resolve(func() (uint64, error) {
+		return 0, errors.NotFound
+	})
+	if !errors.IsNotFound(err) {
+		t.Error("expect not found, but got", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Package gqlutil serves GraphQL schemas on spine HTTP servers
+//
+// Requests follow the usual GraphQL over HTTP convention: a JSON body with
+// `query`, `operationName` and `variables`. Resolver errors are returned
+// with an `extensions.code` derived from their type (e.g. errors.NotFound
+// gives NOT_FOUND), so that clients do not need to parse messages.
+package gqlutil
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/spine/net/http"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// maxRequestSize is the largest request body accepted (1 MiB)
+const maxRequestSize = 1 << 20
+
+// Request is a GraphQL query sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handler returns an HTTP handler executing queries on `schema`
+func Handler(schema *graphql.Schema) http.ServeFunc {
+	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		defer req.HTTP.Body.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(req.HTTP.Body, maxRequestSize))
+		if err != nil {
+			httperrors.Marshal(req.HTTP, w, err)
+			return
+		}
+		r := Request{}
+		if err := json.Unmarshal(data, &r); err != nil {
+			httperrors.Marshal(req.HTTP, w, errors.Bad(&errors.FieldViolation{
+				Field:       "body",
+				Description: err.Error(),
+			}))
+			return
+		}
+		if r.Query == "" {
+			httperrors.Marshal(req.HTTP, w, errors.Bad(&errors.FieldViolation{
+				Field:       "query",
+				Description: "Missing query",
+			}))
+			return
+		}
+
+		res := Exec(ctx, schema, &r)
+		w.JSON(http.StatusOK, res)
+	}
+}
+
+// Exec executes `r` on `schema` and adds error codes to the response
+func Exec(ctx context.Context, schema *graphql.Schema, r *Request) *graphql.Response {
+	res := schema.Exec(ctx, r.Query, r.OperationName, r.Variables)
+	for _, e := range res.Errors {
+		if e.ResolverError == nil {
+			continue
+		}
+		if e.Extensions == nil {
+			e.Extensions = map[string]interface{}{}
+		}
+		e.Extensions["code"] = Code(e.ResolverError)
+	}
+	return res
+}
+
+// Code returns the GraphQL error code of `err`
+func Code(err error) string {
+	switch err := errors.Cause(err); {
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		return "TIMEOUT"
+	case errors.IsBad(err):
+		return "BAD_REQUEST"
+	case errors.IsUnauthenticated(err):
+		return "UNAUTHENTICATED"
+	case errors.IsPermissionDenied(err):
+		return "PERMISSION_DENIED"
+	case errors.IsNotFound(err):
+		return "NOT_FOUND"
+	case errors.IsFailedPrecondition(err):
+		return "FAILED_PRECONDITION"
+	case errors.IsAborted(err):
+		return "CONFLICT"
+	case errors.IsResourceExhausted(err):
+		return "RESOURCE_EXHAUSTED"
+	case errors.IsUnavailable(err):
+		return "UNAVAILABLE"
+	default:
+		return "INTERNAL"
+	}
+}
@@ -0,0 +1,31 @@
+package grpcutil
+
+import (
+	"context"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/errors/grpcerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MarshalErrors converts errors returned by handlers to gRPC statuses (e.g.
+// errors.NotFound to codes.NotFound). Errors which already are a status are
+// returned as is.
+func MarshalErrors(next UnaryHandler) UnaryHandler {
+	return func(ctx context.Context, info *Info, req interface{}) (interface{}, error) {
+		res, err := next(ctx, info, req)
+		if err == nil {
+			return res, nil
+		}
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		s := grpcerrors.Pack(errors.Cause(err))
+		if s.Code() == codes.Unknown {
+			// Keep the context added to the cause
+			return nil, status.Error(codes.Unknown, err.Error())
+		}
+		return nil, s.Err()
+	}
+}
@@ -0,0 +1,188 @@
+// Package grpcutil provides a spine compatible gRPC server.
+//
+// It mirrors the server of spine/net/grpc, which cannot be used with recent
+// versions of grpc-go because it depends on the removed grpc/naming package.
+package grpcutil
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/deixis/spine/config"
+	"github.com/deixis/spine/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// A Server defines parameters for running a spine compatible gRPC server
+type Server struct {
+	services         []service
+	unaryMiddlewares []UnaryServerMiddleware
+
+	ctx    context.Context
+	config *config.Config
+
+	mu       sync.Mutex
+	draining bool
+	GRPC     *grpc.Server
+}
+
+// NewServer creates a new gRPC server
+func NewServer() *Server {
+	return &Server{
+		unaryMiddlewares: []UnaryServerMiddleware{
+			mwUnaryServerLogging,
+		},
+	}
+}
+
+// RegisterService registers a service and its implementation to the gRPC
+// server. It implements grpc.ServiceRegistrar, so it can be called from the
+// IDL generated code.
+//
+// This must be called before invoking Serve.
+func (s *Server) RegisterService(sd *grpc.ServiceDesc, ss interface{}) {
+	s.services = append(s.services, service{sd: sd, ss: ss})
+}
+
+// AppendUnaryMiddleware appends an unary middleware to the call chain
+func (s *Server) AppendUnaryMiddleware(m UnaryServerMiddleware) {
+	s.unaryMiddlewares = append(s.unaryMiddlewares, m)
+}
+
+// Serve starts serving gRPC requests (blocking call)
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(ctx, lis)
+}
+
+// ServeListener starts serving gRPC requests on `lis` (blocking call)
+func (s *Server) ServeListener(ctx context.Context, lis net.Listener) error {
+	cfg := config.Config{}
+	if err := config.TreeFromContext(ctx).Unmarshal(&cfg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		return lis.Close()
+	}
+	s.ctx = ctx
+	s.config = &cfg
+	s.GRPC = grpc.NewServer(grpc.UnaryInterceptor(s.unaryInterceptor))
+	for _, service := range s.services {
+		s.GRPC.RegisterService(service.sd, service.ss)
+	}
+	// Register reflection service on gRPC server
+	reflection.Register(s.GRPC)
+	s.mu.Unlock()
+
+	log.Trace(ctx, "s.grpc.listen", "Listening...",
+		log.String("addr", lis.Addr().String()),
+	)
+	err := s.GRPC.Serve(lis)
+	if err == grpc.ErrServerStopped && s.isDraining() {
+		return nil
+	}
+	return err
+}
+
+// Drain puts the server into drain mode. It stops accepting new connections
+// and waits for pending requests to finish.
+func (s *Server) Drain() {
+	s.mu.Lock()
+	s.draining = true
+	srv := s.GRPC
+	s.mu.Unlock()
+
+	if srv != nil {
+		srv.GracefulStop()
+	}
+}
+
+// isDraining checks whether the server is draining
+func (s *Server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+func (s *Server) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	rinfo := &Info{
+		FullMethod: info.FullMethod,
+		StartTime:  time.Now(),
+	}
+
+	var cancel func()
+	if s.config.Request.Timeout() > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.config.Request.Timeout())
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// Attach app context services to request context
+	ctx = config.TreeWithContext(ctx, config.TreeFromContext(s.ctx))
+	ctx = log.WithContext(ctx, log.FromContext(s.ctx))
+
+	// Build middleware chain and then call it
+	next := func(ctx context.Context, info *Info, req interface{}) (interface{}, error) {
+		return handler(ctx, req)
+	}
+	for i := len(s.unaryMiddlewares) - 1; i >= 0; i-- {
+		next = s.unaryMiddlewares[i](next)
+	}
+	return next(ctx, rinfo, req)
+}
+
+// Info contains information about a request
+type Info struct {
+	// FullMethod is the full RPC method string, i.e., /package.service/method.
+	FullMethod string
+	// StartTime is the time on which the request hast started
+	StartTime time.Time
+}
+
+type UnaryHandler func(ctx context.Context, info *Info, req interface{}) (interface{}, error)
+type UnaryServerMiddleware func(next UnaryHandler) UnaryHandler
+
+type service struct {
+	sd *grpc.ServiceDesc
+	ss interface{}
+}
+
+// mwUnaryServerLogging logs information about gRPC requests/responses
+func mwUnaryServerLogging(next UnaryHandler) UnaryHandler {
+	return func(ctx context.Context, info *Info, req interface{}) (interface{}, error) {
+		logger := log.FromContext(ctx)
+		logger.Trace("h.grpc.req.start", "Unary request start",
+			log.Type("req", req),
+			log.String("full_method", info.FullMethod),
+		)
+
+		// Next middleware
+		res, err := next(ctx, info, req)
+
+		fields := []log.Field{
+			log.Stringer("code", status.Code(err)),
+			log.Duration("duration", time.Now().Sub(info.StartTime)),
+		}
+		if err != nil {
+			fields = append(fields, log.Error(err))
+		}
+		logger.Trace("h.grpc.req.end", "Unary request end", fields...)
+		return res, err
+	}
+}
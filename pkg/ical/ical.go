@@ -0,0 +1,111 @@
+// Package ical writes iCalendar (RFC 5545) invites, which calendar clients
+// import from email attachments (RFC 6047)
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Method is the iTIP method of a calendar
+type Method string
+
+const (
+	// MethodRequest creates or updates an event
+	MethodRequest Method = "REQUEST"
+	// MethodCancel cancels an event
+	MethodCancel Method = "CANCEL"
+)
+
+// Event is a single calendar event
+type Event struct {
+	// UID identifies the event across updates
+	UID string
+	// Sequence must be incremented every time the event changes
+	Sequence    uint64
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Location    string
+	Description string
+	// Organizer and Attendee are email addresses
+	Organizer string
+	Attendee  string
+}
+
+// maxLineLength is the maximum length of a content line (in octets)
+const maxLineLength = 75
+
+// Invite returns a calendar containing `e`, sent with method `m`
+func Invite(m Method, e *Event, now time.Time) []byte {
+	status := "CONFIRMED"
+	if m == MethodCancel {
+		status = "CANCELLED"
+	}
+
+	var buf bytes.Buffer
+	w := func(name, value string) {
+		fold(&buf, name+":"+value)
+	}
+	w("BEGIN", "VCALENDAR")
+	w("VERSION", "2.0")
+	w("PRODID", "-//booking-consensys//booking-api//EN")
+	w("METHOD", string(m))
+	w("BEGIN", "VEVENT")
+	w("UID", escape(e.UID))
+	w("SEQUENCE", fmt.Sprintf("%d", e.Sequence))
+	w("DTSTAMP", formatTime(now))
+	w("DTSTART", formatTime(e.Start))
+	w("DTEND", formatTime(e.End))
+	w("SUMMARY", escape(e.Summary))
+	if e.Location != "" {
+		w("LOCATION", escape(e.Location))
+	}
+	if e.Description != "" {
+		w("DESCRIPTION", escape(e.Description))
+	}
+	if e.Organizer != "" {
+		w("ORGANIZER", "mailto:"+e.Organizer)
+	}
+	if e.Attendee != "" {
+		w("ATTENDEE;ROLE=REQ-PARTICIPANT", "mailto:"+e.Attendee)
+	}
+	w("STATUS", status)
+	w("END", "VEVENT")
+	w("END", "VCALENDAR")
+	return buf.Bytes()
+}
+
+// formatTime returns `t` in the UTC date-time format
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escape escapes the characters which have a meaning in text values
+func escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// fold writes content line `line`, split into lines of at most 75 octets
+// without breaking UTF-8 sequences
+func fold(buf *bytes.Buffer, line string) {
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > maxLineLength {
+			buf.WriteString("\r\n ")
+			n = 1
+		}
+		buf.WriteRune(r)
+		n += size
+	}
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,48 @@
+package jwtutil
+
+import (
+	"context"
+	"strings"
+
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
+	"github.com/deixis/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// GRPCMiddleware parses JWT from gRPC metadata
+type GRPCMiddleware struct {
+	Secret string
+}
+
+// Parse parses JWT from the `authorization` metadata and add it to the
+// request context
+func (mw *GRPCMiddleware) Parse(next grpcutil.UnaryHandler) grpcutil.UnaryHandler {
+	return func(ctx context.Context, info *grpcutil.Info, req interface{}) (interface{}, error) {
+		// Extract authorization from metadata
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 || values[0] == "" {
+			// Request has no token
+			return next(ctx, info, req)
+		}
+
+		// Check auth metadata validity
+		authParts := strings.Fields(values[0])
+		if len(authParts) != 2 || strings.ToLower(authParts[0]) != "bearer" {
+			return nil, errors.Bad(&errors.FieldViolation{
+				Field:       "authorization",
+				Description: "Authorization metadata format must be \"Bearer {token}\"",
+			})
+		}
+
+		// Parse and validate JWT
+		token, err := parse(mw.Secret, authParts[1])
+		if err != nil {
+			return nil, errors.PermissionDenied
+		}
+
+		// Add JWT to context and move on
+		ctx = WithContext(ctx, token)
+		return next(ctx, info, req)
+	}
+}
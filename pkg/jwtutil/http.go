@@ -16,6 +16,8 @@ var (
 
 	// ErrBadSigningMethod is returned when the given alg is wrong
 	ErrBadSigningMethod = errors.New("bad signing method")
+	// ErrMissingSecret is returned when tokens are verified without secret
+	ErrMissingSecret = errors.New("missing secret")
 )
 
 // HTTPMiddleware parses JWT from HTTP header
@@ -46,13 +48,7 @@ func (mw *HTTPMiddleware) Parse(next http.ServeFunc) http.ServeFunc {
 		tokenString := authHeaderParts[1]
 
 		// Parse and validate JWT
-		claims := jwt.StandardClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, ErrBadSigningMethod
-			}
-			return []byte(mw.Secret), nil
-		})
+		token, err := parse(mw.Secret, tokenString)
 		if err != nil {
 			httperrors.Marshal(req.HTTP, w, errors.PermissionDenied)
 			return
@@ -63,3 +59,17 @@ func (mw *HTTPMiddleware) Parse(next http.ServeFunc) http.ServeFunc {
 		next(ctx, w, req)
 	}
 }
+
+// parse parses and validates JWT `tokenString` signed with `secret`
+func parse(secret, tokenString string) (*jwt.Token, error) {
+	claims := jwt.StandardClaims{}
+	return jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrBadSigningMethod
+		}
+		if secret == "" {
+			return nil, ErrMissingSecret
+		}
+		return []byte(secret), nil
+	})
+}
@@ -0,0 +1,566 @@
+// Package kvdbtest is a suite of behaviour tests for `kvdb.Store` drivers
+//
+// The suite describes the behaviour of the Badger driver, which the app is
+// built on, so other drivers can be swapped with it.
+package kvdbtest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/storage/kvdb"
+)
+
+// Run runs the suite against the stores returned by `open`. Each test opens
+// an empty store, and closes it when it is done.
+func Run(t *testing.T, open func(t *testing.T) kvdb.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store kvdb.Store)
+	}{
+		{"OpenClose", testOpenClose},
+		{"WriteThenRead", testWriteThenRead},
+		{"WriteCommitThenRead", testWriteCommitThenRead},
+		{"WriteClearThenRead", testWriteClearThenRead},
+		{"GetRange", testGetRange},
+		{"GetRangeLimit", testGetRangeLimit},
+		{"GetRangeReverse", testGetRangeReverse},
+		{"GetRangePending", testGetRangePending},
+		{"ClearRange", testClearRange},
+		{"Subspace", testSubspace},
+		{"ValueTooLarge", testValueTooLarge},
+		{"Rollback", testRollback},
+		{"AfterCommitCallback", testAfterCommitCallback},
+		{"AfterRollbackCallback", testAfterRollbackCallback},
+		{"Isolation", testIsolation},
+		{"Conflict", testConflict},
+		{"NoConflict", testNoConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, open(t))
+		})
+	}
+}
+
+var entries = []string{
+	"alpha/123",
+	"beta/123",
+	"beta/456",
+	"beta/789",
+	"gamma/123",
+}
+
+func testOpenClose(t *testing.T, store kvdb.Store) {
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testWriteThenRead writes then read data from the same transaction
+func testWriteThenRead(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	expect := []byte("bar")
+
+	got, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Set(kvdb.Key("foo"), expect)
+		return tx.Get(kvdb.Key("foo")).Get()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expect) != string(got.([]byte)) {
+		t.Errorf("expect %s, but got %s", expect, got)
+	}
+}
+
+// testWriteCommitThenRead writes, commit data, and then read data in another tx
+func testWriteCommitThenRead(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	expect := []byte("bar")
+
+	got, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Set(kvdb.Key("foo"), expect)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("expect v to be nil, but got %v", got)
+	}
+
+	got = get(t, store, "foo")
+	if string(expect) != string(got.([]byte)) {
+		t.Errorf("expect %s, but got %s", expect, got)
+	}
+}
+
+func testWriteClearThenRead(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, "foo")
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Clear(kvdb.Key("foo"))
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := get(t, store, "foo")
+	if len(got.([]byte)) > 0 {
+		t.Errorf("expect empty data, but got %s", got)
+	}
+}
+
+func testGetRange(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, entries...)
+
+	expectKeys(t, getRange(t, store, "a", "z"), entries...)
+	expectKeys(t, getRange(t, store, "alpha/123", "gamma/124"), entries...)
+	// Both bounds are included
+	expectKeys(t, getRange(t, store, "alpha/123", "gamma/123"), entries...)
+	expectKeys(t, getRange(t, store, "beta/123", "beta/456"), "beta/123", "beta/456")
+	expectKeys(t, getRange(t, store, "beta/999", "beta/zzz"))
+}
+
+func testGetRangeLimit(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, entries...)
+
+	for i := 1; i <= 7; i++ {
+		expect := i
+		if expect > len(entries) {
+			expect = len(entries)
+		}
+		expectKeys(t, getRange(t, store, "a", "z", kvdb.WithRangeLimit(i)), entries[:expect]...)
+	}
+}
+
+func testGetRangeReverse(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, entries...)
+
+	reversed := make([]string, len(entries))
+	for i, e := range entries {
+		reversed[len(entries)-1-i] = e
+	}
+	expectKeys(t, getRange(t, store, "a", "z", kvdb.WithRangeReverse(true)), reversed...)
+	// With exact match
+	expectKeys(t, getRange(t, store, "alpha/123", "gamma/123", kvdb.WithRangeReverse(true)), reversed...)
+	// Exact match on a sub-slice
+	expectKeys(t,
+		getRange(t, store, "beta/123", "beta/789", kvdb.WithRangeReverse(true)),
+		"beta/789", "beta/456", "beta/123",
+	)
+	// Limit applies from the end
+	expectKeys(t,
+		getRange(t, store, "a", "z", kvdb.WithRangeReverse(true), kvdb.WithRangeLimit(2)),
+		"gamma/123", "beta/789",
+	)
+}
+
+// testGetRangePending ensures ranges include writes of the transaction
+func testGetRangePending(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, entries...)
+
+	res, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Set(kvdb.Key("beta/000"), nil)
+		tx.Clear(kvdb.Key("beta/456"))
+		return tx.GetRange(kvdb.KeyRange{
+			Begin: kvdb.Key("beta/"),
+			End:   kvdb.Key("beta/z"),
+		}).GetSliceWithError()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, res.([]kvdb.KeyValue), "beta/000", "beta/123", "beta/789")
+}
+
+func testClearRange(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, entries...)
+
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		// Out of range
+		tx.ClearRange(kvdb.KeyRange{
+			Begin: kvdb.Key("alpha/0"),
+			End:   kvdb.Key("alpha/1"),
+		})
+		// Range to delete
+		tx.ClearRange(kvdb.KeyRange{
+			Begin: kvdb.Key("beta/0"),
+			End:   kvdb.Key("beta/z"),
+		})
+		// Out of range
+		tx.ClearRange(kvdb.KeyRange{
+			Begin: kvdb.Key("gamma/2"),
+			End:   kvdb.Key("gamma/z"),
+		})
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, getRange(t, store, "a", "z"), "alpha/123", "gamma/123")
+}
+
+func testSubspace(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	ss, err := store.CreateOrOpenDir([]string{"subsub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := ss.Sub("beta")
+
+	_, err = store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Set(ss.Pack(kvdb.Tuple{"alpha", 123}), nil)
+		tx.Set(ss.Pack(kvdb.Tuple{"beta", 123}), nil)
+		tx.Set(ss.Pack(kvdb.Tuple{"beta", 456}), nil)
+		tx.Set(sub.Pack(kvdb.Tuple{789}), nil)
+		tx.Set(ss.Pack(kvdb.Tuple{"gamma", 123}), nil)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.ReadTransact(context.Background(), func(tx kvdb.ReadTransaction) (v interface{}, err error) {
+		elems, err := tx.GetRange(kvdb.KeyRange{
+			Begin: ss.Pack(kvdb.Tuple{"a"}),
+			End:   ss.Pack(kvdb.Tuple{"z"}),
+		}).GetSliceWithError()
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) != 5 {
+			t.Errorf("expect to get %d elements, but got %d", 5, len(elems))
+		}
+
+		elems, err = tx.GetRange(kvdb.KeyRange{
+			Begin: sub.Pack(kvdb.Tuple{nil}),
+			End:   sub.Pack(kvdb.Tuple{kvdb.UUID{0xFF}}),
+		}).GetSliceWithError()
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) != 3 {
+			t.Fatalf("expect to get %d elements in sub-subspace, but got %d", 3, len(elems))
+		}
+		tup, err := sub.Unpack(elems[2].Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(tup) != 1 || tup[0] != int64(789) {
+			t.Errorf("expect to unpack (789), but got %v", tup)
+		}
+
+		// Test same range without subspace
+		elems, err = tx.GetRange(kvdb.KeyRange{
+			Begin: kvdb.Key("a"),
+			End:   kvdb.Key("z"),
+		}).GetSliceWithError()
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) != 0 {
+			t.Errorf("expect to get no elements, but got %d", len(elems))
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testValueTooLarge ensures values above 10KB are rejected on commit
+func testValueTooLarge(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Set(kvdb.Key("foo"), []byte("bar"))
+		tx.Set(kvdb.Key("large"), make([]byte, 11*1024))
+		return nil, nil
+	})
+	if err == nil {
+		t.Error("expect to get an error with a large value")
+	}
+	expectKeys(t, getRange(t, store, "a", "z"))
+}
+
+// testRollback ensures writes are discarded when a transaction fails
+func testRollback(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.Set(kvdb.Key("foo"), []byte("bar"))
+		return nil, errors.New("trigger a rollback")
+	})
+	if err == nil {
+		t.Error("expect to get an error on rollback")
+	}
+	if got := get(t, store, "foo"); len(got.([]byte)) > 0 {
+		t.Errorf("expect write to be discarded, but got %s", got)
+	}
+}
+
+func testAfterCommitCallback(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	var called bool
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.AfterCommit(func(ctx context.Context) {
+			called = true
+		})
+		tx.Set(kvdb.Key("foo"), []byte("bar"))
+		return nil, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !called {
+		t.Error("expect after commit callback to be called on commit")
+	}
+}
+
+func testAfterRollbackCallback(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	var called bool
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		tx.AfterRollback(func(ctx context.Context) {
+			called = true
+		})
+		return nil, errors.New("trigger a rollback")
+	})
+	if err == nil {
+		t.Error("expect to get an error on rollback")
+	}
+	if !called {
+		t.Error("expect after rollback callback to be called on rollback")
+	}
+}
+
+// testIsolation ensures transactions do not see commits which happened after
+// they started
+func testIsolation(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, "foo")
+
+	_, err := store.ReadTransact(context.Background(), func(tx kvdb.ReadTransaction) (v interface{}, err error) {
+		_, err = store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+			tx.Set(kvdb.Key("foo"), []byte("changed"))
+			tx.Set(kvdb.Key("bar"), []byte("new"))
+			return nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		got, err := tx.Get(kvdb.Key("foo")).Get()
+		if err != nil {
+			return nil, err
+		}
+		if string(got) != "foo" {
+			t.Errorf("expect to read %q, but got %q", "foo", got)
+		}
+		elems, err := tx.GetRange(kvdb.KeyRange{
+			Begin: kvdb.Key("a"),
+			End:   kvdb.Key("z"),
+		}).GetSliceWithError()
+		if err != nil {
+			return nil, err
+		}
+		expectKeys(t, elems, "foo")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := get(t, store, "foo"); string(got.([]byte)) != "changed" {
+		t.Errorf("expect to read %q after commit, but got %q", "changed", got)
+	}
+}
+
+// testConflict ensures a transaction fails when a key it read has been
+// written by another transaction in the meantime
+func testConflict(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, "counter", "counter/a")
+
+	tests := []struct {
+		name string
+		read func(tx kvdb.Transaction) error
+	}{
+		{
+			name: "get",
+			read: func(tx kvdb.Transaction) error {
+				_, err := tx.Get(kvdb.Key("counter")).Get()
+				return err
+			},
+		},
+		{
+			name: "range",
+			read: func(tx kvdb.Transaction) error {
+				_, err := tx.GetRange(kvdb.KeyRange{
+					Begin: kvdb.Key("counter"),
+					End:   kvdb.Key("counter/z"),
+				}).GetSliceWithError()
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var rolledBack bool
+		_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+			tx.AfterRollback(func(ctx context.Context) {
+				rolledBack = true
+			})
+			if err := test.read(tx); err != nil {
+				return nil, err
+			}
+
+			_, err = store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+				tx.Set(kvdb.Key("counter"), []byte("concurrent"))
+				return nil, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			tx.Set(kvdb.Key("counter"), []byte(test.name))
+			return nil, nil
+		})
+		if err == nil {
+			t.Errorf("%s - expect to get a conflict", test.name)
+		}
+		if !rolledBack {
+			t.Errorf("%s - expect after rollback callback to be called on conflict", test.name)
+		}
+		if got := get(t, store, "counter"); string(got.([]byte)) != "concurrent" {
+			t.Errorf("%s - expect to read %q, but got %q", test.name, "concurrent", got)
+		}
+	}
+}
+
+// testNoConflict ensures transactions which do not read the same keys can
+// commit concurrently
+func testNoConflict(t *testing.T, store kvdb.Store) {
+	defer store.Close()
+
+	set(t, store, "x", "y")
+
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		if _, err := tx.Get(kvdb.Key("x")).Get(); err != nil {
+			return nil, err
+		}
+
+		_, err = store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+			if _, err := tx.Get(kvdb.Key("y")).Get(); err != nil {
+				return nil, err
+			}
+			tx.Set(kvdb.Key("y"), []byte("b"))
+			return nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		tx.Set(kvdb.Key("x"), []byte("a"))
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := get(t, store, "x"); string(got.([]byte)) != "a" {
+		t.Errorf("expect to read %q, but got %q", "a", got)
+	}
+	if got := get(t, store, "y"); string(got.([]byte)) != "b" {
+		t.Errorf("expect to read %q, but got %q", "b", got)
+	}
+}
+
+// set commits `keys`, with their name as value
+func set(t *testing.T, store kvdb.Store, keys ...string) {
+	t.Helper()
+
+	_, err := store.Transact(context.Background(), func(tx kvdb.Transaction) (v interface{}, err error) {
+		for _, k := range keys {
+			tx.Set(kvdb.Key(k), []byte(k))
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal("error setting keys", err)
+	}
+}
+
+// get reads `key` in a new transaction
+func get(t *testing.T, store kvdb.Store, key string) interface{} {
+	t.Helper()
+
+	v, err := store.ReadTransact(context.Background(), func(tx kvdb.ReadTransaction) (v interface{}, err error) {
+		return tx.Get(kvdb.Key(key)).Get()
+	})
+	if err != nil {
+		t.Fatal("error getting key", err)
+	}
+	return v
+}
+
+// getRange reads a range in a new transaction
+func getRange(
+	t *testing.T, store kvdb.Store, begin, end string, options ...kvdb.RangeOption,
+) []kvdb.KeyValue {
+	t.Helper()
+
+	v, err := store.ReadTransact(context.Background(), func(tx kvdb.ReadTransaction) (v interface{}, err error) {
+		return tx.GetRange(kvdb.KeyRange{
+			Begin: kvdb.Key(begin),
+			End:   kvdb.Key(end),
+		}, options...).GetSliceWithError()
+	})
+	if err != nil {
+		t.Fatal("error getting range", err)
+	}
+	return v.([]kvdb.KeyValue)
+}
+
+// expectKeys ensures `elems` have the given keys, in order, with their name
+// as value when they have been set with set
+func expectKeys(t *testing.T, elems []kvdb.KeyValue, keys ...string) {
+	t.Helper()
+
+	if len(keys) != len(elems) {
+		t.Fatalf("expect to get %d entries, but got %d", len(keys), len(elems))
+	}
+	for i, elem := range elems {
+		if keys[i] != string(elem.Key) {
+			t.Errorf("expect key %s, but got %s", keys[i], elem.Key)
+		}
+		if len(elem.Value) > 0 && !bytes.Equal(elem.Value, elem.Key) {
+			t.Errorf("expect value %s, but got %s", elem.Key, elem.Value)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package kvdbtest_test
+
+import (
+	"testing"
+
+	"github.com/basgys/booking-consensys/pkg/kvdb/kvdbtest"
+	"github.com/deixis/storage/kvdb"
+	"github.com/deixis/storage/kvdb/driver/badger"
+)
+
+// TestBadger ensures the suite describes the Badger driver
+func TestBadger(t *testing.T) {
+	kvdbtest.Run(t, func(t *testing.T) kvdb.Store {
+		store, err := badger.Open(t.TempDir())
+		if err != nil {
+			t.Fatal("error opening badger", err)
+		}
+		return store
+	})
+}
@@ -0,0 +1,77 @@
+// Package kvretry is a `kvdb.Store` wrapper which retries transactions that
+// conflict with concurrent transactions
+//
+// Drivers with optimistic transactions (Badger, memory) fail a commit when a
+// key read by the transaction has been written in the meantime. Transactions
+// only touch the KV store, so running them again is safe. When they keep
+// conflicting, an Aborted error is returned, which is reported as a
+// `409 Conflict`.
+package kvretry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/storage/kvdb"
+)
+
+const (
+	// DefaultAttempts is the number of times a transaction is run by default
+	DefaultAttempts = 5
+	// baseDelay is the longest delay before the first retry. It doubles after
+	// every conflict until it reaches maxDelay.
+	baseDelay = 5 * time.Millisecond
+	maxDelay  = 100 * time.Millisecond
+)
+
+// Store retries the transactions of the wrapped store on conflict
+type Store struct {
+	kvdb.Store
+
+	// Attempts is the number of times a transaction is run before giving up
+	Attempts int
+
+	conflict func(error) bool
+}
+
+// Wrap returns `s` with retries. `conflict` returns whether an error returned
+// by `s` is a transaction conflict.
+func Wrap(s kvdb.Store, conflict func(error) bool) *Store {
+	return &Store{
+		Store:    s,
+		Attempts: DefaultAttempts,
+		conflict: conflict,
+	}
+}
+
+// Transact implements kvdb.Store
+func (s *Store) Transact(
+	ctx context.Context,
+	f func(kvdb.Transaction) (interface{}, error),
+) (interface{}, error) {
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		v, err := s.Store.Transact(ctx, f)
+		if err == nil || !s.conflict(err) {
+			return v, err
+		}
+		if attempt >= s.Attempts {
+			return nil, errors.Aborted(&errors.ConflictViolation{
+				Resource:    "transaction",
+				Description: "Too many concurrent changes. Please retry",
+			})
+		}
+
+		// Spread retries, so that conflicting transactions do not collide again
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(delay)))):
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
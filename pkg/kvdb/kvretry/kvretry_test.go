@@ -0,0 +1,68 @@
+package kvretry_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/basgys/booking-consensys/pkg/kvdb/kvretry"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/errors"
+	"github.com/deixis/storage/kvdb"
+)
+
+func TestStore_Transact(t *testing.T) {
+	store := &conflictingStore{Store: memory.New(), conflicts: 2}
+	s := kvretry.Wrap(store, func(err error) bool { return err == memory.ErrConflict })
+	ctx := kvdb.WithContext(context.Background(), s)
+
+	_, err := kvdb.Transact(ctx, func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+		tx.Set(kvdb.Key("foo"), []byte("bar"))
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal("expect transaction to be retried, but got", err)
+	}
+	if store.calls != 3 {
+		t.Errorf("expect 3 attempts, but got %d", store.calls)
+	}
+
+	store.calls = 0
+	store.conflicts = kvretry.DefaultAttempts
+	_, err = kvdb.Transact(ctx, func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+		return nil, nil
+	})
+	if !errors.IsAborted(err) {
+		t.Errorf("expect Aborted after %d conflicts, but got %v", kvretry.DefaultAttempts, err)
+	}
+	if store.calls != kvretry.DefaultAttempts {
+		t.Errorf("expect %d attempts, but got %d", kvretry.DefaultAttempts, store.calls)
+	}
+
+	store.calls = 0
+	store.conflicts = 0
+	_, err = kvdb.Transact(ctx, func(ctx context.Context, tx kvdb.Transaction) (interface{}, error) {
+		return nil, errors.NotFound
+	})
+	if !errors.IsNotFound(err) || store.calls != 1 {
+		t.Errorf("expect other errors to be returned as is, but got %v after %d attempts", err, store.calls)
+	}
+}
+
+// conflictingStore fails the first `conflicts` transactions with a conflict
+type conflictingStore struct {
+	kvdb.Store
+	conflicts int
+	calls     int
+}
+
+func (s *conflictingStore) Transact(
+	ctx context.Context,
+	f func(kvdb.Transaction) (interface{}, error),
+) (interface{}, error) {
+	s.calls++
+	v, err := s.Store.Transact(ctx, f)
+	if err == nil && s.calls <= s.conflicts {
+		return nil, memory.ErrConflict
+	}
+	return v, err
+}
@@ -0,0 +1,469 @@
+// Package memory is a `kvdb.Store` implementation which keeps all data in
+// memory
+//
+// It behaves like the Badger driver: transactions read a snapshot of the
+// store taken when they start, and see their own writes. Transactions are
+// optimistic; a commit fails with ErrConflict when a key read by the
+// transaction has been written by another transaction in the meantime.
+//
+// Data is lost when the store is closed, so it is meant for tests and
+// ephemeral demos.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/pkg/unit"
+	"github.com/deixis/storage/kvdb"
+	"github.com/deixis/storage/kvdb/kvhook"
+)
+
+const Driver = "memory"
+
+// maxValueSize is the largest value accepted by Set, as with the Badger
+// driver
+const maxValueSize = 10 * unit.KB
+
+var (
+	// ErrConflict is returned on commit when another transaction has written
+	// a key read by the transaction. The transaction can be retried.
+	ErrConflict = errors.New("transaction conflict, please retry")
+	// ErrClosed is returned when the store has been closed
+	ErrClosed = errors.New("store closed")
+)
+
+// Store is an in-memory store, safe for concurrent use
+type Store struct {
+	mu     sync.Mutex
+	closed bool
+	// data contains all committed pairs sorted by key. It is replaced, never
+	// modified, on commit, so running transactions can keep reading it.
+	data []pair
+	// version is incremented on every commit
+	version uint64
+	// commits are the keys written by commits which happened after the oldest
+	// running transaction started
+	commits []commit
+	// running counts running transactions by version at start
+	running map[uint64]int
+}
+
+type pair struct {
+	key   string
+	value []byte
+}
+
+type commit struct {
+	version uint64
+	keys    map[string]struct{}
+}
+
+// New returns an empty store
+func New() *Store {
+	return &Store{running: map[uint64]int{}}
+}
+
+func (s *Store) Transact(
+	ctx context.Context,
+	f func(kvdb.Transaction) (interface{}, error),
+) (v interface{}, err error) {
+	reg := kvhook.NewRegistry()
+
+	t, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.end(t)
+	t.registry = reg
+	t.reads = map[string]struct{}{}
+	t.writes = map[string]write{}
+
+	v, err = f(t)
+	if err == nil && len(t.errors) > 0 {
+		err = errs(t.errors)
+	}
+	if err == nil {
+		err = s.commit(t)
+	}
+
+	if err == nil {
+		reg.FireEvent(ctx, kvhook.EventAfterCommit)
+	} else {
+		reg.FireEvent(ctx, kvhook.EventAfterRollback)
+	}
+
+	return v, err
+}
+
+func (s *Store) ReadTransact(
+	ctx context.Context,
+	f func(kvdb.ReadTransaction) (interface{}, error),
+) (v interface{}, err error) {
+	t, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.end(t)
+
+	v, err = f(t)
+	if err == nil && len(t.errors) > 0 {
+		err = errs(t.errors)
+	}
+	return v, err
+}
+
+func (s *Store) CreateOrOpenDir(path []string) (kvdb.DirectorySubspace, error) {
+	p := make(kvdb.Tuple, len(path))
+	for i, elem := range path {
+		p[i] = elem
+	}
+	return &subspace{prefix: p}, nil
+}
+
+// Close releases all data. The store cannot be used afterwards.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.data = nil
+	s.commits = nil
+	return nil
+}
+
+// begin starts a transaction on the current snapshot
+func (s *Store) begin(ctx context.Context) (*transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	s.running[s.version]++
+	return &transaction{
+		context:  ctx,
+		snapshot: s.data,
+		version:  s.version,
+	}, nil
+}
+
+// end forgets commits which cannot conflict with running transactions anymore
+func (s *Store) end(t *transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running[t.version]--
+	if s.running[t.version] == 0 {
+		delete(s.running, t.version)
+	}
+
+	oldest := s.version
+	for v := range s.running {
+		if v < oldest {
+			oldest = v
+		}
+	}
+	i := 0
+	for i < len(s.commits) && s.commits[i].version <= oldest {
+		i++
+	}
+	s.commits = s.commits[i:]
+}
+
+// commit applies the writes of `t`, unless a key it read has been written
+// since it started
+func (s *Store) commit(t *transaction) error {
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	for _, c := range s.commits {
+		if c.version <= t.version {
+			continue
+		}
+		for k := range t.reads {
+			if _, ok := c.keys[k]; ok {
+				return ErrConflict
+			}
+		}
+	}
+
+	keys := make(map[string]struct{}, len(t.writes))
+	for k := range t.writes {
+		keys[k] = struct{}{}
+	}
+	s.data = merge(s.data, t.sortedWrites())
+	s.version++
+	s.commits = append(s.commits, commit{version: s.version, keys: keys})
+	return nil
+}
+
+type RangeOptions struct {
+	Limit   int
+	Reverse bool
+}
+
+func (o *RangeOptions) SetLimit(l int) {
+	o.Limit = l
+}
+
+func (o *RangeOptions) SetReverse(r bool) {
+	o.Reverse = r
+}
+
+type transaction struct {
+	context  context.Context
+	snapshot []pair
+	version  uint64
+	errors   []error
+	registry kvhook.TransactionRegistry
+
+	// reads are the keys read from the snapshot (read/write transactions only)
+	reads map[string]struct{}
+	// writes are pending until commit (read/write transactions only)
+	writes map[string]write
+}
+
+type write struct {
+	key     string
+	value   []byte
+	cleared bool
+}
+
+func (t *transaction) Context() context.Context {
+	return t.context
+}
+
+func (t *transaction) Get(key kvdb.Key) kvdb.FutureByteSlice {
+	k := string(key)
+	if w, ok := t.writes[k]; ok {
+		if w.cleared {
+			return futureByteSlice(nil)
+		}
+		return futureByteSlice(w.value)
+	}
+	t.read(k)
+
+	i := sort.Search(len(t.snapshot), func(i int) bool {
+		return t.snapshot[i].key >= k
+	})
+	if i < len(t.snapshot) && t.snapshot[i].key == k {
+		return futureByteSlice(t.snapshot[i].value)
+	}
+	return futureByteSlice(nil)
+}
+
+// GetRange returns pairs with a key between r.Begin and r.End, both included
+func (t *transaction) GetRange(r kvdb.KeyRange, options ...kvdb.RangeOption) kvdb.RangeResult {
+	ro := RangeOptions{}
+	for _, opt := range options {
+		opt(&ro)
+	}
+
+	begin, end := string(r.Begin), string(r.End)
+	lo := sort.Search(len(t.snapshot), func(i int) bool {
+		return t.snapshot[i].key >= begin
+	})
+	hi := sort.Search(len(t.snapshot), func(i int) bool {
+		return t.snapshot[i].key > end
+	})
+	if hi < lo {
+		hi = lo
+	}
+	var writes []write
+	for _, w := range t.sortedWrites() {
+		if begin <= w.key && w.key <= end {
+			writes = append(writes, w)
+		}
+	}
+	pairs := merge(t.snapshot[lo:hi], writes)
+
+	if ro.Reverse {
+		for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+			pairs[i], pairs[j] = pairs[j], pairs[i]
+		}
+	}
+	if ro.Limit > 0 && len(pairs) > ro.Limit {
+		pairs = pairs[:ro.Limit]
+	}
+	return &rangeResult{t: t, pairs: pairs}
+}
+
+func (t *transaction) Set(key kvdb.Key, value []byte) {
+	if unit.Byte(len(value)) > maxValueSize {
+		t.errors = append(t.errors, fmt.Errorf("value too large (%d)", len(value)))
+		return
+	}
+
+	k := string(key)
+	t.writes[k] = write{key: k, value: append([]byte{}, value...)}
+}
+
+func (t *transaction) Clear(key kvdb.Key) {
+	k := string(key)
+	t.writes[k] = write{key: k, cleared: true}
+}
+
+func (t *transaction) ClearRange(r kvdb.KeyRange) {
+	i := t.GetRange(r).Iterator()
+	for i.Advance() {
+		kv, err := i.Get()
+		if err != nil {
+			t.errors = append(t.errors, err)
+			continue
+		}
+		t.Clear(kv.Key)
+	}
+}
+
+func (t *transaction) AfterCommit(f func(ctx context.Context)) {
+	t.registry.AfterCommit(f)
+}
+
+func (t *transaction) AfterRollback(f func(ctx context.Context)) {
+	t.registry.AfterRollback(f)
+}
+
+// read adds key `k` to the read set, which is checked for conflicts on commit
+func (t *transaction) read(k string) {
+	if t.reads != nil {
+		t.reads[k] = struct{}{}
+	}
+}
+
+// sortedWrites returns pending writes sorted by key
+func (t *transaction) sortedWrites() []write {
+	l := make([]write, 0, len(t.writes))
+	for _, w := range t.writes {
+		l = append(l, w)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].key < l[j].key })
+	return l
+}
+
+// merge returns a new list of pairs with `writes` applied to `pairs`. Both
+// lists must be sorted by key.
+func merge(pairs []pair, writes []write) []pair {
+	res := make([]pair, 0, len(pairs)+len(writes))
+	i, j := 0, 0
+	for i < len(pairs) || j < len(writes) {
+		switch {
+		case j == len(writes) || (i < len(pairs) && pairs[i].key < writes[j].key):
+			res = append(res, pairs[i])
+			i++
+		default:
+			if i < len(pairs) && pairs[i].key == writes[j].key {
+				i++
+			}
+			if !writes[j].cleared {
+				res = append(res, pair{key: writes[j].key, value: writes[j].value})
+			}
+			j++
+		}
+	}
+	return res
+}
+
+type subspace struct {
+	prefix kvdb.Tuple
+}
+
+func (s *subspace) Sub(el ...kvdb.TupleElement) kvdb.Subspace {
+	p := make(kvdb.Tuple, 0, len(s.prefix)+len(el))
+	p = append(p, s.prefix...)
+	return &subspace{prefix: append(p, el...)}
+}
+
+func (s *subspace) Pack(t kvdb.Tuple) kvdb.Key {
+	p := make(kvdb.Tuple, 0, len(s.prefix)+len(t))
+	p = append(p, s.prefix...)
+	return kvdb.Key(append(p, t...).Pack())
+}
+
+func (s *subspace) Unpack(k kvdb.Key) (kvdb.Tuple, error) {
+	return kvdb.Unpack(bytes.TrimPrefix(k, s.prefix.Pack()))
+}
+
+// futureByteSlice returns a copy of a value, or nil when the key does not
+// exist
+type futureByteSlice []byte
+
+func (s futureByteSlice) Get() ([]byte, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return append([]byte{}, s...), nil
+}
+
+type rangeResult struct {
+	t     *transaction
+	pairs []pair
+}
+
+func (r *rangeResult) GetSliceWithError() ([]kvdb.KeyValue, error) {
+	i := r.Iterator()
+	var res []kvdb.KeyValue
+	for i.Advance() {
+		elem, err := i.Get()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, elem)
+	}
+	return res, nil
+}
+
+func (r *rangeResult) Iterator() kvdb.RangeIterator {
+	return &rangeIterator{t: r.t, pairs: r.pairs, pos: -1}
+}
+
+type rangeIterator struct {
+	t     *transaction
+	pairs []pair
+	pos   int
+}
+
+func (i *rangeIterator) Advance() bool {
+	if i.pos < len(i.pairs) {
+		i.pos++
+	}
+	if i.pos == len(i.pairs) {
+		return false
+	}
+	i.t.read(i.pairs[i.pos].key)
+	return true
+}
+
+func (i *rangeIterator) Get() (kvdb.KeyValue, error) {
+	if i.pos < 0 || i.pos >= len(i.pairs) {
+		return kvdb.KeyValue{}, errors.New("end of iterator")
+	}
+
+	p := i.pairs[i.pos]
+	return kvdb.KeyValue{
+		Key:   kvdb.Key(p.key),
+		Value: append([]byte{}, p.value...),
+	}, nil
+}
+
+type errs []error
+
+func (e errs) Error() string {
+	var b bytes.Buffer
+	for _, err := range e {
+		b.WriteString(err.Error())
+		b.WriteString(", ")
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package memory_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/basgys/booking-consensys/pkg/kvdb/kvdbtest"
+	"github.com/basgys/booking-consensys/pkg/kvdb/memory"
+	"github.com/deixis/storage/kvdb"
+)
+
+func TestStore(t *testing.T) {
+	kvdbtest.Run(t, func(t *testing.T) kvdb.Store {
+		return memory.New()
+	})
+}
+
+// TestConcurrentIncrements ensures concurrent read-modify-write transactions
+// do not lose updates when they are retried on conflict
+func TestConcurrentIncrements(t *testing.T) {
+	store := memory.New()
+	defer store.Close()
+
+	const n = 50
+	incr := func(tx kvdb.Transaction) (interface{}, error) {
+		v, err := tx.Get(kvdb.Key("counter")).Get()
+		if err != nil {
+			return nil, err
+		}
+		i, _ := strconv.Atoi(string(v))
+		tx.Set(kvdb.Key("counter"), []byte(strconv.Itoa(i+1)))
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, err := store.Transact(context.Background(), incr)
+				if err != memory.ErrConflict {
+					if err != nil {
+						t.Error(err)
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, err := store.ReadTransact(context.Background(), func(tx kvdb.ReadTransaction) (interface{}, error) {
+		return tx.Get(kvdb.Key("counter")).Get()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != strconv.Itoa(n) {
+		t.Errorf("expect counter to be %d, but got %s", n, v)
+	}
+}
@@ -0,0 +1,45 @@
+// Package logutil contains spine log printers
+package logutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/deixis/spine/config"
+	"github.com/deixis/spine/log"
+	"github.com/deixis/spine/log/printer"
+)
+
+// StderrName is the name of the stderr printer in the `[log.printer]` section
+// of the config
+const StderrName = "stderr"
+
+func init() {
+	printer.Register(StderrName, NewStderr)
+}
+
+// NewStderr returns a printer which writes log lines to the standard error,
+// so that they do not mix with the output of commands
+func NewStderr(c config.Tree) (log.Printer, error) {
+	return &Printer{W: os.Stderr}, nil
+}
+
+// Printer writes one log line per call to `W`
+type Printer struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (p *Printer) Print(ctx *log.Context, s string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintln(p.W, s)
+	return err
+}
+
+func (p *Printer) Close() error {
+	return nil
+}
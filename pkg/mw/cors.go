@@ -0,0 +1,58 @@
+package mw
+
+import (
+	"context"
+
+	"github.com/deixis/spine/net/http"
+)
+
+// CORS is a middleware that allows browsers to call the API from other origins
+type CORS struct {
+	// Origins are the allowed origins (e.g. https://example.com), or "*" for
+	// any origin. Cross-origin requests are not allowed when it is empty.
+	Origins []string
+}
+
+// Allow adds CORS headers on responses to allowed origins, and answers their
+// preflight requests
+func (mw *CORS) Allow(next http.ServeFunc) http.ServeFunc {
+	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		origin := req.HTTP.Header.Get("Origin")
+		if origin == "" {
+			next(ctx, w, req)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		allowed := mw.allowed(origin)
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Headers", "*")
+			w.Header().Set("Access-Control-Expose-Headers", "*")
+		}
+		if req.HTTP.Method == "OPTIONS" && req.HTTP.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed == "" {
+				w.Head(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.Head(http.StatusOK)
+			return
+		}
+		next(ctx, w, req)
+	}
+}
+
+// allowed returns the value of the Access-Control-Allow-Origin header for
+// `origin`, or an empty string when it is not allowed
+func (mw *CORS) allowed(origin string) string {
+	for _, o := range mw.Origins {
+		switch o {
+		case "*":
+			return "*"
+		case origin:
+			return origin
+		}
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package mw
 import (
 	"context"
 
+	"github.com/basgys/booking-consensys/pkg/grpcutil"
 	"github.com/deixis/spine/net/http"
 	"github.com/deixis/storage/kvdb"
 )
@@ -17,3 +18,11 @@ func (mw *Store) Inject(next http.ServeFunc) http.ServeFunc {
 		next(ctx, w, req)
 	}
 }
+
+// InjectGRPC is the gRPC equivalent of Inject
+func (mw *Store) InjectGRPC(next grpcutil.UnaryHandler) grpcutil.UnaryHandler {
+	return func(ctx context.Context, info *grpcutil.Info, req interface{}) (interface{}, error) {
+		ctx = kvdb.WithContext(ctx, mw.S)
+		return next(ctx, info, req)
+	}
+}
@@ -0,0 +1,31 @@
+package openapi
+
+import (
+	"context"
+
+	"github.com/deixis/errors/httperrors"
+	"github.com/deixis/spine/net/http"
+	"github.com/gorilla/mux"
+)
+
+// Middleware validates requests before calling `next`. Invalid requests are
+// rejected with a 400 Bad Request listing all field violations.
+func (v *Validator) Middleware(next http.ServeFunc) http.ServeFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r.HTTP)
+		if route == nil {
+			next(ctx, w, r)
+			return
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			next(ctx, w, r)
+			return
+		}
+		if err := v.Validate(r.HTTP, path, r.Params); err != nil {
+			httperrors.Marshal(r.HTTP, w, err)
+			return
+		}
+		next(ctx, w, r)
+	}
+}
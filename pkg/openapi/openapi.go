@@ -0,0 +1,220 @@
+// Package openapi describes HTTP APIs with OpenAPI 3 documents and validates
+// requests against them
+//
+// Only the subset of the specification used by this project is supported:
+// operations with path, query and header parameters, JSON request bodies,
+// and local references to components (`#/components/...`).
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/deixis/errors"
+)
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components,omitempty"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem contains the operations of a path keyed by lowercase HTTP method
+// (e.g. "get")
+type PathItem map[string]*Operation
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes the body of a request
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType describes a representation of a body
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Response describes a response of an operation
+type Response struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Components holds the reusable objects of a document
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas,omitempty"`
+	Parameters map[string]*Parameter `json:"parameters,omitempty"`
+	Responses  map[string]*Response  `json:"responses,omitempty"`
+}
+
+// Schema is a JSON schema
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Parse parses OpenAPI document `data`. It fails when a reference cannot be
+// resolved.
+func Parse(data []byte) (*Document, error) {
+	doc := Document{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal OpenAPI document")
+	}
+	if err := doc.resolve(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Operation returns the operation on `path` with `method`, or nil when the
+// document does not describe it
+func (d *Document) Operation(path, method string) *Operation {
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// resolve ensures all references point to a component
+func (d *Document) resolve() error {
+	for path, item := range d.Paths {
+		for method, op := range *item {
+			if err := d.resolveOperation(op); err != nil {
+				return errors.Wrapf(err, "%s %s", strings.ToUpper(method), path)
+			}
+		}
+	}
+	for name, s := range d.Components.Schemas {
+		if err := d.resolveSchema(s); err != nil {
+			return errors.Wrapf(err, "schema %s", name)
+		}
+	}
+	return nil
+}
+
+func (d *Document) resolveOperation(op *Operation) error {
+	for _, p := range op.Parameters {
+		if _, err := d.parameter(p); err != nil {
+			return err
+		}
+		if err := d.resolveSchema(p.Schema); err != nil {
+			return err
+		}
+	}
+	if op.RequestBody != nil {
+		for _, mt := range op.RequestBody.Content {
+			if err := d.resolveSchema(mt.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range op.Responses {
+		if r.Ref == "" {
+			continue
+		}
+		if _, ok := d.Components.Responses[refName(r.Ref, "responses")]; !ok {
+			return errors.Errorf("unresolved reference %s", r.Ref)
+		}
+	}
+	return nil
+}
+
+func (d *Document) resolveSchema(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		_, err := d.schema(s)
+		return err
+	}
+	for _, p := range s.Properties {
+		if err := d.resolveSchema(p); err != nil {
+			return err
+		}
+	}
+	if err := d.resolveSchema(s.Items); err != nil {
+		return err
+	}
+	return d.resolveSchema(s.AdditionalProperties)
+}
+
+// parameter returns the parameter referenced by `p`, or `p` itself
+func (d *Document) parameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	ref, ok := d.Components.Parameters[refName(p.Ref, "parameters")]
+	if !ok {
+		return nil, errors.Errorf("unresolved reference %s", p.Ref)
+	}
+	return ref, nil
+}
+
+// schema returns the schema referenced by `s`, or `s` itself
+func (d *Document) schema(s *Schema) (*Schema, error) {
+	if s.Ref == "" {
+		return s, nil
+	}
+	ref, ok := d.Components.Schemas[refName(s.Ref, "schemas")]
+	if !ok {
+		return nil, errors.Errorf("unresolved reference %s", s.Ref)
+	}
+	return ref, nil
+}
+
+// refName returns the name of the component referenced by `ref` in section
+// `section` (e.g. "#/components/schemas/Room" gives "Room")
+func refName(ref, section string) string {
+	prefix := "#/components/" + section + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ref, prefix)
+}
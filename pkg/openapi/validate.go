@@ -0,0 +1,370 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	nethttp "net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/deixis/errors"
+)
+
+// maxBodySize is the largest request body validated (1 MiB)
+const maxBodySize = 1 << 20
+
+// Validator validates requests against the operations of a document
+type Validator struct {
+	doc      *Document
+	patterns map[string]*regexp.Regexp
+}
+
+// NewValidator returns a validator for `doc`. It fails when a schema pattern
+// is not a valid regular expression.
+func NewValidator(doc *Document) (*Validator, error) {
+	v := &Validator{doc: doc, patterns: map[string]*regexp.Regexp{}}
+	var compile func(s *Schema) error
+	compile = func(s *Schema) error {
+		if s == nil {
+			return nil
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return errors.Wrapf(err, "invalid pattern %s", s.Pattern)
+			}
+			v.patterns[s.Pattern] = re
+		}
+		for _, p := range s.Properties {
+			if err := compile(p); err != nil {
+				return err
+			}
+		}
+		if err := compile(s.Items); err != nil {
+			return err
+		}
+		return compile(s.AdditionalProperties)
+	}
+	for _, s := range doc.Components.Schemas {
+		if err := compile(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range doc.Components.Parameters {
+		if err := compile(p.Schema); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range doc.Paths {
+		for _, op := range *item {
+			for _, p := range op.Parameters {
+				if err := compile(p.Schema); err != nil {
+					return nil, err
+				}
+			}
+			if op.RequestBody == nil {
+				continue
+			}
+			for _, mt := range op.RequestBody.Content {
+				if err := compile(mt.Schema); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return v, nil
+}
+
+// Validate validates request `r` against the operation on path template
+// `path` (e.g. "/booking/rooms/{rid}"). `params` contains the path
+// parameters.
+//
+// All violations are returned at once in an errors.Bad. Requests to
+// operations missing from the document are not validated. JSON bodies are
+// restored so that handlers can read them again.
+func (v *Validator) Validate(
+	r *nethttp.Request, path string, params map[string]string,
+) error {
+	op := v.doc.Operation(path, r.Method)
+	if op == nil {
+		return nil
+	}
+
+	var violations []*errors.FieldViolation
+	for _, p := range op.Parameters {
+		p, _ = v.doc.parameter(p)
+		var value string
+		var ok bool
+		switch p.In {
+		case "path":
+			value, ok = params[p.Name]
+		case "query":
+			var values []string
+			values, ok = r.URL.Query()[p.Name]
+			if ok {
+				value = values[0]
+			}
+		case "header":
+			value = r.Header.Get(p.Name)
+			ok = value != ""
+		default:
+			continue
+		}
+		if !ok {
+			if p.Required {
+				violations = append(violations, &errors.FieldViolation{
+					Field:       p.Name,
+					Description: fmt.Sprintf("Missing %s parameter", p.In),
+				})
+			}
+			continue
+		}
+		violations = append(violations, v.validateParameter(p, value)...)
+	}
+
+	vs, err := v.validateBody(r, op.RequestBody)
+	if err != nil {
+		return err
+	}
+	violations = append(violations, vs...)
+
+	if len(violations) > 0 {
+		return errors.Bad(violations...)
+	}
+	return nil
+}
+
+// validateParameter validates the string `value` of parameter `p`
+func (v *Validator) validateParameter(p *Parameter, value string) []*errors.FieldViolation {
+	if p.Schema == nil {
+		return nil
+	}
+	s, _ := v.doc.schema(p.Schema)
+	var x interface{} = value
+	switch s.Type {
+	case "integer":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return violation(p.Name, "Must be an integer")
+		}
+		x = json.Number(strconv.FormatInt(i, 10))
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return violation(p.Name, "Must be a number")
+		}
+		x = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return violation(p.Name, "Must be a boolean")
+		}
+		x = b
+	}
+	return v.validateValue(p.Name, s, x)
+}
+
+// validateBody validates the JSON body of `r` against `body`. Bodies of other
+// media types are not validated.
+func (v *Validator) validateBody(
+	r *nethttp.Request, body *RequestBody,
+) ([]*errors.FieldViolation, error) {
+	if body == nil || r.Body == nil {
+		return nil, nil
+	}
+	mt, ok := body.Content["application/json"]
+	if !ok || mt.Schema == nil {
+		return nil, nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		t, _, err := mime.ParseMediaType(ct)
+		if err != nil || t != "application/json" {
+			return nil, nil
+		}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading request body")
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return violation("body", "Missing request body"), nil
+		}
+		return nil, nil
+	}
+
+	var x interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&x); err != nil {
+		return violation("body", err.Error()), nil
+	}
+	return v.validateValue("", mt.Schema, x), nil
+}
+
+// validateValue validates decoded JSON value `x` against schema `s`. `field`
+// is the path leading to `x` (e.g. "notifications.reminderMinutes").
+func (v *Validator) validateValue(field string, s *Schema, x interface{}) []*errors.FieldViolation {
+	s, _ = v.doc.schema(s)
+	if x == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return violation(field, "Cannot be null")
+	}
+
+	var violations []*errors.FieldViolation
+	switch s.Type {
+	case "object":
+		obj, ok := x.(map[string]interface{})
+		if !ok {
+			return violation(field, "Must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				violations = append(violations, violation(join(field, name), "Missing field")...)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ps, ok := s.Properties[name]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			if ps == nil {
+				continue
+			}
+			violations = append(violations, v.validateValue(join(field, name), ps, obj[name])...)
+		}
+	case "array":
+		arr, ok := x.([]interface{})
+		if !ok {
+			return violation(field, "Must be an array")
+		}
+		if s.Items == nil {
+			break
+		}
+		for i, item := range arr {
+			f := fmt.Sprintf("%s[%d]", field, i)
+			violations = append(violations, v.validateValue(f, s.Items, item)...)
+		}
+	case "string":
+		str, ok := x.(string)
+		if !ok {
+			return violation(field, "Must be a string")
+		}
+		violations = append(violations, v.validateString(field, s, str)...)
+	case "integer":
+		n, ok := x.(json.Number)
+		if !ok {
+			return violation(field, "Must be an integer")
+		}
+		if _, err := n.Int64(); err != nil {
+			return violation(field, "Must be an integer")
+		}
+		violations = append(violations, validateNumber(field, s, n)...)
+	case "number":
+		n, ok := x.(json.Number)
+		if !ok {
+			return violation(field, "Must be a number")
+		}
+		violations = append(violations, validateNumber(field, s, n)...)
+	case "boolean":
+		if _, ok := x.(bool); !ok {
+			return violation(field, "Must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, x) {
+		violations = append(violations, violation(field, fmt.Sprintf("Must be one of %s", enumString(s.Enum)))...)
+	}
+	return violations
+}
+
+// validateString validates string `str` against the constraints of `s`
+func (v *Validator) validateString(field string, s *Schema, str string) []*errors.FieldViolation {
+	n := utf8.RuneCountInString(str)
+	if s.MinLength != nil && n < *s.MinLength {
+		return violation(field, fmt.Sprintf("Must be at least %d characters long", *s.MinLength))
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return violation(field, fmt.Sprintf("Must be at most %d characters long", *s.MaxLength))
+	}
+	if re, ok := v.patterns[s.Pattern]; ok && !re.MatchString(str) {
+		return violation(field, fmt.Sprintf("Must match %s", s.Pattern))
+	}
+	switch s.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return violation(field, "Must be an RFC 3339 date-time")
+		}
+	case "duration":
+		if _, err := time.ParseDuration(str); err != nil {
+			return violation(field, "Must be a duration (e.g. 1h30m)")
+		}
+	}
+	return nil
+}
+
+// validateNumber validates number `n` against the bounds of `s`
+func validateNumber(field string, s *Schema, n json.Number) []*errors.FieldViolation {
+	f, err := n.Float64()
+	if err != nil {
+		return violation(field, "Must be a number")
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		return violation(field, fmt.Sprintf("Must be at least %v", *s.Minimum))
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		return violation(field, fmt.Sprintf("Must be at most %v", *s.Maximum))
+	}
+	return nil
+}
+
+// inEnum returns whether `x` is one of `enum`
+func inEnum(enum []interface{}, x interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(x) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
+
+// join returns the path to field `name` of object `field`
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func violation(field, description string) []*errors.FieldViolation {
+	if field == "" {
+		field = "body"
+	}
+	return []*errors.FieldViolation{{Field: field, Description: description}}
+}
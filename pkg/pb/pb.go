@@ -0,0 +1,26 @@
+// Package pb contains the protobuf messages and gRPC services of the API.
+//
+// The *.pb.go files are generated from the *.proto files with `make proto`.
+package pb
+
+import (
+	"github.com/deixis/pkg/utc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Timestamp converts `t` to a protobuf timestamp. The zero time is nil.
+func Timestamp(t utc.UTC) *timestamppb.Timestamp {
+	if t == 0 {
+		return nil
+	}
+	return timestamppb.New(t.Time())
+}
+
+// UTC converts protobuf timestamp `ts` to UTC. A nil timestamp is the zero
+// time.
+func UTC(ts *timestamppb.Timestamp) utc.UTC {
+	if ts == nil {
+		return 0
+	}
+	return utc.Convert(ts.AsTime())
+}
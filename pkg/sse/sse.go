@@ -0,0 +1,227 @@
+// Package sse implements Server-Sent Events on top of spine HTTP servers
+//
+// Spine response writers cannot be flushed, so streaming endpoints must be
+// registered with `sse.Endpoint`, which gives handlers access to the
+// underlying connection.
+//
+// Spine also cancels requests after its request timeout (`[request]
+// timeout_ms`), which is far too short for streams. Stream endpoints therefore
+// go through the spine middlewares as any other endpoint, but their handlers
+// run once spine is done with the request, bound by the timeout of their
+// `Streams` instead.
+package sse
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deixis/errors"
+	"github.com/deixis/spine/net/http"
+	"github.com/gorilla/mux"
+)
+
+// LastEventIDHeader is the header sent by clients when they reconnect
+const LastEventIDHeader = "Last-Event-ID"
+
+type contextKey int
+
+const (
+	activeWriterKey contextKey = iota
+	handoffKey
+)
+
+// Streams bounds the lifetime of the streams served by its endpoints
+type Streams struct {
+	// Timeout is the maximum duration of a stream. Clients are expected to
+	// reconnect afterwards.
+	Timeout time.Duration
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewStreams returns streams which end after `timeout`
+func NewStreams(timeout time.Duration) *Streams {
+	return &Streams{
+		Timeout: timeout,
+		done:    make(chan struct{}),
+	}
+}
+
+// Drain ends all streams in progress, and makes new ones end right away.
+//
+// Streams would otherwise hold the graceful shutdown of the server until
+// they time out.
+func (s *Streams) Drain() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// context returns a context with the values of `values`, which is cancelled
+// with `parent`, after the stream timeout or when the streams are drained
+func (s *Streams) context(
+	parent, values context.Context,
+) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, s.Timeout)
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return &detachedContext{Context: ctx, values: values}, cancel
+}
+
+// detachedContext carries the values of a request context which has ended
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c *detachedContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// handoff passes a stream from spine back to the connection handler
+type handoff struct {
+	ctx   context.Context
+	serve func(ctx context.Context)
+}
+
+// Endpoint returns a GET endpoint serving a stream of events with `f`. The
+// stream is bound by `streams` rather than by the request timeout of the
+// server.
+func Endpoint(
+	path string,
+	streams *Streams,
+	f func(ctx context.Context, w http.ResponseWriter, r *http.Request),
+) http.Endpoint {
+	return &endpoint{
+		path:    path,
+		streams: streams,
+		f:       f,
+	}
+}
+
+type endpoint struct {
+	path    string
+	streams *Streams
+	f       func(ctx context.Context, w http.ResponseWriter, r *http.Request)
+}
+
+func (e *endpoint) Path() string {
+	return e.path
+}
+
+func (e *endpoint) Method() string {
+	return http.GET
+}
+
+func (e *endpoint) Attach(r *mux.Router, f func(nethttp.ResponseWriter, *nethttp.Request)) {
+	r.HandleFunc(e.path, func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		// Spine builds the request context from the original request
+		h := &handoff{}
+		ctx := context.WithValue(req.Context(), activeWriterKey, w)
+		ctx = context.WithValue(ctx, handoffKey, h)
+		f(w, req.WithContext(ctx))
+
+		// The connection stays open until the handler returns
+		if h.serve != nil {
+			ctx, cancel := e.streams.context(req.Context(), h.ctx)
+			defer cancel()
+			h.serve(ctx)
+		}
+	}).Methods(http.GET, http.OPTIONS)
+}
+
+func (e *endpoint) Serve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	h, ok := ctx.Value(handoffKey).(*handoff)
+	if !ok {
+		e.f(ctx, w, r)
+		return
+	}
+	// Let spine end the request, and stream afterwards
+	h.ctx = ctx
+	h.serve = func(ctx context.Context) {
+		e.f(ctx, w, r)
+	}
+}
+
+// Event is a message sent on the stream
+type Event struct {
+	// ID is sent back by the client on the `Last-Event-ID` header when it
+	// reconnects
+	ID   string
+	Name string
+	Data []byte
+}
+
+// Writer writes events on a stream
+type Writer struct {
+	w nethttp.ResponseWriter
+	f nethttp.Flusher
+}
+
+// NewWriter starts a stream on the connection attached to `ctx`. It fails when
+// the endpoint has not been registered with `Endpoint`.
+func NewWriter(ctx context.Context, w http.ResponseWriter) (*Writer, error) {
+	rw, ok := ctx.Value(activeWriterKey).(nethttp.ResponseWriter)
+	if !ok {
+		return nil, errors.New("sse: endpoint must be registered with sse.Endpoint")
+	}
+	f, ok := rw.(nethttp.Flusher)
+	if !ok {
+		return nil, errors.New("sse: connection does not support streaming")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	return &Writer{w: w, f: f}, nil
+}
+
+// Retry tells the client how long to wait (in ms) before reconnecting
+func (w *Writer) Retry(ms int) error {
+	if _, err := fmt.Fprintf(w.w, "retry: %d\n\n", ms); err != nil {
+		return err
+	}
+	w.f.Flush()
+	return nil
+}
+
+// Send writes `e` on the stream
+func (w *Writer) Send(e *Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Name)
+	}
+	for _, line := range strings.Split(string(e.Data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := w.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	w.f.Flush()
+	return nil
+}
+
+// Ping sends a comment to keep the connection alive
+func (w *Writer) Ping() error {
+	if _, err := w.w.Write([]byte(":\n\n")); err != nil {
+		return err
+	}
+	w.f.Flush()
+	return nil
+}
@@ -0,0 +1,111 @@
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basgys/booking-consensys/pkg/sse"
+	"github.com/deixis/spine/config"
+	"github.com/deixis/spine/net/http"
+	lt "github.com/deixis/spine/testing"
+)
+
+type contextKey struct{}
+
+// TestEndpoint_Timeout ensures streams outlive the request timeout of the
+// server, and end after the timeout of their streams or when they are
+// drained
+func TestEndpoint_Timeout(t *testing.T) {
+	tree, err := config.LoadTree(strings.NewReader("[request]\n  timeout_ms = 50\n"))
+	if err != nil {
+		t.Fatal("expect to load config, but got", err)
+	}
+	tt := lt.New(t)
+	ctx, cancel := tt.WithCancel(context.Background())
+	defer cancel()
+	ctx = config.TreeWithContext(ctx, tree)
+
+	streams := sse.NewStreams(300 * time.Millisecond)
+	srv := http.NewServer()
+	srv.Append(func(next http.ServeFunc) http.ServeFunc {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			next(context.WithValue(ctx, contextKey{}, "foo"), w, r)
+		}
+	})
+	srv.HandleEndpoint(sse.Endpoint("/stream", streams, func(
+		ctx context.Context, w http.ResponseWriter, r *http.Request,
+	) {
+		stream, err := sse.NewWriter(ctx, w)
+		if err != nil {
+			t.Error("expect to start stream, but got", err)
+			return
+		}
+		// Values set by middlewares are kept
+		data := []byte(fmt.Sprint(ctx.Value(contextKey{})))
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := stream.Send(&sse.Event{Name: "tick", Data: data}); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	addr := fmt.Sprintf("127.0.0.1:%d", lt.NextPort())
+	go srv.Serve(ctx, addr)
+	defer srv.Drain()
+
+	// read returns how long the stream lasted and the data received
+	read := func() (time.Duration, []string) {
+		var res *nethttp.Response
+		for attempt := 0; ; attempt++ {
+			res, err = nethttp.Get("http://" + addr + "/stream")
+			if err == nil {
+				break
+			}
+			if attempt == 10 {
+				t.Fatal("expect to reach server, but got", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		defer res.Body.Close()
+
+		start := time.Now()
+		var data []string
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			}
+		}
+		return time.Since(start), data
+	}
+
+	d, data := read()
+	if d < 250*time.Millisecond || d > time.Second {
+		t.Errorf("expect stream to last 300ms, but got %s", d)
+	}
+	if len(data) < 10 || data[0] != "foo" {
+		t.Errorf("expect events with middleware values, but got %v", data)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		streams.Drain()
+	}()
+	if d, _ := read(); d > 200*time.Millisecond {
+		t.Errorf("expect stream to end when drained, but got %s", d)
+	}
+	if d, _ := read(); d > 100*time.Millisecond {
+		t.Errorf("expect new streams to end once drained, but got %s", d)
+	}
+}
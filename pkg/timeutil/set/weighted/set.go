@@ -0,0 +1,201 @@
+package weighted
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/basgys/booking-consensys/pkg/timeutil/interval"
+)
+
+// Set is a disjoint set of `interval.WeightedInterval`. Adding an interval
+// which overlaps with existing intervals adds its weight to the overlapping
+// portions, and subtracting removes it.
+//
+// e.g.
+//
+//	 Input:     [===2===)
+//	   Set: [=1=====)   |
+//	        |   |   |   |
+//	Output: [=1][=3][=2)
+type Set struct {
+	// non-overlapping intervals sorted by start
+	intervals []interval.WeightedInterval
+}
+
+// Empty returns a new, empty set of intervals.
+func Empty() *Set {
+	return &Set{}
+}
+
+// Copy returns a copy of a set that may be mutated without affecting the original.
+func (s *Set) Copy() *Set {
+	return &Set{append([]interval.WeightedInterval(nil), s.intervals...)}
+}
+
+// Extent returns the Interval defined by the minimum and maximum values of the
+// set.
+func (s *Set) Extent() interval.WeightedInterval {
+	if len(s.intervals) == 0 {
+		return nil
+	}
+	return s.intervals[0].Encompass(s.intervals[len(s.intervals)-1])
+}
+
+// Add adds the weight of x to the set over the range of x.
+func (s *Set) Add(x interval.WeightedInterval) {
+	s.merge(x, true, func(iv interval.WeightedInterval) interval.WeightedInterval {
+		return iv.Add(x.Weight())
+	})
+}
+
+// Sub subtracts the weight of x from the set over the range of x. Intervals
+// which weight drops to zero are removed from the set.
+func (s *Set) Sub(x interval.WeightedInterval) {
+	s.merge(x, false, func(iv interval.WeightedInterval) interval.WeightedInterval {
+		return iv.Substract(x.Weight())
+	})
+}
+
+// merge applies fn to the portions of the set overlapping with x. When insert
+// is true, the portions of x which do not overlap with the set are added as is.
+func (s *Set) merge(
+	x interval.WeightedInterval,
+	insert bool,
+	fn func(interval.WeightedInterval) interval.WeightedInterval,
+) {
+	if x == nil || x.IsZero() {
+		return
+	}
+
+	var newIntervals []interval.WeightedInterval
+	push := func(iv interval.WeightedInterval) {
+		if iv.IsZero() {
+			return
+		}
+		newIntervals = adjoinOrAppend(newIntervals, iv)
+	}
+
+	// rest is the portion of x which has not been merged yet
+	rest := x
+	for _, iv := range s.intervals {
+		if rest == nil || iv.Before(rest) {
+			push(iv)
+			continue
+		}
+		if rest.Before(iv) {
+			if insert {
+				push(rest)
+			}
+			rest = nil
+			push(iv)
+			continue
+		}
+
+		// Align both intervals on the same starting point
+		switch rest.Starting().Cmp(iv.Starting()) {
+		case -1:
+			left, right := rest.Split(iv.Starting())
+			if insert {
+				push(left)
+			}
+			rest = right
+		case 1:
+			left, right := iv.Split(rest.Starting())
+			push(left)
+			iv = right
+		}
+
+		push(fn(iv.Intersect(rest)))
+
+		// Carry over whichever interval ends last
+		switch rest.Ending().Cmp(iv.Ending()) {
+		case -1:
+			_, right := iv.Split(rest.Ending())
+			push(right)
+			rest = nil
+		case 0:
+			rest = nil
+		case 1:
+			_, right := rest.Split(iv.Ending())
+			rest = right
+		}
+	}
+	if rest != nil && insert {
+		push(rest)
+	}
+	s.intervals = newIntervals
+}
+
+// Max returns the highest weight of the set within extents.
+func (s *Set) Max(extents interval.Interval) uint64 {
+	var w uint64
+	for _, iv := range s.intervals[s.searchLow(extents):] {
+		if extents.Before(iv) {
+			break
+		}
+		if iv.Overlap(extents) && iv.Weight() > w {
+			w = iv.Weight()
+		}
+	}
+	return w
+}
+
+// Between returns the intervals within extents. Any interval within the set
+// that overlaps partially with extents is truncated.
+func (s *Set) Between(extents interval.WeightedInterval) []interval.WeightedInterval {
+	var l []interval.WeightedInterval
+	for _, iv := range s.intervals[s.searchLow(extents):] {
+		if extents.Before(iv) {
+			break
+		}
+		portion := iv.Intersect(extents)
+		if portion.IsZero() {
+			continue
+		}
+		l = append(l, portion)
+	}
+	return l
+}
+
+// All returns an ordered slice of all the intervals in the set.
+func (s *Set) All() []interval.WeightedInterval {
+	return append(make([]interval.WeightedInterval, 0, len(s.intervals)), s.intervals...)
+}
+
+// Size returns number of intervals in this set
+func (s *Set) Size() int {
+	return len(s.intervals)
+}
+
+// String returns a human-friendly representation of the set.
+func (s *Set) String() string {
+	var strs []string
+	for _, x := range s.intervals {
+		strs = append(strs, fmt.Sprintf("%s", x))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(strs, ", "))
+}
+
+// searchLow returns the first index in s.intervals that is not before x.
+func (s *Set) searchLow(x interval.Interval) int {
+	return sort.Search(len(s.intervals), func(i int) bool {
+		return !s.intervals[i].Before(x)
+	})
+}
+
+// adjoinOrAppend adds an interval to the end of intervals unless that value
+// directly adjoins the last element of intervals with the same weight, in
+// which case the last element will be replaced by the adjoined interval.
+func adjoinOrAppend(intervals []interval.WeightedInterval, x interval.WeightedInterval) []interval.WeightedInterval {
+	lastIndex := len(intervals) - 1
+	if lastIndex == -1 {
+		return append(intervals, x)
+	}
+	adjoined := intervals[lastIndex].Adjoin(x)
+	if adjoined.IsZero() {
+		return append(intervals, x)
+	}
+	intervals[lastIndex] = adjoined
+	return intervals
+}
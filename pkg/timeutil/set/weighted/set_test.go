@@ -0,0 +1,156 @@
+package weighted_test
+
+import (
+	"testing"
+
+	"github.com/basgys/booking-consensys/pkg/timeutil/interval"
+	"github.com/basgys/booking-consensys/pkg/timeutil/set/weighted"
+	"github.com/basgys/booking-consensys/pkg/timeutil/timespan"
+	"github.com/deixis/pkg/utc"
+)
+
+func TestAdd(t *testing.T) {
+	table := []struct {
+		name   string
+		input  []*timespan.WeightedSpan
+		expect []*timespan.WeightedSpan
+	}{
+		{
+			name:   "single",
+			input:  []*timespan.WeightedSpan{ws(10, 20, 1)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 1)},
+		},
+		{
+			name:   "disjoint",
+			input:  []*timespan.WeightedSpan{ws(30, 40, 2), ws(10, 20, 1)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 1), ws(30, 40, 2)},
+		},
+		{
+			name:   "adjoining same weight",
+			input:  []*timespan.WeightedSpan{ws(10, 20, 1), ws(20, 30, 1)},
+			expect: []*timespan.WeightedSpan{ws(10, 30, 1)},
+		},
+		{
+			name:   "adjoining different weight",
+			input:  []*timespan.WeightedSpan{ws(10, 20, 1), ws(20, 30, 2)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 1), ws(20, 30, 2)},
+		},
+		{
+			name:   "exact match",
+			input:  []*timespan.WeightedSpan{ws(10, 20, 1), ws(10, 20, 2)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 3)},
+		},
+		{
+			name:   "partial overlap",
+			input:  []*timespan.WeightedSpan{ws(10, 30, 1), ws(20, 40, 2)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 1), ws(20, 30, 3), ws(30, 40, 2)},
+		},
+		{
+			name:   "enclosing",
+			input:  []*timespan.WeightedSpan{ws(20, 30, 1), ws(40, 50, 1), ws(10, 60, 2)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 2), ws(20, 30, 3), ws(30, 40, 2), ws(40, 50, 3), ws(50, 60, 2)},
+		},
+		{
+			name:   "inside",
+			input:  []*timespan.WeightedSpan{ws(10, 60, 1), ws(20, 30, 1)},
+			expect: []*timespan.WeightedSpan{ws(10, 20, 1), ws(20, 30, 2), ws(30, 60, 1)},
+		},
+	}
+
+	for _, test := range table {
+		t.Run(test.name, func(t *testing.T) {
+			s := weighted.Empty()
+			for _, iv := range test.input {
+				s.Add(iv)
+			}
+			expectIntervals(t, test.expect, s.All())
+		})
+	}
+}
+
+func TestSub(t *testing.T) {
+	s := weighted.Empty()
+	s.Add(ws(10, 60, 3))
+
+	s.Sub(ws(20, 30, 1))
+	expectIntervals(t, []*timespan.WeightedSpan{
+		ws(10, 20, 3), ws(20, 30, 2), ws(30, 60, 3),
+	}, s.All())
+
+	// Subtracting outside the set is a no-op
+	s.Sub(ws(0, 10, 1))
+	s.Sub(ws(60, 70, 1))
+	expectIntervals(t, []*timespan.WeightedSpan{
+		ws(10, 20, 3), ws(20, 30, 2), ws(30, 60, 3),
+	}, s.All())
+
+	// Dropping to zero removes the portion
+	s.Sub(ws(0, 25, 3))
+	expectIntervals(t, []*timespan.WeightedSpan{
+		ws(25, 30, 2), ws(30, 60, 3),
+	}, s.All())
+
+	// Adding back the subtracted weight merges the portions
+	s.Add(ws(25, 30, 1))
+	expectIntervals(t, []*timespan.WeightedSpan{ws(25, 60, 3)}, s.All())
+
+	s.Sub(ws(0, 100, 5))
+	if s.Size() != 0 {
+		t.Errorf("expect empty set, but got %s", s)
+	}
+}
+
+func TestMax(t *testing.T) {
+	s := weighted.Empty()
+	s.Add(ws(10, 30, 1))
+	s.Add(ws(20, 40, 2))
+
+	table := []struct {
+		start, end int64
+		expect     uint64
+	}{
+		{0, 10, 0},
+		{0, 15, 1},
+		{10, 20, 1},
+		{15, 25, 3},
+		{30, 40, 2},
+		{40, 50, 0},
+		{0, 100, 3},
+	}
+	for _, test := range table {
+		got := s.Max(&timespan.Span{Start: utc.UTC(test.start), End: utc.UTC(test.end)})
+		if got != test.expect {
+			t.Errorf("Max([%d, %d)) = %d, want %d", test.start, test.end, got, test.expect)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	s := weighted.Empty()
+	s.Add(ws(10, 30, 1))
+	s.Add(ws(20, 40, 2))
+
+	expectIntervals(t, []*timespan.WeightedSpan{
+		ws(15, 20, 1), ws(20, 30, 3), ws(30, 35, 2),
+	}, s.Between(ws(15, 35, 1)))
+	expectIntervals(t, nil, s.Between(ws(40, 50, 1)))
+}
+
+func expectIntervals(
+	t *testing.T, expect []*timespan.WeightedSpan, got []interval.WeightedInterval,
+) {
+	t.Helper()
+
+	if len(expect) != len(got) {
+		t.Fatalf("expect %d intervals, but got %d (%v)", len(expect), len(got), got)
+	}
+	for i := range expect {
+		if !expect[i].Equal(got[i].(*timespan.WeightedSpan)) {
+			t.Errorf("expect interval %d to be %s, but got %s", i, expect[i], got[i])
+		}
+	}
+}
+
+func ws(start, end int64, w uint64) *timespan.WeightedSpan {
+	return &timespan.WeightedSpan{Start: utc.UTC(start), End: utc.UTC(end), W: w}
+}
@@ -0,0 +1,140 @@
+package timespan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/basgys/booking-consensys/pkg/timeutil/interval"
+	"github.com/deixis/pkg/utc"
+)
+
+// WeightedSpan is an implementation of interval.WeightedInterval.
+type WeightedSpan struct {
+	Start utc.UTC
+	End   utc.UTC
+	W     uint64
+}
+
+// Duration returns the interval duration
+func (ts *WeightedSpan) Duration() time.Duration {
+	return ts.End.Distance(ts.Start)
+}
+
+func (ts *WeightedSpan) String() string {
+	return fmt.Sprintf("[%s, %s)x%d", ts.Start, ts.End, ts.W)
+}
+
+func (ts *WeightedSpan) Equal(b *WeightedSpan) bool {
+	return ts.Start == b.Start && ts.End == b.End && ts.W == b.W
+}
+
+func (ts *WeightedSpan) Weight() uint64 {
+	return ts.W
+}
+
+// IsZero returns true for the zero value and for intervals without weight.
+func (ts *WeightedSpan) IsZero() bool {
+	return (ts.Start.IsZero() && ts.End.IsZero()) || ts.W == 0
+}
+
+func (ts *WeightedSpan) After(o interval.Interval) bool {
+	return interval.Relation(ts, o).After()
+}
+
+func (ts *WeightedSpan) Before(o interval.Interval) bool {
+	return interval.Relation(ts, o).Before()
+}
+
+func (ts *WeightedSpan) Overlap(o interval.Interval) bool {
+	return interval.Relation(ts, o).Overlap()
+}
+
+func (ts *WeightedSpan) Starting() interval.Endpoint {
+	return endpoint(ts.Start)
+}
+
+func (ts *WeightedSpan) Ending() interval.Endpoint {
+	return endpoint(ts.End)
+}
+
+// Intersect returns the intersection of both intervals with the weight of ts.
+func (ts *WeightedSpan) Intersect(other interval.WeightedInterval) interval.WeightedInterval {
+	b := tryWeightedOrPanic(other)
+	return ts.weighted(max(ts.Start, b.Start), min(ts.End, b.End))
+}
+
+func (ts *WeightedSpan) Add(w uint64) interval.WeightedInterval {
+	return &WeightedSpan{ts.Start, ts.End, ts.W + w}
+}
+
+func (ts *WeightedSpan) Substract(w uint64) interval.WeightedInterval {
+	if w >= ts.W {
+		return &WeightedSpan{}
+	}
+	return &WeightedSpan{ts.Start, ts.End, ts.W - w}
+}
+
+func (ts *WeightedSpan) Split(x interval.Endpoint) (interval.WeightedInterval, interval.WeightedInterval) {
+	e, ok := x.(endpoint)
+	if !ok {
+		panic("unsupported endpoint")
+	}
+	at := utc.UTC(e)
+	return ts.weighted(ts.Start, min(at, ts.End)), ts.weighted(max(at, ts.Start), ts.End)
+}
+
+func (ts *WeightedSpan) Bisect(other interval.WeightedInterval) (interval.WeightedInterval, interval.WeightedInterval) {
+	b := tryWeightedOrPanic(other)
+	intersection := ts.weighted(max(ts.Start, b.Start), min(ts.End, b.End))
+	if intersection.IsZero() {
+		if ts.Before(b) {
+			return ts, &WeightedSpan{}
+		}
+		return &WeightedSpan{}, ts
+	}
+	return ts.weighted(ts.Start, intersection.Start), ts.weighted(intersection.End, ts.End)
+}
+
+// Adjoin returns the union of both intervals when they are adjacent and have
+// the same weight, or the zero interval otherwise.
+func (ts *WeightedSpan) Adjoin(other interval.WeightedInterval) interval.WeightedInterval {
+	b := tryWeightedOrPanic(other)
+	if ts.W != b.W {
+		return &WeightedSpan{}
+	}
+	if ts.End == b.Start {
+		return &WeightedSpan{ts.Start, b.End, ts.W}
+	}
+	if b.End == ts.Start {
+		return &WeightedSpan{b.Start, ts.End, ts.W}
+	}
+	return &WeightedSpan{}
+}
+
+// Encompass returns an interval covering both intervals with the highest of
+// their weights.
+func (ts *WeightedSpan) Encompass(other interval.WeightedInterval) interval.WeightedInterval {
+	b := tryWeightedOrPanic(other)
+	w := ts.W
+	if b.W > w {
+		w = b.W
+	}
+	return &WeightedSpan{min(ts.Start, b.Start), max(ts.End, b.End), w}
+}
+
+// weighted returns the interval [s, e) with the weight of ts, or the zero
+// interval when it is empty.
+func (ts *WeightedSpan) weighted(s, e utc.UTC) *WeightedSpan {
+	if s >= e {
+		return &WeightedSpan{}
+	}
+	return &WeightedSpan{s, e, ts.W}
+}
+
+func tryWeightedOrPanic(i interval.Interval) *WeightedSpan {
+	ws, ok := i.(*WeightedSpan)
+	if !ok {
+		panic(fmt.Errorf("interval must be a weighted time range: %v", i))
+	}
+	return ws
+}
@@ -0,0 +1,73 @@
+package timespan
+
+import (
+	"fmt"
+
+	"github.com/basgys/booking-consensys/pkg/timeutil/set/weighted"
+	"github.com/deixis/pkg/utc"
+)
+
+// WeightedSet is a finite set of weighted time spans. Weights are added and
+// subtracted across overlapping spans, which makes it possible to track the
+// capacity used (or left) over time.
+//
+// This is a time span-specific implemention of weighted.Set.
+type WeightedSet struct {
+	wset *weighted.Set
+}
+
+// EmptyWeighted returns a new, empty WeightedSet.
+func EmptyWeighted() *WeightedSet {
+	return &WeightedSet{weighted.Empty()}
+}
+
+// String returns a human readable version of the set.
+func (s *WeightedSet) String() string {
+	return s.wset.String()
+}
+
+// Copy returns a copy of a set that may be mutated without affecting the original.
+func (s *WeightedSet) Copy() *WeightedSet {
+	return &WeightedSet{s.wset.Copy()}
+}
+
+// Add adds weight w to the time span [start, end).
+func (s *WeightedSet) Add(start, end utc.UTC, w uint64) {
+	if s.wset == nil {
+		panic("timespan.WeightedSet not initialised")
+	}
+	if end < start {
+		panic(fmt.Errorf("start %s before end %s", start, end))
+	}
+	s.wset.Add(&WeightedSpan{start, end, w})
+}
+
+// Sub subtracts weight w from the time span [start, end).
+func (s *WeightedSet) Sub(start, end utc.UTC, w uint64) {
+	if s.wset == nil {
+		panic("timespan.WeightedSet not initialised")
+	}
+	if end < start {
+		panic(fmt.Errorf("start %s before end %s", start, end))
+	}
+	s.wset.Sub(&WeightedSpan{start, end, w})
+}
+
+// Max returns the highest weight within [start, end).
+func (s *WeightedSet) Max(start, end utc.UTC) uint64 {
+	return s.wset.Max(&Span{start, end})
+}
+
+// IntervalsBetween returns the weighted time ranges within [start, end).
+// Ranges which overlap partially with [start, end) are truncated.
+func (s *WeightedSet) IntervalsBetween(start, end utc.UTC) []*WeightedSpan {
+	var l []*WeightedSpan
+	for _, iv := range s.wset.Between(&WeightedSpan{start, end, 1}) {
+		l = append(l, tryWeightedOrPanic(iv))
+	}
+	return l
+}
+
+func (s *WeightedSet) Size() int {
+	return s.wset.Size()
+}